@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	stdOS "os"
+	"strings"
+)
+
+const usage = `usage: cloudevents-gateway [command]
+
+Commands:
+  serve                      Run the gateway (the default)
+  config validate [path]     Validate configuration without running the gateway
+  token generate [flags]     Generate a new random token
+  token hash [token]         Hash a token the same way the gateway does
+  send [flags] <url>         Send a sample CloudEvent to a gateway
+  help                       Show this message
+
+Run a command with -h for details of its flags.
+`
+
+// runCommand runs the command named by the program's arguments, if any, and
+// exits. If no command is named, or the serve command is, it returns so the
+// gateway can start.
+func runCommand() {
+	if code, ran := command(
+		stdOS.Args[1:],
+		stdOS.Stdin,
+		stdOS.Stdout,
+		stdOS.Stderr,
+	); ran {
+		stdOS.Exit(code)
+	}
+}
+
+// command runs the command named by the provided arguments using the provided
+// input and outputs. It returns the command's exit code and true if a command
+// was run. If the arguments name no command, or the serve command, it returns
+// false to indicate that the gateway should start.
+func command(
+	args []string,
+	in io.Reader,
+	out io.Writer,
+	errOut io.Writer,
+) (int, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	switch args[0] {
+	case "serve":
+		if len(args) > 1 {
+			fmt.Fprintln(errOut, "usage: serve")
+			return 2, true
+		}
+		return 0, false
+	case "config":
+		if len(args) > 1 && args[1] == "validate" {
+			return validateCommand(args[2:], out), true
+		}
+	case "validate":
+		// Retained as a shorthand for "config validate"
+		return validateCommand(args[1:], out), true
+	case "token":
+		if len(args) > 1 {
+			switch args[1] {
+			case "generate":
+				return tokenGenerateCommand(args[2:], out, errOut), true
+			case "hash":
+				return tokenHashCommand(args[2:], in, out, errOut), true
+			}
+		}
+	case "send":
+		return sendCommand(args[1:], out, errOut), true
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(out, usage)
+		return 0, true
+	}
+	fmt.Fprintf(
+		errOut,
+		"unknown command %q\n\n%s",
+		strings.Join(args, " "),
+		usage,
+	)
+	return 2, true
+}
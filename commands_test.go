@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommand(t *testing.T) {
+	testCases := []struct {
+		name       string
+		args       []string
+		assertions func(code int, ran bool, out string, errOut string)
+	}{
+		{
+			name: "no command",
+			assertions: func(_ int, ran bool, _ string, _ string) {
+				require.False(t, ran)
+			},
+		},
+		{
+			name: "serve",
+			args: []string{"serve"},
+			assertions: func(_ int, ran bool, _ string, _ string) {
+				require.False(t, ran)
+			},
+		},
+		{
+			name: "serve with unexpected arguments",
+			args: []string{"serve", "now"},
+			assertions: func(code int, ran bool, _ string, errOut string) {
+				require.True(t, ran)
+				require.Equal(t, 2, code)
+				require.Contains(t, errOut, "usage: serve")
+			},
+		},
+		{
+			name: "help",
+			args: []string{"help"},
+			assertions: func(code int, ran bool, out string, _ string) {
+				require.True(t, ran)
+				require.Equal(t, 0, code)
+				require.Equal(t, usage, out)
+			},
+		},
+		{
+			name: "incomplete command",
+			args: []string{"token"},
+			assertions: func(code int, ran bool, _ string, errOut string) {
+				require.True(t, ran)
+				require.Equal(t, 2, code)
+				require.Contains(t, errOut, `unknown command "token"`)
+			},
+		},
+		{
+			name: "unknown command",
+			args: []string{"config", "print"},
+			assertions: func(code int, ran bool, _ string, errOut string) {
+				require.True(t, ran)
+				require.Equal(t, 2, code)
+				require.Contains(t, errOut, `unknown command "config print"`)
+				require.Contains(t, errOut, usage)
+			},
+		},
+		{
+			name: "known command",
+			args: []string{"token", "hash", "foo"},
+			assertions: func(code int, ran bool, out string, _ string) {
+				require.True(t, ran)
+				require.Equal(t, 0, code)
+				require.NotEmpty(t, out)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			errOut := &bytes.Buffer{}
+			code, ran := command(testCase.args, strings.NewReader(""), out, errOut)
+			testCase.assertions(code, ran, out.String(), errOut.String())
+		})
+	}
+}
@@ -2,17 +2,211 @@ package main
 
 // nolint: lll
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
+	"math"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents"
+	ourCloudGRPC "github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/grpc" // nolint: lll
 	ourCloudHTTP "github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/http" // nolint: lll
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/mqtt"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/notifications"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/ratelimit"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/shutdown"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/tokens"
 	"github.com/brigadecore/brigade-foundations/file"
 	"github.com/brigadecore/brigade-foundations/http"
 	"github.com/brigadecore/brigade-foundations/os"
 	"github.com/brigadecore/brigade/sdk/v3/restmachinery"
 	"github.com/pkg/errors"
+	"go.uber.org/zap/zapcore"
 )
 
+// loggerConfig populates configuration for the logger from environment
+// variables.
+func loggerConfig() (logging.Config, error) {
+	config := logging.Config{}
+	levelStr := os.GetEnvVar("LOG_LEVEL", "info")
+	var err error
+	if config.Level, err = zapcore.ParseLevel(levelStr); err != nil {
+		return config, errors.Wrap(err, "error parsing LOG_LEVEL")
+	}
+	config.Format = logging.Format(os.GetEnvVar("LOG_FORMAT", "json"))
+	switch config.Format {
+	case logging.FormatJSON, logging.FormatText:
+	default:
+		return config, errors.Errorf(
+			"LOG_FORMAT %q is invalid; valid values are %q and %q",
+			config.Format,
+			logging.FormatJSON,
+			logging.FormatText,
+		)
+	}
+	return config, nil
+}
+
+// shutdownConfig populates configuration for the gateway's shutdown sequence
+// from environment variables.
+func shutdownConfig() (shutdown.Config, error) {
+	config := shutdown.Config{}
+	var err error
+	if config.Delay, err =
+		os.GetDurationFromEnvVar("SHUTDOWN_DELAY", 0); err != nil {
+		return config, err
+	}
+	if config.Delay < 0 {
+		return config, errors.Errorf(
+			"SHUTDOWN_DELAY %s is invalid; it must not be negative",
+			config.Delay,
+		)
+	}
+	config.GracePeriod, err =
+		os.GetDurationFromEnvVar("SHUTDOWN_GRACE_PERIOD", 25*time.Second)
+	if err != nil {
+		return config, err
+	}
+	if config.GracePeriod < 0 {
+		return config, errors.Errorf(
+			"SHUTDOWN_GRACE_PERIOD %s is invalid; it must not be negative",
+			config.GracePeriod,
+		)
+	}
+	return config, nil
+}
+
+// enricherConfig populates configuration for the enricher from environment
+// variables.
+func enricherConfig() (cloudevents.EnricherConfig, error) {
+	config := cloudevents.EnricherConfig{
+		PodName:     os.GetEnvVar("POD_NAME", ""),
+		ClusterName: os.GetEnvVar("CLUSTER_NAME", ""),
+	}
+	var err error
+	if config.Labels, err =
+		getEnrichmentFieldsFromEnvVar("ENRICHMENT_LABELS"); err != nil {
+		return config, err
+	}
+	if config.Qualifiers, err =
+		getEnrichmentFieldsFromEnvVar("ENRICHMENT_QUALIFIERS"); err != nil {
+		return config, err
+	}
+	config.Extensions, err =
+		getEnrichmentFieldsFromEnvVar("ENRICHMENT_EXTENSIONS")
+	return config, err
+}
+
+// getEnrichmentFieldsFromEnvVar parses a comma-delimited list of enrichment
+// fields from the specified environment variable. If the environment variable
+// is unset, nil is returned.
+func getEnrichmentFieldsFromEnvVar(
+	key string,
+) ([]cloudevents.EnrichmentField, error) {
+	var fields []cloudevents.EnrichmentField
+	for _, fieldStr := range strings.Split(os.GetEnvVar(key, ""), ",") {
+		if fieldStr = strings.TrimSpace(fieldStr); fieldStr == "" {
+			continue
+		}
+		field, err := cloudevents.ParseEnrichmentField(fieldStr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing %s", key)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// promoterConfig populates configuration for the promotion of CloudEvent
+// attributes to Brigade Event qualifiers and labels from environment variables.
+func promoterConfig() cloudevents.PromoterConfig {
+	return cloudevents.PromoterConfig{
+		Qualifiers: getAttributeNamesFromEnvVar("PROMOTED_QUALIFIERS"),
+		Labels:     getAttributeNamesFromEnvVar("PROMOTED_LABELS"),
+	}
+}
+
+// getAttributeNamesFromEnvVar parses a comma-delimited list of CloudEvent
+// attribute names from the specified environment variable. Because attribute
+// names are case-insensitive, they are normalized to lowercase. If the
+// environment variable is unset, nil is returned.
+func getAttributeNamesFromEnvVar(key string) []string {
+	var names []string
+	for _, name := range strings.Split(os.GetEnvVar(key, ""), ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// concurrencyLimiterConfig populates configuration for the limit on
+// concurrent calls to the Brigade API from environment variables.
+func concurrencyLimiterConfig() (cloudevents.ConcurrencyLimiterConfig, error) {
+	config := cloudevents.ConcurrencyLimiterConfig{}
+	var err error
+	if config.MaxInFlight, err =
+		os.GetIntFromEnvVar("API_MAX_IN_FLIGHT", 0); err != nil {
+		return config, err
+	}
+	if config.MaxQueued, err =
+		os.GetIntFromEnvVar("API_MAX_QUEUED", 100); err != nil {
+		return config, err
+	}
+	if config.QueueTimeout, err =
+		os.GetDurationFromEnvVar("API_QUEUE_TIMEOUT", 5*time.Second); err != nil {
+		return config, err
+	}
+	if config.QueueTimeout < 0 {
+		return config, errors.Errorf(
+			"API_QUEUE_TIMEOUT %s is invalid; it must not be negative",
+			config.QueueTimeout,
+		)
+	}
+	return config, nil
+}
+
+// auditSinkConfig populates configuration for the audit sink from environment
+// variables.
+func auditSinkConfig() (audit.SinkConfig, error) {
+	config := audit.SinkConfig{}
+	config.Output = audit.Output(os.GetEnvVar("AUDIT_OUTPUT", "stdout"))
+	switch config.Output {
+	case audit.OutputStdout:
+		return config, nil
+	case audit.OutputFile:
+	default:
+		return config, errors.Errorf(
+			"AUDIT_OUTPUT %q is invalid; valid values are %q and %q",
+			config.Output,
+			audit.OutputStdout,
+			audit.OutputFile,
+		)
+	}
+	var err error
+	if config.FilePath, err =
+		os.GetRequiredEnvVar("AUDIT_FILE_PATH"); err != nil {
+		return config, err
+	}
+	if config.FileMaxSizeMB, err =
+		os.GetIntFromEnvVar("AUDIT_FILE_MAX_SIZE_MB", 100); err != nil {
+		return config, err
+	}
+	if config.FileMaxBackups, err =
+		os.GetIntFromEnvVar("AUDIT_FILE_MAX_BACKUPS", 0); err != nil {
+		return config, err
+	}
+	config.FileMaxAgeDays, err = os.GetIntFromEnvVar("AUDIT_FILE_MAX_AGE_DAYS", 0)
+	return config, err
+}
+
 // apiClientConfig populates the Brigade SDK's APIClientOptions from
 // environment variables.
 func apiClientConfig() (string, string, restmachinery.APIClientOptions, error) {
@@ -30,35 +224,671 @@ func apiClientConfig() (string, string, restmachinery.APIClientOptions, error) {
 	return address, token, opts, err
 }
 
-// tokenFilterConfig populates config for the token filter.
-func tokenFilterConfig() (ourCloudHTTP.TokenFilterConfig, error) {
+// routingConfig is the format of the file indicated by the ROUTING_PATH
+// environment variable.
+type routingConfig struct {
+	Backends map[string]struct {
+		Address            string `json:"address"`
+		Token              string `json:"token"`
+		IgnoreCertWarnings bool   `json:"ignoreCertWarnings"`
+	} `json:"backends"`
+	Routes []cloudevents.Route `json:"routes"`
+}
+
+// routerConfig populates configuration for the router from the file indicated
+// by the ROUTING_PATH environment variable.
+func routerConfig() (cloudevents.RouterConfig, error) {
+	config := cloudevents.RouterConfig{}
+	routingBytes, _, err := getFileContentsFromEnvVar("ROUTING_PATH")
+	if err != nil {
+		return config, err
+	}
+	routing := routingConfig{}
+	if err = json.Unmarshal(routingBytes, &routing); err != nil {
+		return config, err
+	}
+	config.Backends = make(map[string]cloudevents.BackendConfig)
+	for name, backend := range routing.Backends {
+		if backend.Address == "" {
+			return config, errors.Errorf("backend %q has no address", name)
+		}
+		if backend.Token == "" {
+			return config, errors.Errorf("backend %q has no token", name)
+		}
+		config.Backends[name] = cloudevents.BackendConfig{
+			Address: backend.Address,
+			Token:   backend.Token,
+			ClientOptions: restmachinery.APIClientOptions{
+				AllowInsecureConnections: backend.IgnoreCertWarnings,
+			},
+		}
+	}
+	config.Routes = routing.Routes
+	return config, nil
+}
+
+// tokenSourceConfig populates configuration for the source of the gateway's
+// default tokens from environment variables.
+func tokenSourceConfig() (tokens.SourceConfig, error) {
+	config := tokens.SourceConfig{
+		Type: tokens.SourceType(
+			os.GetEnvVar("TOKENS_SOURCE", string(tokens.SourceTypeFile)),
+		),
+	}
+	var err error
+	switch config.Type {
+	case tokens.SourceTypeFile:
+		if os.GetEnvVar("TOKENS_PATH", "") == "" {
+			if contents, ok := configFileContents["TOKENS_PATH"]; ok {
+				config.Contents = contents
+				return config, nil
+			}
+		}
+		config.Path, err = os.GetRequiredEnvVar("TOKENS_PATH")
+	case tokens.SourceTypeDirectory:
+		config.Path, err = os.GetRequiredEnvVar("TOKENS_DIRECTORY_PATH")
+	case tokens.SourceTypeKubernetes:
+		config.KubernetesNamespace =
+			os.GetEnvVar("TOKENS_KUBERNETES_NAMESPACE", "")
+		config.KubernetesLabelSelector, err =
+			os.GetRequiredEnvVar("TOKENS_KUBERNETES_LABEL_SELECTOR")
+		return config, err
+	default:
+		return config, errors.Errorf(
+			"TOKENS_SOURCE %q is invalid; valid values are %q, %q, and %q",
+			config.Type,
+			tokens.SourceTypeFile,
+			tokens.SourceTypeDirectory,
+			tokens.SourceTypeKubernetes,
+		)
+	}
+	if err != nil {
+		return config, err
+	}
+	config.RefreshInterval, err =
+		os.GetDurationFromEnvVar("TOKENS_REFRESH_INTERVAL", time.Minute)
+	return config, err
+}
+
+// tokenFilterConfig populates config for the token filter using the tokens
+// currently provided by the configured source, which is also returned so that
+// it can be watched for changes.
+func tokenFilterConfig(
+	ctx context.Context,
+) (ourCloudHTTP.TokenFilterConfig, tokens.Source, error) {
+	sourceConfig, err := tokenSourceConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	source, err := tokens.NewSource(sourceConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	initialTokens, err := source.Tokens(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	config, err := newTokenFilterConfig(initialTokens)
+	return config, source, err
+}
+
+// tenantTokenSource returns a tokens.Source that provides the tokens of the
+// named tenant from the file indicated by the TENANTS_PATH environment
+// variable, which is checked for changes as often as the gateway's own tokens.
+func tenantTokenSource(name string) (tokens.Source, error) {
+	if os.GetEnvVar("TENANTS_PATH", "") == "" {
+		if contents, ok := configFileContents["TENANTS_PATH"]; ok {
+			return tokens.NewTenantFileSource("", contents, name, 0), nil
+		}
+	}
+	path, err := os.GetRequiredEnvVar("TENANTS_PATH")
+	if err != nil {
+		return nil, err
+	}
+	refreshInterval, err :=
+		os.GetDurationFromEnvVar("TOKENS_REFRESH_INTERVAL", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	return tokens.NewTenantFileSource(path, nil, name, refreshInterval), nil
+}
+
+// newTokenFilterConfig returns token filter config containing the provided
+// tokens, indexed by name. Each token may be in plain text or hashed.
+func newTokenFilterConfig(
+	tokens map[string]ourCloudHTTP.Token,
+) (ourCloudHTTP.TokenFilterConfig, error) {
 	config := ourCloudHTTP.NewTokenFilterConfig()
-	tokensPath, err := os.GetRequiredEnvVar("TOKENS_PATH")
+	for name, token := range tokens {
+		if err := config.AddToken(name, token); err != nil {
+			return config, err
+		}
+	}
+	return config, nil
+}
+
+// tokenExpiryMonitorConfig populates configuration for the monitor that
+// reports tokens that are about to expire from environment variables.
+func tokenExpiryMonitorConfig() (ourCloudHTTP.TokenExpiryMonitorConfig, error) {
+	config := ourCloudHTTP.TokenExpiryMonitorConfig{}
+	var err error
+	if config.WarningPeriod, err = os.GetDurationFromEnvVar(
+		"TOKEN_EXPIRY_WARNING_PERIOD",
+		7*24*time.Hour,
+	); err != nil {
+		return config, err
+	}
+	config.CheckInterval, err =
+		os.GetDurationFromEnvVar("TOKEN_EXPIRY_CHECK_INTERVAL", time.Hour)
+	return config, err
+}
+
+// tenantNameRegex matches valid tenant names. Tenant names appear in URL paths,
+// so they are limited to lowercase letters, digits, and hyphens.
+var tenantNameRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// maxTenantNameLength is the maximum length of a tenant name.
+const maxTenantNameLength = 63
+
+// tenantConfig encapsulates configuration for a single tenant.
+type tenantConfig struct {
+	// Tokens maps the names of senders who may send CloudEvents to the tenant's
+	// endpoint to their tokens, which may be in plain text or hashed.
+	Tokens map[string]ourCloudHTTP.Token `json:"tokens"`
+	// APIToken is the Brigade API token used to create Brigade Events for the
+	// tenant. It is required so that no tenant's Brigade Events are created
+	// using the gateway's own token.
+	APIToken string `json:"apiToken"`
+	// BackendAPITokens maps the names of routing backends, other than the
+	// default backend, to the Brigade API tokens used to create Brigade Events
+	// for the tenant in those backends.
+	BackendAPITokens map[string]string `json:"backendAPITokens"`
+	// AllowedSources enumerates the CloudEvent sources the tenant's senders may
+	// send CloudEvents from. An entry ending in "*" is matched as a prefix. If
+	// unspecified, all sources are allowed.
+	AllowedSources []string `json:"allowedSources"`
+	// Qualifiers are stamped onto each Brigade Event created for the tenant.
+	Qualifiers map[string]string `json:"qualifiers"`
+	// Labels are stamped onto each Brigade Event created for the tenant.
+	Labels map[string]string `json:"labels"`
+	// PromotedQualifiers enumerates the names of CloudEvent attributes promoted
+	// to qualifiers of each Brigade Event created for the tenant.
+	PromotedQualifiers []string `json:"promotedQualifiers"`
+	// PromotedLabels enumerates the names of CloudEvent attributes promoted to
+	// labels of each Brigade Event created for the tenant.
+	PromotedLabels []string `json:"promotedLabels"`
+}
+
+// tenantsConfig populates configuration for all tenants, indexed by name, from
+// the file indicated by the TENANTS_PATH environment variable.
+func tenantsConfig() (map[string]tenantConfig, error) {
+	tenants := map[string]tenantConfig{}
+	tenantsBytes, source, err := getFileContentsFromEnvVar("TENANTS_PATH")
 	if err != nil {
+		return tenants, err
+	}
+	if err = json.Unmarshal(tenantsBytes, &tenants); err != nil {
+		return tenants, err
+	}
+	if len(tenants) == 0 {
+		return tenants, errors.Errorf("%s does not define any tenants", source)
+	}
+	for name, tenant := range tenants {
+		if len(name) > maxTenantNameLength || !tenantNameRegex.MatchString(name) {
+			return tenants, errors.Errorf(
+				"tenant name %q is invalid; names may contain at most %d lowercase "+
+					"letters, digits, and hyphens and must begin and end with a "+
+					"letter or digit",
+				name,
+				maxTenantNameLength,
+			)
+		}
+		if len(tenant.Tokens) == 0 {
+			return tenants, errors.Errorf("tenant %q has no tokens", name)
+		}
+		if _, err = newTokenFilterConfig(tenant.Tokens); err != nil {
+			return tenants, errors.Wrapf(err, "tenant %q", name)
+		}
+		if tenant.APIToken == "" {
+			return tenants, errors.Errorf("tenant %q has no API token", name)
+		}
+		for key, value := range tenant.Qualifiers {
+			// These are always set
+			if key == "source" || key == "type" {
+				return tenants, errors.Errorf(
+					"tenant %q may not override qualifier %q",
+					name,
+					key,
+				)
+			}
+			if err = cloudevents.ValidateQualifier(key, value); err != nil {
+				return tenants,
+					errors.Wrapf(err, "tenant %q has invalid qualifier", name)
+			}
+		}
+		for key, value := range tenant.Labels {
+			if err = cloudevents.ValidateQualifier(key, value); err != nil {
+				return tenants, errors.Wrapf(err, "tenant %q has invalid label", name)
+			}
+		}
+		// Promoted attributes are applied after the tenant's own qualifiers and
+		// labels, so a sender could override those if the names collided.
+		for i, attr := range tenant.PromotedQualifiers {
+			attr = strings.ToLower(strings.TrimSpace(attr))
+			if _, ok := tenant.Qualifiers[attr]; ok {
+				return tenants, errors.Errorf(
+					"tenant %q may not promote CloudEvent attribute %q to a qualifier "+
+						"it already sets",
+					name,
+					attr,
+				)
+			}
+			tenant.PromotedQualifiers[i] = attr
+		}
+		for i, attr := range tenant.PromotedLabels {
+			attr = strings.ToLower(strings.TrimSpace(attr))
+			if _, ok := tenant.Labels[attr]; ok {
+				return tenants, errors.Errorf(
+					"tenant %q may not promote CloudEvent attribute %q to a label it "+
+						"already sets",
+					name,
+					attr,
+				)
+			}
+			tenant.PromotedLabels[i] = attr
+		}
+		tenants[name] = tenant
+	}
+	return tenants, nil
+}
+
+// tenantRouterConfig returns configuration for the router of the named
+// tenant, derived from the provided configuration for the gateway's router.
+// Only routes that may match the tenant's CloudEvents are retained and every
+// backend, other than the default backend, uses the tenant's own API token for
+// that backend so that Brigade Events are never created for the tenant using
+// anyone else's token. An error is returned if the tenant has no API token for
+// a backend any retained route refers to.
+func tenantRouterConfig(
+	routing cloudevents.RouterConfig,
+	name string,
+	tenant tenantConfig,
+) (cloudevents.RouterConfig, error) {
+	config := cloudevents.RouterConfig{
+		Backends: map[string]cloudevents.BackendConfig{},
+	}
+	for i, route := range routing.Routes {
+		if !route.MatchesTenant(name) {
+			continue
+		}
+		for _, backendName := range route.Backends {
+			if backendName == cloudevents.DefaultBackend {
+				continue
+			}
+			backend, ok := routing.Backends[backendName]
+			if !ok {
+				// The router will report this
+				continue
+			}
+			token := tenant.BackendAPITokens[backendName]
+			if token == "" {
+				return config, errors.Errorf(
+					"tenant %q has no API token for backend %q, to which route %d "+
+						"may send its CloudEvents",
+					name,
+					backendName,
+					i,
+				)
+			}
+			backend.Token = token
+			config.Backends[backendName] = backend
+		}
+		config.Routes = append(config.Routes, route)
+	}
+	return config, nil
+}
+
+// trustedProxiesConfig populates the networks of proxies whose forwarding
+// headers are trusted to identify clients from environment variables.
+func trustedProxiesConfig() ([]*net.IPNet, error) {
+	return getNetworksFromEnvVar("TRUSTED_PROXIES")
+}
+
+// ipRulesConfig populates the rules governing the IP addresses of the clients
+// requests are accepted from from environment variables.
+func ipRulesConfig() (ourCloudHTTP.IPRules, error) {
+	rules := ourCloudHTTP.IPRules{}
+	var err error
+	if rules.Allowed, err =
+		getNetworksFromEnvVar("IP_ALLOWED_NETWORKS"); err != nil {
+		return rules, err
+	}
+	rules.Denied, err = getNetworksFromEnvVar("IP_DENIED_NETWORKS")
+	return rules, err
+}
+
+// getNetworksFromEnvVar parses a comma-delimited list of networks in CIDR
+// notation, or individual IP addresses, from the specified environment
+// variable. If the environment variable is unset, nil is returned.
+func getNetworksFromEnvVar(key string) ([]*net.IPNet, error) {
+	var values []string
+	for _, value := range strings.Split(os.GetEnvVar(key, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	networks, err := ourCloudHTTP.ParseNetworks(values)
+	return networks, errors.Wrapf(err, "error parsing %s", key)
+}
+
+// rateLimitFilterConfig populates config for the rate limit filter from
+// environment variables.
+func rateLimitFilterConfig() (ourCloudHTTP.RateLimitFilterConfig, error) {
+	config := ourCloudHTTP.RateLimitFilterConfig{}
+	config.Key = ourCloudHTTP.RateLimitKey(
+		os.GetEnvVar("RATE_LIMIT_KEY", string(ourCloudHTTP.RateLimitKeySender)),
+	)
+	switch config.Key {
+	case ourCloudHTTP.RateLimitKeySender,
+		ourCloudHTTP.RateLimitKeyClientIP,
+		ourCloudHTTP.RateLimitKeySource:
+	default:
+		return config, errors.Errorf(
+			"RATE_LIMIT_KEY %q is invalid; valid values are %q, %q, and %q",
+			config.Key,
+			ourCloudHTTP.RateLimitKeySender,
+			ourCloudHTTP.RateLimitKeyClientIP,
+			ourCloudHTTP.RateLimitKeySource,
+		)
+	}
+	var err error
+	if config.PerKeyLimit, err = rateLimit("RATE_LIMIT_PER_KEY"); err != nil {
 		return config, err
 	}
-	var exists bool
-	if exists, err = file.Exists(tokensPath); err != nil {
+	config.GlobalLimit, err = rateLimit("RATE_LIMIT_GLOBAL")
+	return config, err
+}
+
+// rateLimit populates a ratelimit.Limit from the environment variables whose
+// names are the provided prefix followed by _RATE and _BURST. If no burst is
+// specified, it defaults to the rate, rounded up.
+func rateLimit(prefix string) (ratelimit.Limit, error) {
+	limit := ratelimit.Limit{}
+	var err error
+	if limit.Rate, err = getFloatFromEnvVar(prefix+"_RATE", 0); err != nil {
+		return limit, err
+	}
+	if limit.Rate < 0 {
+		return limit, errors.Errorf("%s_RATE must not be negative", prefix)
+	}
+	if limit.Burst, err = os.GetIntFromEnvVar(
+		prefix+"_BURST",
+		int(math.Ceil(limit.Rate)),
+	); err != nil {
+		return limit, err
+	}
+	if limit.Enabled() && limit.Burst < 1 {
+		return limit, errors.Errorf("%s_BURST must be at least 1", prefix)
+	}
+	return limit, nil
+}
+
+// rateLimitBackendConfig populates configuration for the backend in which rate
+// limiter state is stored from environment variables.
+func rateLimitBackendConfig() (ratelimit.BackendConfig, error) {
+	config := ratelimit.BackendConfig{}
+	config.Type = ratelimit.BackendType(
+		os.GetEnvVar("RATE_LIMIT_BACKEND", string(ratelimit.BackendTypeMemory)),
+	)
+	switch config.Type {
+	case ratelimit.BackendTypeMemory:
+		return config, nil
+	case ratelimit.BackendTypeRedis:
+	default:
+		return config, errors.Errorf(
+			"RATE_LIMIT_BACKEND %q is invalid; valid values are %q and %q",
+			config.Type,
+			ratelimit.BackendTypeMemory,
+			ratelimit.BackendTypeRedis,
+		)
+	}
+	var err error
+	if config.RedisAddress, err =
+		os.GetRequiredEnvVar("RATE_LIMIT_REDIS_ADDRESS"); err != nil {
 		return config, err
 	}
-	if !exists {
-		return config, errors.Errorf("file %s does not exist", tokensPath)
+	config.RedisPassword = os.GetEnvVar("RATE_LIMIT_REDIS_PASSWORD", "")
+	config.RedisKeyPrefix = os.GetEnvVar(
+		"RATE_LIMIT_REDIS_KEY_PREFIX",
+		"brigade-cloudevents-gateway:ratelimit:",
+	)
+	return config, nil
+}
+
+// getFloatFromEnvVar attempts to parse a float from a string value retrieved
+// from the specified environment variable. An error is returned if the string
+// value cannot successfully be parsed as a float.
+func getFloatFromEnvVar(name string, defaultValue float64) (float64, error) {
+	valStr := os.GetEnvVar(name, "")
+	if valStr == "" {
+		return defaultValue, nil
 	}
-	tokenBytes, err := ioutil.ReadFile(tokensPath)
+	val, err := strconv.ParseFloat(valStr, 64)
+	if err != nil {
+		return 0, errors.Errorf(
+			"value %q for environment variable %s was not parsable as a float",
+			valStr,
+			name,
+		)
+	}
+	return val, nil
+}
+
+// webSocketHandlerConfig populates configuration for the WebSocket handler
+// from environment variables.
+func webSocketHandlerConfig() (ourCloudHTTP.WebSocketHandlerConfig, error) {
+	config := ourCloudHTTP.WebSocketHandlerConfig{}
+	var err error
+	config.IdleTimeout, err =
+		os.GetDurationFromEnvVar("WEBSOCKET_IDLE_TIMEOUT", 5*time.Minute)
 	if err != nil {
 		return config, err
 	}
-	plainTextTokens := map[string]string{}
-	if err :=
-		json.Unmarshal(tokenBytes, &plainTextTokens); err != nil {
+	config.MaxInFlight, err = os.GetIntFromEnvVar("WEBSOCKET_MAX_IN_FLIGHT", 10)
+	if err != nil {
 		return config, err
 	}
-	for _, token := range plainTextTokens {
-		config.AddToken(token)
+	maxMessageBytes, err :=
+		os.GetIntFromEnvVar("WEBSOCKET_MAX_MESSAGE_BYTES", 1024*1024)
+	config.MaxMessageBytes = int64(maxMessageBytes)
+	return config, err
+}
+
+// awsHandlerConfig populates configuration for the AWS handler from
+// environment variables.
+func awsHandlerConfig() (ourCloudHTTP.AWSHandlerConfig, error) {
+	config := ourCloudHTTP.AWSHandlerConfig{}
+	const key = "AWS_SNS_ALLOWED_TOPIC_ARNS"
+	for _, arn := range strings.Split(os.GetEnvVar(key, ""), ",") {
+		if arn = strings.TrimSpace(arn); arn == "" {
+			continue
+		}
+		if !strings.HasPrefix(arn, "arn:") {
+			return config, errors.Errorf("%s: %q is not a valid ARN", key, arn)
+		}
+		config.AllowedTopicARNs = append(config.AllowedTopicARNs, arn)
 	}
 	return config, nil
 }
 
+// mqttReceiverConfig populates configuration for the MQTT receiver from
+// environment variables.
+func mqttReceiverConfig() (mqtt.ReceiverConfig, error) {
+	config := mqtt.ReceiverConfig{}
+	brokerURLsStr, err := os.GetRequiredEnvVar("MQTT_BROKER_URLS")
+	if err != nil {
+		return config, err
+	}
+	for _, brokerURLStr := range strings.Split(brokerURLsStr, ",") {
+		brokerURL, err := url.Parse(strings.TrimSpace(brokerURLStr))
+		if err != nil {
+			return config, errors.Wrapf(
+				err,
+				"error parsing MQTT broker URL %q",
+				brokerURLStr,
+			)
+		}
+		config.BrokerURLs = append(config.BrokerURLs, brokerURL)
+	}
+	config.ClientID =
+		os.GetEnvVar("MQTT_CLIENT_ID", "brigade-cloudevents-gateway")
+	config.Username = os.GetEnvVar("MQTT_USERNAME", "")
+	config.Password = os.GetEnvVar("MQTT_PASSWORD", "")
+	subscriptionsBytes, source, err :=
+		getFileContentsFromEnvVar("MQTT_SUBSCRIPTIONS_PATH")
+	if err != nil {
+		return config, err
+	}
+	if err =
+		json.Unmarshal(subscriptionsBytes, &config.Subscriptions); err != nil {
+		return config, err
+	}
+	if len(config.Subscriptions) == 0 {
+		return config, errors.Errorf(
+			"%s does not define any MQTT subscriptions",
+			source,
+		)
+	}
+	for _, sub := range config.Subscriptions {
+		if sub.TopicFilter == "" {
+			return config, errors.New("MQTT subscription has no topic filter")
+		}
+		if sub.QoS > 2 {
+			return config, errors.Errorf(
+				"MQTT subscription to %q has invalid QoS %d",
+				sub.TopicFilter,
+				sub.QoS,
+			)
+		}
+	}
+	if config.Workers, err = os.GetIntFromEnvVar("MQTT_WORKERS", 10); err != nil {
+		return config, err
+	}
+	if config.Workers <= 0 {
+		return config, errors.New("MQTT_WORKERS must be greater than zero")
+	}
+	if config.MaxRetryDuration, err = os.GetDurationFromEnvVar(
+		"MQTT_MAX_RETRY_DURATION",
+		5*time.Minute,
+	); err != nil {
+		return config, err
+	}
+	if config.MaxRetryDuration < 0 {
+		return config, errors.New("MQTT_MAX_RETRY_DURATION may not be negative")
+	}
+	return config, nil
+}
+
+// grpcServerConfig populates configuration for the gRPC server from
+// environment variables. If GRPC_PORT is unset, the gRPC server is multiplexed
+// with the HTTP/S server and TLS settings are irrelevant because the HTTP/S
+// server terminates TLS.
+func grpcServerConfig() (ourCloudGRPC.ServerConfig, error) {
+	config := ourCloudGRPC.ServerConfig{}
+	var err error
+	config.Port, err = os.GetIntFromEnvVar("GRPC_PORT", 0)
+	if err != nil || config.Port == 0 {
+		return config, err
+	}
+	config.TLSEnabled, err = os.GetBoolFromEnvVar("TLS_ENABLED", false)
+	if err != nil {
+		return config, err
+	}
+	if config.TLSEnabled {
+		config.TLSCertPath, err = os.GetRequiredEnvVar("TLS_CERT_PATH")
+		if err != nil {
+			return config, err
+		}
+		config.TLSKeyPath, err = os.GetRequiredEnvVar("TLS_KEY_PATH")
+		if err != nil {
+			return config, err
+		}
+	}
+	return config, nil
+}
+
+// notifierConfig populates configuration for the lifecycle notifier from
+// environment variables.
+func notifierConfig() (notifications.NotifierConfig, error) {
+	config := notifications.NotifierConfig{}
+	projectIDsStr, err := os.GetRequiredEnvVar("NOTIFICATIONS_PROJECTS")
+	if err != nil {
+		return config, err
+	}
+	for _, projectID := range strings.Split(projectIDsStr, ",") {
+		if projectID = strings.TrimSpace(projectID); projectID != "" {
+			config.ProjectIDs = append(config.ProjectIDs, projectID)
+		}
+	}
+	config.PollInterval, err =
+		os.GetDurationFromEnvVar("NOTIFICATIONS_POLL_INTERVAL", 10*time.Second)
+	if err != nil {
+		return config, err
+	}
+	sinksBytes, source, err :=
+		getFileContentsFromEnvVar("NOTIFICATIONS_SINKS_PATH")
+	if err != nil {
+		return config, err
+	}
+	if err = json.Unmarshal(sinksBytes, &config.Sinks); err != nil {
+		return config, err
+	}
+	if len(config.Sinks) == 0 {
+		return config, errors.Errorf(
+			"%s does not define any notification sinks",
+			source,
+		)
+	}
+	for _, sink := range config.Sinks {
+		if _, err = url.ParseRequestURI(sink.URL); err != nil {
+			return config, errors.Wrapf(
+				err,
+				"error parsing notification sink URL %q",
+				sink.URL,
+			)
+		}
+		switch sink.Mode {
+		case "", notifications.SinkModeBinary, notifications.SinkModeStructured:
+		default:
+			return config, errors.Errorf(
+				"notification sink %q has invalid mode %q",
+				sink.URL,
+				sink.Mode,
+			)
+		}
+	}
+	return config, nil
+}
+
+// adminServerConfig populates configuration for the optional admin server from
+// environment variables. A port of 0 indicates that admin endpoints like
+// /metrics should be served by the main server instead.
+func adminServerConfig() (http.ServerConfig, error) {
+	config := http.ServerConfig{}
+	var err error
+	config.Port, err = os.GetIntFromEnvVar("ADMIN_PORT", 0)
+	return config, err
+}
+
+// readinessCheckTTL returns the length of time for which the result of a
+// Brigade API readiness check is reused before the API is checked again.
+func readinessCheckTTL() (time.Duration, error) {
+	return os.GetDurationFromEnvVar("READINESS_CHECK_TTL", 10*time.Second)
+}
+
 // serverConfig populates configuration for the HTTP/S server from environment
 // variables.
 func serverConfig() (http.ServerConfig, error) {
@@ -84,3 +914,32 @@ func serverConfig() (http.ServerConfig, error) {
 	}
 	return config, nil
 }
+
+// getFileContentsFromEnvVar returns the contents of the file indicated by the
+// specified environment variable, along with a description of their source
+// that is suitable for use in error messages. If the environment variable is
+// unset, but the configuration file defines the equivalent contents, those
+// are returned instead.
+func getFileContentsFromEnvVar(name string) ([]byte, string, error) {
+	if os.GetEnvVar(name, "") == "" {
+		if contents, ok := configFileContents[name]; ok {
+			return contents, "configuration file", nil
+		}
+	}
+	path, err := os.GetRequiredEnvVar(name)
+	if err != nil {
+		return nil, "", err
+	}
+	var exists bool
+	if exists, err = file.Exists(path); err != nil {
+		return nil, "", err
+	}
+	if !exists {
+		return nil, "", errors.Errorf("file %s does not exist", path)
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	return contents, "file " + path, nil
+}
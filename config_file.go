@@ -0,0 +1,510 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	stdOS "os"
+	"strconv"
+	"strings"
+	"time"
+
+	ourCloudHTTP "github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/http" // nolint: lll
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/mqtt"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/notifications"
+	"github.com/brigadecore/brigade-foundations/file"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// configFileVersion is the only version of the configuration file format
+// currently supported.
+const configFileVersion = "v1"
+
+// configFileContents maps the names of environment variables that indicate the
+// paths of files (e.g. TOKENS_PATH) to equivalent contents defined by the
+// configuration file. It is populated by loadConfigFile.
+var configFileContents = map[string][]byte{}
+
+// configFile is the format of the configuration file. Every setting is
+// optional and is equivalent to one or more environment variables. Settings
+// equivalent to the contents of a file (e.g. tokens) are decoded generically
+// and checked against the format of that file.
+type configFile struct {
+	Version       string                `yaml:"version"`
+	Log           *logSection           `yaml:"log"`
+	Server        *serverSection        `yaml:"server"`
+	Shutdown      *shutdownSection      `yaml:"shutdown"`
+	Brigade       *brigadeSection       `yaml:"brigade"`
+	Tokens        interface{}           `yaml:"tokens"`
+	TokenSource   *tokenSourceSection   `yaml:"tokenSource"`
+	TokenExpiry   *tokenExpirySection   `yaml:"tokenExpiry"`
+	ClientIP      *clientIPSection      `yaml:"clientIP"`
+	Tenants       interface{}           `yaml:"tenants"`
+	Routing       interface{}           `yaml:"routing"`
+	Enrichment    *enrichmentSection    `yaml:"enrichment"`
+	Promotion     *promotionSection     `yaml:"promotion"`
+	RateLimit     *rateLimitSection     `yaml:"rateLimit"`
+	Audit         *auditSection         `yaml:"audit"`
+	Tracing       *tracingSection       `yaml:"tracing"`
+	WebSocket     *webSocketSection     `yaml:"webSocket"`
+	EventGrid     *eventGridSection     `yaml:"eventGrid"`
+	AWS           *awsSection           `yaml:"aws"`
+	GRPC          *grpcSection          `yaml:"grpc"`
+	MQTT          *mqttSection          `yaml:"mqtt"`
+	Notifications *notificationsSection `yaml:"notifications"`
+}
+
+type logSection struct {
+	Level  *string `yaml:"level"`
+	Format *string `yaml:"format"`
+}
+
+type serverSection struct {
+	Port              *int           `yaml:"port"`
+	TLS               *tlsSection    `yaml:"tls"`
+	AdminPort         *int           `yaml:"adminPort"`
+	ReadinessCheckTTL *time.Duration `yaml:"readinessCheckTTL"`
+}
+
+type tlsSection struct {
+	Enabled  *bool   `yaml:"enabled"`
+	CertPath *string `yaml:"certPath"`
+	KeyPath  *string `yaml:"keyPath"`
+}
+
+type shutdownSection struct {
+	Delay       *time.Duration `yaml:"delay"`
+	GracePeriod *time.Duration `yaml:"gracePeriod"`
+}
+
+type brigadeSection struct {
+	APIAddress            *string        `yaml:"apiAddress"`
+	APIToken              *string        `yaml:"apiToken"`
+	APIIgnoreCertWarnings *bool          `yaml:"apiIgnoreCertWarnings"`
+	APIMaxInFlight        *int           `yaml:"apiMaxInFlight"`
+	APIMaxQueued          *int           `yaml:"apiMaxQueued"`
+	APIQueueTimeout       *time.Duration `yaml:"apiQueueTimeout"`
+}
+
+type tokenSourceSection struct {
+	Type            *string                       `yaml:"type"`
+	DirectoryPath   *string                       `yaml:"directoryPath"`
+	RefreshInterval *time.Duration                `yaml:"refreshInterval"`
+	Kubernetes      *tokenSourceKubernetesSection `yaml:"kubernetes"`
+}
+
+type tokenSourceKubernetesSection struct {
+	Namespace     *string `yaml:"namespace"`
+	LabelSelector *string `yaml:"labelSelector"`
+}
+
+type tokenExpirySection struct {
+	WarningPeriod *time.Duration `yaml:"warningPeriod"`
+	CheckInterval *time.Duration `yaml:"checkInterval"`
+}
+
+type clientIPSection struct {
+	TrustedProxies  []string `yaml:"trustedProxies"`
+	AllowedNetworks []string `yaml:"allowedNetworks"`
+	DeniedNetworks  []string `yaml:"deniedNetworks"`
+}
+
+type enrichmentSection struct {
+	PodName     *string  `yaml:"podName"`
+	ClusterName *string  `yaml:"clusterName"`
+	Labels      []string `yaml:"labels"`
+	Qualifiers  []string `yaml:"qualifiers"`
+	Extensions  []string `yaml:"extensions"`
+}
+
+type promotionSection struct {
+	Qualifiers []string `yaml:"qualifiers"`
+	Labels     []string `yaml:"labels"`
+}
+
+type rateLimitSection struct {
+	Enabled *bool         `yaml:"enabled"`
+	Key     *string       `yaml:"key"`
+	PerKey  *limitSection `yaml:"perKey"`
+	Global  *limitSection `yaml:"global"`
+	Backend *string       `yaml:"backend"`
+	Redis   *redisSection `yaml:"redis"`
+}
+
+type limitSection struct {
+	Rate  *float64 `yaml:"rate"`
+	Burst *int     `yaml:"burst"`
+}
+
+type redisSection struct {
+	Address   *string `yaml:"address"`
+	Password  *string `yaml:"password"`
+	KeyPrefix *string `yaml:"keyPrefix"`
+}
+
+type auditSection struct {
+	Enabled *bool             `yaml:"enabled"`
+	Output  *string           `yaml:"output"`
+	File    *auditFileSection `yaml:"file"`
+}
+
+type auditFileSection struct {
+	Path       *string `yaml:"path"`
+	MaxSizeMB  *int    `yaml:"maxSizeMB"`
+	MaxBackups *int    `yaml:"maxBackups"`
+	MaxAgeDays *int    `yaml:"maxAgeDays"`
+}
+
+type tracingSection struct {
+	Enabled *bool `yaml:"enabled"`
+}
+
+type webSocketSection struct {
+	Enabled         *bool          `yaml:"enabled"`
+	IdleTimeout     *time.Duration `yaml:"idleTimeout"`
+	MaxInFlight     *int           `yaml:"maxInFlight"`
+	MaxMessageBytes *int           `yaml:"maxMessageBytes"`
+}
+
+type eventGridSection struct {
+	Enabled *bool `yaml:"enabled"`
+}
+
+type awsSection struct {
+	Enabled          *bool    `yaml:"enabled"`
+	AllowedTopicARNs []string `yaml:"allowedTopicARNs"`
+}
+
+type grpcSection struct {
+	Enabled *bool `yaml:"enabled"`
+	Port    *int  `yaml:"port"`
+}
+
+type mqttSection struct {
+	Enabled       *bool       `yaml:"enabled"`
+	BrokerURLs    []string    `yaml:"brokerURLs"`
+	ClientID      *string     `yaml:"clientID"`
+	Username      *string     `yaml:"username"`
+	Password      *string     `yaml:"password"`
+	Subscriptions interface{} `yaml:"subscriptions"`
+}
+
+type notificationsSection struct {
+	Enabled      *bool          `yaml:"enabled"`
+	Projects     []string       `yaml:"projects"`
+	PollInterval *time.Duration `yaml:"pollInterval"`
+	Sinks        interface{}    `yaml:"sinks"`
+}
+
+// loadConfigFile loads the configuration file at the specified path. Its
+// settings are applied to the environment, except where the equivalent
+// environment variables are already set, so that environment variables always
+// take precedence. Settings equivalent to the contents of a file are applied
+// to configFileContents on the same basis.
+func loadConfigFile(path string) error {
+	exists, err := file.Exists(path)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.Errorf("file %s does not exist", path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	config, err := parseConfigFile(data)
+	if err != nil {
+		return errors.Wrapf(err, "error loading configuration file %s", path)
+	}
+	envVars, contents, err := config.settings()
+	if err != nil {
+		return errors.Wrapf(err, "error loading configuration file %s", path)
+	}
+	for name, value := range envVars {
+		if stdOS.Getenv(name) == "" {
+			if err = stdOS.Setenv(name, value); err != nil {
+				return err
+			}
+		}
+	}
+	for name, value := range contents {
+		if stdOS.Getenv(name) == "" {
+			configFileContents[name] = value
+		}
+	}
+	return nil
+}
+
+// parseConfigFile parses the provided configuration file contents. An error is
+// returned if the contents are not valid YAML, specify an unsupported version,
+// or contain any unknown or malformed settings.
+func parseConfigFile(data []byte) (*configFile, error) {
+	config := &configFile{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil {
+		if err == io.EOF {
+			return nil, errors.New("configuration file is empty")
+		}
+		return nil, err
+	}
+	if config.Version == "" {
+		return nil, errors.Errorf(
+			"version is not specified; the current version is %q",
+			configFileVersion,
+		)
+	}
+	if config.Version != configFileVersion {
+		return nil, errors.Errorf(
+			"version %q is unsupported; the only supported version is %q",
+			config.Version,
+			configFileVersion,
+		)
+	}
+	return config, nil
+}
+
+// settings returns the values of the environment variables equivalent to the
+// configuration file's settings, indexed by name, and the file contents
+// equivalent to the configuration file's settings, indexed by the name of the
+// environment variable indicating the file's path. Settings that are not
+// specified are omitted. An error is returned if any setting equivalent to
+// the contents of a file does not match the format of that file.
+func (c *configFile) settings() (map[string]string, map[string][]byte, error) {
+	env := configEnv{}
+	contents := map[string][]byte{}
+	addContents := func(
+		envVar string,
+		section string,
+		value interface{},
+		format interface{},
+	) error {
+		if value == nil {
+			return nil
+		}
+		data, err := jsonFromYAML(value, format)
+		if err != nil {
+			return errors.Wrapf(err, "%s is invalid", section)
+		}
+		contents[envVar] = data
+		return nil
+	}
+	if err := addContents(
+		"TOKENS_PATH",
+		"tokens",
+		c.Tokens,
+		&map[string]ourCloudHTTP.Token{},
+	); err != nil {
+		return nil, nil, err
+	}
+	if err := addContents(
+		"TENANTS_PATH",
+		"tenants",
+		c.Tenants,
+		&map[string]tenantConfig{},
+	); err != nil {
+		return nil, nil, err
+	}
+	if c.Tenants != nil {
+		env["TENANTS_ENABLED"] = "true"
+	}
+	if err := addContents(
+		"ROUTING_PATH",
+		"routing",
+		c.Routing,
+		&routingConfig{},
+	); err != nil {
+		return nil, nil, err
+	}
+	if c.Routing != nil {
+		env["ROUTING_ENABLED"] = "true"
+	}
+	if l := c.Log; l != nil {
+		env.setString("LOG_LEVEL", l.Level)
+		env.setString("LOG_FORMAT", l.Format)
+	}
+	if s := c.Server; s != nil {
+		env.setInt("PORT", s.Port)
+		env.setInt("ADMIN_PORT", s.AdminPort)
+		env.setDuration("READINESS_CHECK_TTL", s.ReadinessCheckTTL)
+		if t := s.TLS; t != nil {
+			env.setBool("TLS_ENABLED", t.Enabled)
+			env.setString("TLS_CERT_PATH", t.CertPath)
+			env.setString("TLS_KEY_PATH", t.KeyPath)
+		}
+	}
+	if s := c.Shutdown; s != nil {
+		env.setDuration("SHUTDOWN_DELAY", s.Delay)
+		env.setDuration("SHUTDOWN_GRACE_PERIOD", s.GracePeriod)
+	}
+	if b := c.Brigade; b != nil {
+		env.setString("API_ADDRESS", b.APIAddress)
+		env.setString("API_TOKEN", b.APIToken)
+		env.setBool("API_IGNORE_CERT_WARNINGS", b.APIIgnoreCertWarnings)
+		env.setInt("API_MAX_IN_FLIGHT", b.APIMaxInFlight)
+		env.setInt("API_MAX_QUEUED", b.APIMaxQueued)
+		env.setDuration("API_QUEUE_TIMEOUT", b.APIQueueTimeout)
+	}
+	if t := c.TokenSource; t != nil {
+		env.setString("TOKENS_SOURCE", t.Type)
+		env.setString("TOKENS_DIRECTORY_PATH", t.DirectoryPath)
+		env.setDuration("TOKENS_REFRESH_INTERVAL", t.RefreshInterval)
+		if k := t.Kubernetes; k != nil {
+			env.setString("TOKENS_KUBERNETES_NAMESPACE", k.Namespace)
+			env.setString("TOKENS_KUBERNETES_LABEL_SELECTOR", k.LabelSelector)
+		}
+	}
+	if t := c.TokenExpiry; t != nil {
+		env.setDuration("TOKEN_EXPIRY_WARNING_PERIOD", t.WarningPeriod)
+		env.setDuration("TOKEN_EXPIRY_CHECK_INTERVAL", t.CheckInterval)
+	}
+	if ip := c.ClientIP; ip != nil {
+		env.setList("TRUSTED_PROXIES", ip.TrustedProxies)
+		env.setList("IP_ALLOWED_NETWORKS", ip.AllowedNetworks)
+		env.setList("IP_DENIED_NETWORKS", ip.DeniedNetworks)
+	}
+	if e := c.Enrichment; e != nil {
+		env.setString("POD_NAME", e.PodName)
+		env.setString("CLUSTER_NAME", e.ClusterName)
+		env.setList("ENRICHMENT_LABELS", e.Labels)
+		env.setList("ENRICHMENT_QUALIFIERS", e.Qualifiers)
+		env.setList("ENRICHMENT_EXTENSIONS", e.Extensions)
+	}
+	if p := c.Promotion; p != nil {
+		env.setList("PROMOTED_QUALIFIERS", p.Qualifiers)
+		env.setList("PROMOTED_LABELS", p.Labels)
+	}
+	if r := c.RateLimit; r != nil {
+		env.setBool("RATE_LIMIT_ENABLED", r.Enabled)
+		env.setString("RATE_LIMIT_KEY", r.Key)
+		env.setLimit("RATE_LIMIT_PER_KEY", r.PerKey)
+		env.setLimit("RATE_LIMIT_GLOBAL", r.Global)
+		env.setString("RATE_LIMIT_BACKEND", r.Backend)
+		if redis := r.Redis; redis != nil {
+			env.setString("RATE_LIMIT_REDIS_ADDRESS", redis.Address)
+			env.setString("RATE_LIMIT_REDIS_PASSWORD", redis.Password)
+			env.setString("RATE_LIMIT_REDIS_KEY_PREFIX", redis.KeyPrefix)
+		}
+	}
+	if a := c.Audit; a != nil {
+		env.setBool("AUDIT_ENABLED", a.Enabled)
+		env.setString("AUDIT_OUTPUT", a.Output)
+		if f := a.File; f != nil {
+			env.setString("AUDIT_FILE_PATH", f.Path)
+			env.setInt("AUDIT_FILE_MAX_SIZE_MB", f.MaxSizeMB)
+			env.setInt("AUDIT_FILE_MAX_BACKUPS", f.MaxBackups)
+			env.setInt("AUDIT_FILE_MAX_AGE_DAYS", f.MaxAgeDays)
+		}
+	}
+	if t := c.Tracing; t != nil {
+		env.setBool("TRACING_ENABLED", t.Enabled)
+	}
+	if w := c.WebSocket; w != nil {
+		env.setBool("WEBSOCKET_ENABLED", w.Enabled)
+		env.setDuration("WEBSOCKET_IDLE_TIMEOUT", w.IdleTimeout)
+		env.setInt("WEBSOCKET_MAX_IN_FLIGHT", w.MaxInFlight)
+		env.setInt("WEBSOCKET_MAX_MESSAGE_BYTES", w.MaxMessageBytes)
+	}
+	if e := c.EventGrid; e != nil {
+		env.setBool("EVENT_GRID_ENABLED", e.Enabled)
+	}
+	if a := c.AWS; a != nil {
+		env.setBool("AWS_ENABLED", a.Enabled)
+		env.setList("AWS_SNS_ALLOWED_TOPIC_ARNS", a.AllowedTopicARNs)
+	}
+	if g := c.GRPC; g != nil {
+		env.setBool("GRPC_ENABLED", g.Enabled)
+		env.setInt("GRPC_PORT", g.Port)
+	}
+	if m := c.MQTT; m != nil {
+		env.setBool("MQTT_ENABLED", m.Enabled)
+		env.setList("MQTT_BROKER_URLS", m.BrokerURLs)
+		env.setString("MQTT_CLIENT_ID", m.ClientID)
+		env.setString("MQTT_USERNAME", m.Username)
+		env.setString("MQTT_PASSWORD", m.Password)
+		if err := addContents(
+			"MQTT_SUBSCRIPTIONS_PATH",
+			"mqtt.subscriptions",
+			m.Subscriptions,
+			&[]mqtt.Subscription{},
+		); err != nil {
+			return nil, nil, err
+		}
+	}
+	if n := c.Notifications; n != nil {
+		env.setBool("NOTIFICATIONS_ENABLED", n.Enabled)
+		env.setList("NOTIFICATIONS_PROJECTS", n.Projects)
+		env.setDuration("NOTIFICATIONS_POLL_INTERVAL", n.PollInterval)
+		if err := addContents(
+			"NOTIFICATIONS_SINKS_PATH",
+			"notifications.sinks",
+			n.Sinks,
+			&[]notifications.Sink{},
+		); err != nil {
+			return nil, nil, err
+		}
+	}
+	return env, contents, nil
+}
+
+// jsonFromYAML converts a value decoded generically from YAML to JSON. An
+// error is returned if the JSON cannot be decoded into the provided format
+// without encountering unknown fields or values of the wrong type.
+func jsonFromYAML(value interface{}, format interface{}) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(format); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// configEnv maps the names of environment variables to their values.
+type configEnv map[string]string
+
+func (c configEnv) setString(name string, value *string) {
+	if value != nil {
+		c[name] = *value
+	}
+}
+
+func (c configEnv) setBool(name string, value *bool) {
+	if value != nil {
+		c[name] = strconv.FormatBool(*value)
+	}
+}
+
+func (c configEnv) setInt(name string, value *int) {
+	if value != nil {
+		c[name] = strconv.Itoa(*value)
+	}
+}
+
+func (c configEnv) setDuration(name string, value *time.Duration) {
+	if value != nil {
+		c[name] = value.String()
+	}
+}
+
+func (c configEnv) setList(name string, values []string) {
+	if values != nil {
+		c[name] = strings.Join(values, ",")
+	}
+}
+
+func (c configEnv) setLimit(prefix string, limit *limitSection) {
+	if limit == nil {
+		return
+	}
+	if limit.Rate != nil {
+		c[prefix+"_RATE"] = strconv.FormatFloat(*limit.Rate, 'f', -1, 64)
+	}
+	c.setInt(prefix+"_BURST", limit.Burst)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"io/ioutil"
+	stdOS "os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfigFile(t *testing.T) {
+	testCases := []struct {
+		name       string
+		data       string
+		assertions func(*configFile, error)
+	}{
+		{
+			name: "empty",
+			assertions: func(_ *configFile, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "configuration file is empty")
+			},
+		},
+		{
+			name: "not valid YAML",
+			data: "version: [",
+			assertions: func(_ *configFile, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "yaml:")
+			},
+		},
+		{
+			name: "version not specified",
+			data: "log:\n  level: debug\n",
+			assertions: func(_ *configFile, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "version is not specified")
+			},
+		},
+		{
+			name: "unsupported version",
+			data: "version: v0\n",
+			assertions: func(_ *configFile, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `version "v0" is unsupported`)
+			},
+		},
+		{
+			name: "unknown field",
+			data: "version: v1\nserver:\n  prot: 8080\n",
+			assertions: func(_ *configFile, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "line 3")
+				require.Contains(t, err.Error(), "field prot not found")
+			},
+		},
+		{
+			name: "value of the wrong type",
+			data: "version: v1\nserver:\n  port: eighty\n",
+			assertions: func(_ *configFile, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "line 3")
+				require.Contains(t, err.Error(), "cannot unmarshal")
+			},
+		},
+		{
+			name: "success",
+			data: "version: v1\nserver:\n  port: 8443\n",
+			assertions: func(config *configFile, err error) {
+				require.NoError(t, err)
+				require.Equal(t, configFileVersion, config.Version)
+				require.NotNil(t, config.Server)
+				require.Equal(t, 8443, *config.Server.Port)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			config, err := parseConfigFile([]byte(testCase.data))
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestConfigFileSettings(t *testing.T) {
+	testCases := []struct {
+		name       string
+		data       string
+		assertions func(map[string]string, map[string][]byte, error)
+	}{
+		{
+			name: "tokens are invalid",
+			data: "version: v1\ntokens:\n  foo: [bar]\n",
+			assertions: func(_ map[string]string, _ map[string][]byte, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "tokens is invalid")
+			},
+		},
+		{
+			name: "tenants contain an unknown field",
+			data: "version: v1\ntenants:\n  italian:\n    tokenz: {}\n",
+			assertions: func(_ map[string]string, _ map[string][]byte, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "tenants is invalid")
+				require.Contains(t, err.Error(), `unknown field "tokenz"`)
+			},
+		},
+		{
+			name: "success",
+			data: `version: v1
+log:
+  level: debug
+server:
+  port: 8443
+  tls:
+    enabled: true
+    certPath: /app/certs/tls.crt
+    keyPath: /app/certs/tls.key
+shutdown:
+  gracePeriod: 1m
+brigade:
+  apiAddress: https://brigade.example.com
+  apiMaxInFlight: 10
+promotion:
+  labels:
+  - subject
+  - repo
+rateLimit:
+  enabled: true
+  perKey:
+    rate: 2.5
+    burst: 5
+tokens:
+  foo: bar
+  bat:
+    token: baz
+    expiresAt: 2030-01-01T00:00:00Z
+tokenSource:
+  refreshInterval: 30s
+tokenExpiry:
+  warningPeriod: 72h
+clientIP:
+  trustedProxies:
+  - 10.0.0.0/8
+tenants:
+  italian:
+    tokens:
+      bat: baz
+eventGrid:
+  enabled: true
+aws:
+  enabled: true
+  allowedTopicARNs:
+  - arn:aws:sns:us-east-1:123456789012:foo
+mqtt:
+  enabled: true
+  subscriptions:
+  - topicFilter: devices/+/events
+    qos: 1
+`,
+			assertions: func(
+				env map[string]string,
+				contents map[string][]byte,
+				err error,
+			) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					map[string]string{
+						"LOG_LEVEL":                   "debug",
+						"PORT":                        "8443",
+						"TLS_ENABLED":                 "true",
+						"TLS_CERT_PATH":               "/app/certs/tls.crt",
+						"TLS_KEY_PATH":                "/app/certs/tls.key",
+						"SHUTDOWN_GRACE_PERIOD":       "1m0s",
+						"API_ADDRESS":                 "https://brigade.example.com",
+						"API_MAX_IN_FLIGHT":           "10",
+						"PROMOTED_LABELS":             "subject,repo",
+						"RATE_LIMIT_ENABLED":          "true",
+						"RATE_LIMIT_PER_KEY_RATE":     "2.5",
+						"RATE_LIMIT_PER_KEY_BURST":    "5",
+						"TOKENS_REFRESH_INTERVAL":     "30s",
+						"TOKEN_EXPIRY_WARNING_PERIOD": "72h0m0s",
+						"TRUSTED_PROXIES":             "10.0.0.0/8",
+						"TENANTS_ENABLED":             "true",
+						"EVENT_GRID_ENABLED":          "true",
+						"AWS_ENABLED":                 "true",
+						"AWS_SNS_ALLOWED_TOPIC_ARNS":  "arn:aws:sns:us-east-1:123456789012:foo", // nolint: lll
+						"MQTT_ENABLED":                "true",
+					},
+					env,
+				)
+				require.Len(t, contents, 3)
+				require.JSONEq(
+					t,
+					`{"foo":"bar","bat":{"token":"baz","expiresAt":"2030-01-01T00:00:00Z"}}`, // nolint: lll
+					string(contents["TOKENS_PATH"]),
+				)
+				require.JSONEq(
+					t,
+					`{"italian":{"tokens":{"bat":"baz"}}}`,
+					string(contents["TENANTS_PATH"]),
+				)
+				require.JSONEq(
+					t,
+					`[{"topicFilter":"devices/+/events","qos":1}]`,
+					string(contents["MQTT_SUBSCRIPTIONS_PATH"]),
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			config, err := parseConfigFile([]byte(testCase.data))
+			require.NoError(t, err)
+			env, contents, err := config.settings()
+			testCase.assertions(env, contents, err)
+		})
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	t.Cleanup(func() {
+		configFileContents = map[string][]byte{}
+	})
+	// Registers these to be restored after the test
+	t.Setenv("LOG_FORMAT", "")
+	t.Setenv("TOKENS_PATH", "")
+	t.Setenv("TENANTS_PATH", "")
+	t.Setenv("TENANTS_ENABLED", "")
+	// Environment variables take precedence over the configuration file
+	t.Setenv("LOG_LEVEL", "warn")
+	t.Setenv("TENANTS_PATH", "/app/config/tenants.json")
+	configFile, err := ioutil.TempFile("", "config.yaml")
+	require.NoError(t, err)
+	defer configFile.Close()
+	_, err = configFile.Write(
+		[]byte(`version: v1
+log:
+  level: debug
+  format: console
+tokens:
+  foo: bar
+tenants:
+  italian:
+    tokens:
+      bat: baz
+`),
+	)
+	require.NoError(t, err)
+	require.NoError(t, loadConfigFile(configFile.Name()))
+	require.Equal(t, "warn", stdOS.Getenv("LOG_LEVEL"))
+	require.Equal(t, "console", stdOS.Getenv("LOG_FORMAT"))
+	require.Equal(t, "true", stdOS.Getenv("TENANTS_ENABLED"))
+	require.Contains(t, configFileContents, "TOKENS_PATH")
+	require.NotContains(t, configFileContents, "TENANTS_PATH")
+	// Contents defined by the configuration file are used in place of a file
+	tokenBytes, source, err := getFileContentsFromEnvVar("TOKENS_PATH")
+	require.NoError(t, err)
+	require.Equal(t, "configuration file", source)
+	require.JSONEq(t, `{"foo":"bar"}`, string(tokenBytes))
+}
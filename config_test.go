@@ -1,13 +1,27 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"testing"
+	"time"
 
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents"
+	ourCloudGRPC "github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/grpc" // nolint: lll
 	ourCloudHTTP "github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/http" // nolint: lll
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/mqtt"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/notifications"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/ratelimit"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/shutdown"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/tokens"
 	"github.com/brigadecore/brigade-foundations/http"
 	"github.com/brigadecore/brigade/sdk/v3/restmachinery"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
 )
 
 // Note that unit testing in Go does NOT clear environment variables between
@@ -15,95 +29,2074 @@ import (
 // test functions uses a series of test cases that cumulatively build upon one
 // another.
 
+func TestLoggerConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(logging.Config, error)
+	}{
+		{
+			name: "defaults",
+			assertions: func(config logging.Config, err error) {
+				require.NoError(t, err)
+				require.Equal(t, zapcore.InfoLevel, config.Level)
+				require.Equal(t, logging.FormatJSON, config.Format)
+			},
+		},
+		{
+			name: "LOG_LEVEL invalid",
+			setup: func() {
+				t.Setenv("LOG_LEVEL", "loud")
+			},
+			assertions: func(_ logging.Config, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "error parsing LOG_LEVEL")
+			},
+		},
+		{
+			name: "LOG_FORMAT invalid",
+			setup: func() {
+				t.Setenv("LOG_LEVEL", "debug")
+				t.Setenv("LOG_FORMAT", "xml")
+			},
+			assertions: func(_ logging.Config, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `LOG_FORMAT "xml" is invalid`)
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("LOG_FORMAT", "text")
+			},
+			assertions: func(config logging.Config, err error) {
+				require.NoError(t, err)
+				require.Equal(t, zapcore.DebugLevel, config.Level)
+				require.Equal(t, logging.FormatText, config.Format)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := loggerConfig()
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestShutdownConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(shutdown.Config, error)
+	}{
+		{
+			name: "defaults",
+			assertions: func(config shutdown.Config, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					shutdown.Config{GracePeriod: 25 * time.Second},
+					config,
+				)
+			},
+		},
+		{
+			name: "SHUTDOWN_DELAY not parsable as a duration",
+			setup: func() {
+				t.Setenv("SHUTDOWN_DELAY", "foo")
+			},
+			assertions: func(_ shutdown.Config, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a duration")
+				require.Contains(t, err.Error(), "SHUTDOWN_DELAY")
+			},
+		},
+		{
+			name: "SHUTDOWN_DELAY negative",
+			setup: func() {
+				t.Setenv("SHUTDOWN_DELAY", "-1s")
+			},
+			assertions: func(_ shutdown.Config, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "must not be negative")
+				require.Contains(t, err.Error(), "SHUTDOWN_DELAY")
+			},
+		},
+		{
+			name: "SHUTDOWN_GRACE_PERIOD not parsable as a duration",
+			setup: func() {
+				t.Setenv("SHUTDOWN_DELAY", "5s")
+				t.Setenv("SHUTDOWN_GRACE_PERIOD", "foo")
+			},
+			assertions: func(_ shutdown.Config, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a duration")
+				require.Contains(t, err.Error(), "SHUTDOWN_GRACE_PERIOD")
+			},
+		},
+		{
+			name: "SHUTDOWN_GRACE_PERIOD negative",
+			setup: func() {
+				t.Setenv("SHUTDOWN_GRACE_PERIOD", "-1s")
+			},
+			assertions: func(_ shutdown.Config, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "must not be negative")
+				require.Contains(t, err.Error(), "SHUTDOWN_GRACE_PERIOD")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("SHUTDOWN_GRACE_PERIOD", "1m")
+			},
+			assertions: func(config shutdown.Config, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					shutdown.Config{
+						Delay:       5 * time.Second,
+						GracePeriod: time.Minute,
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := shutdownConfig()
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestEnricherConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(cloudevents.EnricherConfig, error)
+	}{
+		{
+			name: "defaults",
+			assertions: func(config cloudevents.EnricherConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(t, cloudevents.EnricherConfig{}, config)
+			},
+		},
+		{
+			name: "ENRICHMENT_LABELS invalid",
+			setup: func() {
+				t.Setenv("ENRICHMENT_LABELS", "gatewaypod,foo")
+			},
+			assertions: func(_ cloudevents.EnricherConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "ENRICHMENT_LABELS")
+				require.Contains(t, err.Error(), `"foo" is invalid`)
+			},
+		},
+		{
+			name: "ENRICHMENT_QUALIFIERS invalid",
+			setup: func() {
+				t.Setenv("ENRICHMENT_LABELS", "gatewaypod, gatewayversion")
+				t.Setenv("ENRICHMENT_QUALIFIERS", "foo")
+			},
+			assertions: func(_ cloudevents.EnricherConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "ENRICHMENT_QUALIFIERS")
+				require.Contains(t, err.Error(), `"foo" is invalid`)
+			},
+		},
+		{
+			name: "ENRICHMENT_EXTENSIONS invalid",
+			setup: func() {
+				t.Setenv("ENRICHMENT_QUALIFIERS", "gatewaycluster")
+				t.Setenv("ENRICHMENT_EXTENSIONS", "foo")
+			},
+			assertions: func(_ cloudevents.EnricherConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "ENRICHMENT_EXTENSIONS")
+				require.Contains(t, err.Error(), `"foo" is invalid`)
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("ENRICHMENT_EXTENSIONS", "gatewaysender,gatewayreceivedat,")
+				t.Setenv("POD_NAME", "gateway-abcde")
+				t.Setenv("CLUSTER_NAME", "prod")
+			},
+			assertions: func(config cloudevents.EnricherConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					cloudevents.EnricherConfig{
+						Labels: []cloudevents.EnrichmentField{
+							cloudevents.EnrichmentFieldPod,
+							cloudevents.EnrichmentFieldVersion,
+						},
+						Qualifiers: []cloudevents.EnrichmentField{
+							cloudevents.EnrichmentFieldCluster,
+						},
+						Extensions: []cloudevents.EnrichmentField{
+							cloudevents.EnrichmentFieldSender,
+							cloudevents.EnrichmentFieldReceivedAt,
+						},
+						PodName:     "gateway-abcde",
+						ClusterName: "prod",
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := enricherConfig()
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestPromoterConfig(t *testing.T) {
+	require.Equal(t, cloudevents.PromoterConfig{}, promoterConfig())
+	t.Setenv("PROMOTED_QUALIFIERS", "Repo, environment,")
+	t.Setenv("PROMOTED_LABELS", "subject")
+	require.Equal(
+		t,
+		cloudevents.PromoterConfig{
+			Qualifiers: []string{"repo", "environment"},
+			Labels:     []string{"subject"},
+		},
+		promoterConfig(),
+	)
+}
+
+func TestConcurrencyLimiterConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(cloudevents.ConcurrencyLimiterConfig, error)
+	}{
+		{
+			name: "defaults",
+			assertions: func(config cloudevents.ConcurrencyLimiterConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					cloudevents.ConcurrencyLimiterConfig{
+						MaxQueued:    100,
+						QueueTimeout: 5 * time.Second,
+					},
+					config,
+				)
+			},
+		},
+		{
+			name: "API_MAX_IN_FLIGHT not an int",
+			setup: func() {
+				t.Setenv("API_MAX_IN_FLIGHT", "foo")
+			},
+			assertions: func(_ cloudevents.ConcurrencyLimiterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as an int")
+				require.Contains(t, err.Error(), "API_MAX_IN_FLIGHT")
+			},
+		},
+		{
+			name: "API_MAX_QUEUED not an int",
+			setup: func() {
+				t.Setenv("API_MAX_IN_FLIGHT", "10")
+				t.Setenv("API_MAX_QUEUED", "foo")
+			},
+			assertions: func(_ cloudevents.ConcurrencyLimiterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as an int")
+				require.Contains(t, err.Error(), "API_MAX_QUEUED")
+			},
+		},
+		{
+			name: "API_QUEUE_TIMEOUT not parsable as a duration",
+			setup: func() {
+				t.Setenv("API_MAX_QUEUED", "50")
+				t.Setenv("API_QUEUE_TIMEOUT", "foo")
+			},
+			assertions: func(_ cloudevents.ConcurrencyLimiterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a duration")
+				require.Contains(t, err.Error(), "API_QUEUE_TIMEOUT")
+			},
+		},
+		{
+			name: "API_QUEUE_TIMEOUT negative",
+			setup: func() {
+				t.Setenv("API_QUEUE_TIMEOUT", "-1s")
+			},
+			assertions: func(_ cloudevents.ConcurrencyLimiterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "must not be negative")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("API_QUEUE_TIMEOUT", "1s")
+			},
+			assertions: func(config cloudevents.ConcurrencyLimiterConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					cloudevents.ConcurrencyLimiterConfig{
+						MaxInFlight:  10,
+						MaxQueued:    50,
+						QueueTimeout: time.Second,
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := concurrencyLimiterConfig()
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestAuditSinkConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(audit.SinkConfig, error)
+	}{
+		{
+			name: "defaults",
+			assertions: func(config audit.SinkConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(t, audit.SinkConfig{Output: audit.OutputStdout}, config)
+			},
+		},
+		{
+			name: "AUDIT_OUTPUT invalid",
+			setup: func() {
+				t.Setenv("AUDIT_OUTPUT", "syslog")
+			},
+			assertions: func(_ audit.SinkConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `AUDIT_OUTPUT "syslog" is invalid`)
+			},
+		},
+		{
+			name: "AUDIT_FILE_PATH not set",
+			setup: func() {
+				t.Setenv("AUDIT_OUTPUT", "file")
+			},
+			assertions: func(_ audit.SinkConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "value not found for")
+				require.Contains(t, err.Error(), "AUDIT_FILE_PATH")
+			},
+		},
+		{
+			name: "AUDIT_FILE_MAX_SIZE_MB not an int",
+			setup: func() {
+				t.Setenv("AUDIT_FILE_PATH", "/var/log/audit/audit.log")
+				t.Setenv("AUDIT_FILE_MAX_SIZE_MB", "foo")
+			},
+			assertions: func(_ audit.SinkConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as an int")
+				require.Contains(t, err.Error(), "AUDIT_FILE_MAX_SIZE_MB")
+			},
+		},
+		{
+			name: "AUDIT_FILE_MAX_BACKUPS not an int",
+			setup: func() {
+				t.Setenv("AUDIT_FILE_MAX_SIZE_MB", "10")
+				t.Setenv("AUDIT_FILE_MAX_BACKUPS", "foo")
+			},
+			assertions: func(_ audit.SinkConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as an int")
+				require.Contains(t, err.Error(), "AUDIT_FILE_MAX_BACKUPS")
+			},
+		},
+		{
+			name: "AUDIT_FILE_MAX_AGE_DAYS not an int",
+			setup: func() {
+				t.Setenv("AUDIT_FILE_MAX_BACKUPS", "5")
+				t.Setenv("AUDIT_FILE_MAX_AGE_DAYS", "foo")
+			},
+			assertions: func(_ audit.SinkConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as an int")
+				require.Contains(t, err.Error(), "AUDIT_FILE_MAX_AGE_DAYS")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("AUDIT_FILE_MAX_AGE_DAYS", "365")
+			},
+			assertions: func(config audit.SinkConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					audit.SinkConfig{
+						Output:         audit.OutputFile,
+						FilePath:       "/var/log/audit/audit.log",
+						FileMaxSizeMB:  10,
+						FileMaxBackups: 5,
+						FileMaxAgeDays: 365,
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := auditSinkConfig()
+			testCase.assertions(config, err)
+		})
+	}
+}
+
 func TestAPIClientConfig(t *testing.T) {
 	testCases := []struct {
 		name       string
 		setup      func()
-		assertions func(
-			address string,
-			token string,
-			opts restmachinery.APIClientOptions,
-			err error,
-		)
+		assertions func(
+			address string,
+			token string,
+			opts restmachinery.APIClientOptions,
+			err error,
+		)
+	}{
+		{
+			name:  "API_ADDRESS not set",
+			setup: func() {},
+			assertions: func(
+				_ string,
+				_ string,
+				_ restmachinery.APIClientOptions,
+				err error,
+			) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "value not found for")
+				require.Contains(t, err.Error(), "API_ADDRESS")
+			},
+		},
+		{
+			name: "API_TOKEN not set",
+			setup: func() {
+				t.Setenv("API_ADDRESS", "foo")
+			},
+			assertions: func(
+				_ string,
+				_ string,
+				_ restmachinery.APIClientOptions,
+				err error,
+			) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "value not found for")
+				require.Contains(t, err.Error(), "API_TOKEN")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("API_TOKEN", "bar")
+				t.Setenv("API_IGNORE_CERT_WARNINGS", "true")
+			},
+			assertions: func(
+				address string,
+				token string,
+				opts restmachinery.APIClientOptions,
+				err error,
+			) {
+				require.NoError(t, err)
+				require.Equal(t, "foo", address)
+				require.Equal(t, "bar", token)
+				require.True(t, opts.AllowInsecureConnections)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.setup()
+			address, token, opts, err := apiClientConfig()
+			testCase.assertions(address, token, opts, err)
+		})
+	}
+}
+
+func TestRouterConfig(t *testing.T) {
+	writeRoutingFile := func(contents string) {
+		routingFile, err := ioutil.TempFile("", "routing.json")
+		require.NoError(t, err)
+		defer routingFile.Close()
+		_, err = routingFile.Write([]byte(contents))
+		require.NoError(t, err)
+		t.Setenv("ROUTING_PATH", routingFile.Name())
+	}
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(cloudevents.RouterConfig, error)
+	}{
+		{
+			name: "ROUTING_PATH not set",
+			assertions: func(_ cloudevents.RouterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "value not found for")
+				require.Contains(t, err.Error(), "ROUTING_PATH")
+			},
+		},
+		{
+			name: "ROUTING_PATH path does not exist",
+			setup: func() {
+				t.Setenv("ROUTING_PATH", "/completely/bogus/path")
+			},
+			assertions: func(_ cloudevents.RouterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					"file /completely/bogus/path does not exist",
+				)
+			},
+		},
+		{
+			name: "ROUTING_PATH does not contain valid json",
+			setup: func() {
+				writeRoutingFile("this is not json")
+			},
+			assertions: func(_ cloudevents.RouterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "invalid character")
+			},
+		},
+		{
+			name: "backend has no address",
+			setup: func() {
+				writeRoutingFile(`{"backends":{"staging":{"token":"foo"}}}`)
+			},
+			assertions: func(_ cloudevents.RouterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `backend "staging" has no address`)
+			},
+		},
+		{
+			name: "backend has no token",
+			setup: func() {
+				writeRoutingFile(
+					`{"backends":{"staging":{"address":"https://staging"}}}`,
+				)
+			},
+			assertions: func(_ cloudevents.RouterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `backend "staging" has no token`)
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				writeRoutingFile(
+					`{
+						"backends": {
+							"staging": {
+								"address": "https://staging",
+								"token": "foo",
+								"ignoreCertWarnings": true
+							}
+						},
+						"routes": [
+							{
+								"tenants": ["italian"],
+								"sources": ["example/*"],
+								"types": ["example.deploy"],
+								"backends": ["staging", "default"]
+							}
+						]
+					}`,
+				)
+			},
+			assertions: func(config cloudevents.RouterConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					cloudevents.RouterConfig{
+						Backends: map[string]cloudevents.BackendConfig{
+							"staging": {
+								Address: "https://staging",
+								Token:   "foo",
+								ClientOptions: restmachinery.APIClientOptions{
+									AllowInsecureConnections: true,
+								},
+							},
+						},
+						Routes: []cloudevents.Route{
+							{
+								Tenants:  []string{"italian"},
+								Sources:  []string{"example/*"},
+								Types:    []string{"example.deploy"},
+								Backends: []string{"staging", "default"},
+							},
+						},
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := routerConfig()
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestTokenSourceConfig(t *testing.T) {
+	t.Cleanup(func() {
+		configFileContents = map[string][]byte{}
+	})
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(tokens.SourceConfig, error)
+	}{
+		{
+			name: "TOKENS_SOURCE invalid",
+			setup: func() {
+				t.Setenv("TOKENS_SOURCE", "carrier-pigeon")
+			},
+			assertions: func(_ tokens.SourceConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `TOKENS_SOURCE "carrier-pigeon"`)
+			},
+		},
+		{
+			name: "file with TOKENS_PATH not set",
+			setup: func() {
+				t.Setenv("TOKENS_SOURCE", "file")
+			},
+			assertions: func(_ tokens.SourceConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "value not found for")
+				require.Contains(t, err.Error(), "TOKENS_PATH")
+			},
+		},
+		{
+			name: "file with TOKENS_REFRESH_INTERVAL not parsable as a duration",
+			setup: func() {
+				t.Setenv("TOKENS_PATH", "/app/config/tokens.json")
+				t.Setenv("TOKENS_REFRESH_INTERVAL", "foo")
+			},
+			assertions: func(_ tokens.SourceConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a duration")
+				require.Contains(t, err.Error(), "TOKENS_REFRESH_INTERVAL")
+			},
+		},
+		{
+			name: "file",
+			setup: func() {
+				t.Setenv("TOKENS_REFRESH_INTERVAL", "")
+			},
+			assertions: func(config tokens.SourceConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					tokens.SourceConfig{
+						Type:            tokens.SourceTypeFile,
+						Path:            "/app/config/tokens.json",
+						RefreshInterval: time.Minute,
+					},
+					config,
+				)
+			},
+		},
+		{
+			name: "file from configuration file",
+			setup: func() {
+				t.Setenv("TOKENS_PATH", "")
+				configFileContents["TOKENS_PATH"] = []byte(`{"foo":"bar"}`)
+			},
+			assertions: func(config tokens.SourceConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					tokens.SourceConfig{
+						Type:     tokens.SourceTypeFile,
+						Contents: []byte(`{"foo":"bar"}`),
+					},
+					config,
+				)
+			},
+		},
+		{
+			name: "directory with TOKENS_DIRECTORY_PATH not set",
+			setup: func() {
+				t.Setenv("TOKENS_SOURCE", "directory")
+			},
+			assertions: func(_ tokens.SourceConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "value not found for")
+				require.Contains(t, err.Error(), "TOKENS_DIRECTORY_PATH")
+			},
+		},
+		{
+			name: "directory",
+			setup: func() {
+				t.Setenv("TOKENS_DIRECTORY_PATH", "/app/tokens")
+				t.Setenv("TOKENS_REFRESH_INTERVAL", "10s")
+			},
+			assertions: func(config tokens.SourceConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					tokens.SourceConfig{
+						Type:            tokens.SourceTypeDirectory,
+						Path:            "/app/tokens",
+						RefreshInterval: 10 * time.Second,
+					},
+					config,
+				)
+			},
+		},
+		{
+			name: "kubernetes with TOKENS_KUBERNETES_LABEL_SELECTOR not set",
+			setup: func() {
+				t.Setenv("TOKENS_SOURCE", "kubernetes")
+			},
+			assertions: func(_ tokens.SourceConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "value not found for")
+				require.Contains(t, err.Error(), "TOKENS_KUBERNETES_LABEL_SELECTOR")
+			},
+		},
+		{
+			name: "kubernetes",
+			setup: func() {
+				t.Setenv("TOKENS_KUBERNETES_NAMESPACE", "gateway")
+				t.Setenv(
+					"TOKENS_KUBERNETES_LABEL_SELECTOR",
+					"cloudevents.brigade.sh/token=true",
+				)
+			},
+			assertions: func(config tokens.SourceConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					tokens.SourceConfig{
+						Type:                    tokens.SourceTypeKubernetes,
+						KubernetesNamespace:     "gateway",
+						KubernetesLabelSelector: "cloudevents.brigade.sh/token=true",
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := tokenSourceConfig()
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestTenantTokenSource(t *testing.T) {
+	t.Cleanup(func() {
+		configFileContents = map[string][]byte{}
+	})
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(tokens.Source, error)
+	}{
+		{
+			name: "TENANTS_PATH not set",
+			assertions: func(_ tokens.Source, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "value not found for")
+				require.Contains(t, err.Error(), "TENANTS_PATH")
+			},
+		},
+		{
+			name: "TOKENS_REFRESH_INTERVAL not parsable as a duration",
+			setup: func() {
+				t.Setenv("TENANTS_PATH", "/app/config/tenants.json")
+				t.Setenv("TOKENS_REFRESH_INTERVAL", "foo")
+			},
+			assertions: func(_ tokens.Source, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a duration")
+				require.Contains(t, err.Error(), "TOKENS_REFRESH_INTERVAL")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("TOKENS_REFRESH_INTERVAL", "1s")
+			},
+			assertions: func(source tokens.Source, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					tokens.NewTenantFileSource(
+						"/app/config/tenants.json",
+						nil,
+						"italian",
+						time.Second,
+					),
+					source,
+				)
+			},
+		},
+		{
+			name: "configuration file",
+			setup: func() {
+				t.Setenv("TENANTS_PATH", "")
+				configFileContents["TENANTS_PATH"] = []byte(`{}`)
+			},
+			assertions: func(source tokens.Source, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					tokens.NewTenantFileSource("", []byte(`{}`), "italian", 0),
+					source,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			source, err := tenantTokenSource("italian")
+			testCase.assertions(source, err)
+		})
+	}
+}
+
+func TestTokenFilterConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(ourCloudHTTP.TokenFilterConfig, error)
+	}{
+		{
+			name: "TOKENS_PATH not set",
+			assertions: func(_ ourCloudHTTP.TokenFilterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "value not found for")
+				require.Contains(t, err.Error(), "TOKENS_PATH")
+			},
+		},
+		{
+			name: "TOKENS_PATH path does not exist",
+			setup: func() {
+				t.Setenv("TOKENS_PATH", "/completely/bogus/path")
+			},
+			assertions: func(_ ourCloudHTTP.TokenFilterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					"file /completely/bogus/path does not exist",
+				)
+			},
+		},
+		{
+			name: "TOKENS_PATH does not contain valid json",
+			setup: func() {
+				tokensFile, err := ioutil.TempFile("", "tokens.json")
+				require.NoError(t, err)
+				defer tokensFile.Close()
+				_, err = tokensFile.Write([]byte("this is not json"))
+				require.NoError(t, err)
+				t.Setenv("TOKENS_PATH", tokensFile.Name())
+			},
+			assertions: func(_ ourCloudHTTP.TokenFilterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t, err.Error(), "invalid character",
+				)
+			},
+		},
+		{
+			name: "TOKENS_PATH contains an invalid hash",
+			setup: func() {
+				tokensFile, err := ioutil.TempFile("", "tokens.json")
+				require.NoError(t, err)
+				defer tokensFile.Close()
+				_, err = tokensFile.Write([]byte(`{"foo": "$scrypt$ln=15"}`))
+				require.NoError(t, err)
+				t.Setenv("TOKENS_PATH", tokensFile.Name())
+			},
+			assertions: func(_ ourCloudHTTP.TokenFilterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `error adding token "foo"`)
+				require.Contains(t, err.Error(), "invalid scrypt hash")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				hash, err := ourCloudHTTP.HashToken(ourCloudHTTP.TokenHashBcrypt, "baz")
+				require.NoError(t, err)
+				tokensFile, err := ioutil.TempFile("", "tokens.json")
+				require.NoError(t, err)
+				defer tokensFile.Close()
+				_, err = tokensFile.Write(
+					[]byte(fmt.Sprintf(`{"foo": "bar", "bat": %q}`, hash)),
+				)
+				require.NoError(t, err)
+				t.Setenv("TOKENS_PATH", tokensFile.Name())
+			},
+			assertions: func(config ourCloudHTTP.TokenFilterConfig, err error) {
+				require.NoError(t, err)
+				name, err := config.Authenticate(context.Background(), "bar", nil)
+				require.NoError(t, err)
+				require.Equal(t, "foo", name)
+				name, err = config.Authenticate(context.Background(), "baz", nil)
+				require.NoError(t, err)
+				require.Equal(t, "bat", name)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, _, err := tokenFilterConfig(context.Background())
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestTokenExpiryMonitorConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(ourCloudHTTP.TokenExpiryMonitorConfig, error)
+	}{
+		{
+			name: "defaults",
+			assertions: func(
+				config ourCloudHTTP.TokenExpiryMonitorConfig,
+				err error,
+			) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					ourCloudHTTP.TokenExpiryMonitorConfig{
+						WarningPeriod: 7 * 24 * time.Hour,
+						CheckInterval: time.Hour,
+					},
+					config,
+				)
+			},
+		},
+		{
+			name: "TOKEN_EXPIRY_WARNING_PERIOD not parsable as a duration",
+			setup: func() {
+				t.Setenv("TOKEN_EXPIRY_WARNING_PERIOD", "foo")
+			},
+			assertions: func(_ ourCloudHTTP.TokenExpiryMonitorConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a duration")
+				require.Contains(t, err.Error(), "TOKEN_EXPIRY_WARNING_PERIOD")
+			},
+		},
+		{
+			name: "TOKEN_EXPIRY_CHECK_INTERVAL not parsable as a duration",
+			setup: func() {
+				t.Setenv("TOKEN_EXPIRY_WARNING_PERIOD", "24h")
+				t.Setenv("TOKEN_EXPIRY_CHECK_INTERVAL", "foo")
+			},
+			assertions: func(_ ourCloudHTTP.TokenExpiryMonitorConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a duration")
+				require.Contains(t, err.Error(), "TOKEN_EXPIRY_CHECK_INTERVAL")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("TOKEN_EXPIRY_WARNING_PERIOD", "24h")
+				t.Setenv("TOKEN_EXPIRY_CHECK_INTERVAL", "5m")
+			},
+			assertions: func(
+				config ourCloudHTTP.TokenExpiryMonitorConfig,
+				err error,
+			) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					ourCloudHTTP.TokenExpiryMonitorConfig{
+						WarningPeriod: 24 * time.Hour,
+						CheckInterval: 5 * time.Minute,
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := tokenExpiryMonitorConfig()
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestTenantRouterConfig(t *testing.T) {
+	routing := cloudevents.RouterConfig{
+		Backends: map[string]cloudevents.BackendConfig{
+			"staging": {
+				Address: "https://brigade.staging.example.com",
+				Token:   "staging",
+			},
+			"production": {
+				Address: "https://brigade.prod.example.com",
+				Token:   "production",
+			},
+		},
+		Routes: []cloudevents.Route{
+			{
+				Tenants:  []string{"ital*"},
+				Backends: []string{"default", "production"},
+			},
+			{
+				Tenants:  []string{"mexican"},
+				Backends: []string{"staging"},
+			},
+			{
+				Sources:  []string{"example/*"},
+				Backends: []string{"staging"},
+			},
+		},
+	}
+	testCases := []struct {
+		name       string
+		tenant     tenantConfig
+		assertions func(cloudevents.RouterConfig, error)
+	}{
+		{
+			name: "tenant has no API token for a backend",
+			tenant: tenantConfig{
+				BackendAPITokens: map[string]string{"production": "italian-prod"},
+			},
+			assertions: func(_ cloudevents.RouterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					`tenant "italian" has no API token for backend "staging", to `+
+						"which route 2",
+				)
+			},
+		},
+		{
+			name: "success",
+			tenant: tenantConfig{
+				BackendAPITokens: map[string]string{
+					"production": "italian-prod",
+					"staging":    "italian-staging",
+				},
+			},
+			assertions: func(config cloudevents.RouterConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					cloudevents.RouterConfig{
+						Backends: map[string]cloudevents.BackendConfig{
+							"staging": {
+								Address: "https://brigade.staging.example.com",
+								Token:   "italian-staging",
+							},
+							"production": {
+								Address: "https://brigade.prod.example.com",
+								Token:   "italian-prod",
+							},
+						},
+						// The route for another tenant is omitted
+						Routes: []cloudevents.Route{
+							routing.Routes[0],
+							routing.Routes[2],
+						},
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			config, err := tenantRouterConfig(routing, "italian", testCase.tenant)
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestTrustedProxiesConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func([]*net.IPNet, error)
+	}{
+		{
+			name: "TRUSTED_PROXIES not set",
+			assertions: func(networks []*net.IPNet, err error) {
+				require.NoError(t, err)
+				require.Empty(t, networks)
+			},
+		},
+		{
+			name: "TRUSTED_PROXIES invalid",
+			setup: func() {
+				t.Setenv("TRUSTED_PROXIES", "10.0.0.0/33")
+			},
+			assertions: func(_ []*net.IPNet, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "error parsing TRUSTED_PROXIES")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.1,")
+			},
+			assertions: func(networks []*net.IPNet, err error) {
+				require.NoError(t, err)
+				require.Len(t, networks, 2)
+				require.Equal(t, "10.0.0.0/8", networks[0].String())
+				require.Equal(t, "192.0.2.1/32", networks[1].String())
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			networks, err := trustedProxiesConfig()
+			testCase.assertions(networks, err)
+		})
+	}
+}
+
+func TestIPRulesConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(ourCloudHTTP.IPRules, error)
+	}{
+		{
+			name: "no networks",
+			assertions: func(rules ourCloudHTTP.IPRules, err error) {
+				require.NoError(t, err)
+				require.True(t, rules.Empty())
+			},
+		},
+		{
+			name: "IP_ALLOWED_NETWORKS invalid",
+			setup: func() {
+				t.Setenv("IP_ALLOWED_NETWORKS", "foo")
+			},
+			assertions: func(_ ourCloudHTTP.IPRules, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "error parsing IP_ALLOWED_NETWORKS")
+			},
+		},
+		{
+			name: "IP_DENIED_NETWORKS invalid",
+			setup: func() {
+				t.Setenv("IP_ALLOWED_NETWORKS", "10.0.0.0/8")
+				t.Setenv("IP_DENIED_NETWORKS", "foo")
+			},
+			assertions: func(_ ourCloudHTTP.IPRules, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "error parsing IP_DENIED_NETWORKS")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("IP_DENIED_NETWORKS", "10.0.0.1")
+			},
+			assertions: func(rules ourCloudHTTP.IPRules, err error) {
+				require.NoError(t, err)
+				require.Len(t, rules.Allowed, 1)
+				require.Equal(t, "10.0.0.0/8", rules.Allowed[0].String())
+				require.Len(t, rules.Denied, 1)
+				require.Equal(t, "10.0.0.1/32", rules.Denied[0].String())
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			rules, err := ipRulesConfig()
+			testCase.assertions(rules, err)
+		})
+	}
+}
+
+func TestTenantsConfig(t *testing.T) {
+	writeTenantsFile := func(contents string) {
+		tenantsFile, err := ioutil.TempFile("", "tenants.json")
+		require.NoError(t, err)
+		defer tenantsFile.Close()
+		_, err = tenantsFile.Write([]byte(contents))
+		require.NoError(t, err)
+		t.Setenv("TENANTS_PATH", tenantsFile.Name())
+	}
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(map[string]tenantConfig, error)
+	}{
+		{
+			name: "TENANTS_PATH not set",
+			assertions: func(_ map[string]tenantConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "value not found for")
+				require.Contains(t, err.Error(), "TENANTS_PATH")
+			},
+		},
+		{
+			name: "TENANTS_PATH path does not exist",
+			setup: func() {
+				t.Setenv("TENANTS_PATH", "/completely/bogus/path")
+			},
+			assertions: func(_ map[string]tenantConfig, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					"file /completely/bogus/path does not exist",
+				)
+			},
+		},
+		{
+			name: "TENANTS_PATH does not contain valid json",
+			setup: func() {
+				writeTenantsFile("this is not json")
+			},
+			assertions: func(_ map[string]tenantConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "invalid character")
+			},
+		},
+		{
+			name: "TENANTS_PATH defines no tenants",
+			setup: func() {
+				writeTenantsFile("{}")
+			},
+			assertions: func(_ map[string]tenantConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "does not define any tenants")
+			},
+		},
+		{
+			name: "invalid tenant name",
+			setup: func() {
+				writeTenantsFile(`{"Italian":{"tokens":{"foo":"bar"}}}`)
+			},
+			assertions: func(_ map[string]tenantConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `tenant name "Italian" is invalid`)
+			},
+		},
+		{
+			name: "tenant has no tokens",
+			setup: func() {
+				writeTenantsFile(`{"italian":{}}`)
+			},
+			assertions: func(_ map[string]tenantConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `tenant "italian" has no tokens`)
+			},
+		},
+		{
+			name: "tenant has an invalid token hash",
+			setup: func() {
+				writeTenantsFile(`{"italian":{"tokens":{"foo":"$argon2id$v=19"}}}`)
+			},
+			assertions: func(_ map[string]tenantConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `tenant "italian"`)
+				require.Contains(t, err.Error(), "invalid argon2id hash")
+			},
+		},
+		{
+			name: "tenant has no API token",
+			setup: func() {
+				t.Setenv("API_TOKEN", "default")
+				writeTenantsFile(`{"italian":{"tokens":{"foo":"bar"}}}`)
+			},
+			assertions: func(_ map[string]tenantConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `tenant "italian" has no API token`)
+			},
+		},
+		{
+			name: "tenant overrides a reserved qualifier",
+			setup: func() {
+				writeTenantsFile(
+					`{
+						"italian": {
+							"tokens": {"foo": "bar"},
+							"apiToken": "spaghetti",
+							"qualifiers": {"source": "x"}
+						}
+					}`,
+				)
+			},
+			assertions: func(_ map[string]tenantConfig, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					`tenant "italian" may not override qualifier "source"`,
+				)
+			},
+		},
+		{
+			name: "tenant has an invalid qualifier",
+			setup: func() {
+				writeTenantsFile(
+					`{
+						"italian": {
+							"tokens": {"foo": "bar"},
+							"apiToken": "spaghetti",
+							"qualifiers": {"tenant": "italian,mexican"}
+						}
+					}`,
+				)
+			},
+			assertions: func(_ map[string]tenantConfig, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					`tenant "italian" has invalid qualifier: value of "tenant"`,
+				)
+			},
+		},
+		{
+			name: "tenant has an invalid label",
+			setup: func() {
+				writeTenantsFile(
+					`{
+						"italian": {
+							"tokens": {"foo": "bar"},
+							"apiToken": "spaghetti",
+							"labels": {"team name": "pasta"}
+						}
+					}`,
+				)
+			},
+			assertions: func(_ map[string]tenantConfig, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					`tenant "italian" has invalid label: key "team name" is invalid`,
+				)
+			},
+		},
+		{
+			name: "tenant promotes an attribute to a qualifier it sets",
+			setup: func() {
+				writeTenantsFile(
+					`{
+						"italian": {
+							"tokens": {"foo": "bar"},
+							"apiToken": "spaghetti",
+							"qualifiers": {"tenant": "italian"},
+							"promotedQualifiers": [" Tenant "]
+						}
+					}`,
+				)
+			},
+			assertions: func(_ map[string]tenantConfig, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					`tenant "italian" may not promote CloudEvent attribute "tenant" `+
+						"to a qualifier",
+				)
+			},
+		},
+		{
+			name: "tenant promotes an attribute to a label it sets",
+			setup: func() {
+				writeTenantsFile(
+					`{
+						"italian": {
+							"tokens": {"foo": "bar"},
+							"apiToken": "spaghetti",
+							"labels": {"team": "pasta"},
+							"promotedLabels": ["team"]
+						}
+					}`,
+				)
+			},
+			assertions: func(_ map[string]tenantConfig, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					`tenant "italian" may not promote CloudEvent attribute "team" `+
+						"to a label",
+				)
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				writeTenantsFile(
+					`{
+						"italian": {
+							"tokens": {"foo": "bar"},
+							"apiToken": "spaghetti",
+							"allowedSources": ["example/*"],
+							"qualifiers": {"tenant": "italian"},
+							"labels": {"team": "pasta"},
+							"promotedQualifiers": [" Subject "],
+							"promotedLabels": ["repo"]
+						},
+						"mexican": {
+							"tokens": {"bat": "baz"},
+							"apiToken": "tacos"
+						}
+					}`,
+				)
+			},
+			assertions: func(tenants map[string]tenantConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					map[string]tenantConfig{
+						"italian": {
+							Tokens: map[string]ourCloudHTTP.Token{
+								"foo": {Value: "bar"},
+							},
+							APIToken:           "spaghetti",
+							AllowedSources:     []string{"example/*"},
+							Qualifiers:         map[string]string{"tenant": "italian"},
+							Labels:             map[string]string{"team": "pasta"},
+							PromotedQualifiers: []string{"subject"},
+							PromotedLabels:     []string{"repo"},
+						},
+						"mexican": {
+							Tokens: map[string]ourCloudHTTP.Token{
+								"bat": {Value: "baz"},
+							},
+							APIToken: "tacos",
+						},
+					},
+					tenants,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			tenants, err := tenantsConfig()
+			testCase.assertions(tenants, err)
+		})
+	}
+}
+
+func TestRateLimitFilterConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(ourCloudHTTP.RateLimitFilterConfig, error)
+	}{
+		{
+			name: "defaults",
+			assertions: func(config ourCloudHTTP.RateLimitFilterConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					ourCloudHTTP.RateLimitFilterConfig{
+						Key: ourCloudHTTP.RateLimitKeySender,
+					},
+					config,
+				)
+			},
+		},
+		{
+			name: "RATE_LIMIT_KEY invalid",
+			setup: func() {
+				t.Setenv("RATE_LIMIT_KEY", "subject")
+			},
+			assertions: func(_ ourCloudHTTP.RateLimitFilterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `RATE_LIMIT_KEY "subject" is invalid`)
+			},
+		},
+		{
+			name: "RATE_LIMIT_PER_KEY_RATE not parsable as a float",
+			setup: func() {
+				t.Setenv("RATE_LIMIT_KEY", "source")
+				t.Setenv("RATE_LIMIT_PER_KEY_RATE", "foo")
+			},
+			assertions: func(_ ourCloudHTTP.RateLimitFilterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a float")
+				require.Contains(t, err.Error(), "RATE_LIMIT_PER_KEY_RATE")
+			},
+		},
+		{
+			name: "RATE_LIMIT_PER_KEY_RATE negative",
+			setup: func() {
+				t.Setenv("RATE_LIMIT_PER_KEY_RATE", "-1")
+			},
+			assertions: func(_ ourCloudHTTP.RateLimitFilterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					"RATE_LIMIT_PER_KEY_RATE must not be negative",
+				)
+			},
+		},
+		{
+			name: "RATE_LIMIT_PER_KEY_BURST not an int",
+			setup: func() {
+				t.Setenv("RATE_LIMIT_PER_KEY_RATE", "0.5")
+				t.Setenv("RATE_LIMIT_PER_KEY_BURST", "foo")
+			},
+			assertions: func(_ ourCloudHTTP.RateLimitFilterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as an int")
+				require.Contains(t, err.Error(), "RATE_LIMIT_PER_KEY_BURST")
+			},
+		},
+		{
+			name: "RATE_LIMIT_PER_KEY_BURST less than 1",
+			setup: func() {
+				t.Setenv("RATE_LIMIT_PER_KEY_BURST", "0")
+			},
+			assertions: func(_ ourCloudHTTP.RateLimitFilterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					"RATE_LIMIT_PER_KEY_BURST must be at least 1",
+				)
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				// The global burst should default to the rate, rounded up
+				t.Setenv("RATE_LIMIT_PER_KEY_BURST", "5")
+				t.Setenv("RATE_LIMIT_GLOBAL_RATE", "99.5")
+			},
+			assertions: func(config ourCloudHTTP.RateLimitFilterConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					ourCloudHTTP.RateLimitFilterConfig{
+						Key:         ourCloudHTTP.RateLimitKeySource,
+						PerKeyLimit: ratelimit.Limit{Rate: 0.5, Burst: 5},
+						GlobalLimit: ratelimit.Limit{Rate: 99.5, Burst: 100},
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := rateLimitFilterConfig()
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestRateLimitBackendConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(ratelimit.BackendConfig, error)
+	}{
+		{
+			name: "defaults",
+			assertions: func(config ratelimit.BackendConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					ratelimit.BackendConfig{Type: ratelimit.BackendTypeMemory},
+					config,
+				)
+			},
+		},
+		{
+			name: "RATE_LIMIT_BACKEND invalid",
+			setup: func() {
+				t.Setenv("RATE_LIMIT_BACKEND", "etcd")
+			},
+			assertions: func(_ ratelimit.BackendConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `RATE_LIMIT_BACKEND "etcd" is invalid`)
+			},
+		},
+		{
+			name: "RATE_LIMIT_REDIS_ADDRESS not set",
+			setup: func() {
+				t.Setenv("RATE_LIMIT_BACKEND", "redis")
+			},
+			assertions: func(_ ratelimit.BackendConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "value not found for")
+				require.Contains(t, err.Error(), "RATE_LIMIT_REDIS_ADDRESS")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("RATE_LIMIT_REDIS_ADDRESS", "redis:6379")
+				t.Setenv("RATE_LIMIT_REDIS_PASSWORD", "foo")
+			},
+			assertions: func(config ratelimit.BackendConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					ratelimit.BackendConfig{
+						Type:           ratelimit.BackendTypeRedis,
+						RedisAddress:   "redis:6379",
+						RedisPassword:  "foo",
+						RedisKeyPrefix: "brigade-cloudevents-gateway:ratelimit:",
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := rateLimitBackendConfig()
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestWebSocketHandlerConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(ourCloudHTTP.WebSocketHandlerConfig, error)
+	}{
+		{
+			name:  "defaults",
+			setup: func() {},
+			assertions: func(config ourCloudHTTP.WebSocketHandlerConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					ourCloudHTTP.WebSocketHandlerConfig{
+						IdleTimeout:     5 * time.Minute,
+						MaxInFlight:     10,
+						MaxMessageBytes: 1024 * 1024,
+					},
+					config,
+				)
+			},
+		},
+		{
+			name: "WEBSOCKET_IDLE_TIMEOUT not a duration",
+			setup: func() {
+				t.Setenv("WEBSOCKET_IDLE_TIMEOUT", "foo")
+			},
+			assertions: func(_ ourCloudHTTP.WebSocketHandlerConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a duration")
+				require.Contains(t, err.Error(), "WEBSOCKET_IDLE_TIMEOUT")
+			},
+		},
+		{
+			name: "WEBSOCKET_MAX_IN_FLIGHT not an int",
+			setup: func() {
+				t.Setenv("WEBSOCKET_IDLE_TIMEOUT", "1m")
+				t.Setenv("WEBSOCKET_MAX_IN_FLIGHT", "foo")
+			},
+			assertions: func(_ ourCloudHTTP.WebSocketHandlerConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as an int")
+				require.Contains(t, err.Error(), "WEBSOCKET_MAX_IN_FLIGHT")
+			},
+		},
+		{
+			name: "WEBSOCKET_MAX_MESSAGE_BYTES not an int",
+			setup: func() {
+				t.Setenv("WEBSOCKET_MAX_IN_FLIGHT", "5")
+				t.Setenv("WEBSOCKET_MAX_MESSAGE_BYTES", "foo")
+			},
+			assertions: func(_ ourCloudHTTP.WebSocketHandlerConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as an int")
+				require.Contains(t, err.Error(), "WEBSOCKET_MAX_MESSAGE_BYTES")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("WEBSOCKET_MAX_MESSAGE_BYTES", "2048")
+			},
+			assertions: func(config ourCloudHTTP.WebSocketHandlerConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					ourCloudHTTP.WebSocketHandlerConfig{
+						IdleTimeout:     time.Minute,
+						MaxInFlight:     5,
+						MaxMessageBytes: 2048,
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.setup()
+			config, err := webSocketHandlerConfig()
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestAWSHandlerConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(ourCloudHTTP.AWSHandlerConfig, error)
+	}{
+		{
+			name:  "defaults",
+			setup: func() {},
+			assertions: func(config ourCloudHTTP.AWSHandlerConfig, err error) {
+				require.NoError(t, err)
+				require.Empty(t, config.AllowedTopicARNs)
+			},
+		},
+		{
+			name: "AWS_SNS_ALLOWED_TOPIC_ARNS invalid",
+			setup: func() {
+				t.Setenv("AWS_SNS_ALLOWED_TOPIC_ARNS", "foo")
+			},
+			assertions: func(_ ourCloudHTTP.AWSHandlerConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is not a valid ARN")
+				require.Contains(t, err.Error(), "AWS_SNS_ALLOWED_TOPIC_ARNS")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv(
+					"AWS_SNS_ALLOWED_TOPIC_ARNS",
+					"arn:aws:sns:us-east-1:123456789012:foo, "+
+						"arn:aws:sns:us-east-1:123456789012:bar",
+				)
+			},
+			assertions: func(config ourCloudHTTP.AWSHandlerConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					[]string{
+						"arn:aws:sns:us-east-1:123456789012:foo",
+						"arn:aws:sns:us-east-1:123456789012:bar",
+					},
+					config.AllowedTopicARNs,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.setup()
+			config, err := awsHandlerConfig()
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestMQTTReceiverConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(mqtt.ReceiverConfig, error)
 	}{
 		{
-			name:  "API_ADDRESS not set",
-			setup: func() {},
-			assertions: func(
-				_ string,
-				_ string,
-				_ restmachinery.APIClientOptions,
-				err error,
-			) {
+			name: "MQTT_BROKER_URLS not set",
+			assertions: func(_ mqtt.ReceiverConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "value not found for")
+				require.Contains(t, err.Error(), "MQTT_BROKER_URLS")
+			},
+		},
+		{
+			name: "MQTT_BROKER_URLS contains an invalid URL",
+			setup: func() {
+				t.Setenv("MQTT_BROKER_URLS", "mqtt://broker:1883,:bogus")
+			},
+			assertions: func(_ mqtt.ReceiverConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "error parsing MQTT broker URL")
+			},
+		},
+		{
+			name: "MQTT_SUBSCRIPTIONS_PATH not set",
+			setup: func() {
+				t.Setenv("MQTT_BROKER_URLS", "mqtt://broker:1883")
+			},
+			assertions: func(_ mqtt.ReceiverConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "value not found for")
+				require.Contains(t, err.Error(), "MQTT_SUBSCRIPTIONS_PATH")
+			},
+		},
+		{
+			name: "MQTT_SUBSCRIPTIONS_PATH path does not exist",
+			setup: func() {
+				t.Setenv("MQTT_SUBSCRIPTIONS_PATH", "/completely/bogus/path")
+			},
+			assertions: func(_ mqtt.ReceiverConfig, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					"file /completely/bogus/path does not exist",
+				)
+			},
+		},
+		{
+			name: "MQTT_SUBSCRIPTIONS_PATH does not contain valid json",
+			setup: func() {
+				subsFile, err := ioutil.TempFile("", "subscriptions.json")
+				require.NoError(t, err)
+				defer subsFile.Close()
+				_, err = subsFile.Write([]byte("this is not json"))
+				require.NoError(t, err)
+				t.Setenv("MQTT_SUBSCRIPTIONS_PATH", subsFile.Name())
+			},
+			assertions: func(_ mqtt.ReceiverConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "invalid character")
+			},
+		},
+		{
+			name: "MQTT_SUBSCRIPTIONS_PATH defines no subscriptions",
+			setup: func() {
+				subsFile, err := ioutil.TempFile("", "subscriptions.json")
+				require.NoError(t, err)
+				defer subsFile.Close()
+				_, err = subsFile.Write([]byte("[]"))
+				require.NoError(t, err)
+				t.Setenv("MQTT_SUBSCRIPTIONS_PATH", subsFile.Name())
+			},
+			assertions: func(_ mqtt.ReceiverConfig, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					"does not define any MQTT subscriptions",
+				)
+			},
+		},
+		{
+			name: "MQTT_SUBSCRIPTIONS_PATH defines a subscription with invalid QoS",
+			setup: func() {
+				subsFile, err := ioutil.TempFile("", "subscriptions.json")
+				require.NoError(t, err)
+				defer subsFile.Close()
+				_, err = subsFile.Write([]byte(`[{"topicFilter":"foo","qos":3}]`))
+				require.NoError(t, err)
+				t.Setenv("MQTT_SUBSCRIPTIONS_PATH", subsFile.Name())
+			},
+			assertions: func(_ mqtt.ReceiverConfig, err error) {
 				require.Error(t, err)
-				require.Contains(t, err.Error(), "value not found for")
-				require.Contains(t, err.Error(), "API_ADDRESS")
+				require.Contains(t, err.Error(), "has invalid QoS 3")
 			},
 		},
 		{
-			name: "API_TOKEN not set",
+			name: "MQTT_WORKERS is not greater than zero",
 			setup: func() {
-				t.Setenv("API_ADDRESS", "foo")
+				subsFile, err := ioutil.TempFile("", "subscriptions.json")
+				require.NoError(t, err)
+				defer subsFile.Close()
+				_, err = subsFile.Write([]byte(`[{"topicFilter":"foo","qos":1}]`))
+				require.NoError(t, err)
+				t.Setenv("MQTT_SUBSCRIPTIONS_PATH", subsFile.Name())
+				t.Setenv("MQTT_WORKERS", "0")
 			},
-			assertions: func(
-				_ string,
-				_ string,
-				_ restmachinery.APIClientOptions,
-				err error,
-			) {
+			assertions: func(_ mqtt.ReceiverConfig, err error) {
 				require.Error(t, err)
-				require.Contains(t, err.Error(), "value not found for")
-				require.Contains(t, err.Error(), "API_TOKEN")
+				require.Contains(t, err.Error(), "MQTT_WORKERS must be greater")
+			},
+		},
+		{
+			name: "MQTT_MAX_RETRY_DURATION is negative",
+			setup: func() {
+				t.Setenv("MQTT_WORKERS", "")
+				t.Setenv("MQTT_MAX_RETRY_DURATION", "-1m")
+			},
+			assertions: func(_ mqtt.ReceiverConfig, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					"MQTT_MAX_RETRY_DURATION may not be negative",
+				)
 			},
 		},
 		{
 			name: "success",
 			setup: func() {
-				t.Setenv("API_TOKEN", "bar")
-				t.Setenv("API_IGNORE_CERT_WARNINGS", "true")
+				t.Setenv("MQTT_MAX_RETRY_DURATION", "")
+				t.Setenv("MQTT_CLIENT_ID", "gateway-0")
+				t.Setenv("MQTT_USERNAME", "foo")
+				t.Setenv("MQTT_PASSWORD", "bar")
+				subsFile, err := ioutil.TempFile("", "subscriptions.json")
+				require.NoError(t, err)
+				defer subsFile.Close()
+				_, err = subsFile.Write(
+					[]byte(
+						`[{"topicFilter":"devices/+/events","qos":1,"identity":"fleet"}]`,
+					),
+				)
+				require.NoError(t, err)
+				t.Setenv("MQTT_SUBSCRIPTIONS_PATH", subsFile.Name())
 			},
-			assertions: func(
-				address string,
-				token string,
-				opts restmachinery.APIClientOptions,
-				err error,
-			) {
+			assertions: func(config mqtt.ReceiverConfig, err error) {
 				require.NoError(t, err)
-				require.Equal(t, "foo", address)
-				require.Equal(t, "bar", token)
-				require.True(t, opts.AllowInsecureConnections)
+				require.Len(t, config.BrokerURLs, 1)
+				require.Equal(t, "mqtt://broker:1883", config.BrokerURLs[0].String())
+				require.Equal(t, "gateway-0", config.ClientID)
+				require.Equal(t, "foo", config.Username)
+				require.Equal(t, "bar", config.Password)
+				require.Equal(
+					t,
+					[]mqtt.Subscription{
+						{
+							TopicFilter: "devices/+/events",
+							QoS:         1,
+							Identity:    "fleet",
+						},
+					},
+					config.Subscriptions,
+				)
+				require.Equal(t, 10, config.Workers)
+				require.Equal(t, 5*time.Minute, config.MaxRetryDuration)
 			},
 		},
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			testCase.setup()
-			address, token, opts, err := apiClientConfig()
-			testCase.assertions(address, token, opts, err)
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := mqttReceiverConfig()
+			testCase.assertions(config, err)
 		})
 	}
 }
 
-func TestTokenFilterConfig(t *testing.T) {
+func TestNotifierConfig(t *testing.T) {
 	testCases := []struct {
 		name       string
 		setup      func()
-		assertions func(ourCloudHTTP.TokenFilterConfig, error)
+		assertions func(notifications.NotifierConfig, error)
 	}{
 		{
-			name: "TOKENS_PATH not set",
-			assertions: func(_ ourCloudHTTP.TokenFilterConfig, err error) {
+			name: "NOTIFICATIONS_PROJECTS not set",
+			assertions: func(_ notifications.NotifierConfig, err error) {
 				require.Error(t, err)
 				require.Contains(t, err.Error(), "value not found for")
-				require.Contains(t, err.Error(), "TOKENS_PATH")
+				require.Contains(t, err.Error(), "NOTIFICATIONS_PROJECTS")
 			},
 		},
 		{
-			name: "TOKENS_PATH path does not exist",
+			name: "NOTIFICATIONS_POLL_INTERVAL not parsable as duration",
 			setup: func() {
-				t.Setenv("TOKENS_PATH", "/completely/bogus/path")
+				t.Setenv("NOTIFICATIONS_PROJECTS", "italian, mexican")
+				t.Setenv("NOTIFICATIONS_POLL_INTERVAL", "foo")
 			},
-			assertions: func(_ ourCloudHTTP.TokenFilterConfig, err error) {
+			assertions: func(_ notifications.NotifierConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a duration")
+				require.Contains(t, err.Error(), "NOTIFICATIONS_POLL_INTERVAL")
+			},
+		},
+		{
+			name: "NOTIFICATIONS_SINKS_PATH not set",
+			setup: func() {
+				t.Setenv("NOTIFICATIONS_POLL_INTERVAL", "30s")
+			},
+			assertions: func(_ notifications.NotifierConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "value not found for")
+				require.Contains(t, err.Error(), "NOTIFICATIONS_SINKS_PATH")
+			},
+		},
+		{
+			name: "NOTIFICATIONS_SINKS_PATH path does not exist",
+			setup: func() {
+				t.Setenv("NOTIFICATIONS_SINKS_PATH", "/completely/bogus/path")
+			},
+			assertions: func(_ notifications.NotifierConfig, err error) {
 				require.Error(t, err)
 				require.Contains(
 					t,
@@ -113,34 +2106,210 @@ func TestTokenFilterConfig(t *testing.T) {
 			},
 		},
 		{
-			name: "TOKENS_PATH does not contain valid json",
+			name: "NOTIFICATIONS_SINKS_PATH does not contain valid json",
 			setup: func() {
-				tokensFile, err := ioutil.TempFile("", "tokens.json")
+				sinksFile, err := ioutil.TempFile("", "sinks.json")
 				require.NoError(t, err)
-				defer tokensFile.Close()
-				_, err = tokensFile.Write([]byte("this is not json"))
+				defer sinksFile.Close()
+				_, err = sinksFile.Write([]byte("this is not json"))
 				require.NoError(t, err)
-				t.Setenv("TOKENS_PATH", tokensFile.Name())
+				t.Setenv("NOTIFICATIONS_SINKS_PATH", sinksFile.Name())
 			},
-			assertions: func(_ ourCloudHTTP.TokenFilterConfig, err error) {
+			assertions: func(_ notifications.NotifierConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "invalid character")
+			},
+		},
+		{
+			name: "NOTIFICATIONS_SINKS_PATH defines no sinks",
+			setup: func() {
+				sinksFile, err := ioutil.TempFile("", "sinks.json")
+				require.NoError(t, err)
+				defer sinksFile.Close()
+				_, err = sinksFile.Write([]byte("[]"))
+				require.NoError(t, err)
+				t.Setenv("NOTIFICATIONS_SINKS_PATH", sinksFile.Name())
+			},
+			assertions: func(_ notifications.NotifierConfig, err error) {
 				require.Error(t, err)
 				require.Contains(
-					t, err.Error(), "invalid character",
+					t,
+					err.Error(),
+					"does not define any notification sinks",
+				)
+			},
+		},
+		{
+			name: "NOTIFICATIONS_SINKS_PATH defines a sink with an invalid URL",
+			setup: func() {
+				sinksFile, err := ioutil.TempFile("", "sinks.json")
+				require.NoError(t, err)
+				defer sinksFile.Close()
+				_, err = sinksFile.Write([]byte(`[{"url":"bogus"}]`))
+				require.NoError(t, err)
+				t.Setenv("NOTIFICATIONS_SINKS_PATH", sinksFile.Name())
+			},
+			assertions: func(_ notifications.NotifierConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "error parsing notification sink URL")
+			},
+		},
+		{
+			name: "NOTIFICATIONS_SINKS_PATH defines a sink with an invalid mode",
+			setup: func() {
+				sinksFile, err := ioutil.TempFile("", "sinks.json")
+				require.NoError(t, err)
+				defer sinksFile.Close()
+				_, err = sinksFile.Write(
+					[]byte(`[{"url":"https://example.com","mode":"bogus"}]`),
 				)
+				require.NoError(t, err)
+				t.Setenv("NOTIFICATIONS_SINKS_PATH", sinksFile.Name())
+			},
+			assertions: func(_ notifications.NotifierConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `has invalid mode "bogus"`)
 			},
 		},
 		{
 			name: "success",
 			setup: func() {
-				tokensFile, err := ioutil.TempFile("", "tokens.json")
+				sinksFile, err := ioutil.TempFile("", "sinks.json")
 				require.NoError(t, err)
-				defer tokensFile.Close()
-				_, err = tokensFile.Write([]byte(`{"foo": "bar"}`))
+				defer sinksFile.Close()
+				_, err = sinksFile.Write(
+					[]byte(`[{"url":"https://example.com","mode":"structured"}]`),
+				)
 				require.NoError(t, err)
-				t.Setenv("TOKENS_PATH", tokensFile.Name())
+				t.Setenv("NOTIFICATIONS_SINKS_PATH", sinksFile.Name())
 			},
-			assertions: func(config ourCloudHTTP.TokenFilterConfig, err error) {
+			assertions: func(config notifications.NotifierConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(t, []string{"italian", "mexican"}, config.ProjectIDs)
+				require.Equal(t, 30*time.Second, config.PollInterval)
+				require.Equal(
+					t,
+					[]notifications.Sink{
+						{
+							URL:  "https://example.com",
+							Mode: notifications.SinkModeStructured,
+						},
+					},
+					config.Sinks,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			config, err := notifierConfig()
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestGRPCServerConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(ourCloudGRPC.ServerConfig, error)
+	}{
+		{
+			name:  "GRPC_PORT not set",
+			setup: func() {},
+			assertions: func(config ourCloudGRPC.ServerConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(t, ourCloudGRPC.ServerConfig{}, config)
+			},
+		},
+		{
+			name: "GRPC_PORT not an int",
+			setup: func() {
+				t.Setenv("GRPC_PORT", "foo")
+			},
+			assertions: func(_ ourCloudGRPC.ServerConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as an int")
+				require.Contains(t, err.Error(), "GRPC_PORT")
+			},
+		},
+		{
+			name: "TLS_CERT_PATH required but not set",
+			setup: func() {
+				t.Setenv("GRPC_PORT", "8081")
+				t.Setenv("TLS_ENABLED", "true")
+			},
+			assertions: func(_ ourCloudGRPC.ServerConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "value not found for")
+				require.Contains(t, err.Error(), "TLS_CERT_PATH")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("TLS_CERT_PATH", "/var/ssl/cert")
+				t.Setenv("TLS_KEY_PATH", "/var/ssl/key")
+			},
+			assertions: func(config ourCloudGRPC.ServerConfig, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					ourCloudGRPC.ServerConfig{
+						Port:        8081,
+						TLSEnabled:  true,
+						TLSCertPath: "/var/ssl/cert",
+						TLSKeyPath:  "/var/ssl/key",
+					},
+					config,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.setup()
+			config, err := grpcServerConfig()
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestAdminServerConfig(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(http.ServerConfig, error)
+	}{
+		{
+			name: "ADMIN_PORT not set",
+			assertions: func(config http.ServerConfig, err error) {
+				require.NoError(t, err)
+				require.Zero(t, config.Port)
+			},
+		},
+		{
+			name: "ADMIN_PORT not an int",
+			setup: func() {
+				t.Setenv("ADMIN_PORT", "foo")
+			},
+			assertions: func(_ http.ServerConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as an int")
+				require.Contains(t, err.Error(), "ADMIN_PORT")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("ADMIN_PORT", "9090")
+			},
+			assertions: func(config http.ServerConfig, err error) {
 				require.NoError(t, err)
+				require.Equal(t, 9090, config.Port)
 			},
 		},
 	}
@@ -149,12 +2318,58 @@ func TestTokenFilterConfig(t *testing.T) {
 			if testCase.setup != nil {
 				testCase.setup()
 			}
-			config, err := tokenFilterConfig()
+			config, err := adminServerConfig()
 			testCase.assertions(config, err)
 		})
 	}
 }
 
+func TestReadinessCheckTTL(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func()
+		assertions func(time.Duration, error)
+	}{
+		{
+			name: "READINESS_CHECK_TTL not set",
+			assertions: func(ttl time.Duration, err error) {
+				require.NoError(t, err)
+				require.Equal(t, 10*time.Second, ttl)
+			},
+		},
+		{
+			name: "READINESS_CHECK_TTL not parsable as a duration",
+			setup: func() {
+				t.Setenv("READINESS_CHECK_TTL", "foo")
+			},
+			assertions: func(_ time.Duration, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "was not parsable as a duration")
+				require.Contains(t, err.Error(), "READINESS_CHECK_TTL")
+			},
+		},
+		{
+			name: "success",
+			setup: func() {
+				t.Setenv("READINESS_CHECK_TTL", "1m")
+			},
+			assertions: func(ttl time.Duration, err error) {
+				require.NoError(t, err)
+				require.Equal(t, time.Minute, ttl)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.setup != nil {
+				testCase.setup()
+			}
+			ttl, err := readinessCheckTTL()
+			testCase.assertions(ttl, err)
+		})
+	}
+}
+
 func TestServerConfig(t *testing.T) {
 	testCases := []struct {
 		name       string
@@ -0,0 +1,247 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Decision represents the gateway's decision regarding an attempt to ingest a
+// CloudEvent.
+type Decision string
+
+const (
+	// DecisionAccepted represents a CloudEvent that was accepted and from which
+	// one or more Brigade Events were created.
+	DecisionAccepted Decision = "accepted"
+	// DecisionDropped represents a CloudEvent that was accepted, but from which
+	// no Brigade Events were created because no Brigade Project is subscribed to
+	// it.
+	DecisionDropped Decision = "dropped"
+	// DecisionRejected represents an attempt to ingest CloudEvents that was
+	// rejected, for instance because the sender could not be authenticated.
+	DecisionRejected Decision = "rejected"
+	// DecisionFailed represents a CloudEvent that was accepted, but that could
+	// not be handled because of an error.
+	DecisionFailed Decision = "failed"
+)
+
+// Transports over which CloudEvents may be ingested.
+const (
+	TransportHTTP      = "http"
+	TransportWebSocket = "websocket"
+	TransportGRPC      = "grpc"
+	TransportMQTT      = "mqtt"
+)
+
+// Methods by which the senders of CloudEvents may be authenticated.
+const (
+	// AuthMethodToken represents a sender authenticated by a bearer token.
+	AuthMethodToken = "token"
+	// AuthMethodSubscription represents a sender identified by the MQTT
+	// subscription over which its CloudEvents were received. Such senders are
+	// authenticated by the MQTT broker rather than by the gateway.
+	AuthMethodSubscription = "subscription"
+)
+
+// Record is a single audit record describing an attempt to ingest a
+// CloudEvent. Records never contain tokens or any other credentials.
+type Record struct {
+	// Time is the time at which the record was written.
+	Time time.Time `json:"time"`
+	// RequestID is the ID of the request that delivered the CloudEvent.
+	RequestID string `json:"requestID,omitempty"`
+	// Transport is the transport over which the CloudEvent was delivered.
+	Transport string `json:"transport,omitempty"`
+	// ClientIP is the IP address of the client that delivered the CloudEvent.
+	ClientIP string `json:"clientIP,omitempty"`
+	// Tenant is the name of the tenant to whose endpoint the CloudEvent was
+	// delivered.
+	Tenant string `json:"tenant,omitempty"`
+	// Sender is the identity of the authenticated sender of the CloudEvent.
+	Sender string `json:"sender,omitempty"`
+	// AuthMethod is the method by which the sender of the CloudEvent was
+	// authenticated.
+	AuthMethod string `json:"authMethod,omitempty"`
+	// Decision is the gateway's decision regarding the CloudEvent.
+	Decision Decision `json:"decision"`
+	// Reason explains a decision to reject or a failure to handle the
+	// CloudEvent.
+	Reason string `json:"reason,omitempty"`
+	// CloudEventID is the ID of the CloudEvent.
+	CloudEventID string `json:"cloudEventID,omitempty"`
+	// CloudEventSource is the source of the CloudEvent.
+	CloudEventSource string `json:"cloudEventSource,omitempty"`
+	// CloudEventType is the type of the CloudEvent.
+	CloudEventType string `json:"cloudEventType,omitempty"`
+	// BrigadeEventIDs enumerates the IDs of the Brigade Events created from the
+	// CloudEvent.
+	BrigadeEventIDs []string `json:"brigadeEventIDs,omitempty"`
+}
+
+// Request encapsulates details of the request in the course of which audit
+// records are written. It is carried by a context so that components that are
+// unaware of transport-specific details may still write complete records.
+type Request struct {
+	// ID is the ID of the request.
+	ID string
+	// Transport is the transport over which the request was made.
+	Transport string
+	// ClientIP is the IP address of the client that made the request.
+	ClientIP string
+	// Tenant is the name of the tenant to whose endpoint the request was made.
+	Tenant string
+	// Sender is the identity of the authenticated sender of the request.
+	Sender string
+	// AuthMethod is the method by which the sender of the request was
+	// authenticated.
+	AuthMethod string
+}
+
+type requestContextKey struct{}
+
+// ContextWithRequest returns a copy of the provided context that carries the
+// provided Request.
+func ContextWithRequest(ctx context.Context, req Request) context.Context {
+	return context.WithValue(ctx, requestContextKey{}, req)
+}
+
+// RequestFromContext returns the Request carried by the provided context. If
+// the context does not carry a Request, an empty one is returned.
+func RequestFromContext(ctx context.Context) Request {
+	req, _ := ctx.Value(requestContextKey{}).(Request)
+	return req
+}
+
+// Output represents a destination for audit records.
+type Output string
+
+const (
+	// OutputStdout represents audit records being written to stdout.
+	OutputStdout Output = "stdout"
+	// OutputFile represents audit records being written to a file that is
+	// rotated as it grows.
+	OutputFile Output = "file"
+)
+
+// SinkConfig encapsulates configuration for a Sink.
+type SinkConfig struct {
+	// Output is the destination for audit records.
+	Output Output
+	// FilePath is the path to the file audit records are written to when Output
+	// is OutputFile.
+	FilePath string
+	// FileMaxSizeMB is the size in megabytes a file may reach before it is
+	// rotated.
+	FileMaxSizeMB int
+	// FileMaxBackups is the maximum number of rotated files to retain. 0 retains
+	// all of them.
+	FileMaxBackups int
+	// FileMaxAgeDays is the maximum number of days to retain rotated files. 0
+	// retains them indefinitely.
+	FileMaxAgeDays int
+}
+
+// Sink is an interface for components that durably record attempts to ingest
+// CloudEvents.
+type Sink interface {
+	// Write writes the provided Record, completing it with details of the
+	// Request carried by the provided context.
+	Write(context.Context, Record)
+	// Close closes the Sink.
+	Close() error
+}
+
+type sink struct {
+	// mu ensures records written concurrently are never interleaved.
+	mu     sync.Mutex
+	writer io.WriteCloser
+	// encoder encodes records as JSON, one per line.
+	encoder *json.Encoder
+	now     func() time.Time
+}
+
+// NewSink returns an implementation of the Sink interface that writes each
+// Record as a single line of JSON to the configured output.
+func NewSink(config SinkConfig) (Sink, error) {
+	var writer io.WriteCloser
+	switch config.Output {
+	case OutputStdout, "":
+		writer = nopCloser{os.Stdout}
+	case OutputFile:
+		if config.FilePath == "" {
+			return nil, errors.New("no audit file path specified")
+		}
+		writer = &lumberjack.Logger{
+			Filename:   config.FilePath,
+			MaxSize:    config.FileMaxSizeMB,
+			MaxBackups: config.FileMaxBackups,
+			MaxAge:     config.FileMaxAgeDays,
+		}
+	default:
+		return nil, errors.Errorf("unrecognized audit output %q", config.Output)
+	}
+	return newSink(writer), nil
+}
+
+func newSink(writer io.WriteCloser) *sink {
+	return &sink{
+		writer:  writer,
+		encoder: json.NewEncoder(writer),
+		now:     time.Now,
+	}
+}
+
+func (s *sink) Write(ctx context.Context, record Record) {
+	req := RequestFromContext(ctx)
+	record.Time = s.now().UTC()
+	record.RequestID = req.ID
+	record.Transport = req.Transport
+	record.ClientIP = req.ClientIP
+	record.Tenant = req.Tenant
+	record.Sender = req.Sender
+	record.AuthMethod = req.AuthMethod
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.encoder.Encode(record); err != nil {
+		// There's no one to return the error to, so the best we can do is log it
+		zap.L().Error("error writing audit record", zap.Error(err))
+	}
+}
+
+func (s *sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writer.Close()
+}
+
+// nopCloser is an io.WriteCloser whose Close method does nothing. It prevents
+// stdout from being closed.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+type nopSink struct{}
+
+// NewNopSink returns an implementation of the Sink interface that discards
+// every Record. It is used when auditing is disabled.
+func NewNopSink() Sink {
+	return nopSink{}
+}
+
+func (nopSink) Write(context.Context, Record) {}
+
+func (nopSink) Close() error {
+	return nil
+}
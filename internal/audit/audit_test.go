@@ -0,0 +1,166 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+func TestRequestContext(t *testing.T) {
+	require.Equal(t, Request{}, RequestFromContext(context.Background()))
+	req := Request{
+		ID:        "foo",
+		Transport: TransportHTTP,
+		ClientIP:  "10.0.0.1",
+		Sender:    "bar",
+	}
+	require.Equal(
+		t,
+		req,
+		RequestFromContext(ContextWithRequest(context.Background(), req)),
+	)
+}
+
+func TestNewSink(t *testing.T) {
+	testCases := []struct {
+		name       string
+		config     SinkConfig
+		assertions func(Sink, error)
+	}{
+		{
+			name:   "stdout",
+			config: SinkConfig{Output: OutputStdout},
+			assertions: func(s Sink, err error) {
+				require.NoError(t, err)
+				sink, ok := s.(*sink)
+				require.True(t, ok)
+				require.IsType(t, nopCloser{}, sink.writer)
+			},
+		},
+		{
+			name:   "file with no path",
+			config: SinkConfig{Output: OutputFile},
+			assertions: func(_ Sink, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "no audit file path specified")
+			},
+		},
+		{
+			name: "file",
+			config: SinkConfig{
+				Output:         OutputFile,
+				FilePath:       filepath.Join(t.TempDir(), "audit.log"),
+				FileMaxSizeMB:  10,
+				FileMaxBackups: 5,
+				FileMaxAgeDays: 30,
+			},
+			assertions: func(s Sink, err error) {
+				require.NoError(t, err)
+				sink, ok := s.(*sink)
+				require.True(t, ok)
+				writer, ok := sink.writer.(*lumberjack.Logger)
+				require.True(t, ok)
+				require.Equal(t, 10, writer.MaxSize)
+				require.Equal(t, 5, writer.MaxBackups)
+				require.Equal(t, 30, writer.MaxAge)
+				require.NoError(t, s.Close())
+			},
+		},
+		{
+			name:   "unrecognized output",
+			config: SinkConfig{Output: "foo"},
+			assertions: func(_ Sink, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "unrecognized audit output")
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			s, err := NewSink(testCase.config)
+			testCase.assertions(s, err)
+		})
+	}
+}
+
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (b *bufferCloser) Close() error {
+	return nil
+}
+
+func TestSinkWrite(t *testing.T) {
+	buf := &bufferCloser{}
+	s := newSink(buf)
+	now := time.Date(2021, 8, 3, 19, 13, 37, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	ctx := ContextWithRequest(
+		context.Background(),
+		Request{
+			ID:         "foo",
+			Transport:  TransportHTTP,
+			ClientIP:   "10.0.0.1",
+			Tenant:     "team-a",
+			Sender:     "bar",
+			AuthMethod: AuthMethodToken,
+		},
+	)
+	s.Write(
+		ctx,
+		Record{
+			Decision:         DecisionAccepted,
+			CloudEventID:     "1234",
+			CloudEventSource: "example/uri",
+			CloudEventType:   "example.type",
+			BrigadeEventIDs:  []string{"abc", "def"},
+		},
+	)
+	s.Write(
+		context.Background(),
+		Record{
+			Decision: DecisionRejected,
+			Reason:   "invalid_token",
+		},
+	)
+	decoder := json.NewDecoder(&buf.Buffer)
+	record := Record{}
+	require.NoError(t, decoder.Decode(&record))
+	require.Equal(
+		t,
+		Record{
+			Time:             now,
+			RequestID:        "foo",
+			Transport:        TransportHTTP,
+			ClientIP:         "10.0.0.1",
+			Tenant:           "team-a",
+			Sender:           "bar",
+			AuthMethod:       AuthMethodToken,
+			Decision:         DecisionAccepted,
+			CloudEventID:     "1234",
+			CloudEventSource: "example/uri",
+			CloudEventType:   "example.type",
+			BrigadeEventIDs:  []string{"abc", "def"},
+		},
+		record,
+	)
+	record = Record{}
+	require.NoError(t, decoder.Decode(&record))
+	require.Equal(
+		t,
+		Record{
+			Time:     now,
+			Decision: DecisionRejected,
+			Reason:   "invalid_token",
+		},
+		record,
+	)
+	require.False(t, decoder.More())
+}
@@ -0,0 +1,141 @@
+package cloudevents
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrSaturated is returned when a CloudEvent cannot be handled because the
+// maximum number of concurrent calls to the Brigade API are already in flight
+// and no more calls may wait for one of them to complete. Transports should
+// respond by shedding load (e.g. with a 503).
+var ErrSaturated = errors.New("gateway is saturated")
+
+const (
+	shedReasonQueueFull    = "queue_full"
+	shedReasonQueueTimeout = "queue_timeout"
+)
+
+// ConcurrencyLimiterConfig encapsulates configuration for a
+// ConcurrencyLimiter.
+type ConcurrencyLimiterConfig struct {
+	// MaxInFlight is the maximum number of concurrent calls to the Brigade API.
+	// A value of zero indicates there is no limit.
+	MaxInFlight int
+	// MaxQueued is the maximum number of calls that may wait for an in-flight
+	// call to complete when MaxInFlight calls are already in flight.
+	MaxQueued int
+	// QueueTimeout is the maximum length of time a call may wait for an
+	// in-flight call to complete. A value of zero indicates calls may wait
+	// indefinitely (or until their context is canceled).
+	QueueTimeout time.Duration
+}
+
+// ConcurrencyLimiter is an interface for components that limit the number of
+// concurrent calls to the Brigade API.
+type ConcurrencyLimiter interface {
+	// Acquire blocks until a call to the Brigade API may proceed and returns a
+	// function that MUST be called when the call completes. If the call may not
+	// proceed, because too many calls are already waiting or because it waited
+	// too long, ErrSaturated is returned.
+	Acquire(context.Context) (func(), error)
+	// Check returns ErrSaturated if every in-flight slot is in use and the wait
+	// queue is full, i.e. if a new call would be rejected immediately. This makes
+	// the ConcurrencyLimiter usable as a readiness.Checker.
+	Check(context.Context) error
+}
+
+type concurrencyLimiter struct {
+	config ConcurrencyLimiterConfig
+	// slots holds one value per in-flight call.
+	slots chan struct{}
+	// mu guards queued.
+	mu     sync.Mutex
+	queued int
+}
+
+// NewConcurrencyLimiter returns a ConcurrencyLimiter configured as specified.
+func NewConcurrencyLimiter(
+	config ConcurrencyLimiterConfig,
+) ConcurrencyLimiter {
+	c := &concurrencyLimiter{
+		config: config,
+	}
+	if config.MaxInFlight > 0 {
+		c.slots = make(chan struct{}, config.MaxInFlight)
+	}
+	return c
+}
+
+func (c *concurrencyLimiter) Acquire(ctx context.Context) (func(), error) {
+	if c.slots == nil {
+		return func() {}, nil
+	}
+	// Proceed immediately if possible
+	select {
+	case c.slots <- struct{}{}:
+		return c.release(), nil
+	default:
+	}
+	// Otherwise, wait in the queue if there's room in it
+	c.mu.Lock()
+	if c.queued >= c.config.MaxQueued {
+		c.mu.Unlock()
+		brigadeAPIShedTotal.WithLabelValues(shedReasonQueueFull).Inc()
+		return nil, ErrSaturated
+	}
+	c.queued++
+	brigadeAPIQueued.Inc()
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.queued--
+		brigadeAPIQueued.Dec()
+	}()
+	// Receiving from a nil channel blocks forever, so if there is no timeout,
+	// the call waits for a slot or for its context to be canceled.
+	var timeoutCh <-chan time.Time
+	if c.config.QueueTimeout > 0 {
+		timer := time.NewTimer(c.config.QueueTimeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+	select {
+	case c.slots <- struct{}{}:
+		return c.release(), nil
+	case <-timeoutCh:
+		brigadeAPIShedTotal.WithLabelValues(shedReasonQueueTimeout).Inc()
+		return nil, ErrSaturated
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// release records that a slot has been acquired and returns a function that
+// releases it.
+func (c *concurrencyLimiter) release() func() {
+	brigadeAPIInFlight.Inc()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			brigadeAPIInFlight.Dec()
+			<-c.slots
+		})
+	}
+}
+
+func (c *concurrencyLimiter) Check(context.Context) error {
+	if c.slots == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.slots) == cap(c.slots) && c.queued >= c.config.MaxQueued {
+		return ErrSaturated
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package cloudevents
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrencyLimiterUnlimited(t *testing.T) {
+	limiter := NewConcurrencyLimiter(ConcurrencyLimiterConfig{})
+	for i := 0; i < 100; i++ {
+		_, err := limiter.Acquire(context.Background())
+		require.NoError(t, err)
+	}
+	require.NoError(t, limiter.Check(context.Background()))
+}
+
+func TestConcurrencyLimiterQueueFull(t *testing.T) {
+	limiter := NewConcurrencyLimiter(
+		ConcurrencyLimiterConfig{
+			MaxInFlight:  1,
+			QueueTimeout: time.Minute,
+		},
+	)
+	ctx := context.Background()
+	release, err := limiter.Acquire(ctx)
+	require.NoError(t, err)
+	// With no room in the queue, the limiter is saturated
+	require.ErrorIs(t, limiter.Check(ctx), ErrSaturated)
+	counter := brigadeAPIShedTotal.WithLabelValues(shedReasonQueueFull)
+	before := testutil.ToFloat64(counter)
+	_, err = limiter.Acquire(ctx)
+	require.ErrorIs(t, err, ErrSaturated)
+	require.Equal(t, before+1, testutil.ToFloat64(counter))
+	// Once the slot is released, it can be acquired again. Releasing more than
+	// once must be harmless.
+	release()
+	release()
+	require.NoError(t, limiter.Check(ctx))
+	_, err = limiter.Acquire(ctx)
+	require.NoError(t, err)
+}
+
+func TestConcurrencyLimiterQueueTimeout(t *testing.T) {
+	limiter := NewConcurrencyLimiter(
+		ConcurrencyLimiterConfig{
+			MaxInFlight:  1,
+			MaxQueued:    1,
+			QueueTimeout: 10 * time.Millisecond,
+		},
+	)
+	ctx := context.Background()
+	_, err := limiter.Acquire(ctx)
+	require.NoError(t, err)
+	// There is still room in the queue, so the limiter isn't saturated
+	require.NoError(t, limiter.Check(ctx))
+	counter := brigadeAPIShedTotal.WithLabelValues(shedReasonQueueTimeout)
+	before := testutil.ToFloat64(counter)
+	_, err = limiter.Acquire(ctx)
+	require.ErrorIs(t, err, ErrSaturated)
+	require.Equal(t, before+1, testutil.ToFloat64(counter))
+}
+
+func TestConcurrencyLimiterQueued(t *testing.T) {
+	limiter := NewConcurrencyLimiter(
+		ConcurrencyLimiterConfig{
+			MaxInFlight:  1,
+			MaxQueued:    1,
+			QueueTimeout: time.Minute,
+		},
+	)
+	ctx := context.Background()
+	release, err := limiter.Acquire(ctx)
+	require.NoError(t, err)
+	acquiredCh := make(chan error)
+	go func() {
+		_, err := limiter.Acquire(ctx)
+		acquiredCh <- err
+	}()
+	// Wait for the second call to be queued, at which point the limiter is
+	// saturated
+	require.Eventually(
+		t,
+		func() bool { return limiter.Check(ctx) != nil },
+		time.Second,
+		time.Millisecond,
+	)
+	release()
+	select {
+	case err := <-acquiredCh:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		require.Fail(t, "queued call never proceeded")
+	}
+}
+
+func TestConcurrencyLimiterNoQueueTimeout(t *testing.T) {
+	limiter := NewConcurrencyLimiter(
+		ConcurrencyLimiterConfig{
+			MaxInFlight: 1,
+			MaxQueued:   1,
+		},
+	)
+	release, err := limiter.Acquire(context.Background())
+	require.NoError(t, err)
+	acquiredCh := make(chan error)
+	go func() {
+		_, err := limiter.Acquire(context.Background())
+		acquiredCh <- err
+	}()
+	select {
+	case <-acquiredCh:
+		require.Fail(t, "queued call proceeded before a slot was released")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	select {
+	case err = <-acquiredCh:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		require.Fail(t, "queued call never proceeded")
+	}
+}
+
+func TestConcurrencyLimiterContextCanceled(t *testing.T) {
+	limiter := NewConcurrencyLimiter(
+		ConcurrencyLimiterConfig{
+			MaxInFlight:  1,
+			MaxQueued:    1,
+			QueueTimeout: time.Minute,
+		},
+	)
+	_, err := limiter.Acquire(context.Background())
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = limiter.Acquire(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+}
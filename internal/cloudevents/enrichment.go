@@ -0,0 +1,191 @@
+package cloudevents
+
+import (
+	"context"
+	"time"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/brigadecore/brigade-foundations/version"
+	"github.com/brigadecore/brigade/sdk/v3"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/pkg/errors"
+)
+
+// EnrichmentField represents an item of metadata about the receipt of a
+// CloudEvent that the gateway can stamp onto the Brigade Event it creates. The
+// value of an EnrichmentField is also the key of the label or qualifier or the
+// name of the CloudEvent extension attribute that the metadata is stamped onto.
+// Every value is therefore a valid CloudEvent attribute name.
+type EnrichmentField string
+
+const (
+	// EnrichmentFieldReceivedAt is the time, formatted as RFC 3339, at which the
+	// gateway received the CloudEvent.
+	EnrichmentFieldReceivedAt EnrichmentField = "gatewayreceivedat"
+	// EnrichmentFieldVersion is the version of the gateway that received the
+	// CloudEvent.
+	EnrichmentFieldVersion EnrichmentField = "gatewayversion"
+	// EnrichmentFieldPod is the name of the pod (i.e. the gateway replica) that
+	// received the CloudEvent.
+	EnrichmentFieldPod EnrichmentField = "gatewaypod"
+	// EnrichmentFieldCluster is the name of the cluster in which the gateway
+	// that received the CloudEvent runs.
+	EnrichmentFieldCluster EnrichmentField = "gatewaycluster"
+	// EnrichmentFieldSender is the identity of the authenticated sender of the
+	// CloudEvent.
+	EnrichmentFieldSender EnrichmentField = "gatewaysender"
+	// EnrichmentFieldClientIP is the IP address of the client that delivered the
+	// CloudEvent.
+	EnrichmentFieldClientIP EnrichmentField = "gatewayclientip"
+	// EnrichmentFieldTransport is the transport over which the CloudEvent was
+	// delivered.
+	EnrichmentFieldTransport EnrichmentField = "gatewaytransport"
+	// EnrichmentFieldAuthMethod is the method by which the sender of the
+	// CloudEvent was authenticated.
+	EnrichmentFieldAuthMethod EnrichmentField = "gatewayauthmethod"
+)
+
+// EnrichmentFields enumerates all valid EnrichmentFields.
+var EnrichmentFields = []EnrichmentField{
+	EnrichmentFieldReceivedAt,
+	EnrichmentFieldVersion,
+	EnrichmentFieldPod,
+	EnrichmentFieldCluster,
+	EnrichmentFieldSender,
+	EnrichmentFieldClientIP,
+	EnrichmentFieldTransport,
+	EnrichmentFieldAuthMethod,
+}
+
+// ParseEnrichmentField returns the EnrichmentField represented by the provided
+// string or an error if it does not represent a valid EnrichmentField.
+func ParseEnrichmentField(str string) (EnrichmentField, error) {
+	for _, field := range EnrichmentFields {
+		if str == string(field) {
+			return field, nil
+		}
+	}
+	return "", errors.Errorf(
+		"enrichment field %q is invalid; valid values are %q",
+		str,
+		EnrichmentFields,
+	)
+}
+
+// EnricherConfig encapsulates configuration for an Enricher.
+type EnricherConfig struct {
+	// Labels enumerates the fields stamped onto each Brigade Event as labels.
+	Labels []EnrichmentField
+	// Qualifiers enumerates the fields stamped onto each Brigade Event as
+	// qualifiers. Note that Brigade delivers an Event only to Projects whose
+	// subscriptions specify exactly matching qualifiers.
+	Qualifiers []EnrichmentField
+	// Extensions enumerates the fields stamped onto each CloudEvent, as extension
+	// attributes, before it is embedded in the payload of a Brigade Event.
+	Extensions []EnrichmentField
+	// StaticQualifiers are stamped onto each Brigade Event as qualifiers.
+	StaticQualifiers map[string]string
+	// StaticLabels are stamped onto each Brigade Event as labels.
+	StaticLabels map[string]string
+	// PodName is the value of the EnrichmentFieldPod field.
+	PodName string
+	// ClusterName is the value of the EnrichmentFieldCluster field.
+	ClusterName string
+}
+
+// Enricher is an interface for components that stamp metadata about the
+// receipt of a CloudEvent onto the Brigade Event created from it.
+type Enricher interface {
+	// Enrich stamps metadata about the receipt of the provided CloudEvent onto
+	// it and onto the provided Brigade Event, which has not yet been created.
+	// Details of the request that delivered the CloudEvent are taken from the
+	// provided context. Fields with empty values are never stamped, but any
+	// extension attribute of the same name set by the sender is still removed.
+	Enrich(context.Context, *cloudEvents.Event, *sdk.Event)
+}
+
+type enricher struct {
+	config EnricherConfig
+	now    func() time.Time
+}
+
+// NewEnricher returns an Enricher configured as specified. If no fields are
+// configured, the Enricher does nothing.
+func NewEnricher(config EnricherConfig) Enricher {
+	return &enricher{
+		config: config,
+		now:    time.Now,
+	}
+}
+
+func (e *enricher) Enrich(
+	ctx context.Context,
+	cloudEvent *cloudEvents.Event,
+	brigadeEvent *sdk.Event,
+) {
+	for key, value := range e.config.StaticQualifiers {
+		if brigadeEvent.Qualifiers == nil {
+			brigadeEvent.Qualifiers = map[string]string{}
+		}
+		brigadeEvent.Qualifiers[key] = value
+	}
+	for key, value := range e.config.StaticLabels {
+		if brigadeEvent.Labels == nil {
+			brigadeEvent.Labels = map[string]string{}
+		}
+		brigadeEvent.Labels[key] = value
+	}
+	if len(e.config.Labels) == 0 &&
+		len(e.config.Qualifiers) == 0 &&
+		len(e.config.Extensions) == 0 {
+		return
+	}
+	values := e.values(ctx)
+	for _, field := range e.config.Labels {
+		if value := values[field]; value != "" {
+			if brigadeEvent.Labels == nil {
+				brigadeEvent.Labels = map[string]string{}
+			}
+			brigadeEvent.Labels[string(field)] = value
+		}
+	}
+	for _, field := range e.config.Qualifiers {
+		if value := values[field]; value != "" {
+			if brigadeEvent.Qualifiers == nil {
+				brigadeEvent.Qualifiers = map[string]string{}
+			}
+			brigadeEvent.Qualifiers[string(field)] = value
+		}
+	}
+	if len(e.config.Extensions) > 0 {
+		// The caller's copy of the CloudEvent shares its attributes with ours, so
+		// we work with a copy to leave the caller's unmodified.
+		*cloudEvent = cloudEvent.Clone()
+	}
+	for _, field := range e.config.Extensions {
+		// Any value the sender may have set is overwritten, or removed if we have
+		// no value of our own, so that senders cannot masquerade as something
+		// they are not.
+		if value := values[field]; value != "" {
+			cloudEvent.SetExtension(string(field), value)
+		} else {
+			cloudEvent.SetExtension(string(field), nil)
+		}
+	}
+}
+
+// values returns the value of every EnrichmentField for the request carried by
+// the provided context.
+func (e *enricher) values(ctx context.Context) map[EnrichmentField]string {
+	req := audit.RequestFromContext(ctx)
+	return map[EnrichmentField]string{
+		EnrichmentFieldReceivedAt: e.now().UTC().Format(time.RFC3339Nano),
+		EnrichmentFieldVersion:    version.Version(),
+		EnrichmentFieldPod:        e.config.PodName,
+		EnrichmentFieldCluster:    e.config.ClusterName,
+		EnrichmentFieldSender:     req.Sender,
+		EnrichmentFieldClientIP:   req.ClientIP,
+		EnrichmentFieldTransport:  req.Transport,
+		EnrichmentFieldAuthMethod: req.AuthMethod,
+	}
+}
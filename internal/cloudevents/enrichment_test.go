@@ -0,0 +1,204 @@
+package cloudevents
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/brigadecore/brigade-foundations/version"
+	"github.com/brigadecore/brigade/sdk/v3"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEnrichmentField(t *testing.T) {
+	field, err := ParseEnrichmentField("gatewaysender")
+	require.NoError(t, err)
+	require.Equal(t, EnrichmentFieldSender, field)
+	_, err = ParseEnrichmentField("foo")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `enrichment field "foo" is invalid`)
+}
+
+func TestEnricherEnrich(t *testing.T) {
+	now := time.Date(2021, 8, 3, 19, 13, 37, 0, time.UTC)
+	ctx := audit.ContextWithRequest(
+		context.Background(),
+		audit.Request{
+			ID:         "1234",
+			Transport:  audit.TransportHTTP,
+			ClientIP:   "10.0.0.1",
+			Sender:     "example/uri",
+			AuthMethod: audit.AuthMethodToken,
+		},
+	)
+	testCases := []struct {
+		name       string
+		ctx        context.Context
+		config     EnricherConfig
+		assertions func(
+			original cloudEvents.Event,
+			cloudEvent cloudEvents.Event,
+			brigadeEvent sdk.Event,
+		)
+	}{
+		{
+			name:   "nothing configured",
+			ctx:    ctx,
+			config: EnricherConfig{},
+			assertions: func(
+				_ cloudEvents.Event,
+				cloudEvent cloudEvents.Event,
+				brigadeEvent sdk.Event,
+			) {
+				require.Equal(
+					t,
+					map[string]interface{}{"gatewaysender": "spoofed"},
+					cloudEvent.Extensions(),
+				)
+				require.Nil(t, brigadeEvent.Labels)
+				require.Equal(
+					t,
+					map[string]string{"source": "foo"},
+					brigadeEvent.Qualifiers,
+				)
+			},
+		},
+		{
+			name: "labels and qualifiers",
+			ctx:  ctx,
+			config: EnricherConfig{
+				Labels: []EnrichmentField{
+					EnrichmentFieldReceivedAt,
+					EnrichmentFieldVersion,
+					EnrichmentFieldPod,
+					// This has no value, so it should not be stamped
+					EnrichmentFieldCluster,
+				},
+				Qualifiers: []EnrichmentField{EnrichmentFieldSender},
+				PodName:    "gateway-abcde",
+			},
+			assertions: func(
+				_ cloudEvents.Event,
+				cloudEvent cloudEvents.Event,
+				brigadeEvent sdk.Event,
+			) {
+				require.Equal(
+					t,
+					map[string]interface{}{"gatewaysender": "spoofed"},
+					cloudEvent.Extensions(),
+				)
+				require.Equal(
+					t,
+					map[string]string{
+						"gatewayreceivedat": "2021-08-03T19:13:37Z",
+						"gatewayversion":    version.Version(),
+						"gatewaypod":        "gateway-abcde",
+					},
+					brigadeEvent.Labels,
+				)
+				require.Equal(
+					t,
+					map[string]string{
+						"source":        "foo",
+						"gatewaysender": "example/uri",
+					},
+					brigadeEvent.Qualifiers,
+				)
+			},
+		},
+		{
+			name: "extensions",
+			ctx:  ctx,
+			config: EnricherConfig{
+				Extensions: []EnrichmentField{
+					EnrichmentFieldSender,
+					EnrichmentFieldClientIP,
+					EnrichmentFieldTransport,
+					EnrichmentFieldAuthMethod,
+					EnrichmentFieldCluster,
+				},
+				ClusterName: "prod",
+			},
+			assertions: func(
+				original cloudEvents.Event,
+				cloudEvent cloudEvents.Event,
+				brigadeEvent sdk.Event,
+			) {
+				require.Equal(
+					t,
+					map[string]interface{}{
+						"gatewaysender":     "example/uri",
+						"gatewayclientip":   "10.0.0.1",
+						"gatewaytransport":  audit.TransportHTTP,
+						"gatewayauthmethod": audit.AuthMethodToken,
+						"gatewaycluster":    "prod",
+					},
+					cloudEvent.Extensions(),
+				)
+				require.NoError(t, cloudEvent.Validate())
+				// The caller's CloudEvent should be unmodified
+				require.Equal(
+					t,
+					map[string]interface{}{"gatewaysender": "spoofed"},
+					original.Extensions(),
+				)
+				require.Nil(t, brigadeEvent.Labels)
+			},
+		},
+		{
+			name: "static qualifiers and labels",
+			ctx:  ctx,
+			config: EnricherConfig{
+				StaticQualifiers: map[string]string{"tenant": "team-a"},
+				StaticLabels:     map[string]string{"team": "a"},
+			},
+			assertions: func(
+				_ cloudEvents.Event,
+				_ cloudEvents.Event,
+				brigadeEvent sdk.Event,
+			) {
+				require.Equal(
+					t,
+					map[string]string{"source": "foo", "tenant": "team-a"},
+					brigadeEvent.Qualifiers,
+				)
+				require.Equal(t, map[string]string{"team": "a"}, brigadeEvent.Labels)
+			},
+		},
+		{
+			name: "extension with no value",
+			// No details of the request are available
+			ctx: context.Background(),
+			config: EnricherConfig{
+				Extensions: []EnrichmentField{EnrichmentFieldSender},
+			},
+			assertions: func(
+				_ cloudEvents.Event,
+				cloudEvent cloudEvents.Event,
+				_ sdk.Event,
+			) {
+				require.Empty(t, cloudEvent.Extensions())
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			e, ok := NewEnricher(testCase.config).(*enricher)
+			require.True(t, ok)
+			e.now = func() time.Time { return now }
+			original := cloudEvents.NewEvent()
+			original.SetID("1234")
+			original.SetSource("foo")
+			original.SetType("bar")
+			original.SetExtension("gatewaysender", "spoofed")
+			cloudEvent := original
+			brigadeEvent := sdk.Event{
+				Qualifiers: map[string]string{"source": "foo"},
+			}
+			e.Enrich(testCase.ctx, &cloudEvent, &brigadeEvent)
+			testCase.assertions(original, cloudEvent, brigadeEvent)
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package cloudevents
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+)
+
+const (
+	// partialFanOutTTL is how long the Brigade Events created from a CloudEvent
+	// that could not be sent to every Backend it was routed to are remembered.
+	partialFanOutTTL = time.Hour
+	// maxPartialFanOuts is the maximum number of CloudEvents whose Brigade
+	// Events are remembered at once.
+	maxPartialFanOuts = 10000
+)
+
+// fanOutKey identifies a CloudEvent. Per the CloudEvents spec, a CloudEvent is
+// uniquely identified by its source and ID, so a sender retrying a CloudEvent
+// sends one with the same source and ID. Because those are chosen by the
+// sender, CloudEvents are also distinguished by the tenant they were sent to
+// and by who sent them so that no one can affect where anyone else's
+// CloudEvents are sent.
+type fanOutKey struct {
+	tenant string
+	sender string
+	source string
+	id     string
+}
+
+// newFanOutKey returns the fanOutKey identifying the provided CloudEvent,
+// which was received in the course of the request carried by the provided
+// context.
+func newFanOutKey(ctx context.Context, event cloudEvents.Event) fanOutKey {
+	req := audit.RequestFromContext(ctx)
+	return fanOutKey{
+		tenant: req.Tenant,
+		sender: req.Sender,
+		source: event.Source(),
+		id:     event.ID(),
+	}
+}
+
+// partialFanOut records the Brigade Events created from a CloudEvent in each
+// of the Backends in which they were created successfully.
+type partialFanOut struct {
+	created map[string][]CreatedEvent
+	expires time.Time
+}
+
+// fanOutLock serializes fanning out the same CloudEvent.
+type fanOutLock struct {
+	ch chan struct{}
+	// refs is the number of callers holding or waiting for the lock.
+	refs int
+}
+
+// partialFanOuts remembers the Brigade Events created from CloudEvents that
+// could not be sent to every Backend they were routed to so that, if the
+// sender retries, Brigade Events are only created in the Backends that failed
+// and are not duplicated in the others. CloudEvents are only remembered by the
+// replica of the gateway that handled them and only for partialFanOutTTL.
+type partialFanOuts struct {
+	mu       sync.Mutex
+	fanOuts  map[fanOutKey]partialFanOut
+	locks    map[fanOutKey]*fanOutLock
+	maxCount int
+	ttl      time.Duration
+	// now is overridable for testing purposes
+	now func() time.Time
+}
+
+// newPartialFanOuts returns an empty partialFanOuts.
+func newPartialFanOuts() *partialFanOuts {
+	return &partialFanOuts{
+		fanOuts:  map[fanOutKey]partialFanOut{},
+		locks:    map[fanOutKey]*fanOutLock{},
+		maxCount: maxPartialFanOuts,
+		ttl:      partialFanOutTTL,
+		now:      time.Now,
+	}
+}
+
+// lock waits until no other caller holds the lock for the CloudEvent
+// identified by the provided key, then acquires it so that the same CloudEvent
+// isn't fanned out by more than one caller at a time. Unless the provided
+// context is canceled first, in which case its error is returned, a function
+// that releases the lock is returned.
+func (p *partialFanOuts) lock(
+	ctx context.Context,
+	key fanOutKey,
+) (func(), error) {
+	p.mu.Lock()
+	lock, ok := p.locks[key]
+	if !ok {
+		lock = &fanOutLock{ch: make(chan struct{}, 1)}
+		p.locks[key] = lock
+	}
+	lock.refs++
+	p.mu.Unlock()
+	release := func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if lock.refs--; lock.refs == 0 {
+			delete(p.locks, key)
+		}
+	}
+	select {
+	case lock.ch <- struct{}{}:
+		return func() {
+			<-lock.ch
+			release()
+		}, nil
+	case <-ctx.Done():
+		release()
+		return nil, ctx.Err()
+	}
+}
+
+// get returns the Brigade Events previously created from the CloudEvent
+// identified by the provided key, indexed by the name of the Backend they were
+// created in. If none are remembered, an empty map is returned.
+func (p *partialFanOuts) get(key fanOutKey) map[string][]CreatedEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fanOut, ok := p.fanOuts[key]
+	if !ok || !p.now().Before(fanOut.expires) {
+		return map[string][]CreatedEvent{}
+	}
+	return fanOut.created
+}
+
+// set remembers the Brigade Events created from the CloudEvent identified by
+// the provided key, indexed by the name of the Backend they were created in.
+// If no more CloudEvents can be remembered, even after forgetting expired
+// ones, the CloudEvent is not remembered.
+func (p *partialFanOuts) set(
+	key fanOutKey,
+	created map[string][]CreatedEvent,
+) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	if _, ok := p.fanOuts[key]; !ok && len(p.fanOuts) >= p.maxCount {
+		for k, fanOut := range p.fanOuts {
+			if !now.Before(fanOut.expires) {
+				delete(p.fanOuts, k)
+			}
+		}
+		if len(p.fanOuts) >= p.maxCount {
+			return
+		}
+	}
+	p.fanOuts[key] = partialFanOut{
+		created: created,
+		expires: now.Add(p.ttl),
+	}
+}
+
+// delete forgets the CloudEvent identified by the provided key.
+func (p *partialFanOuts) delete(key fanOutKey) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.fanOuts, key)
+}
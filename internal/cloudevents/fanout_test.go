@@ -0,0 +1,91 @@
+package cloudevents
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFanOutKey(t *testing.T) {
+	event := cloudEvents.NewEvent()
+	event.SetID("1234")
+	event.SetSource("foo")
+	ctx := audit.ContextWithRequest(
+		context.Background(),
+		audit.Request{Tenant: "italian", Sender: "ci"},
+	)
+	require.Equal(
+		t,
+		fanOutKey{tenant: "italian", sender: "ci", source: "foo", id: "1234"},
+		newFanOutKey(ctx, event),
+	)
+}
+
+func TestPartialFanOuts(t *testing.T) {
+	newKey := func(id string) fanOutKey {
+		return fanOutKey{tenant: "italian", sender: "ci", source: "foo", id: id}
+	}
+	created := map[string][]CreatedEvent{
+		"staging": {{ID: "abc", ProjectID: "italian", Backend: "staging"}},
+	}
+	now := time.Now()
+	p := newPartialFanOuts()
+	p.maxCount = 1
+	p.now = func() time.Time { return now }
+
+	// Remembered CloudEvents are identified by tenant, sender, source, and ID
+	p.set(newKey("1"), created)
+	require.Equal(t, created, p.get(newKey("1")))
+	require.Empty(t, p.get(newKey("2")))
+	otherTenantKey := newKey("1")
+	otherTenantKey.tenant = "mexican"
+	require.Empty(t, p.get(otherTenantKey))
+
+	// Nothing more is remembered while full
+	p.set(newKey("2"), created)
+	require.Empty(t, p.get(newKey("2")))
+
+	// Expired CloudEvents are forgotten and make room for others
+	now = now.Add(partialFanOutTTL)
+	require.Empty(t, p.get(newKey("1")))
+	p.set(newKey("2"), created)
+	require.Equal(t, created, p.get(newKey("2")))
+
+	p.delete(newKey("2"))
+	require.Empty(t, p.get(newKey("2")))
+}
+
+func TestPartialFanOutsLock(t *testing.T) {
+	key := fanOutKey{source: "foo", id: "1234"}
+	p := newPartialFanOuts()
+	unlock, err := p.lock(context.Background(), key)
+	require.NoError(t, err)
+	// Other CloudEvents aren't affected
+	otherUnlock, err := p.lock(context.Background(), fanOutKey{id: "5678"})
+	require.NoError(t, err)
+	otherUnlock()
+	// The same CloudEvent can't be locked again until it's unlocked
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = p.lock(ctx, key)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	locked := make(chan struct{})
+	go func() {
+		unlock, err := p.lock(context.Background(), key)
+		require.NoError(t, err)
+		unlock()
+		close(locked)
+	}()
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "CloudEvent was not locked after being unlocked")
+	}
+	// Locks are forgotten once no one holds or awaits them
+	require.Empty(t, p.locks)
+}
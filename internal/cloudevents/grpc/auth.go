@@ -0,0 +1,186 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	ourCloudHTTP "github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/http" // nolint: lll
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// requestIDMetadataKey is the metadata key from which the ID of an RPC is read,
+// if the client provided one.
+const requestIDMetadataKey = "x-request-id"
+
+// authenticateUnary is a grpc.UnaryServerInterceptor that conditionally allows
+// or disallows a unary RPC on the basis of a recognized token having been
+// provided.
+func (s *server) authenticateUnary(
+	ctx context.Context,
+	req interface{},
+	_ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	ctx, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// authenticateStream is a grpc.StreamServerInterceptor that conditionally
+// allows or disallows a streaming RPC on the basis of a recognized token having
+// been provided.
+func (s *server) authenticateStream(
+	srv interface{},
+	stream grpc.ServerStream,
+	_ *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	ctx, err := s.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(
+		srv,
+		&serverStream{
+			ServerStream: stream,
+			ctx:          ctx,
+		},
+	)
+}
+
+// serverStream is a grpc.ServerStream whose context can be overridden.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// authenticate looks for a bearer token in the "authorization" metadata entry
+// and returns an error if none was provided or if the one provided is not
+// recognized. Otherwise, it returns a copy of the provided context whose logger
+// and audit details identify the RPC and the authenticated sender.
+func (s *server) authenticate(ctx context.Context) (context.Context, error) {
+	var providedToken, requestID string
+	// Getting values from nil metadata is safe
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if valueParts := strings.SplitN(value, " ", 2); len(valueParts) == 2 &&
+			strings.ToLower(valueParts[0]) == "bearer" {
+			providedToken = valueParts[1]
+			break
+		}
+	}
+	if values := md.Get(requestIDMetadataKey); len(values) > 0 {
+		requestID = values[0]
+	}
+	if requestID == "" {
+		requestID = uuid.New().String()
+	}
+	ctx = logging.ContextWithFields(ctx, logging.RequestID(requestID))
+	auditReq := audit.Request{
+		ID:        requestID,
+		Transport: audit.TransportGRPC,
+	}
+	auditReq.ClientIP = s.clientIP(ctx, md)
+	ctx = audit.ContextWithRequest(ctx, auditReq)
+	if !s.config.IPRules.Allows(net.ParseIP(auditReq.ClientIP)) {
+		logging.FromContext(ctx).Info("rpc denied: client IP not allowed")
+		s.auditSink.Write(
+			ctx,
+			audit.Record{
+				Decision: audit.DecisionRejected,
+				Reason:   "client_ip_not_allowed",
+			},
+		)
+		return ctx, status.Error(codes.PermissionDenied, "client IP not allowed")
+	}
+	// If no token was provided, then access is denied
+	if providedToken == "" {
+		logging.FromContext(ctx).Info("rpc denied: no bearer token provided")
+		s.auditSink.Write(
+			ctx,
+			audit.Record{
+				Decision: audit.DecisionRejected,
+				Reason:   "missing_token",
+			},
+		)
+		return ctx, status.Error(codes.Unauthenticated, "no bearer token provided")
+	}
+	sender, err := s.tokenFilterConfig.Authenticate(
+		ctx,
+		providedToken,
+		net.ParseIP(auditReq.ClientIP),
+	)
+	// A sender is identified even if their token may not be used at present so
+	// that rejections can be attributed
+	if sender != "" {
+		auditReq.Sender = sender
+		auditReq.AuthMethod = audit.AuthMethodToken
+		ctx = audit.ContextWithRequest(ctx, auditReq)
+		ctx = logging.ContextWithFields(ctx, logging.Sender(sender))
+	}
+	if err != nil {
+		logging.FromContext(ctx).Info(
+			"rpc denied",
+			zap.String("authOutcome", ourCloudHTTP.AuthOutcome(err)),
+		)
+		s.auditSink.Write(
+			ctx,
+			audit.Record{
+				Decision: audit.DecisionRejected,
+				Reason:   ourCloudHTTP.AuthOutcome(err),
+			},
+		)
+		if errors.Is(err, context.Canceled) ||
+			errors.Is(err, context.DeadlineExceeded) {
+			return ctx, status.FromContextError(err).Err()
+		}
+		if errors.Is(err, ourCloudHTTP.ErrInvalidToken) {
+			return ctx, status.Error(codes.Unauthenticated, "invalid bearer token")
+		}
+		return ctx, status.Errorf(codes.Unauthenticated, "bearer %s", err)
+	}
+	return ctx, nil
+}
+
+// clientIP returns the IP address of the client that made the RPC with the
+// provided context and metadata. If a ClientIPResolver is configured, it is
+// consulted exactly as it would be for an HTTP/S request with the same remote
+// address and forwarding headers, which gRPC exposes as metadata.
+func (s *server) clientIP(ctx context.Context, md metadata.MD) string {
+	var remoteAddr string
+	if p, ok := peer.FromContext(ctx); ok {
+		remoteAddr = p.Addr.String()
+	}
+	if s.config.ClientIPResolver != nil {
+		return s.config.ClientIPResolver.ClientIP(
+			&http.Request{
+				RemoteAddr: remoteAddr,
+				Header: http.Header{
+					"Forwarded":       md.Get("forwarded"),
+					"X-Forwarded-For": md.Get("x-forwarded-for"),
+				},
+			},
+		)
+	}
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
@@ -0,0 +1,151 @@
+package grpc
+
+import (
+	"context"
+	"io"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents"
+	format "github.com/cloudevents/sdk-go/binding/format/protobuf/v2"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// gatewayServer is the server API for the Gateway service described in
+// gateway.proto.
+type gatewayServer interface {
+	publish(context.Context, *pb.CloudEvent) (*emptypb.Empty, error)
+	publishBatch(grpc.ServerStream) error
+}
+
+// gatewayServiceDesc describes the Gateway service from gateway.proto. It is
+// written by hand in lieu of generating code from gateway.proto because the
+// service is small and all message types it uses are already available as Go
+// types.
+var gatewayServiceDesc = grpc.ServiceDesc{
+	ServiceName: "brigade.cloudevents.v1.Gateway",
+	HandlerType: (*gatewayServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Publish",
+			Handler:    publishHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PublishBatch",
+			Handler:       publishBatchHandler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "gateway.proto",
+}
+
+func publishHandler(
+	srv interface{},
+	ctx context.Context,
+	dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (interface{}, error) {
+	in := &pb.CloudEvent{}
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	gs := srv.(gatewayServer) // nolint: forcetypeassert
+	if interceptor == nil {
+		return gs.publish(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/brigade.cloudevents.v1.Gateway/Publish",
+	}
+	return interceptor(
+		ctx,
+		in,
+		info,
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return gs.publish(ctx, req.(*pb.CloudEvent)) // nolint: forcetypeassert
+		},
+	)
+}
+
+func publishBatchHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(gatewayServer).publishBatch(stream) // nolint: forcetypeassert
+}
+
+func (s *server) publish(
+	ctx context.Context,
+	in *pb.CloudEvent,
+) (*emptypb.Empty, error) {
+	if err := s.handle(ctx, in); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *server) publishBatch(stream grpc.ServerStream) error {
+	for i := 0; ; i++ {
+		in := &pb.CloudEvent{}
+		if err := stream.RecvMsg(in); err != nil {
+			if err == io.EOF {
+				return stream.SendMsg(&emptypb.Empty{})
+			}
+			return err
+		}
+		if err := s.handle(stream.Context(), in); err != nil {
+			st := status.Convert(err)
+			return status.Errorf(
+				st.Code(),
+				"error handling event %d (id %q) in batch: %s",
+				i,
+				in.Id,
+				st.Message(),
+			)
+		}
+	}
+}
+
+// handle converts the provided protobuf representation of a CloudEvent to a
+// cloudEvents.Event and, if it is within configured rate limits, hands it off
+// to the service.
+func (s *server) handle(ctx context.Context, in *pb.CloudEvent) error {
+	event, err := format.FromProto(in)
+	if err != nil {
+		return status.Errorf(
+			codes.InvalidArgument,
+			"error converting protobuf to CloudEvent: %s",
+			err,
+		)
+	}
+	if err = event.Validate(); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid CloudEvent: %s", err)
+	}
+	if s.config.RateLimitFilter != nil {
+		if allowed, retryAfter :=
+			s.config.RateLimitFilter.AllowEvent(ctx, event.Source()); !allowed {
+			return status.Errorf(
+				codes.ResourceExhausted,
+				"rate limit exceeded; retry after %s",
+				retryAfter,
+			)
+		}
+	}
+	if err = s.service.Handle(ctx, *event); err != nil {
+		if errors.Is(err, cloudevents.ErrSaturated) {
+			return status.Error(
+				codes.Unavailable,
+				cloudevents.ErrSaturated.Error(),
+			)
+		}
+		var attrErr *cloudevents.AttributeError
+		if errors.As(err, &attrErr) {
+			return status.Error(codes.InvalidArgument, attrErr.Error())
+		}
+		// The service has already logged the details.
+		return status.Error(codes.Internal, "error handling CloudEvent")
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents"
+	ourCloudHTTP "github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/http" // nolint: lll
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// ServerConfig represents optional configuration for a gRPC server.
+type ServerConfig struct {
+	// Port specifies the port the server should bind to / listen on when it is
+	// run standalone using ListenAndServe. A value of zero indicates the server
+	// will be multiplexed with an HTTP/S server instead.
+	Port int
+	// TLSEnabled specifies whether the server should use TLS when it is run
+	// standalone using ListenAndServe.
+	TLSEnabled bool
+	// TLSCertPath is the path to a PEM-encoded x509 certificate that can be used
+	// for TLS.
+	TLSCertPath string
+	// TLSKeyPath is the path to a PEM-encoded x509 private key that can be used
+	// for TLS.
+	TLSKeyPath string
+	// ClientIPResolver, if non-nil, determines the IP address of the client
+	// that made an RPC from the address of its connection and any forwarding
+	// metadata, just as it does for HTTP/S requests. Otherwise, clients are
+	// identified by the address of their connection.
+	ClientIPResolver ourCloudHTTP.ClientIPResolver
+	// IPRules govern the IP addresses of the clients RPCs are accepted from.
+	IPRules ourCloudHTTP.IPRules
+	// RateLimitFilter, if non-nil, applies rate limits to every CloudEvent
+	// received, including each CloudEvent in a batch.
+	RateLimitFilter ourCloudHTTP.RateLimitFilter
+}
+
+// Server is an interface for a gRPC server that receives CloudEvents. Because
+// it also implements http.Handler, it can either be run standalone on its own
+// port or be multiplexed with an HTTP/S server.
+type Server interface {
+	http.Handler
+	// ListenAndServe runs the gRPC server on its own port until the provided
+	// context is canceled. This function always returns a non-nil error.
+	ListenAndServe(ctx context.Context) error
+}
+
+type server struct {
+	config            ServerConfig
+	service           cloudevents.Service
+	tokenFilterConfig ourCloudHTTP.TokenFilterConfig
+	auditSink         audit.Sink
+	grpcServer        *grpc.Server
+}
+
+// NewServer returns a new gRPC server that hands CloudEvents to the provided
+// cloudevents.Service. Callers are authenticated using the same tokens as the
+// HTTP/S server's token filter. RPCs that fail authentication are recorded
+// using the provided audit.Sink.
+func NewServer(
+	service cloudevents.Service,
+	tokenFilterConfig ourCloudHTTP.TokenFilterConfig,
+	auditSink audit.Sink,
+	config *ServerConfig,
+) (Server, error) {
+	if config == nil {
+		config = &ServerConfig{}
+	}
+	s := &server{
+		config:            *config,
+		service:           service,
+		tokenFilterConfig: tokenFilterConfig,
+		auditSink:         auditSink,
+	}
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(s.authenticateUnary),
+		grpc.StreamInterceptor(s.authenticateStream),
+	}
+	if s.config.TLSEnabled {
+		creds, err := credentials.NewServerTLSFromFile(
+			s.config.TLSCertPath,
+			s.config.TLSKeyPath,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "error loading TLS cert and key")
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	s.grpcServer = grpc.NewServer(opts...)
+	s.grpcServer.RegisterService(&gatewayServiceDesc, s)
+	return s, nil
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.grpcServer.ServeHTTP(w, r)
+}
+
+func (s *server) ListenAndServe(ctx context.Context) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.config.Port))
+	if err != nil {
+		return errors.Wrapf(err, "error listening on port %d", s.config.Port)
+	}
+	logging.FromContext(ctx).Info(
+		"gRPC server is listening",
+		zap.String("address", fmt.Sprintf("0.0.0.0:%d", s.config.Port)),
+		zap.Bool("tlsEnabled", s.config.TLSEnabled),
+	)
+	errCh := make(chan error)
+	go func() {
+		err := s.grpcServer.Serve(listener)
+		select {
+		case errCh <- err:
+		case <-ctx.Done():
+		}
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		s.grpcServer.GracefulStop()
+		return ctx.Err()
+	}
+}
+
+// IsGRPCRequest returns a bool indicating whether the provided HTTP request is
+// a gRPC request. This is useful for routing gRPC requests to a Server that is
+// multiplexed with an HTTP/S server.
+func IsGRPCRequest(r *http.Request) bool {
+	return r.ProtoMajor == 2 &&
+		r.Method == http.MethodPost &&
+		strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
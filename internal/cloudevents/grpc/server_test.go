@@ -0,0 +1,489 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents"
+	ourCloudHTTP "github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/http" // nolint: lll
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/ratelimit"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type mockService struct {
+	HandleFn          func(context.Context, cloudEvents.Event) error
+	HandleWithReplyFn func(
+		context.Context,
+		cloudEvents.Event,
+	) (*cloudEvents.Event, error)
+}
+
+func (m *mockService) Handle(
+	ctx context.Context,
+	event cloudEvents.Event,
+) error {
+	return m.HandleFn(ctx, event)
+}
+
+func (m *mockService) HandleWithReply(
+	ctx context.Context,
+	event cloudEvents.Event,
+) (*cloudEvents.Event, error) {
+	return m.HandleWithReplyFn(ctx, event)
+}
+
+const testToken = "foo"
+
+// testClientConn starts the provided server on an in-memory listener and
+// returns a client connection to it.
+func testClientConn(t *testing.T, s *server) *grpc.ClientConn {
+	listener := bufconn.Listen(1024 * 1024)
+	go s.grpcServer.Serve(listener) // nolint: errcheck
+	t.Cleanup(s.grpcServer.Stop)
+	conn, err := grpc.Dial(
+		"bufnet",
+		grpc.WithContextDialer(
+			func(context.Context, string) (net.Conn, error) {
+				return listener.Dial()
+			},
+		),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func testServer(t *testing.T, handleFn func(cloudEvents.Event) error) *server {
+	tokenFilterConfig := ourCloudHTTP.NewTokenFilterConfig()
+	require.NoError(
+		t,
+		tokenFilterConfig.AddToken(
+			"example/uri",
+			ourCloudHTTP.Token{Value: testToken},
+		),
+	)
+	s, err := NewServer(
+		&mockService{
+			HandleFn: func(_ context.Context, event cloudEvents.Event) error {
+				return handleFn(event)
+			},
+		},
+		tokenFilterConfig,
+		audit.NewNopSink(),
+		nil,
+	)
+	require.NoError(t, err)
+	return s.(*server) // nolint: forcetypeassert
+}
+
+func testEvent(id string) *pb.CloudEvent {
+	return &pb.CloudEvent{
+		Id:          id,
+		Source:      "example/uri",
+		SpecVersion: "1.0",
+		Type:        "example.type",
+	}
+}
+
+func authenticatedContext(token string) context.Context {
+	return metadata.AppendToOutgoingContext(
+		context.Background(),
+		"authorization",
+		"Bearer "+token,
+	)
+}
+
+func TestNewServer(t *testing.T) {
+	testService := &mockService{}
+	testTokenFilterConfig := ourCloudHTTP.NewTokenFilterConfig()
+	s, err := NewServer(
+		testService,
+		testTokenFilterConfig,
+		audit.NewNopSink(),
+		&ServerConfig{Port: 8081},
+	)
+	require.NoError(t, err)
+	srv, ok := s.(*server)
+	require.True(t, ok)
+	require.Equal(t, 8081, srv.config.Port)
+	require.Same(t, testService, srv.service)
+	require.Equal(t, testTokenFilterConfig, srv.tokenFilterConfig)
+	require.NotNil(t, srv.auditSink)
+	require.NotNil(t, srv.grpcServer)
+}
+
+func TestPublish(t *testing.T) {
+	testCases := []struct {
+		name       string
+		ctx        context.Context
+		event      *pb.CloudEvent
+		handleErr  error
+		assertions func(handled bool, err error)
+	}{
+		{
+			name:  "no token provided",
+			ctx:   context.Background(),
+			event: testEvent("1234"),
+			assertions: func(handled bool, err error) {
+				require.Equal(t, codes.Unauthenticated, status.Code(err))
+				require.False(t, handled)
+			},
+		},
+		{
+			name:  "invalid token provided",
+			ctx:   authenticatedContext("bogus-token"),
+			event: testEvent("1234"),
+			assertions: func(handled bool, err error) {
+				require.Equal(t, codes.Unauthenticated, status.Code(err))
+				require.False(t, handled)
+			},
+		},
+		{
+			name:  "invalid CloudEvent",
+			ctx:   authenticatedContext(testToken),
+			event: &pb.CloudEvent{SpecVersion: "1.0"},
+			assertions: func(handled bool, err error) {
+				require.Equal(t, codes.InvalidArgument, status.Code(err))
+				require.False(t, handled)
+			},
+		},
+		{
+			name:      "error handling CloudEvent",
+			ctx:       authenticatedContext(testToken),
+			event:     testEvent("1234"),
+			handleErr: errors.New("something went wrong"),
+			assertions: func(handled bool, err error) {
+				require.Equal(t, codes.Internal, status.Code(err))
+				require.True(t, handled)
+			},
+		},
+		{
+			name:      "gateway saturated",
+			ctx:       authenticatedContext(testToken),
+			event:     testEvent("1234"),
+			handleErr: fmt.Errorf("wrapped: %w", cloudevents.ErrSaturated),
+			assertions: func(handled bool, err error) {
+				require.Equal(t, codes.Unavailable, status.Code(err))
+				require.True(t, handled)
+			},
+		},
+		{
+			name:  "invalid attribute",
+			ctx:   authenticatedContext(testToken),
+			event: testEvent("1234"),
+			handleErr: &cloudevents.AttributeError{
+				Attribute: "subject",
+				Reason:    "something went wrong",
+			},
+			assertions: func(handled bool, err error) {
+				require.Equal(t, codes.InvalidArgument, status.Code(err))
+				require.True(t, handled)
+			},
+		},
+		{
+			name:  "success",
+			ctx:   authenticatedContext(testToken),
+			event: testEvent("1234"),
+			assertions: func(handled bool, err error) {
+				require.NoError(t, err)
+				require.True(t, handled)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var handled bool
+			s := testServer(t, func(event cloudEvents.Event) error {
+				require.Equal(t, testCase.event.Id, event.ID())
+				handled = true
+				return testCase.handleErr
+			})
+			conn := testClientConn(t, s)
+			err := conn.Invoke(
+				testCase.ctx,
+				"/brigade.cloudevents.v1.Gateway/Publish",
+				testCase.event,
+				&emptypb.Empty{},
+			)
+			testCase.assertions(handled, err)
+		})
+	}
+}
+
+func TestPublishBatch(t *testing.T) {
+	testCases := []struct {
+		name            string
+		ctx             context.Context
+		events          []*pb.CloudEvent
+		rateLimitFilter ourCloudHTTP.RateLimitFilter
+		assertions      func(handledIDs []string, err error)
+	}{
+		{
+			name:   "no token provided",
+			ctx:    context.Background(),
+			events: []*pb.CloudEvent{testEvent("1")},
+			assertions: func(handledIDs []string, err error) {
+				require.Equal(t, codes.Unauthenticated, status.Code(err))
+				require.Empty(t, handledIDs)
+			},
+		},
+		{
+			name: "invalid CloudEvent in batch",
+			ctx:  authenticatedContext(testToken),
+			events: []*pb.CloudEvent{
+				testEvent("1"),
+				{Id: "2", SpecVersion: "1.0"},
+				testEvent("3"),
+			},
+			assertions: func(handledIDs []string, err error) {
+				require.Equal(t, codes.InvalidArgument, status.Code(err))
+				require.Contains(t, err.Error(), `event 1 (id "2")`)
+				require.Equal(t, []string{"1"}, handledIDs)
+			},
+		},
+		{
+			name: "rate limit exceeded in batch",
+			ctx:  authenticatedContext(testToken),
+			events: []*pb.CloudEvent{
+				testEvent("1"),
+				testEvent("2"),
+				testEvent("3"),
+			},
+			rateLimitFilter: ourCloudHTTP.NewRateLimitFilter(
+				ourCloudHTTP.RateLimitFilterConfig{
+					Key:         ourCloudHTTP.RateLimitKeySource,
+					PerKeyLimit: ratelimit.Limit{Rate: 0.001, Burst: 2},
+				},
+				ratelimit.NewMemoryBackend(),
+				audit.NewNopSink(),
+			),
+			assertions: func(handledIDs []string, err error) {
+				require.Equal(t, codes.ResourceExhausted, status.Code(err))
+				require.Contains(t, err.Error(), `event 2 (id "3")`)
+				require.Equal(t, []string{"1", "2"}, handledIDs)
+			},
+		},
+		{
+			name: "success",
+			ctx:  authenticatedContext(testToken),
+			events: []*pb.CloudEvent{
+				testEvent("1"),
+				testEvent("2"),
+				testEvent("3"),
+			},
+			assertions: func(handledIDs []string, err error) {
+				require.NoError(t, err)
+				require.Equal(t, []string{"1", "2", "3"}, handledIDs)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			handledIDs := []string{}
+			s := testServer(t, func(event cloudEvents.Event) error {
+				handledIDs = append(handledIDs, event.ID())
+				return nil
+			})
+			s.config.RateLimitFilter = testCase.rateLimitFilter
+			conn := testClientConn(t, s)
+			stream, err := conn.NewStream(
+				testCase.ctx,
+				&gatewayServiceDesc.Streams[0],
+				"/brigade.cloudevents.v1.Gateway/PublishBatch",
+			)
+			require.NoError(t, err)
+			for _, event := range testCase.events {
+				if err = stream.SendMsg(event); err != nil {
+					break
+				}
+			}
+			require.NoError(t, stream.CloseSend())
+			err = stream.RecvMsg(&emptypb.Empty{})
+			testCase.assertions(handledIDs, err)
+		})
+	}
+}
+
+func TestIsGRPCRequest(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "/", nil)
+	require.NoError(t, err)
+	req.ProtoMajor = 2
+	req.Header.Set("Content-Type", "application/grpc+proto")
+	require.True(t, IsGRPCRequest(req))
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+	require.False(t, IsGRPCRequest(req))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.ProtoMajor = 1
+	require.False(t, IsGRPCRequest(req))
+}
+
+type mockAuditSink struct {
+	records []audit.Record
+}
+
+func (m *mockAuditSink) Write(_ context.Context, record audit.Record) {
+	m.records = append(m.records, record)
+}
+
+func (m *mockAuditSink) Close() error {
+	return nil
+}
+
+func TestAuthenticateAudit(t *testing.T) {
+	incomingContext := func(token string, kv ...string) context.Context {
+		ctx := peer.NewContext(
+			context.Background(),
+			&peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 54321}},
+		)
+		md := metadata.Pairs(
+			append([]string{requestIDMetadataKey, "1234"}, kv...)...,
+		)
+		if token != "" {
+			md.Append("authorization", "Bearer "+token)
+		}
+		return metadata.NewIncomingContext(ctx, md)
+	}
+	denied, err := ourCloudHTTP.ParseNetworks([]string{"10.0.0.1"})
+	require.NoError(t, err)
+	deniedBehindProxy, err := ourCloudHTTP.ParseNetworks([]string{"192.0.2.1"})
+	require.NoError(t, err)
+	trustedProxies, err := ourCloudHTTP.ParseNetworks([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	testCases := []struct {
+		name             string
+		ctx              context.Context
+		clientIPResolver ourCloudHTTP.ClientIPResolver
+		ipRules          ourCloudHTTP.IPRules
+		assertions       func(context.Context, []audit.Record)
+	}{
+		{
+			name:    "client IP not allowed",
+			ctx:     incomingContext(testToken),
+			ipRules: ourCloudHTTP.IPRules{Denied: denied},
+			assertions: func(_ context.Context, records []audit.Record) {
+				require.Equal(
+					t,
+					[]audit.Record{
+						{
+							Decision: audit.DecisionRejected,
+							Reason:   "client_ip_not_allowed",
+						},
+					},
+					records,
+				)
+			},
+		},
+		{
+			name: "client IP behind trusted proxy not allowed",
+			ctx: incomingContext(
+				testToken,
+				"x-forwarded-for", "192.0.2.1",
+			),
+			clientIPResolver: ourCloudHTTP.NewClientIPResolver(trustedProxies),
+			ipRules:          ourCloudHTTP.IPRules{Denied: deniedBehindProxy},
+			assertions: func(ctx context.Context, records []audit.Record) {
+				require.Equal(t, "192.0.2.1", audit.RequestFromContext(ctx).ClientIP)
+				require.Equal(
+					t,
+					[]audit.Record{
+						{
+							Decision: audit.DecisionRejected,
+							Reason:   "client_ip_not_allowed",
+						},
+					},
+					records,
+				)
+			},
+		},
+		{
+			name: "forwarding metadata from untrusted client ignored",
+			ctx: incomingContext(
+				testToken,
+				"x-forwarded-for", "192.0.2.1",
+			),
+			clientIPResolver: ourCloudHTTP.NewClientIPResolver(nil),
+			ipRules:          ourCloudHTTP.IPRules{Denied: deniedBehindProxy},
+			assertions: func(ctx context.Context, records []audit.Record) {
+				require.Equal(t, "10.0.0.1", audit.RequestFromContext(ctx).ClientIP)
+				require.Empty(t, records)
+			},
+		},
+		{
+			name: "no token provided",
+			ctx:  incomingContext(""),
+			assertions: func(_ context.Context, records []audit.Record) {
+				require.Equal(
+					t,
+					[]audit.Record{
+						{
+							Decision: audit.DecisionRejected,
+							Reason:   "missing_token",
+						},
+					},
+					records,
+				)
+			},
+		},
+		{
+			name: "invalid token provided",
+			ctx:  incomingContext("bogus-token"),
+			assertions: func(_ context.Context, records []audit.Record) {
+				require.Equal(
+					t,
+					[]audit.Record{
+						{
+							Decision: audit.DecisionRejected,
+							Reason:   "invalid_token",
+						},
+					},
+					records,
+				)
+			},
+		},
+		{
+			name: "valid token provided",
+			ctx:  incomingContext(testToken),
+			assertions: func(ctx context.Context, records []audit.Record) {
+				require.Empty(t, records)
+				require.Equal(
+					t,
+					audit.Request{
+						ID:         "1234",
+						Transport:  audit.TransportGRPC,
+						ClientIP:   "10.0.0.1",
+						Sender:     "example/uri",
+						AuthMethod: audit.AuthMethodToken,
+					},
+					audit.RequestFromContext(ctx),
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			auditSink := &mockAuditSink{}
+			s := testServer(t, nil)
+			s.auditSink = auditSink
+			s.config.ClientIPResolver = testCase.clientIPResolver
+			s.config.IPRules = testCase.ipRules
+			ctx, _ := s.authenticate(testCase.ctx)
+			testCase.assertions(ctx, auditSink.records)
+		})
+	}
+}
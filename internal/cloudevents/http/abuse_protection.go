@@ -2,19 +2,39 @@ package http
 
 import (
 	"fmt"
-	"log"
 	"net/http"
 	"runtime"
 	"strconv"
 	"time"
 
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/shutdown"
 	"github.com/brigadecore/brigade-foundations/version"
 	cloudHTTP "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"go.uber.org/zap"
 )
 
-// ValidateEventSource responds to HTTP OPTIONS requests sent by a CloudEvents
-// 1.0 source as part of the spec's abuse protection scheme.
-func ValidateEventSource(w http.ResponseWriter, r *http.Request) {
+// shutdownKindValidationCallback is the kind of work tracked for every
+// asynchronous handshake callback that has not yet completed.
+const shutdownKindValidationCallback = "validation_callback"
+
+// NewEventSourceValidator returns an http.HandlerFunc that responds to HTTP
+// OPTIONS requests sent by a CloudEvents 1.0 source as part of the spec's abuse
+// protection scheme. Callbacks made to complete asynchronous handshakes are
+// tracked as in-flight work using the provided shutdown.Tracker.
+func NewEventSourceValidator(
+	shutdownTracker shutdown.Tracker,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		validateEventSource(w, r, shutdownTracker)
+	}
+}
+
+func validateEventSource(
+	w http.ResponseWriter,
+	r *http.Request,
+	shutdownTracker shutdown.Tracker,
+) {
 	if r.Method != http.MethodOptions {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
@@ -31,6 +51,7 @@ func ValidateEventSource(w http.ResponseWriter, r *http.Request) {
 	// Complete the handshake asynchronously if a callback URL was provided...
 	if callbackURL :=
 		r.Header.Get("WebHook-Request-Callback"); callbackURL != "" {
+		handshakesTotal.WithLabelValues("async").Inc()
 		headers.Set("User-Agent", userAgentHeaderValue())
 		// The spec is somewhat vague here. It says we can send either GET or POST,
 		// but it doesn't explicitly state that the receiver (the event source we're
@@ -39,24 +60,45 @@ func ValidateEventSource(w http.ResponseWriter, r *http.Request) {
 		// both. If one of the two fails, it's logged and it's not a big deal.
 		//
 		// See https://github.com/cloudevents/spec/issues/1018
-		go executeSourceValidationCallback(http.MethodGet, callbackURL, headers)
-		go executeSourceValidationCallback(http.MethodPost, callbackURL, headers)
+		logger := logging.FromContext(r.Context())
+		for _, method := range []string{http.MethodGet, http.MethodPost} {
+			// Tracking begins before the goroutine starts so that a callback can't
+			// be missed by a shutdown that begins in the interim.
+			go executeSourceValidationCallback(
+				logger,
+				method,
+				callbackURL,
+				headers,
+				shutdownTracker.Track(shutdownKindValidationCallback),
+			)
+		}
 		return
 	}
 
+	handshakesTotal.WithLabelValues("sync").Inc()
 	for k := range headers {
 		w.Header().Set(k, headers.Get(k))
 	}
 }
 
-func executeSourceValidationCallback(method, url string, headers http.Header) {
+func executeSourceValidationCallback(
+	logger *zap.Logger,
+	method string,
+	url string,
+	headers http.Header,
+	done func(),
+) {
+	defer done()
+	logger = logger.With(
+		zap.String("method", method),
+		zap.String("callbackURL", url),
+	)
 	req, err := http.NewRequest(method, url, nil)
 	if err != nil {
-		log.Printf(
-			"error preparing HTTP %s for validation callback URL %s: %s",
-			method,
-			url,
-			err,
+		handshakeCallbacksTotal.WithLabelValues(method, "error").Inc()
+		logger.Error(
+			"error preparing HTTP request for validation callback URL",
+			zap.Error(err),
 		)
 		return
 	}
@@ -71,14 +113,25 @@ func executeSourceValidationCallback(method, url string, headers http.Header) {
 	// asynchronous handshakes, this short delay really shouldn't cause any issue
 	// for OTHER CloudEvents 1.0-compatible event sources.
 	<-time.After(10 * time.Second)
-	if _, err = http.DefaultClient.Do(req); err != nil {
-		log.Printf(
-			"error executing HTTP %s for validation callback URL %s: %s",
-			method,
-			url,
-			err,
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		handshakeCallbacksTotal.WithLabelValues(method, "error").Inc()
+		logger.Error(
+			"error executing HTTP request for validation callback URL",
+			zap.Error(err),
 		)
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		handshakeCallbacksTotal.WithLabelValues(method, "rejected").Inc()
+		logger.Warn(
+			"validation callback URL rejected handshake",
+			zap.Int("statusCode", res.StatusCode),
+		)
+		return
 	}
+	handshakeCallbacksTotal.WithLabelValues(method, "accepted").Inc()
 }
 
 func userAgentHeaderValue() string {
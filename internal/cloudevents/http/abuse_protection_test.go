@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/shutdown"
 	cloudHTTP "github.com/cloudevents/sdk-go/v2/protocol/http"
 	"github.com/stretchr/testify/require"
 )
@@ -166,7 +167,7 @@ func TestValidateEventSource(t *testing.T) {
 				defer s.Close()
 			}
 			rr := httptest.NewRecorder()
-			ValidateEventSource(rr, r)
+			NewEventSourceValidator(shutdown.NewTracker())(rr, r)
 			testCase.assertions(ctx, t, rr.Result(), s)
 		})
 	}
@@ -0,0 +1,485 @@
+package http
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	_ "crypto/sha1" // nolint: gosec
+	_ "crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// snsMessageTypeHeader is the header in which Amazon SNS indicates the type
+	// of the message it is delivering.
+	snsMessageTypeHeader = "X-Amz-Sns-Message-Type"
+	// Types of messages delivered by Amazon SNS
+	snsTypeSubscriptionConfirmation = "SubscriptionConfirmation"
+	snsTypeNotification             = "Notification"
+	snsTypeUnsubscribeConfirmation  = "UnsubscribeConfirmation"
+	// snsNotificationEventType is the type of the CloudEvent an SNS notification
+	// is converted to if its message is neither an EventBridge event nor a
+	// CloudEvent.
+	snsNotificationEventType = "aws.sns.notification"
+	// awsAccountExtension and awsRegionExtension are the CloudEvent extension
+	// attributes to which the account and region of an EventBridge event are
+	// mapped.
+	awsAccountExtension = "awsaccount"
+	awsRegionExtension  = "awsregion"
+	// maxSigningCertBytes is the maximum size of an SNS signing certificate.
+	maxSigningCertBytes = 64 * 1024
+	// maxAWSRequestBytes is the maximum size of an SNS message or EventBridge
+	// event. Both are limited to 256 KiB by AWS, but escaping the message of an
+	// SNS message as a JSON string can make it larger.
+	maxAWSRequestBytes = 1024 * 1024
+)
+
+// snsHostPattern matches the hosts of the URLs from which SNS signing
+// certificates are retrieved and at which SNS subscriptions are confirmed.
+var snsHostPattern = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`) // nolint: lll
+
+// snsMessage is a message delivered by Amazon SNS to an HTTP/S endpoint.
+//
+// See https://docs.aws.amazon.com/sns/latest/dg/sns-message-and-json-formats.html // nolint: lll
+type snsMessage struct {
+	Type             string `json:"Type"`
+	MessageID        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicARN         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+	SubscribeURL     string `json:"SubscribeURL"`
+}
+
+// stringToSign returns the string whose signature is the snsMessage's
+// Signature.
+func (s snsMessage) stringToSign() string {
+	fields := []string{"Message", s.Message, "MessageId", s.MessageID}
+	if s.Type == snsTypeNotification {
+		if s.Subject != "" {
+			fields = append(fields, "Subject", s.Subject)
+		}
+	} else {
+		fields = append(fields, "SubscribeURL", s.SubscribeURL)
+	}
+	fields = append(fields, "Timestamp", s.Timestamp)
+	if s.Type != snsTypeNotification {
+		fields = append(fields, "Token", s.Token)
+	}
+	fields = append(fields, "TopicArn", s.TopicARN, "Type", s.Type)
+	return strings.Join(fields, "\n") + "\n"
+}
+
+// cloudEvent converts the notification to a CloudEvent. If the notification's
+// message is an EventBridge event or a CloudEvent in structured mode, that is
+// what it is converted to. Otherwise, the notification's MessageId becomes the
+// CloudEvent's id, its TopicArn becomes the CloudEvent's source, its Subject
+// becomes the CloudEvent's subject, and its message becomes the CloudEvent's
+// data. An error is returned if the resulting CloudEvent is invalid.
+func (s snsMessage) cloudEvent() (cloudEvents.Event, error) {
+	ebEvent := eventBridgeEvent{}
+	if err := json.Unmarshal([]byte(s.Message), &ebEvent); err == nil &&
+		ebEvent.valid() {
+		return ebEvent.cloudEvent()
+	}
+	event := cloudEvents.NewEvent()
+	if strings.Contains(s.Message, `"specversion"`) {
+		if err := json.Unmarshal([]byte(s.Message), &event); err == nil {
+			return event, event.Validate()
+		}
+		event = cloudEvents.NewEvent()
+	}
+	event.SetID(s.MessageID)
+	event.SetSource(s.TopicARN)
+	event.SetType(snsNotificationEventType)
+	if s.Subject != "" {
+		event.SetSubject(s.Subject)
+	}
+	if t, err := time.Parse(time.RFC3339, s.Timestamp); err == nil {
+		event.SetTime(t)
+	}
+	contentType := cloudEvents.TextPlain
+	if json.Valid([]byte(s.Message)) {
+		contentType = cloudEvents.ApplicationJSON
+	}
+	if err := event.SetData(contentType, []byte(s.Message)); err != nil {
+		return event, err
+	}
+	return event, event.Validate()
+}
+
+// eventBridgeEvent is an event delivered by Amazon EventBridge, either via an
+// SNS topic or directly via an API destination.
+//
+// See https://docs.aws.amazon.com/eventbridge/latest/userguide/eb-events.html
+type eventBridgeEvent struct {
+	ID         string          `json:"id"`
+	DetailType string          `json:"detail-type"`
+	Source     string          `json:"source"`
+	Account    string          `json:"account"`
+	Time       time.Time       `json:"time"`
+	Region     string          `json:"region"`
+	Resources  []string        `json:"resources"`
+	Detail     json.RawMessage `json:"detail"`
+}
+
+// valid returns true if the eventBridgeEvent has the fields every EventBridge
+// event has.
+func (e eventBridgeEvent) valid() bool {
+	return e.ID != "" && e.DetailType != "" && e.Source != "" &&
+		len(e.Detail) > 0
+}
+
+// cloudEvent converts the eventBridgeEvent to a CloudEvent. The event's
+// detail-type becomes the CloudEvent's type, its first resource, if any,
+// becomes the CloudEvent's subject, its detail becomes the CloudEvent's data,
+// and its account and region become the CloudEvent's awsaccount and awsregion
+// extension attributes. An error is returned if the resulting CloudEvent is
+// invalid.
+func (e eventBridgeEvent) cloudEvent() (cloudEvents.Event, error) {
+	event := cloudEvents.NewEvent()
+	event.SetID(e.ID)
+	event.SetSource(e.Source)
+	event.SetType(e.DetailType)
+	if len(e.Resources) > 0 {
+		event.SetSubject(e.Resources[0])
+	}
+	if !e.Time.IsZero() {
+		event.SetTime(e.Time)
+	}
+	if e.Account != "" {
+		event.SetExtension(awsAccountExtension, e.Account)
+	}
+	if e.Region != "" {
+		event.SetExtension(awsRegionExtension, e.Region)
+	}
+	if err := event.SetData(
+		cloudEvents.ApplicationJSON,
+		[]byte(e.Detail),
+	); err != nil {
+		return event, err
+	}
+	return event, event.Validate()
+}
+
+// AWSHandlerConfig encapsulates configuration for the AWS handler.
+type AWSHandlerConfig struct {
+	// AllowedTopicARNs, if non-empty, are the ARNs of the only SNS topics whose
+	// messages are accepted.
+	AllowedTopicARNs []string
+}
+
+// awsHandler is an http.Handler that accepts messages delivered by Amazon SNS
+// and events delivered by Amazon EventBridge.
+type awsHandler struct {
+	service cloudevents.Service
+	config  AWSHandlerConfig
+	// client is used to retrieve signing certificates and confirm
+	// subscriptions. It is overridable for testing purposes.
+	client *http.Client
+	// hostPattern matches the hosts client may make requests to. It is
+	// overridable for testing purposes.
+	hostPattern *regexp.Regexp
+	// certs caches signing certificates, indexed by URL.
+	certs   map[string]*x509.Certificate
+	certsMu sync.Mutex
+	// now returns the current time. It is overridable for testing purposes.
+	now func() time.Time
+}
+
+// NewAWSHandler returns an http.Handler that accepts messages delivered by
+// Amazon SNS and events delivered by Amazon EventBridge via API destinations,
+// converts each to a CloudEvent, and handles it using the provided Service.
+// The signature of every SNS message is verified using the certificate SNS
+// signed it with, which is retrieved from SNS the first time it's needed and
+// cached thereafter. Subscriptions are confirmed as soon as SNS requests
+// confirmation.
+func NewAWSHandler(
+	service cloudevents.Service,
+	config AWSHandlerConfig,
+) http.Handler {
+	return &awsHandler{
+		service:     service,
+		config:      config,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		hostPattern: snsHostPattern,
+		certs:       map[string]*x509.Certificate{},
+		now:         time.Now,
+	}
+}
+
+func (a *awsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(
+		http.MaxBytesReader(w, r.Body, maxAWSRequestBytes),
+	)
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("error reading request body: %s", err),
+			http.StatusRequestEntityTooLarge,
+		)
+		return
+	}
+	messageType := r.Header.Get(snsMessageTypeHeader)
+	if messageType == "" {
+		// Requests not made by SNS are made by EventBridge API destinations
+		ebEvent := eventBridgeEvent{}
+		if err = json.Unmarshal(body, &ebEvent); err != nil || !ebEvent.valid() {
+			http.Error(
+				w,
+				"request is neither an SNS message nor an EventBridge event",
+				http.StatusBadRequest,
+			)
+			return
+		}
+		event, err := ebEvent.cloudEvent()
+		a.handle(w, r, event, err)
+		return
+	}
+	msg := snsMessage{}
+	if err = json.Unmarshal(body, &msg); err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("error decoding SNS message: %s", err),
+			http.StatusBadRequest,
+		)
+		return
+	}
+	if msg.Type != messageType {
+		http.Error(
+			w,
+			fmt.Sprintf(
+				"SNS message type %q does not match %s header",
+				msg.Type,
+				snsMessageTypeHeader,
+			),
+			http.StatusBadRequest,
+		)
+		return
+	}
+	logger := logging.FromContext(r.Context()).With(
+		zap.String("topicARN", msg.TopicARN),
+	)
+	if !a.topicAllowed(msg.TopicARN) {
+		snsRejectedMessagesTotal.WithLabelValues("topic_not_allowed").Inc()
+		logger.Info("SNS message denied: topic not allowed")
+		http.Error(w, "SNS topic not allowed", http.StatusForbidden)
+		return
+	}
+	if err = a.verify(r.Context(), msg); err != nil {
+		snsRejectedMessagesTotal.WithLabelValues("invalid_signature").Inc()
+		logger.Warn("SNS message denied: invalid signature", zap.Error(err))
+		http.Error(
+			w,
+			fmt.Sprintf("invalid SNS message signature: %s", err),
+			http.StatusForbidden,
+		)
+		return
+	}
+	switch msg.Type {
+	case snsTypeSubscriptionConfirmation:
+		if err = a.confirmSubscription(r.Context(), msg); err != nil {
+			snsSubscriptionConfirmationsTotal.WithLabelValues("error").Inc()
+			logger.Error("error confirming SNS subscription", zap.Error(err))
+			http.Error(
+				w,
+				fmt.Sprintf("error confirming SNS subscription: %s", err),
+				http.StatusBadGateway,
+			)
+			return
+		}
+		snsSubscriptionConfirmationsTotal.WithLabelValues("confirmed").Inc()
+		logger.Info("SNS subscription confirmed")
+	case snsTypeUnsubscribeConfirmation:
+		logger.Info("SNS subscription deleted")
+	case snsTypeNotification:
+		event, err := msg.cloudEvent()
+		a.handle(w, r, event, err)
+	default:
+		http.Error(
+			w,
+			fmt.Sprintf("unsupported SNS message type %q", msg.Type),
+			http.StatusBadRequest,
+		)
+	}
+}
+
+// handle handles the provided CloudEvent, which was converted from the
+// provided request, using the Service. If the CloudEvent could not be
+// converted, the provided error is returned to the sender instead.
+func (a *awsHandler) handle(
+	w http.ResponseWriter,
+	r *http.Request,
+	event cloudEvents.Event,
+	err error,
+) {
+	status := http.StatusBadRequest
+	if err == nil {
+		if err = a.service.Handle(r.Context(), event); err != nil {
+			status = statusCode(err)
+		}
+	}
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("error handling event (id %q): %s", event.ID(), err),
+			status,
+		)
+	}
+}
+
+// topicAllowed returns true if messages from the SNS topic with the provided
+// ARN are accepted.
+func (a *awsHandler) topicAllowed(topicARN string) bool {
+	if len(a.config.AllowedTopicARNs) == 0 {
+		return true
+	}
+	for _, allowed := range a.config.AllowedTopicARNs {
+		if topicARN == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// verify returns an error if the provided SNS message's signature was not
+// produced by SNS.
+func (a *awsHandler) verify(ctx context.Context, msg snsMessage) error {
+	var hash crypto.Hash
+	switch msg.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return errors.Errorf(
+			"unsupported signature version %q",
+			msg.SignatureVersion,
+		)
+	}
+	signature, err := base64.StdEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return errors.Wrap(err, "error decoding signature")
+	}
+	cert, err := a.signingCert(ctx, msg.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("signing certificate does not have an RSA public key")
+	}
+	hasher := hash.New()
+	_, _ = hasher.Write([]byte(msg.stringToSign()))
+	return errors.Wrap(
+		rsa.VerifyPKCS1v15(publicKey, hash, hasher.Sum(nil), signature),
+		"signature does not match",
+	)
+}
+
+// signingCert returns the SNS signing certificate at the provided URL,
+// retrieving it only if it isn't already cached or if the cached certificate
+// has expired.
+func (a *awsHandler) signingCert(
+	ctx context.Context,
+	certURL string,
+) (*x509.Certificate, error) {
+	now := a.now()
+	a.certsMu.Lock()
+	cert, ok := a.certs[certURL]
+	a.certsMu.Unlock()
+	if ok && now.Before(cert.NotAfter) {
+		return cert, nil
+	}
+	res, err := a.get(ctx, certURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "error retrieving signing certificate")
+	}
+	defer res.Body.Close()
+	certBytes, err :=
+		ioutil.ReadAll(io.LimitReader(res.Body, maxSigningCertBytes))
+	if err != nil {
+		return nil, errors.Wrap(err, "error retrieving signing certificate")
+	}
+	block, _ := pem.Decode(certBytes)
+	if block == nil {
+		return nil, errors.New("signing certificate is not PEM-encoded")
+	}
+	if cert, err = x509.ParseCertificate(block.Bytes); err != nil {
+		return nil, errors.Wrap(err, "error parsing signing certificate")
+	}
+	if now.Before(cert.NotBefore) || !now.Before(cert.NotAfter) {
+		return nil, errors.New("signing certificate is not currently valid")
+	}
+	a.certsMu.Lock()
+	defer a.certsMu.Unlock()
+	a.certs[certURL] = cert
+	return cert, nil
+}
+
+// confirmSubscription confirms the subscription that the provided
+// SubscriptionConfirmation message requests confirmation of.
+func (a *awsHandler) confirmSubscription(
+	ctx context.Context,
+	msg snsMessage,
+) error {
+	res, err := a.get(ctx, msg.SubscribeURL)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// get makes a GET request to the provided URL, which must be an HTTPS URL whose
+// host belongs to SNS, and returns the response if it was successful. The
+// caller must close the response's body.
+func (a *awsHandler) get(
+	ctx context.Context,
+	rawURL string,
+) (*http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing URL %q", rawURL)
+	}
+	// Messages name these URLs, so only SNS's own URLs are trusted. Otherwise,
+	// anyone could make the gateway visit any URL or trust any certificate.
+	if u.Scheme != "https" || !a.hostPattern.MatchString(u.Hostname()) {
+		return nil, errors.Errorf("URL %q does not belong to SNS", rawURL)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgentHeaderValue())
+	res, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, errors.Errorf("received %d from %s", res.StatusCode, u.Host)
+	}
+	return res, nil
+}
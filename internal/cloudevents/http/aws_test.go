@@ -0,0 +1,730 @@
+package http
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSNSMessageStringToSign(t *testing.T) {
+	testCases := []struct {
+		name     string
+		msg      snsMessage
+		expected string
+	}{
+		{
+			name: "notification without subject",
+			msg: snsMessage{
+				Type:      snsTypeNotification,
+				MessageID: "1234",
+				TopicARN:  "arn:aws:sns:us-east-1:123456789012:foo",
+				Message:   "hello",
+				Timestamp: "2022-01-01T00:00:00.000Z",
+				Token:     "ignored",
+			},
+			expected: "Message\nhello\nMessageId\n1234\n" +
+				"Timestamp\n2022-01-01T00:00:00.000Z\n" +
+				"TopicArn\narn:aws:sns:us-east-1:123456789012:foo\n" +
+				"Type\nNotification\n",
+		},
+		{
+			name: "notification with subject",
+			msg: snsMessage{
+				Type:      snsTypeNotification,
+				MessageID: "1234",
+				TopicARN:  "arn:aws:sns:us-east-1:123456789012:foo",
+				Subject:   "greeting",
+				Message:   "hello",
+				Timestamp: "2022-01-01T00:00:00.000Z",
+			},
+			expected: "Message\nhello\nMessageId\n1234\nSubject\ngreeting\n" +
+				"Timestamp\n2022-01-01T00:00:00.000Z\n" +
+				"TopicArn\narn:aws:sns:us-east-1:123456789012:foo\n" +
+				"Type\nNotification\n",
+		},
+		{
+			name: "subscription confirmation",
+			msg: snsMessage{
+				Type:         snsTypeSubscriptionConfirmation,
+				MessageID:    "1234",
+				Token:        "abcd",
+				TopicARN:     "arn:aws:sns:us-east-1:123456789012:foo",
+				Subject:      "ignored",
+				Message:      "confirm",
+				Timestamp:    "2022-01-01T00:00:00.000Z",
+				SubscribeURL: "https://sns.us-east-1.amazonaws.com/confirm",
+			},
+			expected: "Message\nconfirm\nMessageId\n1234\n" +
+				"SubscribeURL\nhttps://sns.us-east-1.amazonaws.com/confirm\n" +
+				"Timestamp\n2022-01-01T00:00:00.000Z\nToken\nabcd\n" +
+				"TopicArn\narn:aws:sns:us-east-1:123456789012:foo\n" +
+				"Type\nSubscriptionConfirmation\n",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			require.Equal(t, testCase.expected, testCase.msg.stringToSign())
+		})
+	}
+}
+
+func TestSNSMessageCloudEvent(t *testing.T) {
+	testCases := []struct {
+		name       string
+		msg        snsMessage
+		assertions func(cloudEvents.Event, error)
+	}{
+		{
+			name: "plain text message",
+			msg: snsMessage{
+				MessageID: "1234",
+				TopicARN:  "arn:aws:sns:us-east-1:123456789012:foo",
+				Subject:   "greeting",
+				Message:   "hello",
+				Timestamp: "2022-01-01T00:00:00.000Z",
+			},
+			assertions: func(event cloudEvents.Event, err error) {
+				require.NoError(t, err)
+				require.Equal(t, "1234", event.ID())
+				require.Equal(
+					t,
+					"arn:aws:sns:us-east-1:123456789012:foo",
+					event.Source(),
+				)
+				require.Equal(t, snsNotificationEventType, event.Type())
+				require.Equal(t, "greeting", event.Subject())
+				require.Equal(
+					t,
+					time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+					event.Time(),
+				)
+				require.Equal(t, cloudEvents.TextPlain, event.DataContentType())
+				require.Equal(t, "hello", string(event.Data()))
+			},
+		},
+		{
+			name: "JSON message",
+			msg: snsMessage{
+				MessageID: "1234",
+				TopicARN:  "arn:aws:sns:us-east-1:123456789012:foo",
+				Message:   `{"foo":"bar"}`,
+			},
+			assertions: func(event cloudEvents.Event, err error) {
+				require.NoError(t, err)
+				require.Equal(t, snsNotificationEventType, event.Type())
+				require.Equal(t, cloudEvents.ApplicationJSON, event.DataContentType())
+				require.JSONEq(t, `{"foo":"bar"}`, string(event.Data()))
+			},
+		},
+		{
+			name: "EventBridge event message",
+			msg: snsMessage{
+				MessageID: "1234",
+				TopicARN:  "arn:aws:sns:us-east-1:123456789012:foo",
+				Message: `{
+					"id": "5678",
+					"detail-type": "Object Created",
+					"source": "aws.s3",
+					"detail": {"bucket": {"name": "bar"}}
+				}`,
+			},
+			assertions: func(event cloudEvents.Event, err error) {
+				require.NoError(t, err)
+				require.Equal(t, "5678", event.ID())
+				require.Equal(t, "aws.s3", event.Source())
+				require.Equal(t, "Object Created", event.Type())
+			},
+		},
+		{
+			name: "CloudEvent message",
+			msg: snsMessage{
+				MessageID: "1234",
+				TopicARN:  "arn:aws:sns:us-east-1:123456789012:foo",
+				Message: `{
+					"specversion": "1.0",
+					"id": "5678",
+					"source": "example/uri",
+					"type": "example.type",
+					"data": {"foo": "bar"}
+				}`,
+			},
+			assertions: func(event cloudEvents.Event, err error) {
+				require.NoError(t, err)
+				require.Equal(t, "5678", event.ID())
+				require.Equal(t, "example/uri", event.Source())
+				require.Equal(t, "example.type", event.Type())
+				require.JSONEq(t, `{"foo":"bar"}`, string(event.Data()))
+			},
+		},
+		{
+			name: "invalid CloudEvent message",
+			msg: snsMessage{
+				MessageID: "1234",
+				TopicARN:  "arn:aws:sns:us-east-1:123456789012:foo",
+				Message:   `{"specversion": "1.0", "id": "5678"}`,
+			},
+			assertions: func(_ cloudEvents.Event, err error) {
+				require.Error(t, err)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			event, err := testCase.msg.cloudEvent()
+			testCase.assertions(event, err)
+		})
+	}
+}
+
+func TestEventBridgeEventCloudEvent(t *testing.T) {
+	ebEvent := eventBridgeEvent{
+		ID:         "1234",
+		DetailType: "Object Created",
+		Source:     "aws.s3",
+		Account:    "123456789012",
+		Time:       time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+		Region:     "us-east-1",
+		Resources:  []string{"arn:aws:s3:::bar"},
+		Detail:     json.RawMessage(`{"bucket":{"name":"bar"}}`),
+	}
+	require.True(t, ebEvent.valid())
+	event, err := ebEvent.cloudEvent()
+	require.NoError(t, err)
+	require.Equal(t, "1234", event.ID())
+	require.Equal(t, "aws.s3", event.Source())
+	require.Equal(t, "Object Created", event.Type())
+	require.Equal(t, "arn:aws:s3:::bar", event.Subject())
+	require.Equal(t, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), event.Time())
+	require.Equal(t, cloudEvents.ApplicationJSON, event.DataContentType())
+	require.JSONEq(t, `{"bucket":{"name":"bar"}}`, string(event.Data()))
+	require.Equal(t, "123456789012", event.Extensions()[awsAccountExtension])
+	require.Equal(t, "us-east-1", event.Extensions()[awsRegionExtension])
+	require.False(t, eventBridgeEvent{ID: "1234"}.valid())
+}
+
+func TestNewAWSHandler(t *testing.T) {
+	testService := &mockService{}
+	testConfig := AWSHandlerConfig{
+		AllowedTopicARNs: []string{"arn:aws:sns:us-east-1:123456789012:foo"},
+	}
+	h, ok := NewAWSHandler(testService, testConfig).(*awsHandler)
+	require.True(t, ok)
+	require.Same(t, testService, h.service)
+	require.Equal(t, testConfig, h.config)
+	require.NotNil(t, h.client)
+	require.Equal(t, snsHostPattern, h.hostPattern)
+	require.NotNil(t, h.certs)
+	require.NotNil(t, h.now)
+}
+
+func TestSNSHostPattern(t *testing.T) {
+	require.True(t, snsHostPattern.MatchString("sns.us-east-1.amazonaws.com"))
+	require.True(
+		t,
+		snsHostPattern.MatchString("sns.cn-north-1.amazonaws.com.cn"),
+	)
+	require.False(t, snsHostPattern.MatchString("sns.example.com"))
+	require.False(
+		t,
+		snsHostPattern.MatchString("sns.us-east-1.amazonaws.com.example.com"),
+	)
+}
+
+func TestAWSHandler(t *testing.T) {
+	const testTopicARN = "arn:aws:sns:us-east-1:123456789012:foo"
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	certDER, err := x509.CreateCertificate(
+		rand.Reader,
+		&x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		},
+		&x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		},
+		&key.PublicKey,
+		key,
+	)
+	require.NoError(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	// sign signs the provided message using SignatureVersion 2
+	sign := func(msg snsMessage) snsMessage {
+		msg.SignatureVersion = "2"
+		digest := sha256.Sum256([]byte(msg.stringToSign()))
+		signature, err :=
+			rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+		msg.Signature = base64.StdEncoding.EncodeToString(signature)
+		return msg
+	}
+	testCases := []struct {
+		name   string
+		config AWSHandlerConfig
+		// messageType is the value of the SNS message type header
+		messageType string
+		// body returns the body of the request, given the URL of a server that
+		// serves the signing certificate at /cert.pem and records visits to
+		// /confirm
+		body       func(serverURL string) string
+		handleFn   func(context.Context, cloudEvents.Event) error
+		assertions func(
+			handled []string,
+			res *http.Response,
+			body string,
+			confirmed bool,
+		)
+	}{
+		{
+			name: "request body too large",
+			body: func(string) string {
+				return `{"foo": "` + strings.Repeat("a", maxAWSRequestBytes) + `"}`
+			},
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				_ string,
+				_ bool,
+			) {
+				require.Empty(t, handled)
+				require.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+			},
+		},
+		{
+			name: "request is neither SNS message nor EventBridge event",
+			body: func(string) string {
+				return `{"foo": "bar"}`
+			},
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				body string,
+				_ bool,
+			) {
+				require.Empty(t, handled)
+				require.Equal(t, http.StatusBadRequest, res.StatusCode)
+				require.Contains(t, body, "neither an SNS message")
+			},
+		},
+		{
+			name: "EventBridge event",
+			body: func(string) string {
+				return `{
+					"id": "1234",
+					"detail-type": "Object Created",
+					"source": "aws.s3",
+					"detail": {}
+				}`
+			},
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				_ string,
+				_ bool,
+			) {
+				require.Equal(t, []string{"1234"}, handled)
+				require.Equal(t, http.StatusOK, res.StatusCode)
+			},
+		},
+		{
+			name:        "SNS message cannot be decoded",
+			messageType: snsTypeNotification,
+			body: func(string) string {
+				return "foo"
+			},
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				body string,
+				_ bool,
+			) {
+				require.Empty(t, handled)
+				require.Equal(t, http.StatusBadRequest, res.StatusCode)
+				require.Contains(t, body, "error decoding SNS message")
+			},
+		},
+		{
+			name:        "SNS message type does not match header",
+			messageType: snsTypeSubscriptionConfirmation,
+			body: func(string) string {
+				return `{"Type": "Notification"}`
+			},
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				body string,
+				_ bool,
+			) {
+				require.Empty(t, handled)
+				require.Equal(t, http.StatusBadRequest, res.StatusCode)
+				require.Contains(t, body, "does not match")
+			},
+		},
+		{
+			name: "topic not allowed",
+			config: AWSHandlerConfig{
+				AllowedTopicARNs: []string{"arn:aws:sns:us-east-1:123456789012:bar"},
+			},
+			messageType: snsTypeNotification,
+			body: func(serverURL string) string {
+				return marshalSNSMessage(
+					t,
+					sign(snsMessage{
+						Type:           snsTypeNotification,
+						MessageID:      "1234",
+						TopicARN:       testTopicARN,
+						Message:        "hello",
+						SigningCertURL: serverURL + "/cert.pem",
+					}),
+				)
+			},
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				body string,
+				_ bool,
+			) {
+				require.Empty(t, handled)
+				require.Equal(t, http.StatusForbidden, res.StatusCode)
+				require.Contains(t, body, "SNS topic not allowed")
+			},
+		},
+		{
+			name:        "unsupported signature version",
+			messageType: snsTypeNotification,
+			body: func(serverURL string) string {
+				msg := sign(snsMessage{
+					Type:           snsTypeNotification,
+					MessageID:      "1234",
+					TopicARN:       testTopicARN,
+					Message:        "hello",
+					SigningCertURL: serverURL + "/cert.pem",
+				})
+				msg.SignatureVersion = "3"
+				return marshalSNSMessage(t, msg)
+			},
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				body string,
+				_ bool,
+			) {
+				require.Empty(t, handled)
+				require.Equal(t, http.StatusForbidden, res.StatusCode)
+				require.Contains(t, body, "unsupported signature version")
+			},
+		},
+		{
+			name:        "signing certificate URL does not belong to SNS",
+			messageType: snsTypeNotification,
+			body: func(string) string {
+				return marshalSNSMessage(
+					t,
+					sign(snsMessage{
+						Type:           snsTypeNotification,
+						MessageID:      "1234",
+						TopicARN:       testTopicARN,
+						Message:        "hello",
+						SigningCertURL: "https://example.com/cert.pem",
+					}),
+				)
+			},
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				body string,
+				_ bool,
+			) {
+				require.Empty(t, handled)
+				require.Equal(t, http.StatusForbidden, res.StatusCode)
+				require.Contains(t, body, "does not belong to SNS")
+			},
+		},
+		{
+			name:        "tampered message",
+			messageType: snsTypeNotification,
+			body: func(serverURL string) string {
+				msg := sign(snsMessage{
+					Type:           snsTypeNotification,
+					MessageID:      "1234",
+					TopicARN:       testTopicARN,
+					Message:        "hello",
+					SigningCertURL: serverURL + "/cert.pem",
+				})
+				msg.Message = "goodbye"
+				return marshalSNSMessage(t, msg)
+			},
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				body string,
+				_ bool,
+			) {
+				require.Empty(t, handled)
+				require.Equal(t, http.StatusForbidden, res.StatusCode)
+				require.Contains(t, body, "signature does not match")
+			},
+		},
+		{
+			name:        "subscription confirmation",
+			messageType: snsTypeSubscriptionConfirmation,
+			body: func(serverURL string) string {
+				return marshalSNSMessage(
+					t,
+					sign(snsMessage{
+						Type:           snsTypeSubscriptionConfirmation,
+						MessageID:      "1234",
+						Token:          "abcd",
+						TopicARN:       testTopicARN,
+						Message:        "confirm",
+						SigningCertURL: serverURL + "/cert.pem",
+						SubscribeURL:   serverURL + "/confirm",
+					}),
+				)
+			},
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				_ string,
+				confirmed bool,
+			) {
+				require.Empty(t, handled)
+				require.Equal(t, http.StatusOK, res.StatusCode)
+				require.True(t, confirmed)
+			},
+		},
+		{
+			name:        "error confirming subscription",
+			messageType: snsTypeSubscriptionConfirmation,
+			body: func(serverURL string) string {
+				return marshalSNSMessage(
+					t,
+					sign(snsMessage{
+						Type:           snsTypeSubscriptionConfirmation,
+						MessageID:      "1234",
+						Token:          "abcd",
+						TopicARN:       testTopicARN,
+						Message:        "confirm",
+						SigningCertURL: serverURL + "/cert.pem",
+						SubscribeURL:   serverURL + "/missing",
+					}),
+				)
+			},
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				body string,
+				confirmed bool,
+			) {
+				require.Empty(t, handled)
+				require.Equal(t, http.StatusBadGateway, res.StatusCode)
+				require.Contains(t, body, "error confirming SNS subscription")
+				require.False(t, confirmed)
+			},
+		},
+		{
+			name:        "unsubscribe confirmation",
+			messageType: snsTypeUnsubscribeConfirmation,
+			body: func(serverURL string) string {
+				return marshalSNSMessage(
+					t,
+					sign(snsMessage{
+						Type:           snsTypeUnsubscribeConfirmation,
+						MessageID:      "1234",
+						Token:          "abcd",
+						TopicARN:       testTopicARN,
+						Message:        "unsubscribed",
+						SigningCertURL: serverURL + "/cert.pem",
+						SubscribeURL:   serverURL + "/confirm",
+					}),
+				)
+			},
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				_ string,
+				confirmed bool,
+			) {
+				require.Empty(t, handled)
+				require.Equal(t, http.StatusOK, res.StatusCode)
+				require.False(t, confirmed)
+			},
+		},
+		{
+			name:        "error handling notification",
+			messageType: snsTypeNotification,
+			body: func(serverURL string) string {
+				return marshalSNSMessage(
+					t,
+					sign(snsMessage{
+						Type:           snsTypeNotification,
+						MessageID:      "1234",
+						TopicARN:       testTopicARN,
+						Message:        "hello",
+						SigningCertURL: serverURL + "/cert.pem",
+					}),
+				)
+			},
+			handleFn: func(context.Context, cloudEvents.Event) error {
+				return errors.Wrap(cloudevents.ErrSaturated, "something went wrong")
+			},
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				body string,
+				_ bool,
+			) {
+				require.Equal(t, []string{"1234"}, handled)
+				require.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+				require.Contains(t, body, `error handling event (id "1234")`)
+			},
+		},
+		{
+			name: "notification",
+			config: AWSHandlerConfig{
+				AllowedTopicARNs: []string{testTopicARN},
+			},
+			messageType: snsTypeNotification,
+			body: func(serverURL string) string {
+				return marshalSNSMessage(
+					t,
+					sign(snsMessage{
+						Type:           snsTypeNotification,
+						MessageID:      "1234",
+						TopicARN:       testTopicARN,
+						Subject:        "greeting",
+						Message:        "hello",
+						SigningCertURL: serverURL + "/cert.pem",
+					}),
+				)
+			},
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				_ string,
+				_ bool,
+			) {
+				require.Equal(t, []string{"1234"}, handled)
+				require.Equal(t, http.StatusOK, res.StatusCode)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var confirmed bool
+			server := httptest.NewTLSServer(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					require.Equal(t, http.MethodGet, r.Method)
+					switch r.URL.Path {
+					case "/cert.pem":
+						_, _ = w.Write(certPEM)
+					case "/confirm":
+						confirmed = true
+					default:
+						w.WriteHeader(http.StatusNotFound)
+					}
+				}),
+			)
+			defer server.Close()
+			handled := []string{}
+			handler := NewAWSHandler(
+				&mockService{
+					HandleFn: func(ctx context.Context, event cloudEvents.Event) error {
+						handled = append(handled, event.ID())
+						if testCase.handleFn != nil {
+							return testCase.handleFn(ctx, event)
+						}
+						return nil
+					},
+				},
+				testCase.config,
+			).(*awsHandler)
+			handler.client = server.Client()
+			handler.hostPattern = regexp.MustCompile(`^127\.0\.0\.1$`)
+			req := httptest.NewRequest(
+				http.MethodPost,
+				"/aws",
+				strings.NewReader(testCase.body(server.URL)),
+			)
+			if testCase.messageType != "" {
+				req.Header.Set(snsMessageTypeHeader, testCase.messageType)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			res := rr.Result()
+			defer res.Body.Close()
+			testCase.assertions(handled, res, rr.Body.String(), confirmed)
+		})
+	}
+}
+
+func TestAWSHandlerSigningCertCache(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	notAfter := time.Now().Add(time.Hour)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	certDER, err :=
+		x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	var retrievals int
+	server := httptest.NewTLSServer(
+		http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			retrievals++
+			_ = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+		}),
+	)
+	defer server.Close()
+	handler := NewAWSHandler(&mockService{}, AWSHandlerConfig{}).(*awsHandler)
+	handler.client = server.Client()
+	handler.hostPattern = regexp.MustCompile(`^127\.0\.0\.1$`)
+	certURL := server.URL + "/cert.pem"
+	// The certificate is only retrieved once
+	for i := 0; i < 2; i++ {
+		_, err = handler.signingCert(context.Background(), certURL)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, retrievals)
+	// An expired certificate is retrieved again and rejected if it's still
+	// expired
+	handler.now = func() time.Time {
+		return notAfter.Add(time.Minute)
+	}
+	_, err = handler.signingCert(context.Background(), certURL)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not currently valid")
+	require.Equal(t, 2, retrievals)
+}
+
+// marshalSNSMessage returns the JSON representation of the provided
+// snsMessage.
+func marshalSNSMessage(t *testing.T, msg snsMessage) string {
+	msgBytes, err := json.Marshal(msg)
+	require.NoError(t, err)
+	return string(msgBytes)
+}
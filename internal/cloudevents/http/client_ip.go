@@ -0,0 +1,173 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/pkg/errors"
+)
+
+// ClientIPResolver is an interface for components that determine the IP
+// address of the client that made a request.
+type ClientIPResolver interface {
+	// ClientIP returns the IP address of the client that made the provided
+	// request.
+	ClientIP(r *http.Request) string
+}
+
+// clientIPResolver is an implementation of the ClientIPResolver interface that
+// trusts the forwarding headers of requests made by trusted proxies.
+type clientIPResolver struct {
+	trustedProxies []*net.IPNet
+}
+
+// NewClientIPResolver returns an implementation of the ClientIPResolver
+// interface. If a request was made by a proxy within one of the provided
+// trusted networks, the client's address is read from the Forwarded header or,
+// in its absence, the X-Forwarded-For header. Those headers list every hop a
+// request passed through and any client can prepend to them, so they're read
+// from right to left and the first address not within a trusted network is
+// taken to be the client's. Headers of requests made by any other client are
+// ignored so that clients cannot spoof their addresses.
+func NewClientIPResolver(trustedProxies []*net.IPNet) ClientIPResolver {
+	return &clientIPResolver{
+		trustedProxies: trustedProxies,
+	}
+}
+
+func (c *clientIPResolver) ClientIP(r *http.Request) string {
+	addr := clientIP(r)
+	if !c.trusted(addr) {
+		return addr
+	}
+	hops := forwardedFor(r)
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr = hops[i]
+		// An address that isn't an IP (e.g. "unknown" or an obfuscated
+		// identifier) is returned as is. Nothing beyond it can be trusted.
+		if !c.trusted(addr) {
+			return addr
+		}
+	}
+	// Every hop was a trusted proxy, so the leftmost is the best we know of
+	return addr
+}
+
+// trusted returns true if the provided address is an IP within one of the
+// trusted networks.
+func (c *clientIPResolver) trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && containsIP(c.trustedProxies, ip)
+}
+
+// requestClientIP returns the IP address of the client that made the provided
+// request as recorded by the request ID filter, which accounts for trusted
+// proxies, or, if the request ID filter wasn't applied, as returned by
+// clientIP.
+func requestClientIP(r *http.Request) string {
+	if addr := audit.RequestFromContext(r.Context()).ClientIP; addr != "" {
+		return addr
+	}
+	return clientIP(r)
+}
+
+// clientIP returns the IP address of the client that made the provided
+// request, without regard for any forwarding headers.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// forwardedFor returns the addresses a request was forwarded for, from first to
+// last, as listed in its Forwarded header or, in its absence, its
+// X-Forwarded-For header.
+func forwardedFor(r *http.Request) []string {
+	if values := r.Header.Values("Forwarded"); len(values) > 0 {
+		return parseForwarded(values)
+	}
+	addrs := []string{}
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(value, ",") {
+			addrs = append(addrs, stripPort(strings.TrimSpace(addr)))
+		}
+	}
+	return addrs
+}
+
+// parseForwarded returns the value of the "for" parameter of each element of
+// the provided Forwarded header values, as defined by RFC 7239. An element
+// without one is represented by an empty string so that it cannot be mistaken
+// for a trusted proxy.
+func parseForwarded(values []string) []string {
+	addrs := []string{}
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			addr := ""
+			for _, pair := range strings.Split(element, ";") {
+				pairParts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(pairParts) == 2 && strings.EqualFold(pairParts[0], "for") {
+					addr = stripPort(strings.Trim(pairParts[1], `"`))
+				}
+			}
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// stripPort returns the provided address without any port and without the
+// brackets that enclose IPv6 addresses that have one.
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+// ParseNetworks parses the provided networks in CIDR notation. Individual IP
+// addresses are also accepted and are parsed as networks containing only that
+// address.
+func ParseNetworks(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, errors.Errorf("%q is not a valid IP address", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(
+				networks,
+				&net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)},
+			)
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, errors.Errorf("%q is not a valid CIDR", value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// containsIP returns true if the provided IP is within any of the provided
+// networks.
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
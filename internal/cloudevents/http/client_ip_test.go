@@ -0,0 +1,171 @@
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIPResolver(t *testing.T) {
+	trustedProxies, err := ParseNetworks([]string{"10.0.0.0/8", "fd00::/8"})
+	require.NoError(t, err)
+	resolver := NewClientIPResolver(trustedProxies)
+	testCases := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string][]string
+		expectedIP string
+	}{
+		{
+			name:       "no forwarding headers",
+			remoteAddr: "10.0.0.1:54321",
+			expectedIP: "10.0.0.1",
+		},
+		{
+			name:       "forwarding headers from untrusted client",
+			remoteAddr: "192.0.2.1:54321",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"198.51.100.1"},
+				"Forwarded":       {"for=198.51.100.1"},
+			},
+			expectedIP: "192.0.2.1",
+		},
+		{
+			name:       "X-Forwarded-For from trusted proxy",
+			remoteAddr: "10.0.0.1:54321",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"198.51.100.1"},
+			},
+			expectedIP: "198.51.100.1",
+		},
+		{
+			name:       "X-Forwarded-For through several trusted proxies",
+			remoteAddr: "10.0.0.1:54321",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"198.51.100.1, 10.0.0.2", "10.0.0.3"},
+			},
+			expectedIP: "198.51.100.1",
+		},
+		{
+			name:       "X-Forwarded-For with spoofed address",
+			remoteAddr: "10.0.0.1:54321",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"10.0.0.4, 203.0.113.1, 198.51.100.1"},
+			},
+			expectedIP: "198.51.100.1",
+		},
+		{
+			name:       "X-Forwarded-For listing only trusted proxies",
+			remoteAddr: "10.0.0.1:54321",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"10.0.0.2, 10.0.0.3"},
+			},
+			expectedIP: "10.0.0.2",
+		},
+		{
+			name:       "Forwarded takes precedence over X-Forwarded-For",
+			remoteAddr: "10.0.0.1:54321",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"203.0.113.1"},
+				"Forwarded":       {"for=198.51.100.1;proto=https"},
+			},
+			expectedIP: "198.51.100.1",
+		},
+		{
+			name:       "Forwarded with quoted IPv6 address and port",
+			remoteAddr: "[fd00::1]:54321",
+			headers: map[string][]string{
+				"Forwarded": {`For="[2001:db8::1]:4711", for=10.0.0.2:443`},
+			},
+			expectedIP: "2001:db8::1",
+		},
+		{
+			name:       "Forwarded with obfuscated address",
+			remoteAddr: "10.0.0.1:54321",
+			headers: map[string][]string{
+				"Forwarded": {"for=198.51.100.1, for=_hidden"},
+			},
+			expectedIP: "_hidden",
+		},
+		{
+			name:       "Forwarded element without for parameter",
+			remoteAddr: "10.0.0.1:54321",
+			headers: map[string][]string{
+				"Forwarded": {"for=198.51.100.1, by=10.0.0.2"},
+			},
+			expectedIP: "",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.RemoteAddr = testCase.remoteAddr
+			for key, values := range testCase.headers {
+				for _, value := range values {
+					req.Header.Add(key, value)
+				}
+			}
+			require.Equal(t, testCase.expectedIP, resolver.ClientIP(req))
+		})
+	}
+}
+
+func TestRequestClientIP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.RemoteAddr = "10.0.0.1:54321"
+	require.Equal(t, "10.0.0.1", requestClientIP(req))
+	req = req.WithContext(
+		audit.ContextWithRequest(
+			context.Background(),
+			audit.Request{ClientIP: "198.51.100.1"},
+		),
+	)
+	require.Equal(t, "198.51.100.1", requestClientIP(req))
+}
+
+func TestParseNetworks(t *testing.T) {
+	testCases := []struct {
+		name       string
+		values     []string
+		assertions func([]*net.IPNet, error)
+	}{
+		{
+			name:   "invalid CIDR",
+			values: []string{"10.0.0.0/33"},
+			assertions: func(_ []*net.IPNet, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is not a valid CIDR")
+			},
+		},
+		{
+			name:   "invalid IP address",
+			values: []string{"10.0.0"},
+			assertions: func(_ []*net.IPNet, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is not a valid IP address")
+			},
+		},
+		{
+			name:   "success",
+			values: []string{"10.0.0.0/8", " 192.0.2.1 ", "2001:db8::1"},
+			assertions: func(networks []*net.IPNet, err error) {
+				require.NoError(t, err)
+				require.Len(t, networks, 3)
+				require.Equal(t, "10.0.0.0/8", networks[0].String())
+				require.Equal(t, "192.0.2.1/32", networks[1].String())
+				require.Equal(t, "2001:db8::1/128", networks[2].String())
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			networks, err := ParseNetworks(testCase.values)
+			testCase.assertions(networks, err)
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/shutdown"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"go.uber.org/zap"
+)
+
+const (
+	// eventGridSubscriptionValidationEventType is the type of the event Azure
+	// Event Grid sends to an endpoint to validate a new subscription.
+	eventGridSubscriptionValidationEventType = "Microsoft.EventGrid.SubscriptionValidationEvent" // nolint: lll
+	// eventGridSubscriptionDeletedEventType is the type of the event Azure Event
+	// Grid sends to an endpoint when its subscription is deleted.
+	eventGridSubscriptionDeletedEventType = "Microsoft.EventGrid.SubscriptionDeletedEvent" // nolint: lll
+	// eventGridDataVersionExtension is the CloudEvent extension attribute to
+	// which the dataVersion of an Event Grid event is mapped.
+	eventGridDataVersionExtension = "dataversion"
+	// shutdownKindEventGridValidationCallback is the kind of work tracked for
+	// every Event Grid subscription validation callback that has not yet
+	// completed.
+	shutdownKindEventGridValidationCallback = "event_grid_validation_callback"
+	// maxEventGridRequestBytes is the maximum size of a batch of events
+	// delivered by Event Grid.
+	maxEventGridRequestBytes = 1024 * 1024
+)
+
+// eventGridHostPattern matches the hosts of Event Grid's validation URLs in
+// Azure's public and sovereign clouds.
+var eventGridHostPattern = regexp.MustCompile(`^([a-z0-9-]+\.)+eventgrid\.(azure\.net|azure\.us|chinacloudapi\.cn)$`) // nolint: lll
+
+// eventGridEvent is an event in the Azure Event Grid event schema.
+//
+// See https://learn.microsoft.com/en-us/azure/event-grid/event-schema
+type eventGridEvent struct {
+	ID          string          `json:"id"`
+	Topic       string          `json:"topic"`
+	Subject     string          `json:"subject"`
+	EventType   string          `json:"eventType"`
+	EventTime   time.Time       `json:"eventTime"`
+	Data        json.RawMessage `json:"data"`
+	DataVersion string          `json:"dataVersion"`
+}
+
+// eventGridValidationData is the data of a subscription validation event.
+type eventGridValidationData struct {
+	ValidationCode string `json:"validationCode"`
+	ValidationURL  string `json:"validationUrl"`
+}
+
+// cloudEvent converts the eventGridEvent to a CloudEvent. The event's topic
+// becomes the CloudEvent's source, its eventType becomes the CloudEvent's type,
+// and its dataVersion, if any, becomes the CloudEvent's dataversion extension
+// attribute. An error is returned if the resulting CloudEvent is invalid.
+func (e eventGridEvent) cloudEvent() (cloudEvents.Event, error) {
+	event := cloudEvents.NewEvent()
+	event.SetID(e.ID)
+	event.SetSource(e.Topic)
+	event.SetType(e.EventType)
+	if e.Subject != "" {
+		event.SetSubject(e.Subject)
+	}
+	if !e.EventTime.IsZero() {
+		event.SetTime(e.EventTime)
+	}
+	if e.DataVersion != "" {
+		event.SetExtension(eventGridDataVersionExtension, e.DataVersion)
+	}
+	if len(e.Data) > 0 && string(e.Data) != "null" {
+		if err := event.SetData(
+			cloudEvents.ApplicationJSON,
+			[]byte(e.Data),
+		); err != nil {
+			return event, err
+		}
+	}
+	return event, event.Validate()
+}
+
+// eventGridHandler is an http.Handler that accepts events delivered by Azure
+// Event Grid in the Event Grid event schema.
+type eventGridHandler struct {
+	service         cloudevents.Service
+	shutdownTracker shutdown.Tracker
+	// client is used to visit validation URLs. It is overridable for testing
+	// purposes.
+	client *http.Client
+	// hostPattern matches the hosts of validation URLs client may visit. It is
+	// overridable for testing purposes.
+	hostPattern *regexp.Regexp
+}
+
+// NewEventGridHandler returns an http.Handler that accepts events delivered
+// by Azure Event Grid in the Event Grid event schema, converts each to a
+// CloudEvent, and handles it using the provided Service. As with CloudEvents
+// delivered in batches, events are handled in order and handling stops at the
+// first event that cannot be handled. Subscription validation events are
+// answered by echoing their validation code. If an Event Grid validation URL
+// was also provided, it is visited as well so that validation succeeds even if
+// the echoed code is not received. Such visits are tracked as in-flight work
+// using the provided shutdown.Tracker.
+func NewEventGridHandler(
+	service cloudevents.Service,
+	shutdownTracker shutdown.Tracker,
+) http.Handler {
+	return &eventGridHandler{
+		service:         service,
+		shutdownTracker: shutdownTracker,
+		client:          &http.Client{Timeout: 10 * time.Second},
+		hostPattern:     eventGridHostPattern,
+	}
+}
+
+func (e *eventGridHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(
+		http.MaxBytesReader(w, r.Body, maxEventGridRequestBytes),
+	)
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("error reading request body: %s", err),
+			http.StatusRequestEntityTooLarge,
+		)
+		return
+	}
+	events := []eventGridEvent{}
+	if err := json.Unmarshal(body, &events); err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("error decoding Event Grid events: %s", err),
+			http.StatusBadRequest,
+		)
+		return
+	}
+	logger := logging.FromContext(r.Context())
+	for i, egEvent := range events {
+		switch egEvent.EventType {
+		case eventGridSubscriptionValidationEventType:
+			e.validateSubscription(w, r, egEvent)
+			return
+		case eventGridSubscriptionDeletedEventType:
+			logger.Info(
+				"Event Grid subscription deleted",
+				zap.String("topic", egEvent.Topic),
+			)
+			continue
+		}
+		event, err := egEvent.cloudEvent()
+		status := http.StatusBadRequest
+		if err == nil {
+			if err = e.service.Handle(r.Context(), event); err != nil {
+				status = statusCode(err)
+			}
+		}
+		if err != nil {
+			http.Error(
+				w,
+				fmt.Sprintf(
+					"error handling Event Grid event %d (id %q): %s",
+					i,
+					egEvent.ID,
+					err,
+				),
+				status,
+			)
+			return
+		}
+	}
+}
+
+// validateSubscription completes the handshake Azure Event Grid uses to
+// validate a new subscription by echoing the validation code of the provided
+// subscription validation event and, if the event includes a validation URL,
+// visiting that URL asynchronously.
+func (e *eventGridHandler) validateSubscription(
+	w http.ResponseWriter,
+	r *http.Request,
+	egEvent eventGridEvent,
+) {
+	data := eventGridValidationData{}
+	if err := json.Unmarshal(egEvent.Data, &data); err != nil ||
+		data.ValidationCode == "" {
+		http.Error(
+			w,
+			"Event Grid subscription validation event has no validation code",
+			http.StatusBadRequest,
+		)
+		return
+	}
+	logger := logging.FromContext(r.Context()).With(
+		zap.String("topic", egEvent.Topic),
+	)
+	eventGridValidationsTotal.Inc()
+	if data.ValidationURL != "" {
+		if validationURL, err := url.Parse(data.ValidationURL); err != nil ||
+			validationURL.Scheme != "https" ||
+			!e.hostPattern.MatchString(validationURL.Hostname()) {
+			// Only Event Grid should be able to make the gateway visit a URL, and
+			// Event Grid's validation URLs always use HTTPS
+			logger.Warn(
+				"ignoring validation URL that isn't an Event Grid HTTPS URL",
+				zap.String("validationURL", data.ValidationURL),
+			)
+		} else {
+			// Tracking begins before the goroutine starts so that a callback can't
+			// be missed by a shutdown that begins in the interim.
+			go e.executeValidationCallback(
+				logger,
+				data.ValidationURL,
+				e.shutdownTracker.Track(shutdownKindEventGridValidationCallback),
+			)
+		}
+	}
+	logger.Info("validating Event Grid subscription")
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(
+		struct {
+			ValidationResponse string `json:"validationResponse"`
+		}{
+			ValidationResponse: data.ValidationCode,
+		},
+	)
+}
+
+// executeValidationCallback visits the provided Event Grid validation URL.
+func (e *eventGridHandler) executeValidationCallback(
+	logger *zap.Logger,
+	validationURL string,
+	done func(),
+) {
+	defer done()
+	req, err := http.NewRequest(http.MethodGet, validationURL, nil)
+	if err != nil {
+		eventGridValidationCallbacksTotal.WithLabelValues("error").Inc()
+		logger.Error(
+			"error preparing HTTP request for Event Grid validation URL",
+			zap.Error(err),
+		)
+		return
+	}
+	req.Header.Set("User-Agent", userAgentHeaderValue())
+	res, err := e.client.Do(req)
+	if err != nil {
+		eventGridValidationCallbacksTotal.WithLabelValues("error").Inc()
+		logger.Error(
+			"error executing HTTP request for Event Grid validation URL",
+			zap.Error(err),
+		)
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		// Event Grid may already have accepted the echoed validation code
+		eventGridValidationCallbacksTotal.WithLabelValues("rejected").Inc()
+		logger.Info(
+			"Event Grid validation URL rejected handshake",
+			zap.Int("statusCode", res.StatusCode),
+		)
+		return
+	}
+	eventGridValidationCallbacksTotal.WithLabelValues("accepted").Inc()
+}
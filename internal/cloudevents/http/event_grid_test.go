@@ -0,0 +1,376 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/shutdown"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventGridEventCloudEvent(t *testing.T) {
+	testCases := []struct {
+		name       string
+		event      eventGridEvent
+		assertions func(cloudEvents.Event, error)
+	}{
+		{
+			name: "no topic",
+			event: eventGridEvent{
+				ID:        "1234",
+				EventType: "Microsoft.Storage.BlobCreated",
+			},
+			assertions: func(_ cloudEvents.Event, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "source")
+			},
+		},
+		{
+			name: "success",
+			event: eventGridEvent{
+				ID:          "1234",
+				Topic:       "/subscriptions/foo/resourceGroups/bar",
+				Subject:     "/blobServices/default/containers/bat",
+				EventType:   "Microsoft.Storage.BlobCreated",
+				EventTime:   time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+				Data:        json.RawMessage(`{"api":"PutBlob"}`),
+				DataVersion: "1.0",
+			},
+			assertions: func(event cloudEvents.Event, err error) {
+				require.NoError(t, err)
+				require.Equal(t, "1234", event.ID())
+				require.Equal(
+					t,
+					"/subscriptions/foo/resourceGroups/bar",
+					event.Source(),
+				)
+				require.Equal(
+					t,
+					"/blobServices/default/containers/bat",
+					event.Subject(),
+				)
+				require.Equal(t, "Microsoft.Storage.BlobCreated", event.Type())
+				require.Equal(
+					t,
+					time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+					event.Time(),
+				)
+				require.Equal(t, cloudEvents.ApplicationJSON, event.DataContentType())
+				require.JSONEq(t, `{"api":"PutBlob"}`, string(event.Data()))
+				require.Equal(
+					t,
+					"1.0",
+					event.Extensions()[eventGridDataVersionExtension],
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			event, err := testCase.event.cloudEvent()
+			testCase.assertions(event, err)
+		})
+	}
+}
+
+func TestNewEventGridHandler(t *testing.T) {
+	testService := &mockService{}
+	testTracker := shutdown.NewTracker()
+	h, ok := NewEventGridHandler(testService, testTracker).(*eventGridHandler)
+	require.True(t, ok)
+	require.Same(t, testService, h.service)
+	require.Equal(t, testTracker, h.shutdownTracker)
+	require.NotNil(t, h.client)
+	require.NotZero(t, h.client.Timeout)
+	require.Equal(t, eventGridHostPattern, h.hostPattern)
+	require.True(t, h.hostPattern.MatchString("rp-eastus2.eventgrid.azure.net"))
+	require.False(t, h.hostPattern.MatchString("eventgrid.azure.net.example.com"))
+}
+
+func TestEventGridHandler(t *testing.T) {
+	const testEvents = `[
+		{
+			"id": "1234",
+			"topic": "/subscriptions/foo",
+			"eventType": "Microsoft.Storage.BlobCreated",
+			"data": {}
+		},
+		{
+			"id": "5678",
+			"topic": "/subscriptions/foo",
+			"eventType": "Microsoft.Storage.BlobDeleted",
+			"data": {}
+		}
+	]`
+	testCases := []struct {
+		name string
+		body string
+		// validationURL, if true, causes the body's "VALIDATION_URL" placeholder to
+		// be replaced with the URL of a server that records visits
+		validationURL bool
+		handleFn      func(context.Context, cloudEvents.Event) error
+		assertions    func(
+			handled []string,
+			res *http.Response,
+			body string,
+			validated bool,
+		)
+	}{
+		{
+			name: "events cannot be decoded",
+			body: "{}",
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				body string,
+				_ bool,
+			) {
+				require.Empty(t, handled)
+				require.Equal(t, http.StatusBadRequest, res.StatusCode)
+				require.Contains(t, body, "error decoding Event Grid events")
+			},
+		},
+		{
+			name: "validation event without validation code",
+			body: `[{
+				"id": "1234",
+				"eventType": "Microsoft.EventGrid.SubscriptionValidationEvent",
+				"data": {}
+			}]`,
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				body string,
+				_ bool,
+			) {
+				require.Empty(t, handled)
+				require.Equal(t, http.StatusBadRequest, res.StatusCode)
+				require.Contains(t, body, "has no validation code")
+			},
+		},
+		{
+			name: "validation event",
+			body: `[{
+				"id": "1234",
+				"eventType": "Microsoft.EventGrid.SubscriptionValidationEvent",
+				"data": {"validationCode": "abcd"}
+			}]`,
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				body string,
+				validated bool,
+			) {
+				require.Empty(t, handled)
+				require.Equal(t, http.StatusOK, res.StatusCode)
+				require.JSONEq(t, `{"validationResponse":"abcd"}`, body)
+				require.False(t, validated)
+			},
+		},
+		{
+			name: "validation event with validation URL",
+			body: `[{
+				"id": "1234",
+				"eventType": "Microsoft.EventGrid.SubscriptionValidationEvent",
+				"data": {
+					"validationCode": "abcd",
+					"validationUrl": "VALIDATION_URL"
+				}
+			}]`,
+			validationURL: true,
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				body string,
+				validated bool,
+			) {
+				require.Empty(t, handled)
+				require.Equal(t, http.StatusOK, res.StatusCode)
+				require.JSONEq(t, `{"validationResponse":"abcd"}`, body)
+				require.True(t, validated)
+			},
+		},
+		{
+			name: "validation event with HTTP validation URL",
+			body: `[{
+				"id": "1234",
+				"eventType": "Microsoft.EventGrid.SubscriptionValidationEvent",
+				"data": {
+					"validationCode": "abcd",
+					"validationUrl": "http://example.com/validate"
+				}
+			}]`,
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				body string,
+				validated bool,
+			) {
+				require.Equal(t, http.StatusOK, res.StatusCode)
+				require.JSONEq(t, `{"validationResponse":"abcd"}`, body)
+				require.False(t, validated)
+			},
+		},
+		{
+			name: "validation event with validation URL not belonging to Event Grid",
+			body: `[{
+				"id": "1234",
+				"eventType": "Microsoft.EventGrid.SubscriptionValidationEvent",
+				"data": {
+					"validationCode": "abcd",
+					"validationUrl": "https://example.com/validate"
+				}
+			}]`,
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				body string,
+				validated bool,
+			) {
+				require.Equal(t, http.StatusOK, res.StatusCode)
+				require.JSONEq(t, `{"validationResponse":"abcd"}`, body)
+				require.False(t, validated)
+			},
+		},
+		{
+			name: "request body too large",
+			body: "[" + strings.Repeat(" ", maxEventGridRequestBytes) + "]",
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				_ string,
+				_ bool,
+			) {
+				require.Empty(t, handled)
+				require.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+			},
+		},
+		{
+			name: "invalid event",
+			body: `[{"id": "1234", "eventType": "Microsoft.Storage.BlobCreated"}]`,
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				body string,
+				_ bool,
+			) {
+				require.Empty(t, handled)
+				require.Equal(t, http.StatusBadRequest, res.StatusCode)
+				require.Contains(
+					t,
+					body,
+					`error handling Event Grid event 0 (id "1234")`,
+				)
+			},
+		},
+		{
+			name: "error handling event",
+			body: testEvents,
+			handleFn: func(_ context.Context, event cloudEvents.Event) error {
+				if event.ID() == "5678" {
+					return errors.Wrap(cloudevents.ErrSaturated, "something went wrong")
+				}
+				return nil
+			},
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				body string,
+				_ bool,
+			) {
+				require.Equal(t, []string{"1234", "5678"}, handled)
+				require.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+				require.Contains(
+					t,
+					body,
+					`error handling Event Grid event 1 (id "5678")`,
+				)
+			},
+		},
+		{
+			name: "subscription deleted event is ignored",
+			body: `[{
+				"id": "1234",
+				"topic": "/subscriptions/foo",
+				"eventType": "Microsoft.EventGrid.SubscriptionDeletedEvent",
+				"data": {}
+			}]`,
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				_ string,
+				_ bool,
+			) {
+				require.Empty(t, handled)
+				require.Equal(t, http.StatusOK, res.StatusCode)
+			},
+		},
+		{
+			name: "success",
+			body: testEvents,
+			assertions: func(
+				handled []string,
+				res *http.Response,
+				_ string,
+				_ bool,
+			) {
+				require.Equal(t, []string{"1234", "5678"}, handled)
+				require.Equal(t, http.StatusOK, res.StatusCode)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var validated bool
+			server := httptest.NewTLSServer(
+				http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+					require.Equal(t, http.MethodGet, r.Method)
+					validated = true
+				}),
+			)
+			defer server.Close()
+			body := testCase.body
+			if testCase.validationURL {
+				body = strings.Replace(body, "VALIDATION_URL", server.URL, 1)
+			}
+			handled := []string{}
+			tracker := shutdown.NewTracker()
+			handler := &eventGridHandler{
+				service: &mockService{
+					HandleFn: func(ctx context.Context, event cloudEvents.Event) error {
+						handled = append(handled, event.ID())
+						if testCase.handleFn != nil {
+							return testCase.handleFn(ctx, event)
+						}
+						return nil
+					},
+				},
+				shutdownTracker: tracker,
+				client:          server.Client(),
+				hostPattern:     regexp.MustCompile(`^127\.0\.0\.1$`),
+			}
+			req := httptest.NewRequest(
+				http.MethodPost,
+				"/eventgrid",
+				strings.NewReader(body),
+			)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			require.Empty(t, tracker.Wait(ctx))
+			res := rr.Result()
+			defer res.Body.Close()
+			testCase.assertions(handled, res, rr.Body.String(), validated)
+		})
+	}
+}
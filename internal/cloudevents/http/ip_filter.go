@@ -0,0 +1,80 @@
+package http
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	libHTTP "github.com/brigadecore/brigade-foundations/http"
+)
+
+// IPRules are rules governing the IP addresses of the clients requests are
+// accepted from.
+type IPRules struct {
+	// Allowed, if non-empty, are the only networks requests are accepted from.
+	Allowed []*net.IPNet
+	// Denied are networks requests are never accepted from, even if they're also
+	// within an Allowed network.
+	Denied []*net.IPNet
+}
+
+// Empty returns true if the IPRules accept requests from any client.
+func (i IPRules) Empty() bool {
+	return len(i.Allowed) == 0 && len(i.Denied) == 0
+}
+
+// Allows returns true if the IPRules accept requests from the provided IP. A
+// nil IP, meaning the client's address is unknown, is only allowed if the
+// IPRules are empty.
+func (i IPRules) Allows(ip net.IP) bool {
+	if i.Empty() {
+		return true
+	}
+	if ip == nil || containsIP(i.Denied, ip) {
+		return false
+	}
+	return len(i.Allowed) == 0 || containsIP(i.Allowed, ip)
+}
+
+// ipFilter is a component that implements the http.Filter interface and can
+// conditionally allow or disallow a request on the basis of the IP address of
+// the client that made it.
+type ipFilter struct {
+	rules     IPRules
+	auditSink audit.Sink
+}
+
+// NewIPFilter returns a component that implements the http.Filter interface
+// and can conditionally allow or disallow a request on the basis of whether the
+// provided IPRules allow the IP address of the client that made it. The
+// client's address is the one recorded by the request ID filter, so this filter
+// must be applied after it. Disallowed requests receive a 403 response and are
+// recorded using the provided audit.Sink.
+func NewIPFilter(rules IPRules, auditSink audit.Sink) libHTTP.Filter {
+	return &ipFilter{
+		rules:     rules,
+		auditSink: auditSink,
+	}
+}
+
+func (i *ipFilter) Decorate(handle http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !i.rules.Allows(net.ParseIP(requestClientIP(r))) {
+			ipDeniedTotal.Inc()
+			logging.FromContext(r.Context()).Info(
+				"request denied: client IP not allowed",
+			)
+			i.auditSink.Write(
+				r.Context(),
+				audit.Record{
+					Decision: audit.DecisionRejected,
+					Reason:   authOutcomeClientIPNotAllowed,
+				},
+			)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		handle(w, r)
+	}
+}
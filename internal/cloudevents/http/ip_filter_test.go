@@ -0,0 +1,123 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPRulesAllows(t *testing.T) {
+	allowed, err := ParseNetworks([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	denied, err := ParseNetworks([]string{"10.0.0.1"})
+	require.NoError(t, err)
+	testCases := []struct {
+		name     string
+		rules    IPRules
+		ip       net.IP
+		expected bool
+	}{
+		{
+			name:     "no rules",
+			ip:       net.ParseIP("192.0.2.1"),
+			expected: true,
+		},
+		{
+			name:     "no rules and unknown address",
+			expected: true,
+		},
+		{
+			name:     "within allowed network",
+			rules:    IPRules{Allowed: allowed},
+			ip:       net.ParseIP("10.0.0.2"),
+			expected: true,
+		},
+		{
+			name:  "outside allowed networks",
+			rules: IPRules{Allowed: allowed},
+			ip:    net.ParseIP("192.0.2.1"),
+		},
+		{
+			name:  "within denied network",
+			rules: IPRules{Denied: denied},
+			ip:    net.ParseIP("10.0.0.1"),
+		},
+		{
+			name:     "outside denied networks",
+			rules:    IPRules{Denied: denied},
+			ip:       net.ParseIP("10.0.0.2"),
+			expected: true,
+		},
+		{
+			name:  "within both allowed and denied networks",
+			rules: IPRules{Allowed: allowed, Denied: denied},
+			ip:    net.ParseIP("10.0.0.1"),
+		},
+		{
+			name:  "unknown address",
+			rules: IPRules{Denied: denied},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			require.Equal(t, testCase.expected, testCase.rules.Allows(testCase.ip))
+		})
+	}
+}
+
+func TestIPFilter(t *testing.T) {
+	allowed, err := ParseNetworks([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	testCases := []struct {
+		name       string
+		remoteAddr string
+		assertions func(handlerCalled bool, code int, records []audit.Record)
+	}{
+		{
+			name:       "client IP allowed",
+			remoteAddr: "10.0.0.1:54321",
+			assertions: func(handlerCalled bool, code int, records []audit.Record) {
+				require.True(t, handlerCalled)
+				require.Equal(t, http.StatusOK, code)
+				require.Empty(t, records)
+			},
+		},
+		{
+			name:       "client IP not allowed",
+			remoteAddr: "192.0.2.1:54321",
+			assertions: func(handlerCalled bool, code int, records []audit.Record) {
+				require.False(t, handlerCalled)
+				require.Equal(t, http.StatusForbidden, code)
+				require.Equal(
+					t,
+					[]audit.Record{
+						{
+							Decision: audit.DecisionRejected,
+							Reason:   authOutcomeClientIPNotAllowed,
+						},
+					},
+					records,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			auditSink := &mockAuditSink{}
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.RemoteAddr = testCase.remoteAddr
+			rr := httptest.NewRecorder()
+			handlerCalled := false
+			NewIPFilter(IPRules{Allowed: allowed}, auditSink).Decorate(
+				func(http.ResponseWriter, *http.Request) {
+					handlerCalled = true
+				},
+			)(rr, req)
+			testCase.assertions(handlerCalled, rr.Code, auditSink.records)
+		})
+	}
+}
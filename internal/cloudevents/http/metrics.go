@@ -0,0 +1,182 @@
+package http
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "brigade_cloudevents_gateway"
+
+const (
+	authOutcomeAuthorized   = "authorized"
+	authOutcomeMissingToken = "missing_token"
+	authOutcomeInvalidToken = "invalid_token"
+	// These outcomes are for tokens that are recognized but may not be used at
+	// present
+	authOutcomeDisabledToken    = "disabled_token"
+	authOutcomeNotYetValidToken = "not_yet_valid_token"
+	authOutcomeExpiredToken     = "expired_token"
+	// This outcome is for requests from clients whose IP address is not allowed
+	authOutcomeClientIPNotAllowed = "client_ip_not_allowed"
+	// This outcome is for requests that were canceled (e.g. because the client
+	// disconnected) while their tokens were waiting to be verified
+	authOutcomeCanceled = "canceled"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help: "Number of HTTP requests subject to token authentication, by " +
+				"status code and authentication outcome.",
+		},
+		[]string{"code", "auth"},
+	)
+	handshakesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "abuse_protection_handshakes_total",
+			Help: "Number of CloudEvents abuse protection handshakes, by whether " +
+				"they were completed synchronously or asynchronously via callback.",
+		},
+		[]string{"mode"},
+	)
+	handshakeCallbacksTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "abuse_protection_callbacks_total",
+			Help: "Number of CloudEvents abuse protection handshake callbacks, by " +
+				"HTTP method and result.",
+		},
+		[]string{"method", "result"},
+	)
+	eventGridValidationsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "event_grid_validations_total",
+			Help: "Number of Azure Event Grid subscription validation " +
+				"handshakes.",
+		},
+	)
+	eventGridValidationCallbacksTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "event_grid_validation_callbacks_total",
+			Help: "Number of visits to Azure Event Grid subscription validation " +
+				"URLs, by result.",
+		},
+		[]string{"result"},
+	)
+	snsSubscriptionConfirmationsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "sns_subscription_confirmations_total",
+			Help:      "Number of Amazon SNS subscription confirmations, by result.",
+		},
+		[]string{"result"},
+	)
+	snsRejectedMessagesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "sns_rejected_messages_total",
+			Help: "Number of Amazon SNS messages rejected because they were from a " +
+				"topic that isn't allowed or weren't signed by SNS, by reason.",
+		},
+		[]string{"reason"},
+	)
+	rateLimitedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rate_limited_requests_total",
+			Help: "Number of HTTP requests and CloudEvents received over gRPC " +
+				"denied for exceeding a rate limit, by which limit was exceeded.",
+		},
+		[]string{"limit"},
+	)
+	ipDeniedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "ip_denied_requests_total",
+			Help: "Number of HTTP requests denied because the gateway's IP rules " +
+				"don't allow the IP address of the client that made them.",
+		},
+	)
+	tokenExpiryTimestamp = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "token_expiry_timestamp_seconds",
+			Help: "Time at which each token that is not disabled expires, in " +
+				"seconds since the Unix epoch, by tenant and sender.",
+		},
+		[]string{"tenant", "sender"},
+	)
+	tokensExpiringSoon = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "tokens_expiring_soon",
+			Help: "Number of tokens that are not disabled and expire within the " +
+				"configured warning period, by tenant.",
+		},
+		[]string{"tenant"},
+	)
+)
+
+// statusRecorder is an http.ResponseWriter that records the status code of
+// the response written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher so that recording the status of a response
+// doesn't prevent it from being streamed.
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker so that recording the status of a response
+// doesn't prevent the connection from being upgraded (e.g. to a WebSocket).
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && s.status == 0 {
+		s.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// code returns the recorded status code as a string suitable for use as a
+// metric label.
+func (s *statusRecorder) code() string {
+	if s.status == 0 {
+		// Nothing was written, in which case net/http responds with a 200
+		return strconv.Itoa(http.StatusOK)
+	}
+	return strconv.Itoa(s.status)
+}
@@ -0,0 +1,100 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusRecorder(t *testing.T) {
+	testCases := []struct {
+		name     string
+		handler  http.HandlerFunc
+		expected string
+	}{
+		{
+			name:     "nothing written",
+			handler:  func(http.ResponseWriter, *http.Request) {},
+			expected: "200",
+		},
+		{
+			name: "only body written",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("foo"))
+			},
+			expected: "200",
+		},
+		{
+			name: "status written",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+				// Subsequent statuses are superfluous and should be ignored
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			expected: "202",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			recorder := &statusRecorder{ResponseWriter: httptest.NewRecorder()}
+			testCase.handler(
+				recorder,
+				httptest.NewRequest(http.MethodGet, "/", nil),
+			)
+			require.Equal(t, testCase.expected, recorder.code())
+		})
+	}
+}
+
+func TestStatusRecorderHijack(t *testing.T) {
+	recorderCh := make(chan *statusRecorder, 1)
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			recorder := &statusRecorder{ResponseWriter: w}
+			conn, err := (&websocket.Upgrader{}).Upgrade(recorder, r, nil)
+			require.NoError(t, err)
+			defer conn.Close()
+			recorderCh <- recorder
+		}),
+	)
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial(
+		"ws"+strings.TrimPrefix(server.URL, "http"),
+		nil,
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, "101", (<-recorderCh).code())
+}
+
+func TestTokenFilterMetrics(t *testing.T) {
+	testConfig := NewTokenFilterConfig()
+	require.NoError(
+		t,
+		testConfig.AddToken("example/uri", Token{Value: "bar"}),
+	)
+	filter := NewTokenFilter(testConfig, audit.NewNopSink())
+	handler := filter.Decorate(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	authorized := requestsTotal.WithLabelValues("202", authOutcomeAuthorized)
+	invalid := requestsTotal.WithLabelValues("403", authOutcomeInvalidToken)
+	authorizedBefore := testutil.ToFloat64(authorized)
+	invalidBefore := testutil.ToFloat64(invalid)
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Add("Authorization", "Bearer bar")
+	handler(httptest.NewRecorder(), req)
+	req = httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Add("Authorization", "Bearer bogus-token")
+	handler(httptest.NewRecorder(), req)
+
+	require.Equal(t, authorizedBefore+1, testutil.ToFloat64(authorized))
+	require.Equal(t, invalidBefore+1, testutil.ToFloat64(invalid))
+}
@@ -0,0 +1,279 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/ratelimit"
+	libHTTP "github.com/brigadecore/brigade-foundations/http"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// RateLimitKey represents the attribute of a request by which requests are
+// grouped for the purpose of per-key rate limiting.
+type RateLimitKey string
+
+const (
+	// RateLimitKeySender represents requests being grouped by the identity of
+	// their authenticated sender.
+	RateLimitKeySender RateLimitKey = "sender"
+	// RateLimitKeyClientIP represents requests being grouped by the IP address
+	// of the client that made them.
+	RateLimitKeyClientIP RateLimitKey = "clientIP"
+	// RateLimitKeySource represents requests being grouped by the source of the
+	// CloudEvent they deliver.
+	RateLimitKeySource RateLimitKey = "source"
+)
+
+const (
+	rateLimitGlobal = "global"
+	rateLimitPerKey = "per_key"
+)
+
+// maxStructuredEventBytes is the size of the largest structured mode CloudEvent
+// whose source the rate limit filter will read when grouping requests by
+// source. Larger requests are rejected.
+const maxStructuredEventBytes = 1024 * 1024
+
+// RateLimitFilterConfig encapsulates rate limit filter configuration.
+type RateLimitFilterConfig struct {
+	// Key is the attribute of a request by which requests are grouped for the
+	// purpose of enforcing PerKeyLimit.
+	Key RateLimitKey
+	// PerKeyLimit is the limit applied to each group of requests.
+	PerKeyLimit ratelimit.Limit
+	// GlobalLimit is the limit applied to all requests.
+	GlobalLimit ratelimit.Limit
+}
+
+// RateLimitFilter is an interface for components that implement the
+// http.Filter interface and can conditionally allow or disallow a request on
+// the basis of whether it exceeds configured rate limits. The same limits can
+// also be applied to CloudEvents received by other means.
+type RateLimitFilter interface {
+	libHTTP.Filter
+	// AllowEvent returns a bool indicating whether a CloudEvent with the
+	// provided source, received by the means whose details are recorded in the
+	// provided context's audit.Request, is within configured rate limits. If it
+	// isn't, the length of time after which it would be is also returned. A
+	// CloudEvent that isn't allowed is recorded just like a disallowed request.
+	AllowEvent(ctx context.Context, source string) (bool, time.Duration)
+}
+
+// rateLimitFilter is an implementation of the RateLimitFilter interface.
+type rateLimitFilter struct {
+	config        RateLimitFilterConfig
+	perKeyLimiter ratelimit.Limiter
+	globalLimiter ratelimit.Limiter
+	auditSink     audit.Sink
+}
+
+// NewRateLimitFilter returns an implementation of the RateLimitFilter
+// interface. Limiter state is stored using the
+// provided ratelimit.Backend. Disallowed requests receive a 429 response with a
+// Retry-After header and are recorded using the provided audit.Sink. To limit
+// requests per sender, the filter must be applied after the token filter.
+func NewRateLimitFilter(
+	config RateLimitFilterConfig,
+	backend ratelimit.Backend,
+	auditSink audit.Sink,
+) RateLimitFilter {
+	r := &rateLimitFilter{
+		config:    config,
+		auditSink: auditSink,
+	}
+	if config.PerKeyLimit.Enabled() {
+		r.perKeyLimiter =
+			backend.NewLimiter(string(config.Key), config.PerKeyLimit)
+	}
+	if config.GlobalLimit.Enabled() {
+		r.globalLimiter = backend.NewLimiter(rateLimitGlobal, config.GlobalLimit)
+	}
+	return r
+}
+
+func (r *rateLimitFilter) Decorate(handle http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var key string
+		if r.perKeyLimiter != nil {
+			var err error
+			if key, err = r.key(w, req); err != nil {
+				logging.FromContext(req.Context()).Info(
+					"request denied: CloudEvent is too large",
+					zap.Error(err),
+				)
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
+		}
+		if allowed, retryAfter := r.allowAll(req.Context(), key); !allowed {
+			w.Header().Set(
+				"Retry-After",
+				strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))),
+			)
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		handle(w, req)
+	}
+}
+
+func (r *rateLimitFilter) AllowEvent(
+	ctx context.Context,
+	source string,
+) (bool, time.Duration) {
+	return r.allowAll(ctx, r.eventKey(ctx, source))
+}
+
+// allowAll consults every configured Limiter, using the provided key for the
+// per-key limit, and returns false, along with the length of time after which
+// the request would be allowed, if any of them disallows the request.
+func (r *rateLimitFilter) allowAll(
+	ctx context.Context,
+	key string,
+) (bool, time.Duration) {
+	// The per-key limit is checked first so that requests denied by it don't
+	// consume tokens from the global bucket.
+	if r.perKeyLimiter != nil {
+		allowed, retryAfter :=
+			r.allow(ctx, r.perKeyLimiter, rateLimitPerKey, key)
+		if !allowed {
+			return false, retryAfter
+		}
+	}
+	if r.globalLimiter != nil {
+		return r.allow(ctx, r.globalLimiter, rateLimitGlobal, "")
+	}
+	return true, 0
+}
+
+// allow consults the provided Limiter and, if the request is disallowed,
+// records that and returns false along with the length of time after which the
+// request would be allowed. If the Limiter returns an error, the request is
+// allowed so that an unavailable backend doesn't bring down the gateway.
+func (r *rateLimitFilter) allow(
+	ctx context.Context,
+	limiter ratelimit.Limiter,
+	limit string,
+	key string,
+) (bool, time.Duration) {
+	logger := logging.FromContext(ctx)
+	allowed, retryAfter, err := limiter.Allow(ctx, key)
+	if err != nil {
+		logger.Error(
+			"error checking rate limit; allowing request",
+			zap.String("limit", limit),
+			zap.Error(err),
+		)
+		return true, 0
+	}
+	if allowed {
+		return true, 0
+	}
+	rateLimitedTotal.WithLabelValues(limit).Inc()
+	logger.Info(
+		"request denied: rate limit exceeded",
+		zap.String("limit", limit),
+		zap.Duration("retryAfter", retryAfter),
+	)
+	r.auditSink.Write(
+		ctx,
+		audit.Record{
+			Decision: audit.DecisionRejected,
+			Reason:   "rate_limited",
+		},
+	)
+	return false, retryAfter
+}
+
+// key returns the key identifying the group the provided request belongs to
+// for the purpose of per-key rate limiting. Requests whose source cannot be
+// determined, including batches, which group CloudEvents that may have many
+// sources, are grouped by client IP instead so that they don't all share a
+// single group. An error is returned if the request is too large for its
+// source to be determined.
+func (r *rateLimitFilter) key(
+	w http.ResponseWriter,
+	req *http.Request,
+) (string, error) {
+	switch r.config.Key {
+	case RateLimitKeyClientIP:
+		return requestClientIP(req), nil
+	case RateLimitKeySource:
+		source, err := eventSource(w, req)
+		if err != nil {
+			return "", err
+		}
+		if source == "" {
+			return requestClientIP(req), nil
+		}
+		return source, nil
+	default:
+		return senderKey(req.Context()), nil
+	}
+}
+
+// eventKey returns the key identifying the group a CloudEvent with the
+// provided source, received by the means whose details are recorded in the
+// provided context, belongs to for the purpose of per-key rate limiting.
+func (r *rateLimitFilter) eventKey(ctx context.Context, source string) string {
+	switch r.config.Key {
+	case RateLimitKeyClientIP:
+		return audit.RequestFromContext(ctx).ClientIP
+	case RateLimitKeySource:
+		return source
+	default:
+		return senderKey(ctx)
+	}
+}
+
+// senderKey returns the authenticated sender recorded in the provided context.
+// The token filter records the authenticated sender along with the other
+// details of the request. Senders are only unique within a tenant, so the
+// sender of a tenant is qualified by the tenant's name.
+func senderKey(ctx context.Context) string {
+	auditReq := audit.RequestFromContext(ctx)
+	if auditReq.Tenant != "" {
+		return auditReq.Tenant + "/" + auditReq.Sender
+	}
+	return auditReq.Sender
+}
+
+// eventSource returns the source of the CloudEvent delivered by the provided
+// request, or an empty string if it cannot be determined. In structured mode,
+// this requires reading the request body, which is then restored so it can be
+// read again by the handler. An error is returned if the body is larger than
+// maxStructuredEventBytes.
+func eventSource(w http.ResponseWriter, req *http.Request) (string, error) {
+	if source := req.Header.Get("Ce-Source"); source != "" {
+		return source, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType != "application/cloudevents+json" || req.Body == nil {
+		return "", nil
+	}
+	body, err := io.ReadAll(
+		http.MaxBytesReader(w, req.Body, maxStructuredEventBytes),
+	)
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return "", errors.Wrap(err, "error reading request body")
+	}
+	event := struct {
+		Source string `json:"source"`
+	}{}
+	// If the body isn't valid, the handler will reject it.
+	json.Unmarshal(body, &event) // nolint: errcheck
+	return event.Source, nil
+}
@@ -0,0 +1,409 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/ratelimit"
+	"github.com/stretchr/testify/require"
+)
+
+type mockLimiter struct {
+	AllowFn func(context.Context, string) (bool, time.Duration, error)
+}
+
+func (m *mockLimiter) Allow(
+	ctx context.Context,
+	key string,
+) (bool, time.Duration, error) {
+	return m.AllowFn(ctx, key)
+}
+
+type mockRateLimitBackend struct {
+	NewLimiterFn func(string, ratelimit.Limit) ratelimit.Limiter
+}
+
+func (m *mockRateLimitBackend) NewLimiter(
+	name string,
+	limit ratelimit.Limit,
+) ratelimit.Limiter {
+	return m.NewLimiterFn(name, limit)
+}
+
+func (m *mockRateLimitBackend) Close() error {
+	return nil
+}
+
+func TestNewRateLimitFilter(t *testing.T) {
+	testCases := []struct {
+		name       string
+		config     RateLimitFilterConfig
+		assertions func(*rateLimitFilter)
+	}{
+		{
+			name: "no limits",
+			assertions: func(filter *rateLimitFilter) {
+				require.Nil(t, filter.perKeyLimiter)
+				require.Nil(t, filter.globalLimiter)
+			},
+		},
+		{
+			name: "per key and global limits",
+			config: RateLimitFilterConfig{
+				Key:         RateLimitKeySender,
+				PerKeyLimit: ratelimit.Limit{Rate: 1, Burst: 1},
+				GlobalLimit: ratelimit.Limit{Rate: 10, Burst: 10},
+			},
+			assertions: func(filter *rateLimitFilter) {
+				require.NotNil(t, filter.perKeyLimiter)
+				require.NotNil(t, filter.globalLimiter)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			filter, ok := NewRateLimitFilter(
+				testCase.config,
+				ratelimit.NewMemoryBackend(),
+				audit.NewNopSink(),
+			).(*rateLimitFilter)
+			require.True(t, ok)
+			testCase.assertions(filter)
+		})
+	}
+}
+
+func TestRateLimitFilter(t *testing.T) {
+	senderRequest := func(sender string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/events", nil)
+		return req.WithContext(
+			audit.ContextWithRequest(req.Context(), audit.Request{Sender: sender}),
+		)
+	}
+	testCases := []struct {
+		name       string
+		config     RateLimitFilterConfig
+		backend    ratelimit.Backend
+		requests   []*http.Request
+		assertions func(
+			codes []int,
+			rr *httptest.ResponseRecorder,
+			auditSink *mockAuditSink,
+		)
+	}{
+		{
+			name: "per sender limit exceeded",
+			config: RateLimitFilterConfig{
+				Key:         RateLimitKeySender,
+				PerKeyLimit: ratelimit.Limit{Rate: 0.5, Burst: 1},
+			},
+			backend: ratelimit.NewMemoryBackend(),
+			requests: []*http.Request{
+				senderRequest("foo"),
+				senderRequest("bar"),
+				senderRequest("foo"),
+			},
+			assertions: func(
+				codes []int,
+				rr *httptest.ResponseRecorder,
+				auditSink *mockAuditSink,
+			) {
+				require.Equal(
+					t,
+					[]int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+					codes,
+				)
+				require.Equal(t, "2", rr.Header().Get("Retry-After"))
+				require.Equal(
+					t,
+					[]audit.Record{
+						{
+							Decision: audit.DecisionRejected,
+							Reason:   "rate_limited",
+						},
+					},
+					auditSink.records,
+				)
+			},
+		},
+		{
+			name: "global limit exceeded",
+			config: RateLimitFilterConfig{
+				Key:         RateLimitKeySender,
+				PerKeyLimit: ratelimit.Limit{Rate: 1, Burst: 1},
+				GlobalLimit: ratelimit.Limit{Rate: 1, Burst: 1},
+			},
+			backend: ratelimit.NewMemoryBackend(),
+			requests: []*http.Request{
+				senderRequest("foo"),
+				senderRequest("bar"),
+			},
+			assertions: func(
+				codes []int,
+				rr *httptest.ResponseRecorder,
+				_ *mockAuditSink,
+			) {
+				require.Equal(
+					t,
+					[]int{http.StatusOK, http.StatusTooManyRequests},
+					codes,
+				)
+				require.Equal(t, "1", rr.Header().Get("Retry-After"))
+			},
+		},
+		{
+			name: "error checking limit",
+			config: RateLimitFilterConfig{
+				GlobalLimit: ratelimit.Limit{Rate: 1, Burst: 1},
+			},
+			backend: &mockRateLimitBackend{
+				NewLimiterFn: func(string, ratelimit.Limit) ratelimit.Limiter {
+					return &mockLimiter{
+						AllowFn: func(
+							context.Context,
+							string,
+						) (bool, time.Duration, error) {
+							return false, 0, errors.New("something went wrong")
+						},
+					}
+				},
+			},
+			requests: []*http.Request{senderRequest("foo")},
+			assertions: func(
+				codes []int,
+				_ *httptest.ResponseRecorder,
+				_ *mockAuditSink,
+			) {
+				require.Equal(t, []int{http.StatusOK}, codes)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			auditSink := &mockAuditSink{}
+			filter := NewRateLimitFilter(
+				testCase.config,
+				testCase.backend,
+				auditSink,
+			)
+			handle := filter.Decorate(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			var codes []int
+			var rr *httptest.ResponseRecorder
+			for _, req := range testCase.requests {
+				rr = httptest.NewRecorder()
+				handle(rr, req)
+				codes = append(codes, rr.Code)
+			}
+			testCase.assertions(codes, rr, auditSink)
+		})
+	}
+}
+
+func TestRateLimitFilterAllowEvent(t *testing.T) {
+	auditSink := &mockAuditSink{}
+	filter := NewRateLimitFilter(
+		RateLimitFilterConfig{
+			Key:         RateLimitKeySource,
+			PerKeyLimit: ratelimit.Limit{Rate: 0.5, Burst: 1},
+		},
+		ratelimit.NewMemoryBackend(),
+		auditSink,
+	)
+	ctx := context.Background()
+	allowed, _ := filter.AllowEvent(ctx, "example/uri")
+	require.True(t, allowed)
+	allowed, _ = filter.AllowEvent(ctx, "another/uri")
+	require.True(t, allowed)
+	allowed, retryAfter := filter.AllowEvent(ctx, "example/uri")
+	require.False(t, allowed)
+	require.Greater(t, retryAfter, time.Duration(0))
+	require.Equal(
+		t,
+		[]audit.Record{
+			{
+				Decision: audit.DecisionRejected,
+				Reason:   "rate_limited",
+			},
+		},
+		auditSink.records,
+	)
+}
+
+func TestRateLimitFilterEventKey(t *testing.T) {
+	testCases := []struct {
+		name string
+		key  RateLimitKey
+		want string
+	}{
+		{
+			name: "sender",
+			key:  RateLimitKeySender,
+			want: "italian/foo",
+		},
+		{
+			name: "client IP",
+			key:  RateLimitKeyClientIP,
+			want: "10.0.0.1",
+		},
+		{
+			name: "source",
+			key:  RateLimitKeySource,
+			want: "example/uri",
+		},
+	}
+	ctx := audit.ContextWithRequest(
+		context.Background(),
+		audit.Request{Tenant: "italian", Sender: "foo", ClientIP: "10.0.0.1"},
+	)
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			filter := &rateLimitFilter{
+				config: RateLimitFilterConfig{Key: testCase.key},
+			}
+			require.Equal(t, testCase.want, filter.eventKey(ctx, "example/uri"))
+		})
+	}
+}
+
+func TestRateLimitFilterKey(t *testing.T) {
+	testCases := []struct {
+		name       string
+		key        RateLimitKey
+		setup      func() *http.Request
+		assertions func(key string, req *http.Request)
+	}{
+		{
+			name: "sender",
+			key:  RateLimitKeySender,
+			setup: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/events", nil)
+				return req.WithContext(
+					audit.ContextWithRequest(
+						req.Context(),
+						audit.Request{Sender: "foo"},
+					),
+				)
+			},
+			assertions: func(key string, _ *http.Request) {
+				require.Equal(t, "foo", key)
+			},
+		},
+		{
+			name: "sender of a tenant",
+			key:  RateLimitKeySender,
+			setup: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/events/italian", nil)
+				return req.WithContext(
+					audit.ContextWithRequest(
+						req.Context(),
+						audit.Request{Tenant: "italian", Sender: "foo"},
+					),
+				)
+			},
+			assertions: func(key string, _ *http.Request) {
+				require.Equal(t, "italian/foo", key)
+			},
+		},
+		{
+			name: "client IP",
+			key:  RateLimitKeyClientIP,
+			setup: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/events", nil)
+				req.RemoteAddr = "10.0.0.1:54321"
+				return req
+			},
+			assertions: func(key string, _ *http.Request) {
+				require.Equal(t, "10.0.0.1", key)
+			},
+		},
+		{
+			name: "source in binary mode",
+			key:  RateLimitKeySource,
+			setup: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/events", nil)
+				req.Header.Set("Ce-Source", "example/uri")
+				return req
+			},
+			assertions: func(key string, _ *http.Request) {
+				require.Equal(t, "example/uri", key)
+			},
+		},
+		{
+			name: "source in structured mode",
+			key:  RateLimitKeySource,
+			setup: func() *http.Request {
+				req := httptest.NewRequest(
+					http.MethodPost,
+					"/events",
+					strings.NewReader(`{"source":"example/uri"}`),
+				)
+				req.Header.Set(
+					"Content-Type",
+					"application/cloudevents+json; charset=utf-8",
+				)
+				return req
+			},
+			assertions: func(key string, req *http.Request) {
+				require.Equal(t, "example/uri", key)
+				// The body should still be readable
+				body, err := io.ReadAll(req.Body)
+				require.NoError(t, err)
+				require.Equal(t, `{"source":"example/uri"}`, string(body))
+			},
+		},
+		{
+			name: "source of a batch",
+			key:  RateLimitKeySource,
+			setup: func() *http.Request {
+				req := httptest.NewRequest(
+					http.MethodPost,
+					"/events",
+					strings.NewReader(`[{"source":"example/uri"}]`),
+				)
+				req.Header.Set("Content-Type", "application/cloudevents-batch+json")
+				req.RemoteAddr = "10.0.0.1:54321"
+				return req
+			},
+			assertions: func(key string, _ *http.Request) {
+				require.Equal(t, "10.0.0.1", key)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			filter := &rateLimitFilter{
+				config: RateLimitFilterConfig{Key: testCase.key},
+			}
+			req := testCase.setup()
+			key, err := filter.key(httptest.NewRecorder(), req)
+			require.NoError(t, err)
+			testCase.assertions(key, req)
+		})
+	}
+}
+
+func TestRateLimitFilterKeyTooLarge(t *testing.T) {
+	filter := &rateLimitFilter{
+		config: RateLimitFilterConfig{Key: RateLimitKeySource},
+	}
+	req := httptest.NewRequest(
+		http.MethodPost,
+		"/events",
+		strings.NewReader(
+			`{"source":"example/uri","data":"`+
+				strings.Repeat("a", maxStructuredEventBytes)+`"}`,
+		),
+	)
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+	_, err := filter.key(httptest.NewRecorder(), req)
+	require.Error(t, err)
+}
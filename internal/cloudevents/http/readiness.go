@@ -0,0 +1,20 @@
+package http
+
+import (
+	"context"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/readiness"
+	"github.com/pkg/errors"
+)
+
+// NewTokensChecker returns a readiness.Checker that verifies at least one token
+// has been loaded into the provided TokenFilterConfig. Without any tokens, no
+// sender can authenticate and the gateway cannot accept CloudEvents.
+func NewTokensChecker(config TokenFilterConfig) readiness.Checker {
+	return readiness.CheckerFunc(func(context.Context) error {
+		if config.tokenCount() == 0 {
+			return errors.New("no tokens are loaded")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,18 @@
+package http
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokensChecker(t *testing.T) {
+	config := NewTokenFilterConfig()
+	checker := NewTokensChecker(config)
+	err := checker.Check(context.Background())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no tokens are loaded")
+	require.NoError(t, config.AddToken("foo", Token{Value: "bar"}))
+	require.NoError(t, checker.Check(context.Background()))
+}
@@ -0,0 +1,104 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	cloudHTTP "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"github.com/pkg/errors"
+)
+
+// replyPreference is the preference (per RFC 7240) a sender expresses using
+// the Prefer header to request a CloudEvent identifying the Brigade Events
+// that were created in reply to the CloudEvent it sent.
+const replyPreference = "return=representation"
+
+// NewReceiveFn returns a function suitable for use with
+// client.NewHTTPReceiveHandler that handles CloudEvents using the provided
+// Service. If the sender requested it, the function replies with a CloudEvent
+// identifying the Brigade Events that were created. Otherwise, it does not
+// reply. To make the sender's request headers available to this function, the
+// handler it is used with must be decorated using WithRequestData.
+func NewReceiveFn(
+	service cloudevents.Service,
+) func(context.Context, cloudEvents.Event) (*cloudEvents.Event, cloudEvents.Result) { // nolint: lll
+	return func(
+		ctx context.Context,
+		event cloudEvents.Event,
+	) (*cloudEvents.Event, cloudEvents.Result) {
+		if !replyRequested(ctx) {
+			if err := service.Handle(ctx, event); err != nil {
+				return nil, result(err)
+			}
+			return nil, nil
+		}
+		reply, err := service.HandleWithReply(ctx, event)
+		if err != nil {
+			return nil, result(err)
+		}
+		return reply, nil
+	}
+}
+
+// result converts an error returned by the service to a cloudEvents.Result
+// whose status code is determined by statusCode. Errors that result in a 500
+// are returned as is.
+func result(err error) cloudEvents.Result {
+	if status := statusCode(err); status != http.StatusInternalServerError {
+		return cloudHTTP.NewResult(status, "%w", err)
+	}
+	return err
+}
+
+// statusCode returns the HTTP status code that corresponds to an error
+// returned by the service. If the gateway is saturated, the status code sheds
+// load with a 503. If the CloudEvent has an invalid attribute, the status code
+// is a 400. If the CloudEvent's source is not allowed, the status code is a
+// 403. Otherwise, the status code is a 500.
+func statusCode(err error) int {
+	if errors.Is(err, cloudevents.ErrSaturated) {
+		return http.StatusServiceUnavailable
+	}
+	var attrErr *cloudevents.AttributeError
+	if errors.As(err, &attrErr) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, cloudevents.ErrSourceNotAllowed) {
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// WithRequestData decorates an http.HandlerFunc such that details of each
+// request, including its headers, are available from the context of any
+// CloudEvents receive function it invokes.
+func WithRequestData(handle http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handle(
+			w,
+			r.WithContext(cloudHTTP.WithRequestDataAtContext(r.Context(), r)),
+		)
+	}
+}
+
+// replyRequested returns a bool indicating whether the sender of the request
+// whose details are available from the provided context requested a reply.
+func replyRequested(ctx context.Context) bool {
+	requestData := cloudHTTP.RequestDataFromContext(ctx)
+	if requestData == nil {
+		return false
+	}
+	for _, prefer := range requestData.Header.Values("Prefer") {
+		for _, preference := range strings.Split(prefer, ",") {
+			// Discard any parameters
+			preference = strings.SplitN(preference, ";", 2)[0]
+			if strings.EqualFold(strings.TrimSpace(preference), replyPreference) {
+				return true
+			}
+		}
+	}
+	return false
+}
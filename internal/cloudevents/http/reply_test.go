@@ -0,0 +1,185 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/client"
+	cloudHTTP "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReceiveFn(t *testing.T) {
+	testReply := cloudEvents.NewEvent()
+	testReply.SetID("5678")
+	testReply.SetSource("brigade.sh/cloudevents")
+	testReply.SetType("sh.brigade.event.created")
+	testService := &mockService{
+		HandleFn: func(context.Context, cloudEvents.Event) error {
+			return nil
+		},
+		HandleWithReplyFn: func(
+			context.Context,
+			cloudEvents.Event,
+		) (*cloudEvents.Event, error) {
+			return &testReply, nil
+		},
+	}
+	testCases := []struct {
+		name       string
+		prefer     string
+		service    *mockService
+		assertions func(*http.Response)
+	}{
+		{
+			name:    "reply not requested",
+			service: testService,
+			assertions: func(res *http.Response) {
+				require.Equal(t, http.StatusOK, res.StatusCode)
+				require.Empty(t, res.Header.Get("Ce-Type"))
+			},
+		},
+		{
+			name:    "reply requested",
+			prefer:  "respond-async, return=representation",
+			service: testService,
+			assertions: func(res *http.Response) {
+				require.Equal(t, http.StatusOK, res.StatusCode)
+				require.Equal(t, "sh.brigade.event.created", res.Header.Get("Ce-Type"))
+				require.Equal(t, "5678", res.Header.Get("Ce-Id"))
+			},
+		},
+		{
+			name:   "reply requested; error handling CloudEvent",
+			prefer: "return=representation",
+			service: &mockService{
+				HandleWithReplyFn: func(
+					context.Context,
+					cloudEvents.Event,
+				) (*cloudEvents.Event, error) {
+					return nil, errors.New("something went wrong")
+				},
+			},
+			assertions: func(res *http.Response) {
+				require.Equal(t, http.StatusInternalServerError, res.StatusCode)
+				require.Empty(t, res.Header.Get("Ce-Type"))
+			},
+		},
+		{
+			name: "gateway saturated",
+			service: &mockService{
+				HandleFn: func(context.Context, cloudEvents.Event) error {
+					return fmt.Errorf("wrapped: %w", cloudevents.ErrSaturated)
+				},
+			},
+			assertions: func(res *http.Response) {
+				require.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+			},
+		},
+		{
+			name: "invalid attribute",
+			service: &mockService{
+				HandleFn: func(context.Context, cloudEvents.Event) error {
+					return &cloudevents.AttributeError{
+						Attribute: "subject",
+						Reason:    "something went wrong",
+					}
+				},
+			},
+			assertions: func(res *http.Response) {
+				require.Equal(t, http.StatusBadRequest, res.StatusCode)
+			},
+		},
+		{
+			name: "source not allowed",
+			service: &mockService{
+				HandleFn: func(context.Context, cloudEvents.Event) error {
+					return cloudevents.ErrSourceNotAllowed
+				},
+			},
+			assertions: func(res *http.Response) {
+				require.Equal(t, http.StatusForbidden, res.StatusCode)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			proto, err := cloudHTTP.New()
+			require.NoError(t, err)
+			handler, err := client.NewHTTPReceiveHandler(
+				context.Background(),
+				proto,
+				NewReceiveFn(testCase.service),
+			)
+			require.NoError(t, err)
+			server := httptest.NewServer(WithRequestData(handler.ServeHTTP))
+			defer server.Close()
+			req, err := http.NewRequest(
+				http.MethodPost,
+				server.URL,
+				strings.NewReader(
+					`{"specversion":"1.0","id":"1234","source":"example/uri",`+
+						`"type":"example.type"}`,
+				),
+			)
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/cloudevents+json")
+			if testCase.prefer != "" {
+				req.Header.Set("Prefer", testCase.prefer)
+			}
+			res, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			testCase.assertions(res)
+		})
+	}
+}
+
+func TestReplyRequested(t *testing.T) {
+	testCases := []struct {
+		name     string
+		ctx      context.Context
+		expected bool
+	}{
+		{
+			name:     "no request data",
+			ctx:      context.Background(),
+			expected: false,
+		},
+		{
+			name:     "no Prefer header",
+			ctx:      contextWithPrefer(""),
+			expected: false,
+		},
+		{
+			name:     "different preference",
+			ctx:      contextWithPrefer("respond-async"),
+			expected: false,
+		},
+		{
+			name:     "preference with parameters",
+			ctx:      contextWithPrefer("Return=Representation; foo=bar"),
+			expected: true,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			require.Equal(t, testCase.expected, replyRequested(testCase.ctx))
+		})
+	}
+}
+
+func contextWithPrefer(prefer string) context.Context {
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	if prefer != "" {
+		r.Header.Set("Prefer", prefer)
+	}
+	return cloudHTTP.WithRequestDataAtContext(context.Background(), r)
+}
@@ -0,0 +1,62 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	libHTTP "github.com/brigadecore/brigade-foundations/http"
+	"github.com/google/uuid"
+)
+
+const (
+	// requestIDHeader is the header from which a request's ID is read, if the
+	// sender provided one, and to which it is written in the response.
+	requestIDHeader = "X-Request-ID"
+	// maxRequestIDLength is the maximum length of a sender-provided request ID.
+	// Longer IDs are replaced with generated ones.
+	maxRequestIDLength = 128
+)
+
+// requestIDFilter is a component that implements the http.Filter interface and
+// assigns an ID to every request.
+type requestIDFilter struct {
+	clientIPResolver ClientIPResolver
+}
+
+// NewRequestIDFilter returns a component that implements the http.Filter
+// interface and assigns an ID to every request. If the sender provided an ID
+// using the X-Request-ID header, it is used. Otherwise, one is generated. The
+// ID is returned to the sender using the same header and every message logged
+// or audit record written in the course of handling the request is tagged with
+// it. The IP address of the client, as determined by the provided
+// ClientIPResolver, is recorded alongside the ID for the benefit of the filters
+// and handlers that follow.
+func NewRequestIDFilter(clientIPResolver ClientIPResolver) libHTTP.Filter {
+	return &requestIDFilter{
+		clientIPResolver: clientIPResolver,
+	}
+}
+
+func (r *requestIDFilter) Decorate(handle http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		requestID := req.Header.Get(requestIDHeader)
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			requestID = uuid.New().String()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+		ctx := logging.ContextWithFields(
+			req.Context(),
+			logging.RequestID(requestID),
+		)
+		ctx = audit.ContextWithRequest(
+			ctx,
+			audit.Request{
+				ID:        requestID,
+				Transport: audit.TransportHTTP,
+				ClientIP:  r.clientIPResolver.ClientIP(req),
+			},
+		)
+		handle(w, req.WithContext(ctx))
+	}
+}
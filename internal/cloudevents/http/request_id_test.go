@@ -0,0 +1,111 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRequestIDFilter(t *testing.T) {
+	testCases := []struct {
+		name       string
+		requestID  string
+		assertions func(requestID string, logged map[string]interface{})
+	}{
+		{
+			name: "no request ID provided",
+			assertions: func(requestID string, logged map[string]interface{}) {
+				require.NotEmpty(t, requestID)
+				require.Equal(t, requestID, logged["requestID"])
+			},
+		},
+		{
+			name:      "request ID provided",
+			requestID: "1234",
+			assertions: func(requestID string, logged map[string]interface{}) {
+				require.Equal(t, "1234", requestID)
+				require.Equal(t, "1234", logged["requestID"])
+			},
+		},
+		{
+			name:      "request ID provided is too long",
+			requestID: strings.Repeat("a", maxRequestIDLength+1),
+			assertions: func(requestID string, logged map[string]interface{}) {
+				require.NotEmpty(t, requestID)
+				require.NotEqual(
+					t,
+					strings.Repeat("a", maxRequestIDLength+1),
+					requestID,
+				)
+				require.Equal(t, requestID, logged["requestID"])
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.InfoLevel)
+			req := httptest.NewRequest(http.MethodPost, "/", nil).WithContext(
+				logging.ContextWithLogger(context.Background(), zap.New(core)),
+			)
+			if testCase.requestID != "" {
+				req.Header.Set(requestIDHeader, testCase.requestID)
+			}
+			rr := httptest.NewRecorder()
+			NewRequestIDFilter(NewClientIPResolver(nil)).Decorate(
+				func(_ http.ResponseWriter, r *http.Request) {
+					logging.FromContext(r.Context()).Info("foo")
+				},
+			)(rr, req)
+			require.Equal(t, 1, logs.Len())
+			testCase.assertions(
+				rr.Result().Header.Get(requestIDHeader), // nolint: bodyclose
+				logs.All()[0].ContextMap(),
+			)
+		})
+	}
+}
+
+func TestRequestIDFilterAudit(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.RemoteAddr = "10.0.0.1:54321"
+	req.Header.Set(requestIDHeader, "1234")
+	var auditReq audit.Request
+	NewRequestIDFilter(NewClientIPResolver(nil)).Decorate(
+		func(_ http.ResponseWriter, r *http.Request) {
+			auditReq = audit.RequestFromContext(r.Context())
+		},
+	)(httptest.NewRecorder(), req)
+	require.Equal(
+		t,
+		audit.Request{
+			ID:        "1234",
+			Transport: audit.TransportHTTP,
+			ClientIP:  "10.0.0.1",
+		},
+		auditReq,
+	)
+}
+
+func TestRequestIDFilterClientIP(t *testing.T) {
+	trustedProxies, err := ParseNetworks([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.RemoteAddr = "10.0.0.1:54321"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	var clientIP string
+	NewRequestIDFilter(NewClientIPResolver(trustedProxies)).Decorate(
+		func(_ http.ResponseWriter, r *http.Request) {
+			clientIP = audit.RequestFromContext(r.Context()).ClientIP
+		},
+	)(httptest.NewRecorder(), req)
+	require.Equal(t, "198.51.100.1", clientIP)
+}
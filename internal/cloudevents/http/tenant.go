@@ -0,0 +1,46 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	"github.com/gorilla/mux"
+)
+
+// tenantVar is the name of the route variable from which the name of the
+// tenant a request is addressed to is read.
+const tenantVar = "tenant"
+
+// tenantHandler is an http.Handler that dispatches each request to the handler
+// for the tenant it is addressed to.
+type tenantHandler struct {
+	handlers map[string]http.Handler
+}
+
+// NewTenantHandler returns an http.Handler that dispatches each request to the
+// handler, from those provided, for the tenant named by the request's {tenant}
+// route variable. Requests addressed to unknown tenants receive a 404. Every
+// message logged or audit record written in the course of handling a request
+// is tagged with the tenant's name, so the returned handler must be decorated
+// by any filter that establishes the details of the request, such as the one
+// returned from NewRequestIDFilter, and not the other way around.
+func NewTenantHandler(handlers map[string]http.Handler) http.Handler {
+	return &tenantHandler{
+		handlers: handlers,
+	}
+}
+
+func (t *tenantHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tenant := mux.Vars(r)[tenantVar]
+	handler, ok := t.handlers[tenant]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	ctx := logging.ContextWithFields(r.Context(), logging.Tenant(tenant))
+	req := audit.RequestFromContext(ctx)
+	req.Tenant = tenant
+	ctx = audit.ContextWithRequest(ctx, req)
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
@@ -0,0 +1,85 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestTenantHandler(t *testing.T) {
+	testCases := []struct {
+		name       string
+		tenant     string
+		assertions func(
+			rr *httptest.ResponseRecorder,
+			req audit.Request,
+			logged map[string]interface{},
+		)
+	}{
+		{
+			name:   "unknown tenant",
+			tenant: "nope",
+			assertions: func(
+				rr *httptest.ResponseRecorder,
+				_ audit.Request,
+				logged map[string]interface{},
+			) {
+				require.Equal(t, http.StatusNotFound, rr.Code)
+				require.Nil(t, logged)
+			},
+		},
+		{
+			name:   "known tenant",
+			tenant: "italian",
+			assertions: func(
+				rr *httptest.ResponseRecorder,
+				req audit.Request,
+				logged map[string]interface{},
+			) {
+				require.Equal(t, http.StatusOK, rr.Code)
+				require.Equal(t, "italian", req.Tenant)
+				// Details established earlier should be preserved
+				require.Equal(t, "1234", req.ID)
+				require.Equal(t, "italian", logged["tenant"])
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.InfoLevel)
+			ctx := logging.ContextWithLogger(context.Background(), zap.New(core))
+			ctx = audit.ContextWithRequest(ctx, audit.Request{ID: "1234"})
+			var req audit.Request
+			handler := NewTenantHandler(
+				map[string]http.Handler{
+					"italian": http.HandlerFunc(
+						func(_ http.ResponseWriter, r *http.Request) {
+							req = audit.RequestFromContext(r.Context())
+							logging.FromContext(r.Context()).Info("handled")
+						},
+					),
+				},
+			)
+			r := mux.SetURLVars(
+				httptest.NewRequest(http.MethodPost, "/", nil).WithContext(ctx),
+				map[string]string{tenantVar: testCase.tenant},
+			)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, r)
+			var logged map[string]interface{}
+			if logs.Len() > 0 {
+				logged = logs.All()[0].ContextMap()
+			}
+			testCase.assertions(rr, req, logged)
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package http
+
+import (
+	"context"
+	"time"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// TokenExpiryMonitorConfig encapsulates configuration for the
+// TokenExpiryMonitor.
+type TokenExpiryMonitorConfig struct {
+	// WarningPeriod is how long before a token expires that it is reported as
+	// expiring soon.
+	WarningPeriod time.Duration
+	// CheckInterval is how often tokens are checked.
+	CheckInterval time.Duration
+}
+
+// TokenExpiryMonitor is an interface for components that periodically report,
+// through logs and metrics, tokens that are about to expire or have expired.
+type TokenExpiryMonitor interface {
+	// Run checks tokens until the provided context is canceled. This function
+	// always returns a non-nil error.
+	Run(context.Context) error
+}
+
+type tokenExpiryMonitor struct {
+	config TokenExpiryMonitorConfig
+	// configs are the TokenFilterConfigs to check, indexed by tenant. The
+	// gateway's default tokens are indexed by the empty string.
+	configs map[string]TokenFilterConfig
+	// now returns the current time. It is overridable for testing purposes.
+	now func() time.Time
+}
+
+// NewTokenExpiryMonitor returns an implementation of the TokenExpiryMonitor
+// interface that checks the tokens of each of the provided TokenFilterConfigs,
+// indexed by tenant. The gateway's default tokens should be indexed by the
+// empty string.
+func NewTokenExpiryMonitor(
+	configs map[string]TokenFilterConfig,
+	config TokenExpiryMonitorConfig,
+) TokenExpiryMonitor {
+	if config.CheckInterval <= 0 {
+		config.CheckInterval = time.Hour
+	}
+	return &tokenExpiryMonitor{
+		config:  config,
+		configs: configs,
+		now:     time.Now,
+	}
+}
+
+func (t *tokenExpiryMonitor) Run(ctx context.Context) error {
+	ticker := time.NewTicker(t.config.CheckInterval)
+	defer ticker.Stop()
+	for {
+		t.check(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// check logs a warning for each token that expires within the warning period
+// and updates token expiry metrics.
+func (t *tokenExpiryMonitor) check(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+	now := t.now()
+	for tenant, config := range t.configs {
+		var expiringSoon int
+		// Tokens may have been removed since they were last checked
+		tokenExpiryTimestamp.DeletePartialMatch(prometheus.Labels{"tenant": tenant})
+		for sender, expiresAt := range config.tokenExpirations() {
+			tokenExpiryTimestamp.WithLabelValues(tenant, sender).
+				Set(float64(expiresAt.Unix()))
+			fields := []zap.Field{
+				logging.Sender(sender),
+				zap.Time("expiresAt", expiresAt),
+			}
+			if tenant != "" {
+				fields = append(fields, logging.Tenant(tenant))
+			}
+			switch {
+			case !now.Before(expiresAt):
+				// Expired tokens are already rejected, so there's nothing urgent
+				// about them
+				logger.Info("token has expired", fields...)
+			case expiresAt.Sub(now) <= t.config.WarningPeriod:
+				expiringSoon++
+				logger.Warn("token expires soon", fields...)
+			}
+		}
+		tokensExpiringSoon.WithLabelValues(tenant).Set(float64(expiringSoon))
+	}
+}
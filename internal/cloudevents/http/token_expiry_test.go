@@ -0,0 +1,116 @@
+package http
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestNewTokenExpiryMonitor(t *testing.T) {
+	configs := map[string]TokenFilterConfig{"": NewTokenFilterConfig()}
+	monitor, ok := NewTokenExpiryMonitor(
+		configs,
+		TokenExpiryMonitorConfig{WarningPeriod: time.Hour},
+	).(*tokenExpiryMonitor)
+	require.True(t, ok)
+	require.Equal(t, configs, monitor.configs)
+	require.Equal(t, time.Hour, monitor.config.WarningPeriod)
+	// A default check interval is applied
+	require.Equal(t, time.Hour, monitor.config.CheckInterval)
+	require.NotNil(t, monitor.now)
+}
+
+func TestTokenExpiryMonitorCheck(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	defaultConfig := NewTokenFilterConfig()
+	require.NoError(t, defaultConfig.AddToken("foo", Token{Value: "foo"}))
+	require.NoError(
+		t,
+		defaultConfig.AddToken(
+			"bar",
+			Token{Value: "bar", ExpiresAt: now.Add(time.Hour)},
+		),
+	)
+	require.NoError(
+		t,
+		defaultConfig.AddToken(
+			"bat",
+			Token{Value: "bat", ExpiresAt: now.Add(30 * 24 * time.Hour)},
+		),
+	)
+	tenantConfig := NewTokenFilterConfig()
+	require.NoError(
+		t,
+		tenantConfig.AddToken(
+			"baz",
+			Token{Value: "baz", ExpiresAt: now.Add(-time.Hour)},
+		),
+	)
+	monitor := &tokenExpiryMonitor{
+		config: TokenExpiryMonitorConfig{WarningPeriod: 24 * time.Hour},
+		configs: map[string]TokenFilterConfig{
+			"":    defaultConfig,
+			"foo": tenantConfig,
+		},
+		now: func() time.Time { return now },
+	}
+	core, logs := observer.New(zapcore.InfoLevel)
+	monitor.check(logging.ContextWithLogger(context.Background(), zap.New(core)))
+
+	require.Equal(
+		t,
+		float64(now.Add(time.Hour).Unix()),
+		testutil.ToFloat64(tokenExpiryTimestamp.WithLabelValues("", "bar")),
+	)
+	require.Equal(
+		t,
+		float64(now.Add(-time.Hour).Unix()),
+		testutil.ToFloat64(tokenExpiryTimestamp.WithLabelValues("foo", "baz")),
+	)
+	require.Equal(
+		t,
+		float64(1),
+		testutil.ToFloat64(tokensExpiringSoon.WithLabelValues("")),
+	)
+	require.Equal(
+		t,
+		float64(0),
+		testutil.ToFloat64(tokensExpiringSoon.WithLabelValues("foo")),
+	)
+
+	expiringSoon := logs.FilterMessage("token expires soon").All()
+	require.Len(t, expiringSoon, 1)
+	require.Equal(t, zapcore.WarnLevel, expiringSoon[0].Level)
+	require.Equal(t, "bar", expiringSoon[0].ContextMap()["sender"])
+	expired := logs.FilterMessage("token has expired").All()
+	require.Len(t, expired, 1)
+	require.Equal(t, "baz", expired[0].ContextMap()["sender"])
+	require.Equal(t, "foo", expired[0].ContextMap()["tenant"])
+
+	// Metrics for tokens that have been removed are removed as well
+	before := testutil.CollectAndCount(tokenExpiryTimestamp)
+	require.NoError(t, tenantConfig.SetTokens(map[string]Token{}))
+	monitor.check(context.Background())
+	require.Equal(
+		t,
+		before-1,
+		testutil.CollectAndCount(tokenExpiryTimestamp),
+	)
+}
+
+func TestTokenExpiryMonitorRun(t *testing.T) {
+	monitor := NewTokenExpiryMonitor(
+		map[string]TokenFilterConfig{},
+		TokenExpiryMonitorConfig{CheckInterval: time.Millisecond},
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, monitor.Run(ctx))
+}
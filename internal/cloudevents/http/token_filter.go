@@ -1,97 +1,533 @@
 package http
 
 import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
 	"net/http"
+	"runtime"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
 	"github.com/brigadecore/brigade-foundations/crypto"
 	libHTTP "github.com/brigadecore/brigade-foundations/http"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
 )
 
+var (
+	// ErrInvalidToken is returned when a token matches none of the configured
+	// tokens.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenDisabled is returned when a token matches a configured token that
+	// is disabled.
+	ErrTokenDisabled = errors.New("token is disabled")
+	// ErrTokenNotYetValid is returned when a token matches a configured token
+	// whose NotBefore time has not yet arrived.
+	ErrTokenNotYetValid = errors.New("token is not yet valid")
+	// ErrTokenExpired is returned when a token matches a configured token whose
+	// ExpiresAt time has passed.
+	ErrTokenExpired = errors.New("token has expired")
+	// ErrClientIPNotAllowed is returned when a token matches a configured token
+	// whose network restrictions don't allow the IP address of the client.
+	ErrClientIPNotAllowed = errors.New(
+		"token may not be used from the client's IP address",
+	)
+)
+
+// accessTokenQueryParam is the query parameter in which senders that can't set
+// an Authorization header may provide their token.
+const accessTokenQueryParam = "access_token"
+
+// maxFailedVerifications is the maximum number of tokens that failed
+// verification against every hash computed using a slow key derivation
+// function that are remembered at once.
+const maxFailedVerifications = 10000
+
+// Token is a token that senders may authenticate with, along with optional
+// restrictions on when and from where it may be used. In JSON, a Token is
+// either a string, which is taken to be its Value, or an object with a "token"
+// field and any of the optional "notBefore", "expiresAt", "disabled",
+// "allowedNetworks", and "deniedNetworks" fields.
+type Token struct {
+	// Value is the token in plain text or hashed.
+	Value string `json:"token"`
+	// NotBefore, if non-zero, is the time before which the token may not be
+	// used.
+	NotBefore time.Time `json:"notBefore"`
+	// ExpiresAt, if non-zero, is the time at and after which the token may not
+	// be used.
+	ExpiresAt time.Time `json:"expiresAt"`
+	// Disabled indicates the token may not be used at all.
+	Disabled bool `json:"disabled"`
+	// AllowedNetworks, if non-empty, are the only networks, in CIDR notation,
+	// that clients may use the token from.
+	AllowedNetworks []string `json:"allowedNetworks"`
+	// DeniedNetworks are networks, in CIDR notation, that clients may never use
+	// the token from.
+	DeniedNetworks []string `json:"deniedNetworks"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (t *Token) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &t.Value); err == nil {
+		return nil
+	}
+	// Use an alias type so this function isn't invoked recursively
+	type token Token
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// Unknown fields are more likely to be misspelled restrictions than anything
+	// that can safely be ignored
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode((*token)(t)); err != nil {
+		return err
+	}
+	return t.Validate()
+}
+
+// Validate returns an error if the Token doesn't specify a value, if its value
+// appears to be a hash but cannot be parsed, if it expires before it becomes
+// valid, or if any of its networks cannot be parsed.
+func (t Token) Validate() error {
+	if t.Value == "" {
+		return errors.New("token is not specified")
+	}
+	if _, _, err := newTokenVerifier(t.Value); err != nil {
+		return err
+	}
+	if !t.NotBefore.IsZero() && !t.ExpiresAt.IsZero() &&
+		!t.ExpiresAt.After(t.NotBefore) {
+		return errors.New("token expires before it becomes valid")
+	}
+	_, err := t.ipRules()
+	return err
+}
+
+// ipRules returns IPRules parsed from the Token's networks.
+func (t Token) ipRules() (IPRules, error) {
+	var rules IPRules
+	var err error
+	if rules.Allowed, err = ParseNetworks(t.AllowedNetworks); err != nil {
+		return rules, errors.Wrap(err, "error parsing allowed networks")
+	}
+	if rules.Denied, err = ParseNetworks(t.DeniedNetworks); err != nil {
+		return rules, errors.Wrap(err, "error parsing denied networks")
+	}
+	return rules, nil
+}
+
 // TokenFilterConfig is the interface for a component that encapsulates token
 // filter configuration.
 type TokenFilterConfig interface {
-	// AddToken adds a token to the TokenFilterConfig implementation's instance's
-	// internal list of tokens. Implementations MUST hash the provided token
-	// before addition to the list so that plain text tokens do not float around
-	// in memory long-term.
-	AddToken(string)
-	getHashedTokens() []string
+	// AddToken adds a named Token to the TokenFilterConfig implementation's
+	// instance's internal list of tokens. The name identifies senders who
+	// authenticate using the Token. The Token's value may be in plain text or
+	// may be a hash computed using HashToken or a compatible tool.
+	// Implementations MUST hash plain text tokens before addition to the list
+	// so that they do not float around in memory long-term. An error is
+	// returned if the Token's value appears to be a hash but cannot be parsed.
+	AddToken(name string, token Token) error
+	// SetTokens replaces all of the TokenFilterConfig implementation's
+	// instance's tokens with the provided Tokens, indexed by name. If any
+	// Token's value appears to be a hash but cannot be parsed, an error is
+	// returned and the existing tokens are retained.
+	SetTokens(tokens map[string]Token) error
+	// Authenticate returns the name of the Token among the TokenFilterConfig
+	// implementation's instance's tokens that matches the provided plain text
+	// token. If no Token matches, ErrInvalidToken is returned. If the matching
+	// Token may not be used at present, its name is returned along with
+	// ErrTokenDisabled, ErrTokenNotYetValid, or ErrTokenExpired. If it may not
+	// be used from the provided client IP, which is nil if unknown, its name is
+	// returned along with ErrClientIPNotAllowed. If the provided context is
+	// canceled while the token is waiting to be verified, the context's error is
+	// returned.
+	Authenticate(
+		ctx context.Context,
+		token string,
+		clientIP net.IP,
+	) (string, error)
+	tokenCount() int
+	// tokenExpirations returns the ExpiresAt time of every Token that is not
+	// disabled and has one, indexed by name.
+	tokenExpirations() map[string]time.Time
+}
+
+// hashedToken is a named token.
+type hashedToken struct {
+	name string
+	// hash is a salted SHA-256 hash of the token. For tokens that were
+	// configured as hashes computed using a slow key derivation function, it is
+	// empty until a sender first authenticates using the token.
+	hash string
+	// verifier is non-nil for tokens that were configured as hashes computed
+	// using a slow key derivation function.
+	verifier  tokenVerifier
+	notBefore time.Time
+	expiresAt time.Time
+	disabled  bool
+	ipRules   IPRules
+}
+
+// usable returns nil if the token may be used at the specified time from the
+// specified client IP. Otherwise, it returns an error explaining why not.
+func (h *hashedToken) usable(now time.Time, clientIP net.IP) error {
+	if h.disabled {
+		return ErrTokenDisabled
+	}
+	if !h.notBefore.IsZero() && now.Before(h.notBefore) {
+		return ErrTokenNotYetValid
+	}
+	if !h.expiresAt.IsZero() && !now.Before(h.expiresAt) {
+		return ErrTokenExpired
+	}
+	if !h.ipRules.Allows(clientIP) {
+		return ErrClientIPNotAllowed
+	}
+	return nil
 }
 
 // tokenFilterConfig encapsulates token filter configuration.
 type tokenFilterConfig struct {
-	hashedTokens []string
+	// salt is a random, per-process salt used for hashing tokens.
+	salt   string
+	tokens []*hashedToken
+	// failedVerifications holds the salted SHA-256 hashes of tokens that were
+	// verified against every hash computed using a slow key derivation function
+	// and matched none of them. It is replaced whenever tokens are.
+	failedVerifications map[string]struct{}
+	// maxFailedVerifications is overridable for testing purposes.
+	maxFailedVerifications int
+	// verificationSlots bounds the number of tokens concurrently being verified
+	// against hashes computed using a slow key derivation function.
+	verificationSlots chan struct{}
+	// mu guards tokens, failedVerifications, and the hash field of each
+	// hashedToken.
+	mu sync.RWMutex
+	// now returns the current time. It is overridable for testing purposes.
+	now func() time.Time
 }
 
 // NewTokenFilterConfig returns an initialized implementation of the
 // TokenFilterConfig interface.
 func NewTokenFilterConfig() TokenFilterConfig {
 	return &tokenFilterConfig{
-		hashedTokens: []string{},
+		salt:                   uuid.New().String(),
+		tokens:                 []*hashedToken{},
+		failedVerifications:    map[string]struct{}{},
+		maxFailedVerifications: maxFailedVerifications,
+		verificationSlots:      make(chan struct{}, runtime.NumCPU()),
+		now:                    time.Now,
+	}
+}
+
+func (t *tokenFilterConfig) AddToken(name string, token Token) error {
+	hashed, err := t.newHashedToken(name, token)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tokens = append(t.tokens, hashed)
+	t.failedVerifications = map[string]struct{}{}
+	return nil
+}
+
+func (t *tokenFilterConfig) SetTokens(tokens map[string]Token) error {
+	hashedTokens := make([]*hashedToken, 0, len(tokens))
+	for name, token := range tokens {
+		hashed, err := t.newHashedToken(name, token)
+		if err != nil {
+			return err
+		}
+		hashedTokens = append(hashedTokens, hashed)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tokens = hashedTokens
+	t.failedVerifications = map[string]struct{}{}
+	return nil
+}
+
+// newHashedToken returns a hashedToken for the provided named Token.
+func (t *tokenFilterConfig) newHashedToken(
+	name string,
+	token Token,
+) (*hashedToken, error) {
+	verifier, isHash, err := newTokenVerifier(token.Value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error adding token %q", name)
+	}
+	ipRules, err := token.ipRules()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error adding token %q", name)
 	}
+	hashed := &hashedToken{
+		name:      name,
+		verifier:  verifier,
+		notBefore: token.NotBefore,
+		expiresAt: token.ExpiresAt,
+		disabled:  token.Disabled,
+		ipRules:   ipRules,
+	}
+	if !isHash {
+		hashed.hash = crypto.Hash(t.salt, token.Value)
+	}
+	return hashed, nil
+}
+
+func (t *tokenFilterConfig) Authenticate(
+	ctx context.Context,
+	token string,
+	clientIP net.IP,
+) (string, error) {
+	hash := []byte(crypto.Hash(t.salt, token))
+	// Every hash is compared in constant time, and all are compared even after
+	// a match is found, so that timing reveals nothing about the tokens
+	var match *hashedToken
+	t.mu.RLock()
+	// Tokens may be replaced while they're being verified below
+	tokens := t.tokens
+	failedVerifications := t.failedVerifications
+	for _, hashedToken := range tokens {
+		if subtle.ConstantTimeCompare(hash, []byte(hashedToken.hash)) == 1 &&
+			match == nil {
+			match = hashedToken
+		}
+	}
+	t.mu.RUnlock()
+	if match == nil {
+		// Verifying a token against a hash computed using a slow key derivation
+		// function is expensive, so once a token has been verified, its salted
+		// SHA-256 hash is remembered and subsequent requests using it take the
+		// fast path above
+		var err error
+		if match, err = t.verify(
+			ctx,
+			token,
+			string(hash),
+			tokens,
+			failedVerifications,
+		); err != nil {
+			return "", err
+		}
+	}
+	if match == nil {
+		return "", ErrInvalidToken
+	}
+	return match.name, match.usable(t.now(), clientIP)
+}
+
+// verify returns the first of the provided tokens that was configured as a
+// hash computed using a slow key derivation function and that matches the
+// provided plain text token, or nil if none match. Tokens that match none are
+// remembered in the provided failedVerifications, along with their salted
+// SHA-256 hashes, so that senders repeatedly using an unrecognized token cannot
+// make the gateway verify it over and over. So that senders using many
+// different unrecognized tokens cannot exhaust the gateway's CPU, no more
+// tokens than there are CPUs are verified concurrently. If the provided context
+// is canceled while waiting for others to be verified, its error is returned.
+func (t *tokenFilterConfig) verify(
+	ctx context.Context,
+	token string,
+	hash string,
+	tokens []*hashedToken,
+	failedVerifications map[string]struct{},
+) (*hashedToken, error) {
+	var hasVerifiers bool
+	for _, hashedToken := range tokens {
+		if hashedToken.verifier != nil {
+			hasVerifiers = true
+			break
+		}
+	}
+	if !hasVerifiers || t.verificationFailed(hash, failedVerifications) {
+		return nil, nil
+	}
+	select {
+	case t.verificationSlots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() {
+		<-t.verificationSlots
+	}()
+	// The same token may have failed verification while waiting for a slot
+	if t.verificationFailed(hash, failedVerifications) {
+		return nil, nil
+	}
+	for _, hashedToken := range tokens {
+		if hashedToken.verifier != nil && hashedToken.verifier.verify(token) {
+			t.mu.Lock()
+			hashedToken.hash = hash
+			t.mu.Unlock()
+			return hashedToken, nil
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// Once full, forget every token that failed verification rather than keep
+	// track of which failed least recently
+	if len(failedVerifications) >= t.maxFailedVerifications {
+		for failedHash := range failedVerifications {
+			delete(failedVerifications, failedHash)
+		}
+	}
+	failedVerifications[hash] = struct{}{}
+	return nil, nil
+}
+
+// verificationFailed returns true if the token with the provided salted SHA-256
+// hash is among the provided failedVerifications.
+func (t *tokenFilterConfig) verificationFailed(
+	hash string,
+	failedVerifications map[string]struct{},
+) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	_, failed := failedVerifications[hash]
+	return failed
+}
+
+func (t *tokenFilterConfig) tokenCount() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.tokens)
 }
 
-func (t *tokenFilterConfig) AddToken(token string) {
-	t.hashedTokens = append(t.hashedTokens, crypto.Hash("", token))
+func (t *tokenFilterConfig) tokenExpirations() map[string]time.Time {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	expirations := map[string]time.Time{}
+	for _, hashedToken := range t.tokens {
+		if !hashedToken.disabled && !hashedToken.expiresAt.IsZero() {
+			expirations[hashedToken.name] = hashedToken.expiresAt
+		}
+	}
+	return expirations
 }
 
-func (t *tokenFilterConfig) getHashedTokens() []string {
-	return t.hashedTokens
+// AuthOutcome returns the outcome of authentication, suitable for use as a
+// metric label, in audit records, and in logs, that corresponds to an error
+// returned from TokenFilterConfig.Authenticate.
+func AuthOutcome(err error) string {
+	switch {
+	case err == nil:
+		return authOutcomeAuthorized
+	case errors.Is(err, ErrTokenDisabled):
+		return authOutcomeDisabledToken
+	case errors.Is(err, ErrTokenNotYetValid):
+		return authOutcomeNotYetValidToken
+	case errors.Is(err, ErrTokenExpired):
+		return authOutcomeExpiredToken
+	case errors.Is(err, ErrClientIPNotAllowed):
+		return authOutcomeClientIPNotAllowed
+	case errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded):
+		return authOutcomeCanceled
+	}
+	return authOutcomeInvalidToken
 }
 
 // tokenFilter is a component that implements the http.Filter interface and can
 // conditionally allow or disallow a request on the basis of a recognized token
 // having been provided.
 type tokenFilter struct {
-	config TokenFilterConfig
+	config    TokenFilterConfig
+	auditSink audit.Sink
 }
 
 // NewTokenFilter returns a component that implements the http.Filter interface
 // and can conditionally allow or disallow a request on the basis of a
-// recognized token having been provided.
-func NewTokenFilter(config TokenFilterConfig) libHTTP.Filter {
+// recognized token having been provided. Disallowed requests are recorded
+// using the provided audit.Sink.
+func NewTokenFilter(
+	config TokenFilterConfig,
+	auditSink audit.Sink,
+) libHTTP.Filter {
 	return &tokenFilter{
-		config: config,
+		config:    config,
+		auditSink: auditSink,
 	}
 }
 
 func (t *tokenFilter) Decorate(handle http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Look for a token in the Authorization header first
-		var providedToken string
-		if headerValue := r.Header.Get("Authorization"); headerValue != "" {
-			if headerValueParts := strings.SplitN(
-				headerValue,
-				" ",
-				2,
-			); len(headerValueParts) == 2 &&
-				strings.ToLower(headerValueParts[0]) == "bearer" {
-				providedToken = headerValueParts[1]
-			}
-		}
-		// Then try the access_token query param
-		if providedToken == "" {
-			providedToken = r.URL.Query().Get("access_token")
+		recorder := &statusRecorder{ResponseWriter: w}
+		_, span := tracer.Start(r.Context(), "authenticate")
+		sender, authOutcome := t.authenticate(r)
+		span.SetAttributes(attribute.String("auth.outcome", authOutcome))
+		span.End()
+		defer func() {
+			requestsTotal.WithLabelValues(recorder.code(), authOutcome).Inc()
+		}()
+		ctx := r.Context()
+		// A sender is identified even if their token may not be used at present
+		// so that rejections can be attributed
+		if sender != "" {
+			ctx = logging.ContextWithFields(ctx, logging.Sender(sender))
+			auditReq := audit.RequestFromContext(ctx)
+			auditReq.Sender = sender
+			auditReq.AuthMethod = audit.AuthMethodToken
+			ctx = audit.ContextWithRequest(ctx, auditReq)
 		}
-		// If no token was provided, then access is denied
-		if providedToken == "" {
-			w.WriteHeader(http.StatusForbidden)
-			return
-		}
-		hashedProvidedToken := crypto.Hash("", providedToken)
-		var allowed bool
-		for _, hashedAllowedToken := range t.config.getHashedTokens() {
-			if hashedProvidedToken == hashedAllowedToken {
-				allowed = true
-				break
+		if authOutcome != authOutcomeAuthorized {
+			logging.FromContext(ctx).Info(
+				"request denied",
+				zap.String("authOutcome", authOutcome),
+			)
+			t.auditSink.Write(
+				ctx,
+				audit.Record{
+					Decision: audit.DecisionRejected,
+					Reason:   authOutcome,
+				},
+			)
+			if authOutcome == authOutcomeCanceled {
+				recorder.WriteHeader(http.StatusServiceUnavailable)
+				return
 			}
-		}
-		if !allowed {
-			w.WriteHeader(http.StatusForbidden)
+			recorder.WriteHeader(http.StatusForbidden)
 			return
 		}
 		// If we get this far, everything checks out. Handle the request.
-		handle(w, r)
+		handle(recorder, r.WithContext(ctx))
+	}
+}
+
+// authenticate returns the name of the sender of the provided request, if
+// authenticated, and the outcome of authenticating it.
+func (t *tokenFilter) authenticate(r *http.Request) (string, string) {
+	// Look for a token in the Authorization header first
+	var providedToken string
+	if headerValue := r.Header.Get("Authorization"); headerValue != "" {
+		if headerValueParts := strings.SplitN(
+			headerValue,
+			" ",
+			2,
+		); len(headerValueParts) == 2 &&
+			strings.ToLower(headerValueParts[0]) == "bearer" {
+			providedToken = headerValueParts[1]
+		}
+	}
+	// Then try the access_token query param
+	if providedToken == "" {
+		providedToken = r.URL.Query().Get(accessTokenQueryParam)
+	}
+	// If no token was provided, then access is denied
+	if providedToken == "" {
+		return "", authOutcomeMissingToken
 	}
+	sender, err := t.config.Authenticate(
+		r.Context(),
+		providedToken,
+		net.ParseIP(requestClientIP(r)),
+	)
+	return sender, AuthOutcome(err)
 }
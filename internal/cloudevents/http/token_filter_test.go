@@ -1,12 +1,18 @@
 package http
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
 	"github.com/brigadecore/brigade-foundations/crypto"
 	"github.com/stretchr/testify/require"
 )
@@ -14,43 +20,550 @@ import (
 func TestNewTokenFilterConfig(t *testing.T) {
 	config, ok := NewTokenFilterConfig().(*tokenFilterConfig)
 	require.True(t, ok)
-	require.NotNil(t, config.hashedTokens)
+	require.NotEmpty(t, config.salt)
+	require.NotNil(t, config.tokens)
+	require.NotNil(t, config.failedVerifications)
+	require.NotZero(t, config.maxFailedVerifications)
+	require.NotZero(t, cap(config.verificationSlots))
+	// Every instance has its own salt
+	otherConfig, ok := NewTokenFilterConfig().(*tokenFilterConfig)
+	require.True(t, ok)
+	require.NotEqual(t, config.salt, otherConfig.salt)
+}
+
+func TestTokenUnmarshalJSON(t *testing.T) {
+	testCases := []struct {
+		name       string
+		json       string
+		assertions func(Token, error)
+	}{
+		{
+			name: "string",
+			json: `"foo"`,
+			assertions: func(token Token, err error) {
+				require.NoError(t, err)
+				require.Equal(t, Token{Value: "foo"}, token)
+			},
+		},
+		{
+			name: "object with unknown field",
+			json: `{"token":"foo","expires":"2022-01-01T00:00:00Z"}`,
+			assertions: func(_ Token, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `unknown field "expires"`)
+			},
+		},
+		{
+			name: "object without token",
+			json: `{"disabled":true}`,
+			assertions: func(_ Token, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "token is not specified")
+			},
+		},
+		{
+			name: "object with unparseable hash",
+			json: `{"token":"$scrypt$ln=15"}`,
+			assertions: func(_ Token, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "scrypt")
+			},
+		},
+		{
+			name: "object that expires before it becomes valid",
+			json: `{
+				"token": "foo",
+				"notBefore": "2022-02-01T00:00:00Z",
+				"expiresAt": "2022-01-01T00:00:00Z"
+			}`,
+			assertions: func(_ Token, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "expires before it becomes valid")
+			},
+		},
+		{
+			name: "object with invalid network",
+			json: `{"token":"foo","allowedNetworks":["10.0.0.0/33"]}`,
+			assertions: func(_ Token, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "error parsing allowed networks")
+			},
+		},
+		{
+			name: "object",
+			json: `{
+				"token": "foo",
+				"notBefore": "2022-01-01T00:00:00Z",
+				"expiresAt": "2022-02-01T00:00:00Z",
+				"disabled": true,
+				"allowedNetworks": ["10.0.0.0/8"],
+				"deniedNetworks": ["10.0.0.1"]
+			}`,
+			assertions: func(token Token, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					Token{
+						Value:           "foo",
+						NotBefore:       time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+						ExpiresAt:       time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC),
+						Disabled:        true,
+						AllowedNetworks: []string{"10.0.0.0/8"},
+						DeniedNetworks:  []string{"10.0.0.1"},
+					},
+					token,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			token := Token{}
+			err := json.Unmarshal([]byte(testCase.json), &token)
+			testCase.assertions(token, err)
+		})
+	}
 }
 
 func TestAddToken(t *testing.T) {
+	testCases := []struct {
+		name       string
+		token      Token
+		assertions func(*tokenFilterConfig, error)
+	}{
+		{
+			name:  "plain text token",
+			token: Token{Value: "foo"},
+			assertions: func(config *tokenFilterConfig, err error) {
+				require.NoError(t, err)
+				require.Len(t, config.tokens, 1)
+				require.Equal(t, "example/uri", config.tokens[0].name)
+				require.Equal(
+					t,
+					crypto.Hash(config.salt, "foo"),
+					config.tokens[0].hash,
+				)
+				require.Nil(t, config.tokens[0].verifier)
+			},
+		},
+		{
+			name: "hashed token",
+			token: Token{
+				Value: "$2a$04$Sfr4R0Sn5QJ8Kx4f7yU0DO1v9hD9S3I0a0pTbUpFzb0sbIuY4h6zW",
+			},
+			assertions: func(config *tokenFilterConfig, err error) {
+				require.NoError(t, err)
+				require.Len(t, config.tokens, 1)
+				require.Equal(t, "example/uri", config.tokens[0].name)
+				require.Empty(t, config.tokens[0].hash)
+				require.NotNil(t, config.tokens[0].verifier)
+			},
+		},
+		{
+			name:  "invalid hash",
+			token: Token{Value: "$argon2id$v=19$m=19456,t=2,p=1$c2FsdA"},
+			assertions: func(config *tokenFilterConfig, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `error adding token "example/uri"`)
+				require.Empty(t, config.tokens)
+			},
+		},
+		{
+			name: "token with restrictions",
+			token: Token{
+				Value:     "foo",
+				NotBefore: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+				ExpiresAt: time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC),
+				Disabled:  true,
+			},
+			assertions: func(config *tokenFilterConfig, err error) {
+				require.NoError(t, err)
+				require.Len(t, config.tokens, 1)
+				require.Equal(
+					t,
+					time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+					config.tokens[0].notBefore,
+				)
+				require.Equal(
+					t,
+					time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC),
+					config.tokens[0].expiresAt,
+				)
+				require.True(t, config.tokens[0].disabled)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			config, ok := NewTokenFilterConfig().(*tokenFilterConfig)
+			require.True(t, ok)
+			err := config.AddToken("example/uri", testCase.token)
+			testCase.assertions(config, err)
+		})
+	}
+}
+
+func TestAddHashedToken(t *testing.T) {
 	const testToken = "foo"
+	config := NewTokenFilterConfig()
+	require.NoError(
+		t,
+		config.AddToken(
+			"example/uri",
+			Token{Value: "$sha256$" + strings.ToUpper(crypto.Hash("", testToken))},
+		),
+	)
+	name, err := config.Authenticate(context.Background(), testToken, nil)
+	require.NoError(t, err)
+	require.Equal(t, "example/uri", name)
+}
+
+func TestSetTokens(t *testing.T) {
+	config := NewTokenFilterConfig()
+	require.NoError(t, config.AddToken("foo", Token{Value: "foo"}))
+	// Invalid tokens are rejected without affecting existing tokens
+	err := config.SetTokens(
+		map[string]Token{
+			"bar": {Value: "bar"},
+			"bat": {Value: "$scrypt$ln=15"},
+		},
+	)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `error adding token "bat"`)
+	name, err := config.Authenticate(context.Background(), "foo", nil)
+	require.NoError(t, err)
+	require.Equal(t, "foo", name)
+	// Valid tokens replace existing tokens
+	require.NoError(
+		t,
+		config.SetTokens(
+			map[string]Token{
+				"bar": {Value: "bar"},
+				"bat": {Value: "bat"},
+			},
+		),
+	)
+	require.Equal(t, 2, config.tokenCount())
+	_, err = config.Authenticate(context.Background(), "foo", nil)
+	require.Equal(t, ErrInvalidToken, err)
+	name, err = config.Authenticate(context.Background(), "bat", nil)
+	require.NoError(t, err)
+	require.Equal(t, "bat", name)
+}
+
+func TestAuthenticate(t *testing.T) {
+	now := time.Date(2022, 1, 15, 0, 0, 0, 0, time.UTC)
+	config, ok := NewTokenFilterConfig().(*tokenFilterConfig)
+	require.True(t, ok)
+	config.now = func() time.Time { return now }
+	require.NoError(t, config.AddToken("example/uri", Token{Value: "foo"}))
+	require.NoError(
+		t,
+		config.AddToken("disabled/uri", Token{Value: "bar", Disabled: true}),
+	)
+	require.NoError(
+		t,
+		config.AddToken(
+			"future/uri",
+			Token{Value: "bat", NotBefore: now.Add(time.Hour)},
+		),
+	)
+	require.NoError(
+		t,
+		config.AddToken(
+			"expired/uri",
+			Token{Value: "baz", ExpiresAt: now},
+		),
+	)
+	require.NoError(
+		t,
+		config.AddToken(
+			"current/uri",
+			Token{
+				Value:     "qux",
+				NotBefore: now,
+				ExpiresAt: now.Add(time.Hour),
+			},
+		),
+	)
+	require.NoError(
+		t,
+		config.AddToken(
+			"restricted/uri",
+			Token{
+				Value:           "quux",
+				AllowedNetworks: []string{"10.0.0.0/8"},
+				DeniedNetworks:  []string{"10.0.0.1"},
+			},
+		),
+	)
+	for _, algorithm := range []string{
+		TokenHashArgon2id,
+		TokenHashBcrypt,
+		TokenHashScrypt,
+	} {
+		hash, err := HashToken(algorithm, algorithm+"-token")
+		require.NoError(t, err)
+		require.NoError(t, config.AddToken(algorithm+"/uri", Token{Value: hash}))
+	}
+	testCases := []struct {
+		name         string
+		token        string
+		clientIP     net.IP
+		expectedName string
+		expectedErr  error
+	}{
+		{
+			name:         "plain text token",
+			token:        "foo",
+			expectedName: "example/uri",
+		},
+		{
+			name:         "argon2id hashed token",
+			token:        "argon2id-token",
+			expectedName: "argon2id/uri",
+		},
+		{
+			name:         "bcrypt hashed token",
+			token:        "bcrypt-token",
+			expectedName: "bcrypt/uri",
+		},
+		{
+			name:         "scrypt hashed token",
+			token:        "scrypt-token",
+			expectedName: "scrypt/uri",
+		},
+		{
+			name:         "disabled token",
+			token:        "bar",
+			expectedName: "disabled/uri",
+			expectedErr:  ErrTokenDisabled,
+		},
+		{
+			name:         "not yet valid token",
+			token:        "bat",
+			expectedName: "future/uri",
+			expectedErr:  ErrTokenNotYetValid,
+		},
+		{
+			name:         "expired token",
+			token:        "baz",
+			expectedName: "expired/uri",
+			expectedErr:  ErrTokenExpired,
+		},
+		{
+			name:         "token within its validity period",
+			token:        "qux",
+			expectedName: "current/uri",
+		},
+		{
+			name:         "token used from an allowed network",
+			token:        "quux",
+			clientIP:     net.ParseIP("10.0.0.2"),
+			expectedName: "restricted/uri",
+		},
+		{
+			name:         "token used from outside its allowed networks",
+			token:        "quux",
+			clientIP:     net.ParseIP("192.168.0.1"),
+			expectedName: "restricted/uri",
+			expectedErr:  ErrClientIPNotAllowed,
+		},
+		{
+			name:         "token used from a denied network",
+			token:        "quux",
+			clientIP:     net.ParseIP("10.0.0.1"),
+			expectedName: "restricted/uri",
+			expectedErr:  ErrClientIPNotAllowed,
+		},
+		{
+			name:         "restricted token used from an unknown address",
+			token:        "quux",
+			expectedName: "restricted/uri",
+			expectedErr:  ErrClientIPNotAllowed,
+		},
+		{
+			name:        "bogus token",
+			token:       "bogus-token",
+			expectedErr: ErrInvalidToken,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			// The second attempt exercises tokens remembered after the first
+			for i := 0; i < 2; i++ {
+				name, err := config.Authenticate(
+					context.Background(),
+					testCase.token,
+					testCase.clientIP,
+				)
+				require.Equal(t, testCase.expectedErr, err)
+				require.Equal(t, testCase.expectedName, name)
+			}
+		})
+	}
+	// Verified tokens are remembered by their salted hashes
+	for _, token := range config.tokens {
+		require.NotEmpty(t, token.hash)
+	}
+}
+
+// countingVerifier is a tokenVerifier that counts its verifications.
+type countingVerifier struct {
+	token         string
+	verifications int
+}
+
+func (c *countingVerifier) verify(token string) bool {
+	c.verifications++
+	return token == c.token
+}
+
+func TestAuthenticateFailedVerifications(t *testing.T) {
+	verifier := &countingVerifier{token: "foo"}
 	config, ok := NewTokenFilterConfig().(*tokenFilterConfig)
 	require.True(t, ok)
-	require.Empty(t, config.hashedTokens)
-	config.AddToken(testToken)
-	require.Len(t, config.hashedTokens, 1)
-	require.Equal(t, crypto.Hash("", testToken), config.hashedTokens[0])
+	config.maxFailedVerifications = 2
+	config.tokens = []*hashedToken{{name: "example/uri", verifier: verifier}}
+	// An unrecognized token is only verified once
+	for i := 0; i < 2; i++ {
+		_, err := config.Authenticate(context.Background(), "bar", nil)
+		require.Equal(t, ErrInvalidToken, err)
+	}
+	require.Equal(t, 1, verifier.verifications)
+	require.Len(t, config.failedVerifications, 1)
+	// Once full, tokens that failed verification are forgotten
+	_, err := config.Authenticate(context.Background(), "bat", nil)
+	require.Equal(t, ErrInvalidToken, err)
+	require.Len(t, config.failedVerifications, 2)
+	_, err = config.Authenticate(context.Background(), "baz", nil)
+	require.Equal(t, ErrInvalidToken, err)
+	require.Len(t, config.failedVerifications, 1)
+	require.Equal(t, 3, verifier.verifications)
+	// A recognized token is only verified once
+	for i := 0; i < 2; i++ {
+		name, err := config.Authenticate(context.Background(), "foo", nil)
+		require.NoError(t, err)
+		require.Equal(t, "example/uri", name)
+	}
+	require.Equal(t, 4, verifier.verifications)
+	// Tokens that failed verification are forgotten when tokens change
+	require.NoError(t, config.AddToken("other/uri", Token{Value: "qux"}))
+	require.Empty(t, config.failedVerifications)
+	// No slots are left occupied
+	require.Empty(t, config.verificationSlots)
 }
 
-func TestGetHashedTokens(t *testing.T) {
-	testHashedTokens := []string{"foo", "bar"}
-	config := tokenFilterConfig{
-		hashedTokens: testHashedTokens,
+func TestAuthenticateCanceled(t *testing.T) {
+	verifier := &countingVerifier{token: "foo"}
+	config, ok := NewTokenFilterConfig().(*tokenFilterConfig)
+	require.True(t, ok)
+	config.tokens = []*hashedToken{{name: "example/uri", verifier: verifier}}
+	// Occupy every verification slot
+	for i := 0; i < cap(config.verificationSlots); i++ {
+		config.verificationSlots <- struct{}{}
 	}
-	require.Equal(t, testHashedTokens, config.getHashedTokens())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := config.Authenticate(ctx, "foo", nil)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, authOutcomeCanceled, AuthOutcome(err))
+	require.Zero(t, verifier.verifications)
+	// A canceled verification is not remembered as a failed one
+	require.Empty(t, config.failedVerifications)
+}
+
+func TestTokenCount(t *testing.T) {
+	config := NewTokenFilterConfig()
+	require.Zero(t, config.tokenCount())
+	require.NoError(t, config.AddToken("foo", Token{Value: "bar"}))
+	require.Equal(t, 1, config.tokenCount())
+}
+
+func TestTokenExpirations(t *testing.T) {
+	expiresAt := time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)
+	config := NewTokenFilterConfig()
+	require.NoError(t, config.AddToken("foo", Token{Value: "foo"}))
+	require.NoError(
+		t,
+		config.AddToken("bar", Token{Value: "bar", ExpiresAt: expiresAt}),
+	)
+	require.NoError(
+		t,
+		config.AddToken(
+			"bat",
+			Token{Value: "bat", ExpiresAt: expiresAt, Disabled: true},
+		),
+	)
+	require.Equal(
+		t,
+		map[string]time.Time{"bar": expiresAt},
+		config.tokenExpirations(),
+	)
+}
+
+func TestAuthOutcome(t *testing.T) {
+	require.Equal(t, authOutcomeAuthorized, AuthOutcome(nil))
+	require.Equal(t, authOutcomeInvalidToken, AuthOutcome(ErrInvalidToken))
+	require.Equal(t, authOutcomeDisabledToken, AuthOutcome(ErrTokenDisabled))
+	require.Equal(
+		t,
+		authOutcomeNotYetValidToken,
+		AuthOutcome(ErrTokenNotYetValid),
+	)
+	require.Equal(t, authOutcomeExpiredToken, AuthOutcome(ErrTokenExpired))
+	require.Equal(
+		t,
+		authOutcomeClientIPNotAllowed,
+		AuthOutcome(ErrClientIPNotAllowed),
+	)
+	require.Equal(t, authOutcomeCanceled, AuthOutcome(context.Canceled))
+	require.Equal(
+		t,
+		authOutcomeCanceled,
+		AuthOutcome(context.DeadlineExceeded),
+	)
 }
 
 func TestNewTokenFilter(t *testing.T) {
 	testConfig := NewTokenFilterConfig()
-	filter, ok := NewTokenFilter(testConfig).(*tokenFilter)
+	filter, ok := NewTokenFilter(testConfig, audit.NewNopSink()).(*tokenFilter)
 	require.True(t, ok)
 	require.Equal(t, testConfig, filter.config)
+	require.NotNil(t, filter.auditSink)
 }
 
 func TestTokenFilter(t *testing.T) {
 	testConfig := NewTokenFilterConfig()
 	const testToken = "bar"
-	testConfig.AddToken(testToken)
+	require.NoError(
+		t,
+		testConfig.AddToken("example/uri", Token{Value: testToken}),
+	)
+	require.NoError(
+		t,
+		testConfig.AddToken(
+			"expired/uri",
+			Token{Value: "baz", ExpiresAt: time.Now().Add(-time.Hour)},
+		),
+	)
+	require.NoError(
+		t,
+		testConfig.AddToken(
+			"restricted/uri",
+			Token{Value: "qux", AllowedNetworks: []string{"10.0.0.0/8"}},
+		),
+	)
 	testCases := []struct {
 		name       string
 		filter     *tokenFilter
 		setup      func() *http.Request
-		assertions func(handlerCalled bool, r *http.Response)
+		assertions func(
+			handlerCalled bool,
+			r *http.Response,
+			sender string,
+			records []audit.Record,
+		)
 	}{
 		{
 			name: "valid token provided in Authorization header",
@@ -63,9 +576,16 @@ func TestTokenFilter(t *testing.T) {
 				req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", testToken))
 				return req
 			},
-			assertions: func(handlerCalled bool, r *http.Response) {
+			assertions: func(
+				handlerCalled bool,
+				r *http.Response,
+				sender string,
+				records []audit.Record,
+			) {
 				require.Equal(t, http.StatusOK, r.StatusCode)
 				require.True(t, handlerCalled)
+				require.Equal(t, "example/uri", sender)
+				require.Empty(t, records)
 			},
 		},
 		{
@@ -81,9 +601,16 @@ func TestTokenFilter(t *testing.T) {
 				req.URL.RawQuery = q.Encode()
 				return req
 			},
-			assertions: func(handlerCalled bool, r *http.Response) {
+			assertions: func(
+				handlerCalled bool,
+				r *http.Response,
+				sender string,
+				records []audit.Record,
+			) {
 				require.Equal(t, http.StatusOK, r.StatusCode)
 				require.True(t, handlerCalled)
+				require.Equal(t, "example/uri", sender)
+				require.Empty(t, records)
 			},
 		},
 		{
@@ -96,9 +623,24 @@ func TestTokenFilter(t *testing.T) {
 				require.NoError(t, err)
 				return req
 			},
-			assertions: func(handlerCalled bool, r *http.Response) {
+			assertions: func(
+				handlerCalled bool,
+				r *http.Response,
+				_ string,
+				records []audit.Record,
+			) {
 				require.Equal(t, http.StatusForbidden, r.StatusCode)
 				require.False(t, handlerCalled)
+				require.Equal(
+					t,
+					[]audit.Record{
+						{
+							Decision: audit.DecisionRejected,
+							Reason:   authOutcomeMissingToken,
+						},
+					},
+					records,
+				)
 			},
 		},
 		{
@@ -112,24 +654,148 @@ func TestTokenFilter(t *testing.T) {
 				req.Header.Add("Authorization", "Bearer bogus-token")
 				return req
 			},
-			assertions: func(handlerCalled bool, r *http.Response) {
+			assertions: func(
+				handlerCalled bool,
+				r *http.Response,
+				_ string,
+				records []audit.Record,
+			) {
 				require.Equal(t, http.StatusForbidden, r.StatusCode)
 				require.False(t, handlerCalled)
+				require.Equal(
+					t,
+					[]audit.Record{
+						{
+							Decision: audit.DecisionRejected,
+							Reason:   authOutcomeInvalidToken,
+						},
+					},
+					records,
+				)
+			},
+		},
+		{
+			name: "expired token provided",
+			filter: &tokenFilter{
+				config: testConfig,
+			},
+			setup: func() *http.Request {
+				req, err := http.NewRequest(http.MethodPost, "/", nil)
+				require.NoError(t, err)
+				req.Header.Add("Authorization", "Bearer baz")
+				return req
+			},
+			assertions: func(
+				handlerCalled bool,
+				r *http.Response,
+				_ string,
+				records []audit.Record,
+			) {
+				require.Equal(t, http.StatusForbidden, r.StatusCode)
+				require.False(t, handlerCalled)
+				require.Equal(
+					t,
+					[]audit.Record{
+						{
+							Decision: audit.DecisionRejected,
+							Reason:   authOutcomeExpiredToken,
+						},
+					},
+					records,
+				)
+			},
+		},
+		{
+			name: "restricted token provided from an allowed network",
+			filter: &tokenFilter{
+				config: testConfig,
+			},
+			setup: func() *http.Request {
+				req, err := http.NewRequest(http.MethodPost, "/", nil)
+				require.NoError(t, err)
+				req.Header.Add("Authorization", "Bearer qux")
+				// The client's address is that recorded by the request ID filter
+				req.RemoteAddr = "192.168.0.1:54321"
+				return req.WithContext(
+					audit.ContextWithRequest(
+						req.Context(),
+						audit.Request{ClientIP: "10.0.0.1"},
+					),
+				)
+			},
+			assertions: func(
+				handlerCalled bool,
+				r *http.Response,
+				sender string,
+				records []audit.Record,
+			) {
+				require.Equal(t, http.StatusOK, r.StatusCode)
+				require.True(t, handlerCalled)
+				require.Equal(t, "restricted/uri", sender)
+				require.Empty(t, records)
+			},
+		},
+		{
+			name: "restricted token provided from outside its allowed networks",
+			filter: &tokenFilter{
+				config: testConfig,
+			},
+			setup: func() *http.Request {
+				req, err := http.NewRequest(http.MethodPost, "/", nil)
+				require.NoError(t, err)
+				req.Header.Add("Authorization", "Bearer qux")
+				req.RemoteAddr = "192.168.0.1:54321"
+				return req
+			},
+			assertions: func(
+				handlerCalled bool,
+				r *http.Response,
+				_ string,
+				records []audit.Record,
+			) {
+				require.Equal(t, http.StatusForbidden, r.StatusCode)
+				require.False(t, handlerCalled)
+				require.Equal(
+					t,
+					[]audit.Record{
+						{
+							Decision: audit.DecisionRejected,
+							Reason:   authOutcomeClientIPNotAllowed,
+						},
+					},
+					records,
+				)
 			},
 		},
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
+			auditSink := &mockAuditSink{}
+			testCase.filter.auditSink = auditSink
 			rr := httptest.NewRecorder()
 			req := testCase.setup()
 			handlerCalled := false
+			var sender string
 			testCase.filter.Decorate(func(w http.ResponseWriter, r *http.Request) {
 				handlerCalled = true
+				sender = audit.RequestFromContext(r.Context()).Sender
 				w.WriteHeader(http.StatusOK)
 			})(rr, req)
 			res := rr.Result()
 			defer res.Body.Close()
-			testCase.assertions(handlerCalled, res)
+			testCase.assertions(handlerCalled, res, sender, auditSink.records)
 		})
 	}
 }
+
+type mockAuditSink struct {
+	records []audit.Record
+}
+
+func (m *mockAuditSink) Write(_ context.Context, record audit.Record) {
+	m.records = append(m.records, record)
+}
+
+func (m *mockAuditSink) Close() error {
+	return nil
+}
@@ -0,0 +1,284 @@
+package http
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// TokenHashArgon2id identifies tokens hashed using argon2id. Such hashes are
+	// encoded as $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>,
+	// where the salt and hash are base64 encoded without padding.
+	TokenHashArgon2id = "argon2id"
+	// TokenHashBcrypt identifies tokens hashed using bcrypt. Such hashes begin
+	// with $2a$, $2b$, or $2y$.
+	TokenHashBcrypt = "bcrypt"
+	// TokenHashScrypt identifies tokens hashed using scrypt. Such hashes are
+	// encoded as $scrypt$ln=<log2 N>,r=<block size>,p=<threads>$<salt>$<hash>,
+	// where the salt and hash are base64 encoded without padding.
+	TokenHashScrypt = "scrypt"
+	// TokenHashSHA256 identifies tokens hashed using unsalted SHA-256, as by
+	// earlier versions of the token hash command. Such hashes are encoded as
+	// $sha256$<hash>, where the hash is hex encoded. They are accepted so that
+	// existing configuration keeps working, but cannot be produced by HashToken.
+	TokenHashSHA256 = "sha256"
+)
+
+const (
+	tokenHashSaltLength = 16
+	tokenHashKeyLength  = 32
+	// Parameters used for hashing tokens using argon2id and scrypt, per OWASP's
+	// recommendations
+	argon2idMemory  = 19 * 1024
+	argon2idTime    = 2
+	argon2idThreads = 1
+	scryptLogN      = 15
+	scryptR         = 8
+	scryptP         = 1
+)
+
+var tokenHashEncoding = base64.RawStdEncoding
+
+// HashToken returns a hash of the provided plain text token computed using the
+// specified algorithm (TokenHashArgon2id, TokenHashBcrypt, or TokenHashScrypt).
+// The hash is salted and encoded such that it can be used in place of the plain
+// text token when configuring tokens.
+func HashToken(algorithm string, token string) (string, error) {
+	if algorithm == TokenHashBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(token), bcrypt.DefaultCost)
+		if err != nil {
+			return "", errors.Wrap(err, "error hashing token")
+		}
+		return string(hash), nil
+	}
+	salt := make([]byte, tokenHashSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", errors.Wrap(err, "error generating salt")
+	}
+	var params string
+	var key []byte
+	switch algorithm {
+	case TokenHashArgon2id:
+		params = fmt.Sprintf(
+			"v=%d$m=%d,t=%d,p=%d",
+			argon2.Version,
+			argon2idMemory,
+			argon2idTime,
+			argon2idThreads,
+		)
+		key = argon2.IDKey(
+			[]byte(token),
+			salt,
+			argon2idTime,
+			argon2idMemory,
+			argon2idThreads,
+			tokenHashKeyLength,
+		)
+	case TokenHashScrypt:
+		params = fmt.Sprintf("ln=%d,r=%d,p=%d", scryptLogN, scryptR, scryptP)
+		var err error
+		if key, err = scrypt.Key(
+			[]byte(token),
+			salt,
+			1<<scryptLogN,
+			scryptR,
+			scryptP,
+			tokenHashKeyLength,
+		); err != nil {
+			return "", errors.Wrap(err, "error hashing token")
+		}
+	default:
+		return "", errors.Errorf("unsupported hash algorithm %q", algorithm)
+	}
+	return fmt.Sprintf(
+		"$%s$%s$%s$%s",
+		algorithm,
+		params,
+		tokenHashEncoding.EncodeToString(salt),
+		tokenHashEncoding.EncodeToString(key),
+	), nil
+}
+
+// tokenVerifier is an interface for components that can verify whether a plain
+// text token matches a hash computed using a slow, salted key derivation
+// function.
+type tokenVerifier interface {
+	verify(token string) bool
+}
+
+// newTokenVerifier parses the provided value as a token hash. If the value
+// doesn't begin with the prefix of a supported algorithm, it isn't a hash, so
+// nil and false are returned. This is so that plain text tokens that merely
+// look like hashes continue to work. If the value begins with the prefix of a
+// supported algorithm but cannot be parsed, an error is returned.
+func newTokenVerifier(value string) (tokenVerifier, bool, error) {
+	switch {
+	case strings.HasPrefix(value, "$2a$"),
+		strings.HasPrefix(value, "$2b$"),
+		strings.HasPrefix(value, "$2y$"):
+		if _, err := bcrypt.Cost([]byte(value)); err != nil {
+			return nil, true, errors.Wrap(err, "invalid bcrypt hash")
+		}
+		return bcryptVerifier(value), true, nil
+	case strings.HasPrefix(value, "$"+TokenHashArgon2id+"$"):
+		verifier, err := newArgon2idVerifier(value)
+		return verifier, true, err
+	case strings.HasPrefix(value, "$"+TokenHashScrypt+"$"):
+		verifier, err := newScryptVerifier(value)
+		return verifier, true, err
+	case strings.HasPrefix(value, "$"+TokenHashSHA256+"$"):
+		hash, err := hex.DecodeString(
+			strings.TrimPrefix(value, "$"+TokenHashSHA256+"$"),
+		)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, true, errors.New("invalid sha256 hash")
+		}
+		return sha256Verifier(hash), true, nil
+	}
+	return nil, false, nil
+}
+
+// bcryptVerifier verifies tokens against a bcrypt hash.
+type bcryptVerifier []byte
+
+func (b bcryptVerifier) verify(token string) bool {
+	return bcrypt.CompareHashAndPassword(b, []byte(token)) == nil
+}
+
+// sha256Verifier verifies tokens against an unsalted SHA-256 hash.
+type sha256Verifier []byte
+
+func (s sha256Verifier) verify(token string) bool {
+	hash := sha256.Sum256([]byte(token))
+	return subtle.ConstantTimeCompare(hash[:], s) == 1
+}
+
+// argon2idVerifier verifies tokens against an argon2id hash.
+type argon2idVerifier struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func newArgon2idVerifier(value string) (*argon2idVerifier, error) {
+	// The value is split into "", "argon2id", version, parameters, salt, and key
+	parts := strings.Split(value, "$")
+	if len(parts) != 6 {
+		return nil, errors.New("invalid argon2id hash: wrong number of fields")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, errors.Wrap(err, "invalid argon2id hash: error parsing version")
+	}
+	if version != argon2.Version {
+		return nil, errors.Errorf(
+			"invalid argon2id hash: unsupported version %d",
+			version,
+		)
+	}
+	a := &argon2idVerifier{}
+	if _, err := fmt.Sscanf(
+		parts[3],
+		"m=%d,t=%d,p=%d",
+		&a.memory,
+		&a.time,
+		&a.threads,
+	); err != nil {
+		return nil,
+			errors.Wrap(err, "invalid argon2id hash: error parsing parameters")
+	}
+	if a.time == 0 || a.threads == 0 {
+		return nil, errors.New("invalid argon2id hash: invalid parameters")
+	}
+	var err error
+	if a.salt, a.key, err = decodeSaltAndKey(parts[4], parts[5]); err != nil {
+		return nil, errors.Wrap(err, "invalid argon2id hash")
+	}
+	return a, nil
+}
+
+func (a *argon2idVerifier) verify(token string) bool {
+	key := argon2.IDKey(
+		[]byte(token),
+		a.salt,
+		a.time,
+		a.memory,
+		a.threads,
+		uint32(len(a.key)),
+	)
+	return subtle.ConstantTimeCompare(key, a.key) == 1
+}
+
+// scryptVerifier verifies tokens against an scrypt hash.
+type scryptVerifier struct {
+	logN uint8
+	r    int
+	p    int
+	salt []byte
+	key  []byte
+}
+
+func newScryptVerifier(value string) (*scryptVerifier, error) {
+	// The value is split into "", "scrypt", parameters, salt, and key
+	parts := strings.Split(value, "$")
+	if len(parts) != 5 {
+		return nil, errors.New("invalid scrypt hash: wrong number of fields")
+	}
+	s := &scryptVerifier{}
+	if _, err := fmt.Sscanf(
+		parts[2],
+		"ln=%d,r=%d,p=%d",
+		&s.logN,
+		&s.r,
+		&s.p,
+	); err != nil {
+		return nil,
+			errors.Wrap(err, "invalid scrypt hash: error parsing parameters")
+	}
+	var err error
+	if s.salt, s.key, err = decodeSaltAndKey(parts[3], parts[4]); err != nil {
+		return nil, errors.Wrap(err, "invalid scrypt hash")
+	}
+	// These are the same constraints scrypt.Key places on its parameters
+	if s.logN == 0 || s.logN > 62 || s.r <= 0 || s.p <= 0 ||
+		uint64(s.r)*uint64(s.p) >= 1<<30 {
+		return nil, errors.New("invalid scrypt hash: invalid parameters")
+	}
+	return s, nil
+}
+
+func (s *scryptVerifier) verify(token string) bool {
+	key, err := scrypt.Key([]byte(token), s.salt, 1<<s.logN, s.r, s.p, len(s.key))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(key, s.key) == 1
+}
+
+// decodeSaltAndKey decodes the salt and key fields of a token hash.
+func decodeSaltAndKey(encodedSalt, encodedKey string) ([]byte, []byte, error) {
+	salt, err := tokenHashEncoding.DecodeString(encodedSalt)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error decoding salt")
+	}
+	key, err := tokenHashEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error decoding hash")
+	}
+	if len(key) == 0 {
+		return nil, nil, errors.New("hash is empty")
+	}
+	return salt, key, nil
+}
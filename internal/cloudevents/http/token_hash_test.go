@@ -0,0 +1,182 @@
+package http
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashToken(t *testing.T) {
+	testCases := []struct {
+		algorithm  string
+		assertions func(hash string, err error)
+	}{
+		{
+			algorithm: "md5",
+			assertions: func(_ string, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `unsupported hash algorithm "md5"`)
+			},
+		},
+		{
+			algorithm: TokenHashArgon2id,
+			assertions: func(hash string, err error) {
+				require.NoError(t, err)
+				require.True(t, strings.HasPrefix(hash, "$argon2id$v=19$"))
+			},
+		},
+		{
+			algorithm: TokenHashBcrypt,
+			assertions: func(hash string, err error) {
+				require.NoError(t, err)
+				require.True(t, strings.HasPrefix(hash, "$2a$"))
+			},
+		},
+		{
+			algorithm: TokenHashScrypt,
+			assertions: func(hash string, err error) {
+				require.NoError(t, err)
+				require.True(t, strings.HasPrefix(hash, "$scrypt$ln=15,r=8,p=1$"))
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.algorithm, func(t *testing.T) {
+			hash, err := HashToken(testCase.algorithm, "foo")
+			testCase.assertions(hash, err)
+			if err != nil {
+				return
+			}
+			// Hashes are salted
+			otherHash, err := HashToken(testCase.algorithm, "foo")
+			require.NoError(t, err)
+			require.NotEqual(t, hash, otherHash)
+			// Hashes can be verified
+			verifier, isHash, err := newTokenVerifier(hash)
+			require.NoError(t, err)
+			require.True(t, isHash)
+			require.True(t, verifier.verify("foo"))
+			require.False(t, verifier.verify("bar"))
+		})
+	}
+}
+
+func TestNewTokenVerifier(t *testing.T) {
+	testCases := []struct {
+		name       string
+		value      string
+		assertions func(verifier tokenVerifier, isHash bool, err error)
+	}{
+		{
+			name:  "plain text",
+			value: "foo$bar",
+			assertions: func(verifier tokenVerifier, isHash bool, err error) {
+				require.NoError(t, err)
+				require.False(t, isHash)
+				require.Nil(t, verifier)
+			},
+		},
+		{
+			name:  "plain text that looks like a hash",
+			value: "$argon2i$v=19$m=19456,t=2,p=1$c2FsdA$a2V5",
+			assertions: func(verifier tokenVerifier, isHash bool, err error) {
+				require.NoError(t, err)
+				require.False(t, isHash)
+				require.Nil(t, verifier)
+			},
+		},
+		{
+			name:  "invalid bcrypt hash",
+			value: "$2a$foo",
+			assertions: func(_ tokenVerifier, isHash bool, err error) {
+				require.True(t, isHash)
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "invalid bcrypt hash")
+			},
+		},
+		{
+			name:  "invalid sha256 hash",
+			value: "$sha256$abcd",
+			assertions: func(_ tokenVerifier, isHash bool, err error) {
+				require.True(t, isHash)
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "invalid sha256 hash")
+			},
+		},
+		{
+			name: "sha256 hash",
+			// SHA-256 hash of "foo"
+			value: "$sha256$" +
+				"2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+			assertions: func(verifier tokenVerifier, isHash bool, err error) {
+				require.NoError(t, err)
+				require.True(t, isHash)
+				require.True(t, verifier.verify("foo"))
+				require.False(t, verifier.verify("bar"))
+			},
+		},
+		{
+			name:  "argon2id hash with wrong number of fields",
+			value: "$argon2id$v=19$m=19456,t=2,p=1$c2FsdA",
+			assertions: func(_ tokenVerifier, _ bool, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "wrong number of fields")
+			},
+		},
+		{
+			name:  "argon2id hash with unsupported version",
+			value: "$argon2id$v=16$m=19456,t=2,p=1$c2FsdA$a2V5",
+			assertions: func(_ tokenVerifier, _ bool, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "unsupported version 16")
+			},
+		},
+		{
+			name:  "argon2id hash with invalid parameters",
+			value: "$argon2id$v=19$m=19456,t=0,p=1$c2FsdA$a2V5",
+			assertions: func(_ tokenVerifier, _ bool, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "invalid parameters")
+			},
+		},
+		{
+			name:  "argon2id hash with invalid salt",
+			value: "$argon2id$v=19$m=19456,t=2,p=1$!!!$a2V5",
+			assertions: func(_ tokenVerifier, _ bool, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "error decoding salt")
+			},
+		},
+		{
+			name:  "scrypt hash with unparseable parameters",
+			value: "$scrypt$n=15,r=8,p=1$c2FsdA$a2V5",
+			assertions: func(_ tokenVerifier, _ bool, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "error parsing parameters")
+			},
+		},
+		{
+			name:  "scrypt hash with invalid parameters",
+			value: "$scrypt$ln=0,r=8,p=1$c2FsdA$a2V5",
+			assertions: func(_ tokenVerifier, _ bool, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "invalid parameters")
+			},
+		},
+		{
+			name:  "scrypt hash with empty hash",
+			value: "$scrypt$ln=15,r=8,p=1$c2FsdA$",
+			assertions: func(_ tokenVerifier, _ bool, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "hash is empty")
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			verifier, isHash, err := newTokenVerifier(testCase.value)
+			testCase.assertions(verifier, isHash, err)
+		})
+	}
+}
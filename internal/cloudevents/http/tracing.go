@@ -0,0 +1,66 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer(
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/http", // nolint: lll
+)
+
+// requestURIContextKey is the key under which the URL of a request whose
+// access token was redacted for tracing purposes is stored in its context.
+type requestURIContextKey struct{}
+
+// requestURI is the URL of a request whose access token was redacted for
+// tracing purposes.
+type requestURI struct {
+	url        *url.URL
+	requestURI string
+}
+
+// NewTracingHandler returns an http.Handler that starts a span with the
+// provided operation name for every request before handling it using the
+// provided http.Handler. Because the span records the request's URL, any
+// access token in the URL's query is redacted from the request the span is
+// started for. The provided http.Handler receives the request with its
+// original URL.
+func NewTracingHandler(handler http.Handler, operation string) http.Handler {
+	traced := otelhttp.NewHandler(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if original, ok :=
+				r.Context().Value(requestURIContextKey{}).(requestURI); ok {
+				r = r.WithContext(r.Context())
+				r.URL = original.url
+				r.RequestURI = original.requestURI
+			}
+			handler.ServeHTTP(w, r)
+		}),
+		operation,
+	)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if !query.Has(accessTokenQueryParam) {
+			traced.ServeHTTP(w, r)
+			return
+		}
+		query.Del(accessTokenQueryParam)
+		redactedURL := *r.URL
+		redactedURL.RawQuery = query.Encode()
+		redacted := r.WithContext(
+			context.WithValue(
+				r.Context(),
+				requestURIContextKey{},
+				requestURI{url: r.URL, requestURI: r.RequestURI},
+			),
+		)
+		redacted.URL = &redactedURL
+		redacted.RequestURI = redactedURL.RequestURI()
+		traced.ServeHTTP(w, redacted)
+	})
+}
@@ -0,0 +1,95 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdkTrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracingHandler(t *testing.T) {
+	const testToken = "MySharedSecret"
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(
+		sdkTrace.NewTracerProvider(sdkTrace.WithSpanProcessor(recorder)),
+	)
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	testCases := []struct {
+		name       string
+		target     string
+		assertions func(handled *http.Request, span sdkTrace.ReadOnlySpan)
+	}{
+		{
+			name:   "request without access token",
+			target: "/events?foo=bar",
+			assertions: func(handled *http.Request, span sdkTrace.ReadOnlySpan) {
+				require.Equal(t, "/events?foo=bar", handled.RequestURI)
+				require.Equal(
+					t,
+					span.SpanContext(),
+					trace.SpanContextFromContext(handled.Context()),
+				)
+			},
+		},
+		{
+			name:   "request with access token",
+			target: "/events?access_token=" + testToken + "&foo=bar",
+			assertions: func(handled *http.Request, span sdkTrace.ReadOnlySpan) {
+				// The handler receives the original request...
+				require.Equal(
+					t,
+					"/events?access_token="+testToken+"&foo=bar",
+					handled.RequestURI,
+				)
+				require.Equal(
+					t,
+					testToken,
+					handled.URL.Query().Get(accessTokenQueryParam),
+				)
+				require.Equal(
+					t,
+					span.SpanContext(),
+					trace.SpanContextFromContext(handled.Context()),
+				)
+				// ...but the span doesn't record the token
+				var target string
+				for _, attr := range span.Attributes() {
+					require.NotContains(t, attr.Value.Emit(), testToken)
+					if attr.Key == "http.target" {
+						target = attr.Value.AsString()
+					}
+				}
+				require.Equal(t, "/events?foo=bar", target)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var handled *http.Request
+			handler := NewTracingHandler(
+				http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+					handled = r
+				}),
+				"POST /events",
+			)
+			handler.ServeHTTP(
+				httptest.NewRecorder(),
+				httptest.NewRequest(
+					http.MethodPost,
+					testCase.target,
+					strings.NewReader(""),
+				),
+			)
+			spans := recorder.Ended()
+			span := spans[len(spans)-1]
+			require.Equal(t, "POST /events", span.Name())
+			testCase.assertions(handled, span)
+		})
+	}
+}
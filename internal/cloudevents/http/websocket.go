@@ -0,0 +1,257 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	webSocketAckType  = "ack"
+	webSocketNackType = "nack"
+)
+
+// WebSocketHandlerConfig encapsulates configuration for the WebSocket handler.
+type WebSocketHandlerConfig struct {
+	// IdleTimeout is the maximum length of time a connection may remain open
+	// without receiving any message before the gateway closes it.
+	IdleTimeout time.Duration
+	// MaxInFlight is the maximum number of CloudEvents received over a single
+	// connection that may be handled concurrently. When this limit is reached,
+	// the gateway stops reading from the connection until an in-flight event has
+	// been handled, thereby applying backpressure to the producer.
+	MaxInFlight int
+	// MaxMessageBytes is the maximum size, in bytes, of a single message.
+	// Connections over which a larger message is received are closed.
+	MaxMessageBytes int64
+	// RateLimitFilter, if non-nil, applies rate limits to every CloudEvent
+	// received. CloudEvents exceeding a limit are nacked.
+	RateLimitFilter RateLimitFilter
+}
+
+// webSocketAck is a message sent to the producer for every message received
+// over a WebSocket connection to indicate whether the CloudEvent it contained
+// was handled successfully (ack) or not (nack).
+type webSocketAck struct {
+	// Type is either "ack" or "nack".
+	Type string `json:"type"`
+	// ID is the id of the CloudEvent being acknowledged. This may be empty if a
+	// message could not be parsed as a CloudEvent at all.
+	ID string `json:"id,omitempty"`
+	// Error describes why a CloudEvent was not handled successfully.
+	Error string `json:"error,omitempty"`
+}
+
+type webSocketHandler struct {
+	ctx      context.Context
+	config   WebSocketHandlerConfig
+	service  cloudevents.Service
+	upgrader websocket.Upgrader
+}
+
+// NewWebSocketHandler returns an http.Handler that upgrades requests to
+// WebSocket connections over which producers may send a stream of structured
+// mode CloudEvents. An ack or nack message is sent for every message received.
+// If the configuration includes a RateLimitFilter, it is applied to every
+// CloudEvent received.
+// When the provided context is canceled, the handler stops reading new
+// messages from all open connections, waits for in-flight events to be
+// handled, and closes the connections.
+func NewWebSocketHandler(
+	ctx context.Context,
+	service cloudevents.Service,
+	config WebSocketHandlerConfig,
+) http.Handler {
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = 5 * time.Minute
+	}
+	if config.MaxInFlight <= 0 {
+		config.MaxInFlight = 1
+	}
+	return &webSocketHandler{
+		ctx:     ctx,
+		config:  config,
+		service: service,
+		upgrader: websocket.Upgrader{
+			// Producers are not expected to be browsers and every connection must be
+			// authenticated with a token, so there is no need to restrict origins.
+			CheckOrigin: func(*http.Request) bool { return true },
+		},
+	}
+}
+
+func (h *webSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already responded to the client with an error.
+		return
+	}
+	logger := logging.FromContext(r.Context())
+	// Handling of an event that has already been received is never interrupted,
+	// even if the gateway is shutting down, so events are handled using a
+	// context that is never canceled, but that still carries the logger, audit
+	// details, and span for the connection.
+	handleCtx := logging.ContextWithLogger(context.Background(), logger)
+	handleCtx =
+		trace.ContextWithSpan(handleCtx, trace.SpanFromContext(r.Context()))
+	auditReq := audit.RequestFromContext(r.Context())
+	auditReq.Transport = audit.TransportWebSocket
+	handleCtx = audit.ContextWithRequest(handleCtx, auditReq)
+	defer conn.Close()
+	if h.config.MaxMessageBytes > 0 {
+		conn.SetReadLimit(h.config.MaxMessageBytes)
+	}
+
+	var writeMu sync.Mutex // gorilla/websocket supports only one writer at a time
+	writeJSON := func(v interface{}) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if err := conn.WriteJSON(v); err != nil {
+			logger.Error("error writing to WebSocket connection", zap.Error(err))
+		}
+	}
+	writeClose := func(code int, text string) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.WriteControl( // nolint: errcheck
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(code, text),
+			time.Now().Add(5*time.Second),
+		)
+	}
+
+	// When the gateway is shutting down, stop reading new messages by forcing
+	// the blocking read below to return immediately. deadlineMu guards against
+	// the read loop extending the deadline after that has happened.
+	var deadlineMu sync.Mutex
+	var shuttingDown bool
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	go func() {
+		select {
+		case <-h.ctx.Done():
+			deadlineMu.Lock()
+			defer deadlineMu.Unlock()
+			shuttingDown = true
+			conn.SetReadDeadline(time.Now()) // nolint: errcheck
+		case <-doneCh:
+		}
+	}()
+
+	inFlightCh := make(chan struct{}, h.config.MaxInFlight)
+	var inFlightWG sync.WaitGroup
+	closeCode := websocket.CloseNormalClosure
+	closeText := ""
+	for {
+		deadlineMu.Lock()
+		if !shuttingDown {
+			conn.SetReadDeadline( // nolint: errcheck
+				time.Now().Add(h.config.IdleTimeout),
+			)
+		}
+		deadlineMu.Unlock()
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			if h.ctx.Err() != nil {
+				closeCode = websocket.CloseGoingAway
+				closeText = "gateway is shutting down"
+			} else if netErr, ok := err.(interface{ Timeout() bool }); ok &&
+				netErr.Timeout() {
+				closeText = "idle timeout"
+			} else if websocket.IsUnexpectedCloseError(
+				err,
+				websocket.CloseNormalClosure,
+				websocket.CloseGoingAway,
+			) {
+				logger.Error("error reading from WebSocket connection", zap.Error(err))
+			}
+			break
+		}
+		// This blocks if MaxInFlight events are already being handled. Since we
+		// won't read the next message until this unblocks, this applies
+		// backpressure to the producer.
+		inFlightCh <- struct{}{}
+		inFlightWG.Add(1)
+		go func() {
+			defer inFlightWG.Done()
+			defer func() { <-inFlightCh }()
+			writeJSON(h.handleMessage(handleCtx, msg))
+		}()
+	}
+	// Wait for all in-flight events to be handled and acknowledged before
+	// closing the connection.
+	inFlightWG.Wait()
+	writeClose(closeCode, closeText)
+}
+
+// handleMessage unmarshals a structured mode CloudEvent from the provided
+// message, hands it off to the service, and returns an appropriate ack or
+// nack.
+func (h *webSocketHandler) handleMessage(
+	ctx context.Context,
+	msg []byte,
+) webSocketAck {
+	event := cloudEvents.NewEvent()
+	if err := json.Unmarshal(msg, &event); err != nil {
+		return webSocketAck{
+			Type:  webSocketNackType,
+			Error: "message is not a valid structured mode CloudEvent",
+		}
+	}
+	if err := event.Validate(); err != nil {
+		return webSocketAck{
+			Type:  webSocketNackType,
+			ID:    event.ID(),
+			Error: err.Error(),
+		}
+	}
+	if h.config.RateLimitFilter != nil {
+		if allowed, retryAfter :=
+			h.config.RateLimitFilter.AllowEvent(ctx, event.Source()); !allowed {
+			return webSocketAck{
+				Type:  webSocketNackType,
+				ID:    event.ID(),
+				Error: fmt.Sprintf("rate limit exceeded; retry after %s", retryAfter),
+			}
+		}
+	}
+	if err := h.service.Handle(ctx, event); err != nil {
+		if errors.Is(err, cloudevents.ErrSaturated) {
+			return webSocketAck{
+				Type:  webSocketNackType,
+				ID:    event.ID(),
+				Error: cloudevents.ErrSaturated.Error(),
+			}
+		}
+		var attrErr *cloudevents.AttributeError
+		if errors.As(err, &attrErr) {
+			return webSocketAck{
+				Type:  webSocketNackType,
+				ID:    event.ID(),
+				Error: attrErr.Error(),
+			}
+		}
+		// The service has already logged the details.
+		return webSocketAck{
+			Type:  webSocketNackType,
+			ID:    event.ID(),
+			Error: "error handling CloudEvent",
+		}
+	}
+	return webSocketAck{
+		Type: webSocketAckType,
+		ID:   event.ID(),
+	}
+}
@@ -0,0 +1,331 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/ratelimit"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdkTrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type mockService struct {
+	HandleFn          func(context.Context, cloudEvents.Event) error
+	HandleWithReplyFn func(
+		context.Context,
+		cloudEvents.Event,
+	) (*cloudEvents.Event, error)
+}
+
+func (m *mockService) Handle(
+	ctx context.Context,
+	event cloudEvents.Event,
+) error {
+	return m.HandleFn(ctx, event)
+}
+
+func (m *mockService) HandleWithReply(
+	ctx context.Context,
+	event cloudEvents.Event,
+) (*cloudEvents.Event, error) {
+	return m.HandleWithReplyFn(ctx, event)
+}
+
+func TestNewWebSocketHandler(t *testing.T) {
+	testService := &mockService{}
+	h, ok := NewWebSocketHandler(
+		context.Background(),
+		testService,
+		WebSocketHandlerConfig{},
+	).(*webSocketHandler)
+	require.True(t, ok)
+	require.Same(t, testService, h.service)
+	// Check defaults
+	require.Equal(t, 5*time.Minute, h.config.IdleTimeout)
+	require.Equal(t, 1, h.config.MaxInFlight)
+}
+
+func TestWebSocketHandler(t *testing.T) {
+	testCases := []struct {
+		name       string
+		msg        string
+		handleErr  error
+		assertions func(webSocketAck)
+	}{
+		{
+			name: "message is not json",
+			msg:  "this is not json",
+			assertions: func(ack webSocketAck) {
+				require.Equal(t, webSocketNackType, ack.Type)
+				require.Empty(t, ack.ID)
+				require.Contains(t, ack.Error, "not a valid structured mode CloudEvent")
+			},
+		},
+		{
+			name: "invalid CloudEvent",
+			msg:  `{"specversion":"1.0","id":"1234"}`,
+			assertions: func(ack webSocketAck) {
+				require.Equal(t, webSocketNackType, ack.Type)
+				require.Equal(t, "1234", ack.ID)
+				require.NotEmpty(t, ack.Error)
+			},
+		},
+		{
+			name: "error handling CloudEvent",
+			msg: `{"specversion":"1.0","id":"1234","source":"example/uri",` +
+				`"type":"example.type"}`,
+			handleErr: errors.New("something went wrong"),
+			assertions: func(ack webSocketAck) {
+				require.Equal(t, webSocketNackType, ack.Type)
+				require.Equal(t, "1234", ack.ID)
+				require.Equal(t, "error handling CloudEvent", ack.Error)
+			},
+		},
+		{
+			name: "gateway saturated",
+			msg: `{"specversion":"1.0","id":"1234","source":"example/uri",` +
+				`"type":"example.type"}`,
+			handleErr: fmt.Errorf("wrapped: %w", cloudevents.ErrSaturated),
+			assertions: func(ack webSocketAck) {
+				require.Equal(t, webSocketNackType, ack.Type)
+				require.Equal(t, "1234", ack.ID)
+				require.Equal(t, "gateway is saturated", ack.Error)
+			},
+		},
+		{
+			name: "invalid attribute",
+			msg: `{"specversion":"1.0","id":"1234","source":"example/uri",` +
+				`"type":"example.type"}`,
+			handleErr: &cloudevents.AttributeError{
+				Attribute: "subject",
+				Reason:    "something went wrong",
+			},
+			assertions: func(ack webSocketAck) {
+				require.Equal(t, webSocketNackType, ack.Type)
+				require.Equal(t, "1234", ack.ID)
+				require.Contains(t, ack.Error, `attribute "subject" is invalid`)
+			},
+		},
+		{
+			name: "success",
+			msg: `{"specversion":"1.0","id":"1234","source":"example/uri",` +
+				`"type":"example.type"}`,
+			assertions: func(ack webSocketAck) {
+				require.Equal(t, webSocketAckType, ack.Type)
+				require.Equal(t, "1234", ack.ID)
+				require.Empty(t, ack.Error)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			server := httptest.NewServer(
+				NewWebSocketHandler(
+					context.Background(),
+					&mockService{
+						HandleFn: func(context.Context, cloudEvents.Event) error {
+							return testCase.handleErr
+						},
+					},
+					WebSocketHandlerConfig{},
+				),
+			)
+			defer server.Close()
+			conn, _, err := websocket.DefaultDialer.Dial(
+				"ws"+strings.TrimPrefix(server.URL, "http"),
+				nil,
+			)
+			require.NoError(t, err)
+			defer conn.Close()
+			err = conn.WriteMessage(websocket.TextMessage, []byte(testCase.msg))
+			require.NoError(t, err)
+			ack := webSocketAck{}
+			err = conn.ReadJSON(&ack)
+			require.NoError(t, err)
+			testCase.assertions(ack)
+		})
+	}
+}
+
+func TestWebSocketHandlerRateLimit(t *testing.T) {
+	var handled int
+	server := httptest.NewServer(
+		NewWebSocketHandler(
+			context.Background(),
+			&mockService{
+				HandleFn: func(context.Context, cloudEvents.Event) error {
+					handled++
+					return nil
+				},
+			},
+			WebSocketHandlerConfig{
+				RateLimitFilter: NewRateLimitFilter(
+					RateLimitFilterConfig{
+						Key:         RateLimitKeySource,
+						PerKeyLimit: ratelimit.Limit{Rate: 0.001, Burst: 1},
+					},
+					ratelimit.NewMemoryBackend(),
+					audit.NewNopSink(),
+				),
+			},
+		),
+	)
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial(
+		"ws"+strings.TrimPrefix(server.URL, "http"),
+		nil,
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	// Limits apply to each CloudEvent, not to the connection
+	for _, id := range []string{"1", "2"} {
+		err = conn.WriteMessage(
+			websocket.TextMessage,
+			[]byte(
+				`{"specversion":"1.0","id":"`+id+`","source":"example/uri",`+
+					`"type":"example.type"}`,
+			),
+		)
+		require.NoError(t, err)
+	}
+	ack := webSocketAck{}
+	require.NoError(t, conn.ReadJSON(&ack))
+	require.Equal(t, webSocketAck{Type: webSocketAckType, ID: "1"}, ack)
+	ack = webSocketAck{}
+	require.NoError(t, conn.ReadJSON(&ack))
+	require.Equal(t, webSocketNackType, ack.Type)
+	require.Equal(t, "2", ack.ID)
+	require.Contains(t, ack.Error, "rate limit exceeded")
+	require.Equal(t, 1, handled)
+}
+
+func TestWebSocketHandlerTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(
+		sdkTrace.NewTracerProvider(sdkTrace.WithSpanProcessor(recorder)),
+	)
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	handledCh := make(chan trace.SpanContext, 1)
+	server := httptest.NewServer(
+		NewTracingHandler(
+			NewWebSocketHandler(
+				context.Background(),
+				&mockService{
+					HandleFn: func(ctx context.Context, _ cloudEvents.Event) error {
+						handledCh <- trace.SpanContextFromContext(ctx)
+						return nil
+					},
+				},
+				WebSocketHandlerConfig{},
+			),
+			"GET /ws/events",
+		),
+	)
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial(
+		"ws"+strings.TrimPrefix(server.URL, "http"),
+		nil,
+	)
+	require.NoError(t, err)
+	err = conn.WriteMessage(
+		websocket.TextMessage,
+		[]byte(
+			`{"specversion":"1.0","id":"1234","source":"example/uri",`+
+				`"type":"example.type"}`,
+		),
+	)
+	require.NoError(t, err)
+	ack := webSocketAck{}
+	require.NoError(t, conn.ReadJSON(&ack))
+	require.NoError(t, conn.Close())
+	require.Eventually(
+		t,
+		func() bool { return len(recorder.Ended()) == 1 },
+		time.Second,
+		10*time.Millisecond,
+	)
+	// CloudEvents are handled within the connection's span
+	require.Equal(t, recorder.Ended()[0].SpanContext(), <-handledCh)
+}
+
+func TestWebSocketHandlerIdleTimeout(t *testing.T) {
+	server := httptest.NewServer(
+		NewWebSocketHandler(
+			context.Background(),
+			&mockService{},
+			WebSocketHandlerConfig{
+				IdleTimeout: 100 * time.Millisecond,
+			},
+		),
+	)
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial(
+		"ws"+strings.TrimPrefix(server.URL, "http"),
+		nil,
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, _, err = conn.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure))
+	require.Contains(t, err.Error(), "idle timeout")
+}
+
+func TestWebSocketHandlerShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handlingCh := make(chan struct{})
+	releaseCh := make(chan struct{})
+	server := httptest.NewServer(
+		NewWebSocketHandler(
+			ctx,
+			&mockService{
+				HandleFn: func(context.Context, cloudEvents.Event) error {
+					close(handlingCh)
+					<-releaseCh
+					return nil
+				},
+			},
+			WebSocketHandlerConfig{},
+		),
+	)
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial(
+		"ws"+strings.TrimPrefix(server.URL, "http"),
+		nil,
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	err = conn.WriteMessage(
+		websocket.TextMessage,
+		[]byte(
+			`{"specversion":"1.0","id":"1234","source":"example/uri",`+
+				`"type":"example.type"}`,
+		),
+	)
+	require.NoError(t, err)
+	// Begin shutting down while the event is still being handled
+	<-handlingCh
+	cancel()
+	close(releaseCh)
+	// The in-flight event should still be acknowledged...
+	ack := webSocketAck{}
+	err = conn.ReadJSON(&ack)
+	require.NoError(t, err)
+	require.Equal(t, webSocketAckType, ack.Type)
+	require.Equal(t, "1234", ack.ID)
+	// ...before the connection is closed
+	_, _, err = conn.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway))
+}
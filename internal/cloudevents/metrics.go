@@ -0,0 +1,77 @@
+package cloudevents
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "brigade_cloudevents_gateway"
+
+const (
+	// eventResultHandled indicates a CloudEvent resulted in the creation of at
+	// least one Brigade Event.
+	eventResultHandled = "handled"
+	// eventResultDropped indicates a CloudEvent was accepted by the Brigade API,
+	// but no Brigade Project was subscribed to it, so no Brigade Event was
+	// created.
+	eventResultDropped = "dropped"
+	// eventResultFailed indicates an error occurred handling a CloudEvent.
+	eventResultFailed = "failed"
+	// eventResultShed indicates a CloudEvent was not handled because the gateway
+	// was saturated.
+	eventResultShed = "shed"
+	// eventResultInvalid indicates a CloudEvent was rejected because an
+	// attribute to be promoted had an invalid value.
+	eventResultInvalid = "invalid"
+	// eventResultForbidden indicates a CloudEvent was rejected because its
+	// source was not among those the sender was allowed to send from.
+	eventResultForbidden = "forbidden"
+)
+
+var (
+	eventsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "events_total",
+			Help: "Number of CloudEvents handled, by result (handled, dropped, " +
+				"failed, shed, invalid, or forbidden).",
+		},
+		[]string{"result"},
+	)
+	brigadeAPIRequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "brigade_api_request_duration_seconds",
+			Help: "Latency of requests to the Brigade API, by operation, " +
+				"backend, and whether the request succeeded.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"operation", "backend", "success"},
+	)
+	brigadeAPIInFlight = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "brigade_api_in_flight_requests",
+			Help: "Number of requests to the Brigade API currently in flight and " +
+				"subject to the concurrency limit.",
+		},
+	)
+	brigadeAPIQueued = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "brigade_api_queued_requests",
+			Help: "Number of requests to the Brigade API currently waiting for an " +
+				"in-flight request to complete.",
+		},
+	)
+	brigadeAPIShedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "brigade_api_shed_requests_total",
+			Help: "Number of requests to the Brigade API that were never made " +
+				"because the gateway was saturated, by reason (queue_full or " +
+				"queue_timeout).",
+		},
+		[]string{"reason"},
+	)
+)
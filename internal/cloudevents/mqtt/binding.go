@@ -0,0 +1,80 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"strings"
+
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/binding/spec"
+	cloudEventsEvent "github.com/cloudevents/sdk-go/v2/event"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/pkg/errors"
+)
+
+// eventFromMessage converts an MQTT 5 PUBLISH message to a CloudEvent in
+// accordance with the CloudEvents MQTT protocol binding. If the message's
+// content type is a CloudEvents structured mode media type, the payload is
+// unmarshaled as a structured CloudEvent. Otherwise, the message is treated
+// as a binary mode CloudEvent, with CloudEvent attributes carried in the
+// message's user properties and the payload carrying the event data. User
+// properties that are neither CloudEvent attributes nor valid names for
+// CloudEvent extension attributes are unrelated to the CloudEvent and are
+// ignored.
+//
+// See https://github.com/cloudevents/spec/blob/v1.0/mqtt-protocol-binding.md
+func eventFromMessage(msg *paho.Publish) (cloudEvents.Event, error) {
+	event := cloudEvents.NewEvent()
+	var contentType string
+	var userProps paho.UserProperties
+	if msg.Properties != nil {
+		contentType = msg.Properties.ContentType
+		userProps = msg.Properties.User
+	}
+	if strings.HasPrefix(contentType, cloudEvents.ApplicationCloudEventsJSON) {
+		if err := json.Unmarshal(msg.Payload, &event); err != nil {
+			return event, errors.Wrap(
+				err,
+				"error unmarshaling structured mode CloudEvent",
+			)
+		}
+		return event, event.Validate()
+	}
+	specVersionStr := userProps.Get("specversion")
+	if specVersionStr == "" {
+		return event, errors.New(
+			"message is neither a structured nor a binary mode CloudEvent",
+		)
+	}
+	specVersion := spec.VS.Version(specVersionStr)
+	if specVersion == nil {
+		return event, errors.Errorf(
+			"unsupported CloudEvents spec version %q",
+			specVersionStr,
+		)
+	}
+	event.Context = specVersion.NewContext()
+	for _, prop := range userProps {
+		name := strings.ToLower(prop.Key)
+		if name == "specversion" {
+			continue
+		}
+		if specVersion.Attribute(name) == nil &&
+			!cloudEventsEvent.IsExtensionNameValid(name) {
+			continue
+		}
+		if err :=
+			specVersion.SetAttribute(event.Context, name, prop.Value); err != nil {
+			return event, errors.Wrapf(
+				err,
+				"error setting CloudEvent attribute %q",
+				name,
+			)
+		}
+	}
+	if len(msg.Payload) > 0 {
+		if err := event.SetData(contentType, msg.Payload); err != nil {
+			return event, errors.Wrap(err, "error setting CloudEvent data")
+		}
+	}
+	return event, event.Validate()
+}
@@ -0,0 +1,133 @@
+package mqtt
+
+import (
+	"testing"
+
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventFromMessage(t *testing.T) {
+	testCases := []struct {
+		name       string
+		msg        *paho.Publish
+		assertions func(cloudEvents.Event, error)
+	}{
+		{
+			name: "neither structured nor binary mode",
+			msg: &paho.Publish{
+				Payload: []byte("foo"),
+			},
+			assertions: func(_ cloudEvents.Event, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					"neither a structured nor a binary mode CloudEvent",
+				)
+			},
+		},
+		{
+			name: "structured mode with invalid json",
+			msg: &paho.Publish{
+				Properties: &paho.PublishProperties{
+					ContentType: cloudEvents.ApplicationCloudEventsJSON,
+				},
+				Payload: []byte("this is not json"),
+			},
+			assertions: func(_ cloudEvents.Event, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					"error unmarshaling structured mode CloudEvent",
+				)
+			},
+		},
+		{
+			name: "structured mode success",
+			msg: &paho.Publish{
+				Properties: &paho.PublishProperties{
+					ContentType: cloudEvents.ApplicationCloudEventsJSON,
+				},
+				Payload: []byte(
+					`{"specversion":"1.0","id":"1234","source":"example/uri",` +
+						`"type":"example.type","data":{"foo":"bar"}}`,
+				),
+			},
+			assertions: func(event cloudEvents.Event, err error) {
+				require.NoError(t, err)
+				require.Equal(t, "1234", event.ID())
+				require.Equal(t, "example/uri", event.Source())
+				require.Equal(t, "example.type", event.Type())
+				require.JSONEq(t, `{"foo":"bar"}`, string(event.Data()))
+			},
+		},
+		{
+			name: "binary mode with unsupported spec version",
+			msg: &paho.Publish{
+				Properties: &paho.PublishProperties{
+					User: paho.UserProperties{
+						{Key: "specversion", Value: "42.0"},
+					},
+				},
+			},
+			assertions: func(_ cloudEvents.Event, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "unsupported CloudEvents spec")
+			},
+		},
+		{
+			name: "binary mode missing required attributes",
+			msg: &paho.Publish{
+				Properties: &paho.PublishProperties{
+					User: paho.UserProperties{
+						{Key: "specversion", Value: "1.0"},
+						{Key: "id", Value: "1234"},
+					},
+				},
+			},
+			assertions: func(_ cloudEvents.Event, err error) {
+				require.Error(t, err)
+			},
+		},
+		{
+			name: "binary mode success",
+			msg: &paho.Publish{
+				Properties: &paho.PublishProperties{
+					ContentType: "application/json",
+					User: paho.UserProperties{
+						{Key: "specversion", Value: "1.0"},
+						{Key: "id", Value: "1234"},
+						{Key: "source", Value: "example/uri"},
+						{Key: "type", Value: "example.type"},
+						{Key: "environment", Value: "production"},
+						{Key: "x-device-model", Value: "thermostat"},
+					},
+				},
+				Payload: []byte(`{"foo":"bar"}`),
+			},
+			assertions: func(event cloudEvents.Event, err error) {
+				require.NoError(t, err)
+				require.Equal(t, "1234", event.ID())
+				require.Equal(t, "example/uri", event.Source())
+				require.Equal(t, "example.type", event.Type())
+				require.Equal(t, "application/json", event.DataContentType())
+				require.Equal(
+					t,
+					"production",
+					event.Extensions()["environment"],
+				)
+				require.Len(t, event.Extensions(), 1)
+				require.JSONEq(t, `{"foo":"bar"}`, string(event.Data()))
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			event, err := eventFromMessage(testCase.msg)
+			testCase.assertions(event, err)
+		})
+	}
+}
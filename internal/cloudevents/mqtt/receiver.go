@@ -0,0 +1,333 @@
+package mqtt
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	"github.com/brigadecore/brigade/sdk/v3/meta"
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// initialRetryInterval is how long the receiver waits before handling a
+	// message again after failing to handle it for the first time.
+	initialRetryInterval = time.Second
+	// maxRetryInterval is the longest the receiver waits between successive
+	// attempts to handle a message.
+	maxRetryInterval = time.Minute
+)
+
+// Subscription represents a single MQTT topic filter the gateway should
+// subscribe to, along with the QoS level to subscribe with and the identity
+// that CloudEvents received on matching topics are attributed to.
+type Subscription struct {
+	// TopicFilter is an MQTT topic filter, which may include the + and #
+	// wildcards. Shared subscriptions (e.g. $share/<group>/<filter>) can be used
+	// to balance messages across multiple replicas of the gateway.
+	TopicFilter string `json:"topicFilter"`
+	// QoS is the maximum quality of service level at which the broker should
+	// deliver messages published to matching topics.
+	QoS byte `json:"qos"`
+	// Identity is a recognizable identifier for the devices or other producers
+	// that publish to matching topics. This serves the same purpose for MQTT as
+	// a token does for HTTP. It identifies who sent an event.
+	Identity string `json:"identity"`
+}
+
+// ReceiverConfig encapsulates configuration for the MQTT receiver.
+type ReceiverConfig struct {
+	// BrokerURLs are the URLs of the MQTT broker(s) to connect to. Supported
+	// schemes are mqtt, tcp, ssl, tls, ws, and wss.
+	BrokerURLs []*url.URL
+	// ClientID is the MQTT client identifier the gateway uses when connecting
+	// to the broker.
+	ClientID string
+	// Username is the username the gateway uses to authenticate to the broker.
+	Username string
+	// Password is the password the gateway uses to authenticate to the broker.
+	Password string
+	// Subscriptions are the topic filters the gateway should subscribe to.
+	Subscriptions []Subscription
+	// Workers is the maximum number of messages handled concurrently.
+	Workers int
+	// MaxRetryDuration is how long the gateway keeps trying to handle a message
+	// that failed to be handled for a reason that may be transient before giving
+	// up on it. If zero, such messages are not retried.
+	MaxRetryDuration time.Duration
+}
+
+// Receiver is an interface for components that subscribe to topics on an MQTT
+// broker and handle any CloudEvents published to those topics.
+type Receiver interface {
+	// Run connects to the MQTT broker, subscribes to configured topics, and
+	// handles CloudEvents until the provided context is canceled. The connection
+	// is automatically re-established if it is lost. This function always
+	// returns a non-nil error.
+	Run(context.Context) error
+}
+
+type receiver struct {
+	config  ReceiverConfig
+	service cloudevents.Service
+	// initialRetryInterval and maxRetryInterval bound how long the receiver
+	// waits between attempts to handle a message. They are overridable for
+	// testing purposes.
+	initialRetryInterval time.Duration
+	maxRetryInterval     time.Duration
+	// workers limits the number of messages handled concurrently.
+	workers chan struct{}
+	// handling tracks messages that are being handled.
+	handling sync.WaitGroup
+}
+
+// NewReceiver returns an implementation of the Receiver interface that hands
+// CloudEvents received over MQTT to the provided cloudevents.Service.
+func NewReceiver(
+	config ReceiverConfig,
+	service cloudevents.Service,
+) Receiver {
+	return &receiver{
+		config:               config,
+		service:              service,
+		initialRetryInterval: initialRetryInterval,
+		maxRetryInterval:     maxRetryInterval,
+		workers:              make(chan struct{}, config.Workers),
+	}
+}
+
+func (r *receiver) Run(ctx context.Context) error {
+	logger := logging.FromContext(ctx)
+	// Handling of a message that has already been received is never interrupted,
+	// even if the receiver is stopping, so messages are handled using a context
+	// that is never canceled, but that still carries the logger.
+	handleCtx := logging.ContextWithLogger(context.Background(), logger)
+	clientConfig := autopaho.ClientConfig{
+		BrokerUrls: r.config.BrokerURLs,
+		KeepAlive:  30,
+		OnConnectionUp: func(
+			cm *autopaho.ConnectionManager,
+			_ *paho.Connack,
+		) {
+			r.subscribe(ctx, cm)
+		},
+		OnConnectError: func(err error) {
+			logger.Error("error connecting to MQTT broker", zap.Error(err))
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID: r.config.ClientID,
+			Router: paho.NewSingleHandlerRouter(
+				func(msg *paho.Publish) {
+					r.dispatch(handleCtx, ctx.Done(), msg)
+				},
+			),
+			OnClientError: func(err error) {
+				logger.Error("MQTT client error", zap.Error(err))
+			},
+		},
+	}
+	clientConfig.SetUsernamePassword(
+		r.config.Username,
+		[]byte(r.config.Password),
+	)
+	cm, err := autopaho.NewConnection(ctx, clientConfig)
+	if err != nil {
+		return err
+	}
+	<-cm.Done()
+	r.handling.Wait()
+	return ctx.Err()
+}
+
+// dispatch hands the provided message off to a worker to be handled. The paho
+// client routes every message it receives on the same goroutine, so messages
+// are handled by workers that don't block it. If every worker is busy, this
+// function blocks until one is free.
+func (r *receiver) dispatch(
+	ctx context.Context,
+	stopCh <-chan struct{},
+	msg *paho.Publish,
+) {
+	r.workers <- struct{}{}
+	r.handling.Add(1)
+	go func() {
+		defer func() {
+			<-r.workers
+			r.handling.Done()
+		}()
+		r.handleMessage(ctx, stopCh, msg)
+	}()
+}
+
+// subscribe subscribes to all configured topic filters. It is called every time
+// a connection to the broker is (re-)established.
+func (r *receiver) subscribe(
+	ctx context.Context,
+	cm *autopaho.ConnectionManager,
+) {
+	subscriptions := map[string]paho.SubscribeOptions{}
+	for _, sub := range r.config.Subscriptions {
+		subscriptions[sub.TopicFilter] = paho.SubscribeOptions{QoS: sub.QoS}
+	}
+	if _, err := cm.Subscribe(
+		ctx,
+		&paho.Subscribe{Subscriptions: subscriptions},
+	); err != nil {
+		logging.FromContext(ctx).Error(
+			"error subscribing to MQTT topics",
+			zap.Error(err),
+		)
+	}
+}
+
+// handleMessage converts a message received from the broker to a CloudEvent
+// and hands it off to the service. Messages published to topics that do not
+// match any configured subscription are dropped, as are messages that are not
+// valid CloudEvents. The paho client acknowledges a message received with QoS
+// 1 or 2 as soon as it has been dispatched and, because the client always
+// starts a clean session, the broker never redelivers a message after that
+// or after the connection is lost. So rather than give up on a message that
+// failed to be handled for a reason that may be transient (e.g. the gateway
+// being saturated or the Brigade API being unavailable), this function tries
+// again, with exponential backoff, until the message is handled, fails for a
+// reason that won't change by trying again, the configured MaxRetryDuration
+// would be exceeded, or the provided channel is closed because the receiver is
+// stopping.
+func (r *receiver) handleMessage(
+	ctx context.Context,
+	stopCh <-chan struct{},
+	msg *paho.Publish,
+) {
+	// There is no request per se, but every message is assigned an ID so that
+	// all messages logged and audit records written in the course of handling
+	// it can be correlated.
+	requestID := uuid.New().String()
+	ctx = logging.ContextWithFields(
+		ctx,
+		logging.RequestID(requestID),
+		zap.String("topic", msg.Topic),
+	)
+	sub, ok := r.subscriptionFor(msg.Topic)
+	if !ok {
+		logging.FromContext(ctx).Warn(
+			"dropping MQTT message received on unrecognized topic",
+		)
+		return
+	}
+	ctx = logging.ContextWithFields(ctx, logging.Sender(sub.Identity))
+	ctx = audit.ContextWithRequest(
+		ctx,
+		audit.Request{
+			ID:         requestID,
+			Transport:  audit.TransportMQTT,
+			Sender:     sub.Identity,
+			AuthMethod: audit.AuthMethodSubscription,
+		},
+	)
+	event, err := eventFromMessage(msg)
+	if err != nil {
+		logging.FromContext(ctx).Warn(
+			"dropping invalid CloudEvent received over MQTT",
+			zap.Error(err),
+		)
+		return
+	}
+	retryDeadline := time.Now().Add(r.config.MaxRetryDuration)
+	retryInterval := r.initialRetryInterval
+	for {
+		// The service logs its own errors, so there is nothing more to do with
+		// them here besides deciding whether to try again.
+		if err = r.service.Handle(ctx, event); err == nil || !isTransient(err) {
+			return
+		}
+		if time.Now().Add(retryInterval).After(retryDeadline) {
+			logging.FromContext(ctx).Error(
+				"giving up on handling CloudEvent received over MQTT",
+				zap.Duration("maxRetryDuration", r.config.MaxRetryDuration),
+			)
+			return
+		}
+		logging.FromContext(ctx).Warn(
+			"will retry handling CloudEvent received over MQTT",
+			zap.Duration("retryInterval", retryInterval),
+		)
+		select {
+		case <-time.After(retryInterval):
+		case <-stopCh:
+			logging.FromContext(ctx).Error(
+				"receiver stopped before CloudEvent received over MQTT was handled",
+			)
+			return
+		}
+		if retryInterval *= 2; retryInterval > r.maxRetryInterval {
+			retryInterval = r.maxRetryInterval
+		}
+	}
+}
+
+// isTransient returns a bool indicating whether the provided error, returned
+// from cloudevents.Service.Handle, is one that might not occur if handling
+// the same CloudEvent were attempted again. Errors caused by the CloudEvent
+// itself or by the Brigade API refusing the request are not transient.
+func isTransient(err error) bool {
+	var attrErr *cloudevents.AttributeError
+	var authnErr *meta.ErrAuthentication
+	var authzErr *meta.ErrAuthorization
+	var badReqErr *meta.ErrBadRequest
+	var notFoundErr *meta.ErrNotFound
+	var conflictErr *meta.ErrConflict
+	var notSupportedErr *meta.ErrNotSupported
+	return !errors.As(err, &attrErr) &&
+		!errors.Is(err, cloudevents.ErrSourceNotAllowed) &&
+		!errors.As(err, &authnErr) &&
+		!errors.As(err, &authzErr) &&
+		!errors.As(err, &badReqErr) &&
+		!errors.As(err, &notFoundErr) &&
+		!errors.As(err, &conflictErr) &&
+		!errors.As(err, &notSupportedErr)
+}
+
+// subscriptionFor returns the first configured Subscription whose topic filter
+// matches the provided topic.
+func (r *receiver) subscriptionFor(topic string) (Subscription, bool) {
+	for _, sub := range r.config.Subscriptions {
+		if topicMatches(sub.TopicFilter, topic) {
+			return sub, true
+		}
+	}
+	return Subscription{}, false
+}
+
+// topicMatches returns a bool indicating whether the provided topic matches
+// the provided MQTT topic filter. Shared subscription prefixes are ignored.
+func topicMatches(filter string, topic string) bool {
+	if strings.HasPrefix(filter, "$share/") {
+		// Strip $share/<group name>/
+		if parts := strings.SplitN(filter, "/", 3); len(parts) == 3 {
+			filter = parts[2]
+		}
+	}
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, filterLevel := range filterLevels {
+		if filterLevel == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if filterLevel != "+" && filterLevel != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
@@ -0,0 +1,279 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents"
+	"github.com/brigadecore/brigade/sdk/v3/meta"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/stretchr/testify/require"
+)
+
+type mockService struct {
+	HandleFn          func(context.Context, cloudEvents.Event) error
+	HandleWithReplyFn func(
+		context.Context,
+		cloudEvents.Event,
+	) (*cloudEvents.Event, error)
+}
+
+func (m *mockService) Handle(
+	ctx context.Context,
+	event cloudEvents.Event,
+) error {
+	return m.HandleFn(ctx, event)
+}
+
+func (m *mockService) HandleWithReply(
+	ctx context.Context,
+	event cloudEvents.Event,
+) (*cloudEvents.Event, error) {
+	return m.HandleWithReplyFn(ctx, event)
+}
+
+func TestNewReceiver(t *testing.T) {
+	testConfig := ReceiverConfig{
+		ClientID: "foo",
+		Workers:  2,
+	}
+	testService := &mockService{}
+	r, ok := NewReceiver(testConfig, testService).(*receiver)
+	require.True(t, ok)
+	require.Equal(t, testConfig, r.config)
+	require.Same(t, testService, r.service)
+	require.Equal(t, initialRetryInterval, r.initialRetryInterval)
+	require.Equal(t, maxRetryInterval, r.maxRetryInterval)
+	require.Equal(t, 2, cap(r.workers))
+}
+
+func TestReceiverHandleMessage(t *testing.T) {
+	testConfig := ReceiverConfig{
+		Subscriptions: []Subscription{
+			{
+				TopicFilter: "devices/+/events",
+				QoS:         1,
+				Identity:    "fleet",
+			},
+		},
+		MaxRetryDuration: time.Hour,
+	}
+	testMsgProps := &paho.PublishProperties{
+		User: paho.UserProperties{
+			{Key: "specversion", Value: "1.0"},
+			{Key: "id", Value: "1234"},
+			{Key: "source", Value: "example/uri"},
+			{Key: "type", Value: "example.type"},
+		},
+	}
+	testCases := []struct {
+		name          string
+		msg           *paho.Publish
+		handleErrs    []error
+		stopped       bool
+		noRetries     bool
+		expectHandled bool
+		expectCalls   int
+	}{
+		{
+			name: "unrecognized topic",
+			msg: &paho.Publish{
+				Topic:      "somewhere/else",
+				Properties: testMsgProps,
+			},
+			expectHandled: false,
+		},
+		{
+			name: "invalid CloudEvent",
+			msg: &paho.Publish{
+				Topic: "devices/foo/events",
+			},
+			expectHandled: false,
+		},
+		{
+			name: "success",
+			msg: &paho.Publish{
+				Topic:      "devices/foo/events",
+				Properties: testMsgProps,
+			},
+			expectHandled: true,
+			expectCalls:   1,
+		},
+		{
+			name: "success after transient errors",
+			msg: &paho.Publish{
+				Topic:      "devices/foo/events",
+				Properties: testMsgProps,
+			},
+			handleErrs: []error{
+				cloudevents.ErrSaturated,
+				&meta.ErrInternalServer{},
+			},
+			expectHandled: true,
+			expectCalls:   3,
+		},
+		{
+			name: "permanent error",
+			msg: &paho.Publish{
+				Topic:      "devices/foo/events",
+				Properties: testMsgProps,
+			},
+			handleErrs:    []error{&meta.ErrBadRequest{}},
+			expectHandled: false,
+			expectCalls:   1,
+		},
+		{
+			name: "retries exhausted",
+			msg: &paho.Publish{
+				Topic:      "devices/foo/events",
+				Properties: testMsgProps,
+			},
+			handleErrs:    []error{errors.New("something went wrong")},
+			noRetries:     true,
+			expectHandled: false,
+			expectCalls:   1,
+		},
+		{
+			name: "receiver stopped while retrying",
+			msg: &paho.Publish{
+				Topic:      "devices/foo/events",
+				Properties: testMsgProps,
+			},
+			handleErrs:    []error{errors.New("something went wrong")},
+			stopped:       true,
+			expectHandled: false,
+			expectCalls:   1,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var handled bool
+			var calls int
+			r := &receiver{
+				config: testConfig,
+				// Retry immediately unless the receiver is stopped, in which case
+				// it must not retry at all
+				initialRetryInterval: time.Nanosecond,
+				maxRetryInterval:     time.Nanosecond,
+				service: &mockService{
+					HandleFn: func(ctx context.Context, event cloudEvents.Event) error {
+						require.Equal(t, "1234", event.ID())
+						auditReq := audit.RequestFromContext(ctx)
+						require.NotEmpty(t, auditReq.ID)
+						require.Equal(t, audit.TransportMQTT, auditReq.Transport)
+						require.Equal(t, "fleet", auditReq.Sender)
+						require.Equal(
+							t,
+							audit.AuthMethodSubscription,
+							auditReq.AuthMethod,
+						)
+						calls++
+						if calls <= len(testCase.handleErrs) {
+							return testCase.handleErrs[calls-1]
+						}
+						handled = true
+						return nil
+					},
+				},
+			}
+			stopCh := make(chan struct{})
+			if testCase.stopped {
+				r.initialRetryInterval = time.Hour
+				close(stopCh)
+			}
+			if testCase.noRetries {
+				r.config.MaxRetryDuration = 0
+			}
+			r.handleMessage(context.Background(), stopCh, testCase.msg)
+			require.Equal(t, testCase.expectHandled, handled)
+			require.Equal(t, testCase.expectCalls, calls)
+		})
+	}
+}
+
+func TestReceiverDispatch(t *testing.T) {
+	const testTopic = "devices/foo/events"
+	msg := func(id string) *paho.Publish {
+		return &paho.Publish{
+			Topic: testTopic,
+			Properties: &paho.PublishProperties{
+				User: paho.UserProperties{
+					{Key: "specversion", Value: "1.0"},
+					{Key: "id", Value: id},
+					{Key: "source", Value: "example/uri"},
+					{Key: "type", Value: "example.type"},
+				},
+			},
+		}
+	}
+	failing := make(chan struct{}, 1)
+	handled := make(chan string, 1)
+	r := &receiver{
+		config: ReceiverConfig{
+			Subscriptions:    []Subscription{{TopicFilter: testTopic}},
+			MaxRetryDuration: time.Hour,
+		},
+		initialRetryInterval: time.Millisecond,
+		maxRetryInterval:     time.Millisecond,
+		workers:              make(chan struct{}, 2),
+		service: &mockService{
+			HandleFn: func(_ context.Context, event cloudEvents.Event) error {
+				if event.ID() == "1" {
+					select {
+					case failing <- struct{}{}:
+					default:
+					}
+					return cloudevents.ErrSaturated
+				}
+				handled <- event.ID()
+				return nil
+			},
+		},
+	}
+	stopCh := make(chan struct{})
+	r.dispatch(context.Background(), stopCh, msg("1"))
+	<-failing
+	// The second message is handled while the first keeps failing
+	r.dispatch(context.Background(), stopCh, msg("2"))
+	select {
+	case id := <-handled:
+		require.Equal(t, "2", id)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "second message was not handled")
+	}
+	// Once the receiver stops, the first message is given up on
+	close(stopCh)
+	r.handling.Wait()
+	require.Empty(t, r.workers)
+}
+
+func TestTopicMatches(t *testing.T) {
+	testCases := []struct {
+		filter  string
+		topic   string
+		matches bool
+	}{
+		{"devices/foo/events", "devices/foo/events", true},
+		{"devices/foo/events", "devices/bar/events", false},
+		{"devices/+/events", "devices/bar/events", true},
+		{"devices/+/events", "devices/bar/baz/events", false},
+		{"devices/#", "devices/bar/baz/events", true},
+		{"devices/#", "devices", true},
+		{"devices/+", "devices", false},
+		{"devices/foo", "devices/foo/events", false},
+		{"$share/gateways/devices/+/events", "devices/bar/events", true},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.filter+" "+testCase.topic, func(t *testing.T) {
+			require.Equal(
+				t,
+				testCase.matches,
+				topicMatches(testCase.filter, testCase.topic),
+			)
+		})
+	}
+}
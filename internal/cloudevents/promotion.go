@@ -0,0 +1,235 @@
+package cloudevents
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/brigadecore/brigade/sdk/v3"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/types"
+	"github.com/pkg/errors"
+)
+
+// maxPromotedValueLength is the maximum length, in characters, of the value of
+// a promoted attribute.
+const maxPromotedValueLength = 250
+
+// attributeNameRegex matches valid CloudEvent attribute names.
+var attributeNameRegex = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// qualifierKeyRegex matches valid keys of Brigade Event qualifiers and labels.
+var qualifierKeyRegex = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?$`) // nolint: lll
+
+// AttributeError is returned when the value of a CloudEvent attribute that is
+// to be promoted cannot be used as the value of a Brigade Event qualifier or
+// label. It indicates the CloudEvent is unacceptable and that the sender
+// should not retry without correcting it.
+type AttributeError struct {
+	// Attribute is the name of the offending attribute.
+	Attribute string
+	// Reason explains what is wrong with the attribute's value.
+	Reason string
+}
+
+func (a *AttributeError) Error() string {
+	return fmt.Sprintf(
+		"value of CloudEvent attribute %q is invalid: %s",
+		a.Attribute,
+		a.Reason,
+	)
+}
+
+// PromoterConfig encapsulates configuration for a Promoter.
+type PromoterConfig struct {
+	// Qualifiers enumerates the names of CloudEvent attributes, including
+	// extension attributes, that are promoted to Brigade Event qualifiers.
+	Qualifiers []string
+	// Labels enumerates the names of CloudEvent attributes, including extension
+	// attributes, that are promoted to Brigade Event labels.
+	Labels []string
+}
+
+// Promoter is an interface for components that promote the attributes of a
+// CloudEvent to the qualifiers and labels of the Brigade Event created from it
+// so that Brigade Projects may subscribe to CloudEvents with finer granularity
+// than just their source and type.
+type Promoter interface {
+	// Promote copies the normalized values of configured attributes of the
+	// provided CloudEvent to the qualifiers and labels of the provided Brigade
+	// Event, which has not yet been created. Each qualifier or label is keyed by
+	// the name of the attribute. Attributes that are not set are skipped. If the
+	// value of any attribute is invalid, an *AttributeError is returned.
+	Promote(cloudEvents.Event, *sdk.Event) error
+}
+
+type promoter struct {
+	config PromoterConfig
+}
+
+// NewPromoter returns a Promoter configured as specified. An error is returned
+// if any configured attribute name is invalid or may not be promoted.
+func NewPromoter(config PromoterConfig) (Promoter, error) {
+	for _, name := range config.Qualifiers {
+		if err := validatePromotedAttributeName(name); err != nil {
+			return nil, err
+		}
+		// These are always set
+		if name == "source" || name == "type" {
+			return nil, errors.Errorf(
+				"CloudEvent attribute %q is already a qualifier",
+				name,
+			)
+		}
+	}
+	for _, name := range config.Labels {
+		if err := validatePromotedAttributeName(name); err != nil {
+			return nil, err
+		}
+	}
+	return &promoter{
+		config: config,
+	}, nil
+}
+
+// validatePromotedAttributeName returns an error if the provided name is not a
+// valid CloudEvent attribute name or is reserved for enrichment.
+func validatePromotedAttributeName(name string) error {
+	if !attributeNameRegex.MatchString(name) {
+		return errors.Errorf(
+			"%q is not a valid CloudEvent attribute name; names may contain only "+
+				"lowercase letters and digits",
+			name,
+		)
+	}
+	if _, err := ParseEnrichmentField(name); err == nil {
+		return errors.Errorf(
+			"CloudEvent attribute %q is reserved for enrichment and may not be "+
+				"promoted",
+			name,
+		)
+	}
+	return nil
+}
+
+func (p *promoter) Promote(
+	cloudEvent cloudEvents.Event,
+	brigadeEvent *sdk.Event,
+) error {
+	for _, name := range p.config.Qualifiers {
+		value, ok, err := promotedValue(cloudEvent, name)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if brigadeEvent.Qualifiers == nil {
+				brigadeEvent.Qualifiers = map[string]string{}
+			}
+			brigadeEvent.Qualifiers[name] = value
+		}
+	}
+	for _, name := range p.config.Labels {
+		value, ok, err := promotedValue(cloudEvent, name)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if brigadeEvent.Labels == nil {
+				brigadeEvent.Labels = map[string]string{}
+			}
+			brigadeEvent.Labels[name] = value
+		}
+	}
+	return nil
+}
+
+// promotedValue returns the normalized value of the specified attribute of the
+// provided CloudEvent and a bool indicating whether the attribute is set. If
+// the attribute is set, but its value is unacceptable as the value of a
+// Brigade Event qualifier or label, an *AttributeError is returned.
+func promotedValue(
+	event cloudEvents.Event,
+	name string,
+) (string, bool, error) {
+	var value interface{}
+	switch name {
+	case "id":
+		value = event.ID()
+	case "source":
+		value = event.Source()
+	case "specversion":
+		value = event.SpecVersion()
+	case "type":
+		value = event.Type()
+	case "datacontenttype":
+		value = event.DataContentType()
+	case "dataschema":
+		value = event.DataSchema()
+	case "subject":
+		value = event.Subject()
+	case "time":
+		if t := event.Time(); !t.IsZero() {
+			value = t
+		}
+	default:
+		value = event.Extensions()[name]
+	}
+	if value == nil {
+		return "", false, nil
+	}
+	// Values are normalized to their canonical string representation and
+	// surrounding whitespace is trimmed
+	str, err := types.Format(value)
+	if err != nil {
+		return "", false, &AttributeError{Attribute: name, Reason: err.Error()}
+	}
+	if str = strings.TrimSpace(str); str == "" {
+		return "", false, nil
+	}
+	if err = validateQualifierValue(str); err != nil {
+		return "", false, &AttributeError{Attribute: name, Reason: err.Error()}
+	}
+	return str, true, nil
+}
+
+// ValidateQualifier returns an error if the provided key and value cannot be
+// used as a qualifier or label of a Brigade Event. Keys must consist of
+// letters, digits, periods, hyphens, and underscores, and must begin and end
+// with a letter or digit. Values are subject to the same rules as the values of
+// promoted attributes.
+func ValidateQualifier(key, value string) error {
+	if !qualifierKeyRegex.MatchString(key) {
+		return errors.Errorf(
+			"key %q is invalid; keys may contain only letters, digits, periods, "+
+				"hyphens, and underscores and must begin and end with a letter or "+
+				"digit",
+			key,
+		)
+	}
+	if strings.TrimSpace(value) == "" {
+		return errors.Errorf("value of %q must not be empty", key)
+	}
+	return errors.Wrapf(validateQualifierValue(value), "value of %q", key)
+}
+
+// validateQualifierValue returns an error if the provided value cannot be used
+// as the value of a Brigade Event qualifier or label.
+func validateQualifierValue(value string) error {
+	if utf8.RuneCountInString(value) > maxPromotedValueLength {
+		return errors.Errorf(
+			"value exceeds the maximum length of %d characters",
+			maxPromotedValueLength,
+		)
+	}
+	// Brigade encodes qualifiers and labels as comma-delimited key=value pairs
+	// when selecting Events, so values must not contain either delimiter.
+	if strings.ContainsAny(value, ",=") {
+		return errors.New(`value must not contain "," or "="`)
+	}
+	if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		return errors.New("value must not contain control characters")
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package cloudevents
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brigadecore/brigade/sdk/v3"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPromoter(t *testing.T) {
+	testCases := []struct {
+		name       string
+		config     PromoterConfig
+		assertions func(Promoter, error)
+	}{
+		{
+			name:   "invalid attribute name",
+			config: PromoterConfig{Labels: []string{"Repo"}},
+			assertions: func(_ Promoter, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					"not a valid CloudEvent attribute name",
+				)
+			},
+		},
+		{
+			name:   "attribute reserved for enrichment",
+			config: PromoterConfig{Labels: []string{"gatewaysender"}},
+			assertions: func(_ Promoter, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "reserved for enrichment")
+			},
+		},
+		{
+			name:   "attribute is already a qualifier",
+			config: PromoterConfig{Qualifiers: []string{"source"}},
+			assertions: func(_ Promoter, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "already a qualifier")
+			},
+		},
+		{
+			name: "success",
+			config: PromoterConfig{
+				Qualifiers: []string{"repo"},
+				Labels:     []string{"subject", "environment"},
+			},
+			assertions: func(p Promoter, err error) {
+				require.NoError(t, err)
+				require.NotNil(t, p)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.assertions(NewPromoter(testCase.config))
+		})
+	}
+}
+
+func TestPromoterPromote(t *testing.T) {
+	testCases := []struct {
+		name       string
+		config     PromoterConfig
+		setup      func(*cloudEvents.Event)
+		assertions func(sdk.Event, error)
+	}{
+		{
+			name: "attributes not set",
+			config: PromoterConfig{
+				Qualifiers: []string{"repo"},
+				Labels:     []string{"subject", "time"},
+			},
+			setup: func(*cloudEvents.Event) {},
+			assertions: func(event sdk.Event, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					map[string]string{"source": "example/uri"},
+					event.Qualifiers,
+				)
+				require.Nil(t, event.Labels)
+			},
+		},
+		{
+			name: "attributes set",
+			config: PromoterConfig{
+				Qualifiers: []string{"repo", "environment"},
+				Labels:     []string{"subject", "time", "attempt", "type"},
+			},
+			setup: func(event *cloudEvents.Event) {
+				event.SetSubject("  my-subject ")
+				event.SetTime(
+					time.Date(2021, 8, 3, 19, 13, 37, 0, time.FixedZone("", 3600)),
+				)
+				event.SetExtension("repo", "brigadecore/brigade")
+				event.SetExtension("environment", "production")
+				event.SetExtension("attempt", 3)
+			},
+			assertions: func(event sdk.Event, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					map[string]string{
+						"source":      "example/uri",
+						"repo":        "brigadecore/brigade",
+						"environment": "production",
+					},
+					event.Qualifiers,
+				)
+				require.Equal(
+					t,
+					map[string]string{
+						"subject": "my-subject",
+						"time":    "2021-08-03T18:13:37Z",
+						"attempt": "3",
+						"type":    "example.type",
+					},
+					event.Labels,
+				)
+			},
+		},
+		{
+			name:   "value contains delimiter",
+			config: PromoterConfig{Qualifiers: []string{"repo"}},
+			setup: func(event *cloudEvents.Event) {
+				event.SetExtension("repo", "foo=bar")
+			},
+			assertions: func(_ sdk.Event, err error) {
+				attrErr, ok := err.(*AttributeError)
+				require.True(t, ok)
+				require.Equal(t, "repo", attrErr.Attribute)
+				require.Contains(t, attrErr.Reason, "must not contain")
+			},
+		},
+		{
+			name:   "value contains control character",
+			config: PromoterConfig{Labels: []string{"subject"}},
+			setup: func(event *cloudEvents.Event) {
+				event.SetSubject("foo\nbar")
+			},
+			assertions: func(_ sdk.Event, err error) {
+				attrErr, ok := err.(*AttributeError)
+				require.True(t, ok)
+				require.Equal(t, "subject", attrErr.Attribute)
+				require.Contains(t, attrErr.Reason, "control characters")
+			},
+		},
+		{
+			name:   "value too long",
+			config: PromoterConfig{Labels: []string{"subject"}},
+			setup: func(event *cloudEvents.Event) {
+				event.SetSubject(strings.Repeat("a", maxPromotedValueLength+1))
+			},
+			assertions: func(_ sdk.Event, err error) {
+				attrErr, ok := err.(*AttributeError)
+				require.True(t, ok)
+				require.Equal(t, "subject", attrErr.Attribute)
+				require.Contains(t, attrErr.Reason, "maximum length")
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			p, err := NewPromoter(testCase.config)
+			require.NoError(t, err)
+			cloudEvent := cloudEvents.NewEvent()
+			cloudEvent.SetID("1234")
+			cloudEvent.SetSource("example/uri")
+			cloudEvent.SetType("example.type")
+			testCase.setup(&cloudEvent)
+			brigadeEvent := sdk.Event{
+				Qualifiers: map[string]string{"source": "example/uri"},
+			}
+			err = p.Promote(cloudEvent, &brigadeEvent)
+			testCase.assertions(brigadeEvent, err)
+		})
+	}
+}
+
+func TestValidateQualifier(t *testing.T) {
+	testCases := []struct {
+		name        string
+		key         string
+		value       string
+		expectedErr string
+	}{
+		{
+			name:        "invalid key",
+			key:         "-team",
+			value:       "pasta",
+			expectedErr: `key "-team" is invalid`,
+		},
+		{
+			name:        "empty value",
+			key:         "team",
+			value:       " ",
+			expectedErr: `value of "team" must not be empty`,
+		},
+		{
+			name:        "value too long",
+			key:         "team",
+			value:       strings.Repeat("a", maxPromotedValueLength+1),
+			expectedErr: "maximum length",
+		},
+		{
+			name:        "value contains delimiter",
+			key:         "team",
+			value:       "pasta=good",
+			expectedErr: `must not contain "," or "="`,
+		},
+		{
+			name:        "value contains control character",
+			key:         "team",
+			value:       "pasta\n",
+			expectedErr: "control characters",
+		},
+		{
+			name:  "valid",
+			key:   "Team.name_1-a",
+			value: "pasta",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := ValidateQualifier(testCase.key, testCase.value)
+			if testCase.expectedErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			require.Contains(t, err.Error(), testCase.expectedErr)
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package cloudevents
+
+import (
+	"context"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/brigadecore/brigade/sdk/v3"
+	"github.com/brigadecore/brigade/sdk/v3/restmachinery"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/pkg/errors"
+)
+
+// DefaultBackend is the name of the Backend to which Brigade Events are sent
+// when no Route matches the CloudEvent they were created from. Routes may also
+// refer to it by this name.
+const DefaultBackend = "default"
+
+// Backend is a named Brigade API server to which Brigade Events may be sent.
+type Backend struct {
+	// Name identifies the Backend in routes, logs, and replies.
+	Name string
+	// EventsClient is used to create Brigade Events in the Backend.
+	EventsClient sdk.EventsClient
+}
+
+// Route is a rule for selecting the Backends to which the Brigade Events
+// created from a CloudEvent are sent. A Route matches a CloudEvent only if
+// every criterion it specifies matches. A Route that specifies no criteria
+// matches every CloudEvent.
+type Route struct {
+	// Tenants enumerates the names of tenants. If specified, the Route matches
+	// only CloudEvents sent to one of these tenants' endpoints.
+	Tenants []string `json:"tenants"`
+	// Sources enumerates CloudEvent sources. If specified, the Route matches only
+	// CloudEvents from one of these sources. An entry ending in "*" matches any
+	// source beginning with the characters that precede it.
+	Sources []string `json:"sources"`
+	// Types enumerates CloudEvent types. If specified, the Route matches only
+	// CloudEvents of one of these types. An entry ending in "*" matches any type
+	// beginning with the characters that precede it.
+	Types []string `json:"types"`
+	// Backends enumerates the names of the Backends to which Brigade Events are
+	// sent when the Route matches. If more than one is specified, a Brigade
+	// Event is sent to each.
+	Backends []string `json:"backends"`
+}
+
+// MatchesTenant returns a bool indicating whether the Route may match
+// CloudEvents sent to the named tenant's endpoint. The empty string names no
+// tenant.
+func (r Route) MatchesTenant(tenant string) bool {
+	return len(r.Tenants) == 0 || matchesAny(r.Tenants, tenant)
+}
+
+// BackendConfig encapsulates configuration for a Backend.
+type BackendConfig struct {
+	// Address is the address of the Brigade API server, including leading
+	// protocol (http:// or https://).
+	Address string
+	// Token is the Brigade API token used to create Brigade Events.
+	Token string
+	// ClientOptions are options for the client used to create Brigade Events.
+	ClientOptions restmachinery.APIClientOptions
+}
+
+// RouterConfig encapsulates configuration for a Router.
+type RouterConfig struct {
+	// Backends maps the names of Backends, other than the DefaultBackend, to
+	// their configuration.
+	Backends map[string]BackendConfig
+	// Routes are evaluated in order. The first that matches a CloudEvent
+	// determines the Backends the Brigade Events created from it are sent to.
+	Routes []Route
+}
+
+// Router is an interface for components that select the Backends to which the
+// Brigade Events created from a CloudEvent are sent.
+type Router interface {
+	// Route returns the Backends to which Brigade Events created from the
+	// provided CloudEvent are sent. Details of the request that delivered the
+	// CloudEvent are taken from the provided context. At least one Backend is
+	// always returned.
+	Route(context.Context, cloudEvents.Event) []Backend
+}
+
+type route struct {
+	Route
+	backends []Backend
+}
+
+type router struct {
+	routes         []route
+	defaultBackend []Backend
+}
+
+// NewRouter returns a Router that selects Backends as specified by the
+// provided configuration. CloudEvents that no Route matches are sent to the
+// DefaultBackend, which uses the provided sdk.EventsClient. An error is
+// returned if any Backend is named DefaultBackend or if any Route refers to an
+// unknown Backend or to no Backends at all.
+func NewRouter(
+	defaultEventsClient sdk.EventsClient,
+	config RouterConfig,
+) (Router, error) {
+	backends := map[string]Backend{
+		DefaultBackend: {Name: DefaultBackend, EventsClient: defaultEventsClient},
+	}
+	for name, backendConfig := range config.Backends {
+		if name == "" {
+			return nil, errors.New("backend name must not be empty")
+		}
+		if name == DefaultBackend {
+			return nil, errors.Errorf("backend name %q is reserved", name)
+		}
+		backends[name] = Backend{
+			Name: name,
+			EventsClient: sdk.NewEventsClient(
+				backendConfig.Address,
+				backendConfig.Token,
+				&backendConfig.ClientOptions,
+			),
+		}
+	}
+	r := &router{
+		routes:         make([]route, len(config.Routes)),
+		defaultBackend: []Backend{backends[DefaultBackend]},
+	}
+	for i, cfg := range config.Routes {
+		if len(cfg.Backends) == 0 {
+			return nil, errors.Errorf("route %d does not specify any backends", i)
+		}
+		r.routes[i] = route{
+			Route:    cfg,
+			backends: make([]Backend, len(cfg.Backends)),
+		}
+		for j, name := range cfg.Backends {
+			backend, ok := backends[name]
+			if !ok {
+				return nil, errors.Errorf(
+					"route %d refers to unknown backend %q",
+					i,
+					name,
+				)
+			}
+			r.routes[i].backends[j] = backend
+		}
+	}
+	return r, nil
+}
+
+func (r *router) Route(
+	ctx context.Context,
+	event cloudEvents.Event,
+) []Backend {
+	tenant := audit.RequestFromContext(ctx).Tenant
+	for _, rt := range r.routes {
+		if !rt.MatchesTenant(tenant) {
+			continue
+		}
+		if len(rt.Sources) > 0 && !matchesAny(rt.Sources, event.Source()) {
+			continue
+		}
+		if len(rt.Types) > 0 && !matchesAny(rt.Types, event.Type()) {
+			continue
+		}
+		return rt.backends
+	}
+	return r.defaultBackend
+}
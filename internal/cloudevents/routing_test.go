@@ -0,0 +1,161 @@
+package cloudevents
+
+import (
+	"context"
+	"testing"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	sdkTesting "github.com/brigadecore/brigade/sdk/v3/testing"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRouter(t *testing.T) {
+	testCases := []struct {
+		name       string
+		config     RouterConfig
+		assertions func(Router, error)
+	}{
+		{
+			name: "backend uses reserved name",
+			config: RouterConfig{
+				Backends: map[string]BackendConfig{
+					DefaultBackend: {Address: "https://brigade.example.com"},
+				},
+			},
+			assertions: func(_ Router, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `backend name "default" is reserved`)
+			},
+		},
+		{
+			name: "route specifies no backends",
+			config: RouterConfig{
+				Routes: []Route{{Sources: []string{"example/*"}}},
+			},
+			assertions: func(_ Router, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "does not specify any backends")
+			},
+		},
+		{
+			name: "route refers to unknown backend",
+			config: RouterConfig{
+				Routes: []Route{{Backends: []string{"staging"}}},
+			},
+			assertions: func(_ Router, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					`route 0 refers to unknown backend "staging"`,
+				)
+			},
+		},
+		{
+			name: "success",
+			config: RouterConfig{
+				Backends: map[string]BackendConfig{
+					"staging": {Address: "https://staging.example.com"},
+				},
+				Routes: []Route{{Backends: []string{"staging", DefaultBackend}}},
+			},
+			assertions: func(r Router, err error) {
+				require.NoError(t, err)
+				rt, ok := r.(*router)
+				require.True(t, ok)
+				require.Len(t, rt.defaultBackend, 1)
+				require.Equal(t, DefaultBackend, rt.defaultBackend[0].Name)
+				require.Len(t, rt.routes, 1)
+				require.Len(t, rt.routes[0].backends, 2)
+				require.Equal(t, "staging", rt.routes[0].backends[0].Name)
+				require.Equal(t, DefaultBackend, rt.routes[0].backends[1].Name)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			r, err := NewRouter(&sdkTesting.MockEventsClient{}, testCase.config)
+			testCase.assertions(r, err)
+		})
+	}
+}
+
+func TestRouterRoute(t *testing.T) {
+	r, err := NewRouter(
+		&sdkTesting.MockEventsClient{},
+		RouterConfig{
+			Backends: map[string]BackendConfig{
+				"staging":    {Address: "https://staging.example.com"},
+				"production": {Address: "https://production.example.com"},
+			},
+			Routes: []Route{
+				{
+					Tenants:  []string{"italian"},
+					Backends: []string{"staging"},
+				},
+				{
+					Sources:  []string{"example/*"},
+					Types:    []string{"example.deploy"},
+					Backends: []string{"staging", "production"},
+				},
+				{
+					Sources:  []string{"example/uri"},
+					Backends: []string{"production"},
+				},
+			},
+		},
+	)
+	require.NoError(t, err)
+	testCases := []struct {
+		name     string
+		tenant   string
+		source   string
+		typ      string
+		backends []string
+	}{
+		{
+			name:     "tenant matches",
+			tenant:   "italian",
+			source:   "example/uri",
+			typ:      "example.deploy",
+			backends: []string{"staging"},
+		},
+		{
+			name:     "source and type match",
+			tenant:   "mexican",
+			source:   "example/uri",
+			typ:      "example.deploy",
+			backends: []string{"staging", "production"},
+		},
+		{
+			name:     "only source matches",
+			source:   "example/uri",
+			typ:      "example.build",
+			backends: []string{"production"},
+		},
+		{
+			name:     "nothing matches",
+			source:   "example/foo",
+			typ:      "example.build",
+			backends: []string{DefaultBackend},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx := audit.ContextWithRequest(
+				context.Background(),
+				audit.Request{Tenant: testCase.tenant},
+			)
+			event := cloudEvents.NewEvent()
+			event.SetSource(testCase.source)
+			event.SetType(testCase.typ)
+			var names []string
+			for _, backend := range r.Route(ctx, event) {
+				require.NotNil(t, backend.EventsClient)
+				names = append(names, backend.Name)
+			}
+			require.Equal(t, testCase.backends, names)
+		})
+	}
+}
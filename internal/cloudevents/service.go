@@ -3,63 +3,374 @@ package cloudevents
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/shutdown"
 	"github.com/brigadecore/brigade/sdk/v3"
 	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
 const (
 	eventSource = "brigade.sh/cloudevents"
 	eventType   = "cloudevent"
+
+	// ReplyType is the type of the CloudEvent returned by
+	// Service.HandleWithReply.
+	ReplyType = "sh.brigade.event.created"
+
+	// shutdownKindCloudEvent is the kind of work tracked for every CloudEvent
+	// being handled.
+	shutdownKindCloudEvent = "cloud_event"
 )
 
+// ReplyData is the data of the CloudEvent returned by Service.HandleWithReply.
+type ReplyData struct {
+	// Events enumerates the Brigade Events that were created from the handled
+	// CloudEvent. There is one for each Brigade Project subscribed to it.
+	Events []CreatedEvent `json:"events"`
+}
+
+// CreatedEvent identifies a single Brigade Event created from a handled
+// CloudEvent.
+type CreatedEvent struct {
+	// ID is the Brigade Event's identifier.
+	ID string `json:"id"`
+	// ProjectID is the identifier of the Brigade Project the Event was created
+	// for.
+	ProjectID string `json:"projectID"`
+	// Backend is the name of the Backend the Event was created in.
+	Backend string `json:"backend"`
+}
+
 // Service is an interface for components that can handle CloudEvents.
 // Implementations of this interface are transport-agnostic.
 type Service interface {
 	// Handle handles a CloudEvent.
 	Handle(context.Context, cloudEvents.Event) error
+	// HandleWithReply handles a CloudEvent and returns a reply CloudEvent of
+	// type ReplyType whose data identifies the Brigade Events that were created.
+	HandleWithReply(
+		context.Context,
+		cloudEvents.Event,
+	) (*cloudEvents.Event, error)
 }
 
 type service struct {
-	eventsClient sdk.EventsClient
+	router             Router
+	auditSink          audit.Sink
+	concurrencyLimiter ConcurrencyLimiter
+	enricher           Enricher
+	promoter           Promoter
+	shutdownTracker    shutdown.Tracker
+	partialFanOuts     *partialFanOuts
 }
 
 // NewService returns an implementation of the Service interface for handling
-// CloudEvents.
-func NewService(eventsClient sdk.EventsClient) Service {
+// CloudEvents. The Brigade Events created from each CloudEvent are sent to the
+// Backends selected by the provided Router. If there is more than one, the
+// Brigade Events are sent to all of them concurrently and an error is returned
+// if sending to any of them fails. In that case, the Backends the Brigade
+// Events were sent to successfully are remembered for an hour so that, if the
+// sender retries the same CloudEvent, they're only sent to the others. The
+// outcome of handling each CloudEvent is
+// recorded using the provided audit.Sink. Calls to the Brigade API are subject
+// to the provided ConcurrencyLimiter. If the gateway is saturated, the
+// Service's methods return an error for which errors.Is(err, ErrSaturated) is
+// true. Metadata about the receipt of each CloudEvent is stamped onto the
+// Brigade Events created from it using the provided Enricher, and selected
+// attributes of each CloudEvent are promoted to qualifiers and labels of those
+// Brigade Events using the provided Promoter. If an attribute's value is
+// invalid, the Service's methods return an *AttributeError. Every CloudEvent
+// being handled, including any waiting on the ConcurrencyLimiter, is tracked
+// as in-flight work using the provided shutdown.Tracker.
+func NewService(
+	router Router,
+	auditSink audit.Sink,
+	concurrencyLimiter ConcurrencyLimiter,
+	enricher Enricher,
+	promoter Promoter,
+	shutdownTracker shutdown.Tracker,
+) Service {
 	return &service{
-		eventsClient: eventsClient,
+		router:             router,
+		auditSink:          auditSink,
+		concurrencyLimiter: concurrencyLimiter,
+		enricher:           enricher,
+		promoter:           promoter,
+		shutdownTracker:    shutdownTracker,
+		partialFanOuts:     newPartialFanOuts(),
 	}
 }
 
 func (s *service) Handle(ctx context.Context, event cloudEvents.Event) error {
+	_, err := s.createEvents(ctx, event)
+	return err
+}
+
+func (s *service) HandleWithReply(
+	ctx context.Context,
+	event cloudEvents.Event,
+) (*cloudEvents.Event, error) {
+	events, err := s.createEvents(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+	data := ReplyData{
+		// Never nil so that the data's events are never null
+		Events: append([]CreatedEvent{}, events...),
+	}
+	reply := cloudEvents.NewEvent()
+	reply.SetID(uuid.New().String())
+	reply.SetSource(eventSource)
+	reply.SetType(ReplyType)
+	// The subject identifies the CloudEvent this is a reply to
+	reply.SetSubject(event.ID())
+	reply.SetTime(time.Now())
+	// Marshaling ReplyData cannot fail
+	reply.SetData( // nolint: errcheck
+		cloudEvents.ApplicationJSON,
+		data,
+	)
+	return &reply, nil
+}
+
+// createEvents creates Brigade Events from the provided CloudEvent and returns
+// identifiers for them.
+func (s *service) createEvents(
+	ctx context.Context,
+	event cloudEvents.Event,
+) ([]CreatedEvent, error) {
+	defer s.shutdownTracker.Track(shutdownKindCloudEvent)()
+	ctx, span := startHandleSpan(ctx, event)
+	defer span.End()
+	logger := logging.FromContext(ctx).With(
+		zap.String("cloudEventID", event.ID()),
+		zap.String("cloudEventSource", event.Source()),
+		zap.String("cloudEventType", event.Type()),
+	)
+	brigadeEvent := sdk.Event{
+		Source: eventSource,
+		Type:   eventType,
+		Qualifiers: map[string]string{
+			"source": event.Source(),
+			"type":   event.Type(),
+		},
+	}
+	// This happens before the CloudEvent is marshaled so that any extension
+	// attributes added to it are included in the payload
+	s.enricher.Enrich(ctx, &event, &brigadeEvent)
+	if err := s.promoter.Promote(event, &brigadeEvent); err != nil {
+		logger.Info("rejecting cloud event", zap.Error(err))
+		eventsTotal.WithLabelValues(eventResultInvalid).Inc()
+		s.audit(
+			ctx,
+			event,
+			audit.DecisionRejected,
+			"invalid_attribute",
+			nil,
+		)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
 	eventJSON, err := json.Marshal(event)
 	if err != nil {
 		err = errors.Wrap(err, "error marshaling cloud event to JSON")
 		// Nothing in cloudevents/sdk-go's HTTP protocol bindings seems to log the
 		// error we return, so we log it ourselves here.
-		log.Println(err)
-		return err
+		logger.Error("error handling cloud event", zap.Error(err))
+		eventsTotal.WithLabelValues(eventResultFailed).Inc()
+		s.audit(ctx, event, audit.DecisionFailed, err.Error(), nil)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
 	}
-	if _, err = s.eventsClient.Create(
+	brigadeEvent.Payload = string(eventJSON)
+	events, err := s.createBrigadeEvents(
 		ctx,
-		sdk.Event{
-			Source: eventSource,
-			Type:   eventType,
-			Qualifiers: map[string]string{
-				"source": event.Source(),
-				"type":   event.Type(),
-			},
-			Payload: string(eventJSON),
-		},
-		nil,
-	); err != nil {
+		event,
+		s.router.Route(ctx, event),
+		brigadeEvent,
+	)
+	if errors.Is(err, ErrSaturated) {
+		eventsTotal.WithLabelValues(eventResultShed).Inc()
+		logger.Warn("gateway is saturated; shedding cloud event")
+		s.audit(ctx, event, audit.DecisionRejected, "saturated", events)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return events, err
+	}
+	if err != nil {
+		eventsTotal.WithLabelValues(eventResultFailed).Inc()
 		err = errors.Wrap(err, "error creating brigade event from cloud event")
 		// Nothing in cloudevents/sdk-go's HTTP protocol bindings seems to log the
 		// error we return, so we log it ourselves here.
-		log.Println(err)
+		logger.Error("error handling cloud event", zap.Error(err))
+		s.audit(ctx, event, audit.DecisionFailed, err.Error(), events)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return events, err
 	}
-	return err
+	if len(events) == 0 {
+		eventsTotal.WithLabelValues(eventResultDropped).Inc()
+		logger.Info("no brigade project is subscribed to cloud event")
+		s.audit(ctx, event, audit.DecisionDropped, "", events)
+		return events, nil
+	}
+	eventsTotal.WithLabelValues(eventResultHandled).Inc()
+	for _, e := range events {
+		logger.Info(
+			"created brigade event from cloud event",
+			logging.BrigadeEventID(e.ID),
+			zap.String("projectID", e.ProjectID),
+			zap.String("backend", e.Backend),
+		)
+	}
+	s.audit(ctx, event, audit.DecisionAccepted, "", events)
+	return events, nil
+}
+
+// audit writes an audit record describing the outcome of handling the provided
+// CloudEvent.
+func (s *service) audit(
+	ctx context.Context,
+	event cloudEvents.Event,
+	decision audit.Decision,
+	reason string,
+	events []CreatedEvent,
+) {
+	record := audit.Record{
+		Decision:         decision,
+		Reason:           reason,
+		CloudEventID:     event.ID(),
+		CloudEventSource: event.Source(),
+		CloudEventType:   event.Type(),
+	}
+	for _, e := range events {
+		record.BrigadeEventIDs = append(record.BrigadeEventIDs, e.ID)
+	}
+	s.auditSink.Write(ctx, record)
+}
+
+// createBrigadeEvents creates the provided Brigade Event, created from the
+// provided CloudEvent, in each of the provided Backends and returns
+// identifiers for all the Brigade Events that were created. If creating the
+// Brigade Event in any Backend fails, the first such error is returned along
+// with the Brigade Events that were created in the other Backends, which are
+// remembered so that they aren't created again if the CloudEvent is retried.
+// The same CloudEvent is never fanned out concurrently, so a retry waits for
+// any previous attempt to finish and creates only the Brigade Events that
+// attempt didn't.
+func (s *service) createBrigadeEvents(
+	ctx context.Context,
+	cloudEvent cloudEvents.Event,
+	backends []Backend,
+	event sdk.Event,
+) ([]CreatedEvent, error) {
+	if len(backends) == 1 {
+		return s.createBrigadeEvent(ctx, backends[0], event)
+	}
+	key := newFanOutKey(ctx, cloudEvent)
+	unlock, err := s.partialFanOuts.lock(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	previous := s.partialFanOuts.get(key)
+	results := make([][]CreatedEvent, len(backends))
+	errs := make([]error, len(backends))
+	wg := sync.WaitGroup{}
+	for i := range backends {
+		if created, ok := previous[backends[i].Name]; ok {
+			logging.FromContext(ctx).Info(
+				"brigade event was already created in backend; skipping",
+				zap.String("backend", backends[i].Name),
+			)
+			results[i] = created
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = s.createBrigadeEvent(ctx, backends[i], event)
+		}(i)
+	}
+	wg.Wait()
+	var events []CreatedEvent
+	succeeded := map[string][]CreatedEvent{}
+	for i, backend := range backends {
+		events = append(events, results[i]...)
+		if errs[i] == nil {
+			succeeded[backend.Name] = results[i]
+		} else if err == nil {
+			err = errors.Wrapf(errs[i], "error sending to backend %q", backend.Name)
+		}
+	}
+	// Once a CloudEvent has been retried, it continues to be remembered even
+	// after it has been sent to every Backend so that any other retries of it
+	// that are still in flight don't create its Brigade Events again
+	if len(succeeded) > 0 && (err != nil || len(previous) > 0) {
+		s.partialFanOuts.set(key, succeeded)
+	} else {
+		s.partialFanOuts.delete(key)
+	}
+	return events, err
+}
+
+// createBrigadeEvent creates the provided Brigade Event in the provided
+// Backend, subject to the concurrency limit, recording the latency of the API
+// call and tracing it. The trace context of the API call is copied onto the
+// Brigade Event's source state so that Brigade Workers may continue the trace.
+func (s *service) createBrigadeEvent(
+	ctx context.Context,
+	backend Backend,
+	event sdk.Event,
+) ([]CreatedEvent, error) {
+	release, err := s.concurrencyLimiter.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	ctx, span := tracer.Start(
+		ctx,
+		"brigade.events.create",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("brigade.backend", backend.Name)),
+	)
+	defer span.End()
+	event.SourceState = traceSourceState(ctx)
+	start := time.Now()
+	events, err := backend.EventsClient.Create(ctx, event, nil)
+	brigadeAPIRequestDuration.WithLabelValues(
+		"create_event",
+		backend.Name,
+		strconv.FormatBool(err == nil),
+	).Observe(time.Since(start).Seconds())
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(
+		attribute.Int("brigade.events.created", len(events.Items)),
+	)
+	created := make([]CreatedEvent, len(events.Items))
+	for i, e := range events.Items {
+		created[i] = CreatedEvent{
+			ID:        e.ID,
+			ProjectID: e.ProjectID,
+			Backend:   backend.Name,
+		}
+	}
+	return created, nil
 }
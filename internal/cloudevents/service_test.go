@@ -2,25 +2,57 @@ package cloudevents
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"sync"
 	"testing"
 
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/shutdown"
 	"github.com/brigadecore/brigade/sdk/v3"
+	"github.com/brigadecore/brigade/sdk/v3/meta"
 	sdkTesting "github.com/brigadecore/brigade/sdk/v3/testing"
 	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestNewService(t *testing.T) {
 	s, ok := NewService(
 		// Totally unusable client that is enough to fulfill the dependencies for
 		// this test...
-		&sdkTesting.MockEventsClient{
-			LogsClient: &sdkTesting.MockLogsClient{},
-		},
+		newTestRouter(
+			&sdkTesting.MockEventsClient{
+				LogsClient: &sdkTesting.MockLogsClient{},
+			},
+		),
+		audit.NewNopSink(),
+		NewConcurrencyLimiter(ConcurrencyLimiterConfig{}),
+		NewEnricher(EnricherConfig{}),
+		&promoter{},
+		shutdown.NewTracker(),
 	).(*service)
 	require.True(t, ok)
-	require.NotNil(t, s.eventsClient)
+	require.NotNil(t, s.router)
+	require.NotNil(t, s.auditSink)
+	require.NotNil(t, s.concurrencyLimiter)
+	require.NotNil(t, s.enricher)
+	require.NotNil(t, s.promoter)
+	require.NotNil(t, s.shutdownTracker)
+}
+
+// newTestRouter returns a Router that sends every Brigade Event to the
+// DefaultBackend, which uses the provided sdk.EventsClient.
+func newTestRouter(eventsClient sdk.EventsClient) Router {
+	return &router{
+		defaultBackend: []Backend{
+			{Name: DefaultBackend, EventsClient: eventsClient},
+		},
+	}
 }
 
 func TestHandle(t *testing.T) {
@@ -40,7 +72,12 @@ func TestHandle(t *testing.T) {
 		{
 			name: "error creating brigade event",
 			service: &service{
-				eventsClient: &sdkTesting.MockEventsClient{
+				auditSink:          audit.NewNopSink(),
+				concurrencyLimiter: NewConcurrencyLimiter(ConcurrencyLimiterConfig{}),
+				enricher:           NewEnricher(EnricherConfig{}),
+				promoter:           &promoter{},
+				shutdownTracker:    shutdown.NewTracker(),
+				router: newTestRouter(&sdkTesting.MockEventsClient{
 					CreateFn: func(
 						context.Context,
 						sdk.Event,
@@ -48,7 +85,7 @@ func TestHandle(t *testing.T) {
 					) (sdk.EventList, error) {
 						return sdk.EventList{}, errors.New("something went wrong")
 					},
-				},
+				}),
 			},
 			assertions: func(err error) {
 				require.Error(t, err)
@@ -63,7 +100,12 @@ func TestHandle(t *testing.T) {
 		{
 			name: "success",
 			service: &service{
-				eventsClient: &sdkTesting.MockEventsClient{
+				auditSink:          audit.NewNopSink(),
+				concurrencyLimiter: NewConcurrencyLimiter(ConcurrencyLimiterConfig{}),
+				enricher:           NewEnricher(EnricherConfig{}),
+				promoter:           &promoter{},
+				shutdownTracker:    shutdown.NewTracker(),
+				router: newTestRouter(&sdkTesting.MockEventsClient{
 					CreateFn: func(
 						_ context.Context,
 						event sdk.Event,
@@ -82,7 +124,7 @@ func TestHandle(t *testing.T) {
 						require.NotEmpty(t, event.Payload)
 						return sdk.EventList{}, nil
 					},
-				},
+				}),
 			},
 			assertions: func(err error) {
 				require.NoError(t, err)
@@ -96,3 +138,668 @@ func TestHandle(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleWithReply(t *testing.T) {
+	testCloudEvent := cloudEvents.NewEvent()
+	testCloudEvent.SetID("1234")
+	testCloudEvent.SetSource("foo")
+	testCloudEvent.SetType("bar")
+	testCases := []struct {
+		name       string
+		service    *service
+		assertions func(*cloudEvents.Event, error)
+	}{
+		{
+			name: "error creating brigade event",
+			service: &service{
+				auditSink:          audit.NewNopSink(),
+				concurrencyLimiter: NewConcurrencyLimiter(ConcurrencyLimiterConfig{}),
+				enricher:           NewEnricher(EnricherConfig{}),
+				promoter:           &promoter{},
+				shutdownTracker:    shutdown.NewTracker(),
+				router: newTestRouter(&sdkTesting.MockEventsClient{
+					CreateFn: func(
+						context.Context,
+						sdk.Event,
+						*sdk.EventCreateOptions,
+					) (sdk.EventList, error) {
+						return sdk.EventList{}, errors.New("something went wrong")
+					},
+				}),
+			},
+			assertions: func(reply *cloudEvents.Event, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "something went wrong")
+				require.Nil(t, reply)
+			},
+		},
+		{
+			name: "success",
+			service: &service{
+				auditSink:          audit.NewNopSink(),
+				concurrencyLimiter: NewConcurrencyLimiter(ConcurrencyLimiterConfig{}),
+				enricher:           NewEnricher(EnricherConfig{}),
+				promoter:           &promoter{},
+				shutdownTracker:    shutdown.NewTracker(),
+				router: newTestRouter(&sdkTesting.MockEventsClient{
+					CreateFn: func(
+						context.Context,
+						sdk.Event,
+						*sdk.EventCreateOptions,
+					) (sdk.EventList, error) {
+						return sdk.EventList{
+							Items: []sdk.Event{
+								{
+									ObjectMeta: meta.ObjectMeta{ID: "abc"},
+									ProjectID:  "italian",
+								},
+								{
+									ObjectMeta: meta.ObjectMeta{ID: "def"},
+									ProjectID:  "mexican",
+								},
+							},
+						}, nil
+					},
+				}),
+			},
+			assertions: func(reply *cloudEvents.Event, err error) {
+				require.NoError(t, err)
+				require.NotNil(t, reply)
+				require.NoError(t, reply.Validate())
+				require.Equal(t, ReplyType, reply.Type())
+				require.Equal(t, eventSource, reply.Source())
+				require.Equal(t, "1234", reply.Subject())
+				data := ReplyData{}
+				require.NoError(t, reply.DataAs(&data))
+				require.Equal(
+					t,
+					ReplyData{
+						Events: []CreatedEvent{
+							{
+								ID:        "abc",
+								ProjectID: "italian",
+								Backend:   DefaultBackend,
+							},
+							{
+								ID:        "def",
+								ProjectID: "mexican",
+								Backend:   DefaultBackend,
+							},
+						},
+					},
+					data,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			reply, err := testCase.service.HandleWithReply(
+				context.Background(),
+				testCloudEvent,
+			)
+			testCase.assertions(reply, err)
+		})
+	}
+}
+
+func TestHandleMetrics(t *testing.T) {
+	testCloudEvent := cloudEvents.NewEvent()
+	testCloudEvent.SetID("1234")
+	testCloudEvent.SetSource("metrics")
+	testCloudEvent.SetType("test")
+	testCases := []struct {
+		name     string
+		events   sdk.EventList
+		err      error
+		expected string
+	}{
+		{
+			name: "handled",
+			events: sdk.EventList{
+				Items: []sdk.Event{{ObjectMeta: meta.ObjectMeta{ID: "abc"}}},
+			},
+			expected: eventResultHandled,
+		},
+		{
+			name:     "dropped",
+			expected: eventResultDropped,
+		},
+		{
+			name:     "failed",
+			err:      errors.New("something went wrong"),
+			expected: eventResultFailed,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			counter := eventsTotal.WithLabelValues(testCase.expected)
+			before := testutil.ToFloat64(counter)
+			s := &service{
+				auditSink:          audit.NewNopSink(),
+				concurrencyLimiter: NewConcurrencyLimiter(ConcurrencyLimiterConfig{}),
+				enricher:           NewEnricher(EnricherConfig{}),
+				promoter:           &promoter{},
+				shutdownTracker:    shutdown.NewTracker(),
+				router: newTestRouter(&sdkTesting.MockEventsClient{
+					CreateFn: func(
+						context.Context,
+						sdk.Event,
+						*sdk.EventCreateOptions,
+					) (sdk.EventList, error) {
+						return testCase.events, testCase.err
+					},
+				}),
+			}
+			_ = s.Handle(context.Background(), testCloudEvent)
+			require.Equal(t, before+1, testutil.ToFloat64(counter))
+		})
+	}
+}
+
+func TestHandleLogging(t *testing.T) {
+	testCloudEvent := cloudEvents.NewEvent()
+	testCloudEvent.SetID("1234")
+	testCloudEvent.SetSource("example/uri")
+	testCloudEvent.SetType("example.type")
+	core, logs := observer.New(zapcore.InfoLevel)
+	ctx := logging.ContextWithFields(
+		logging.ContextWithLogger(context.Background(), zap.New(core)),
+		logging.RequestID("abcd"),
+		logging.Sender("example/uri"),
+	)
+	s := &service{
+		auditSink:          audit.NewNopSink(),
+		concurrencyLimiter: NewConcurrencyLimiter(ConcurrencyLimiterConfig{}),
+		enricher:           NewEnricher(EnricherConfig{}),
+		promoter:           &promoter{},
+		shutdownTracker:    shutdown.NewTracker(),
+		router: newTestRouter(&sdkTesting.MockEventsClient{
+			CreateFn: func(
+				context.Context,
+				sdk.Event,
+				*sdk.EventCreateOptions,
+			) (sdk.EventList, error) {
+				return sdk.EventList{
+					Items: []sdk.Event{
+						{
+							ObjectMeta: meta.ObjectMeta{ID: "efgh"},
+							ProjectID:  "italian",
+						},
+					},
+				}, nil
+			},
+		}),
+	}
+	require.NoError(t, s.Handle(ctx, testCloudEvent))
+	require.Equal(t, 1, logs.Len())
+	require.Equal(
+		t,
+		map[string]interface{}{
+			"requestID":        "abcd",
+			"sender":           "example/uri",
+			"cloudEventID":     "1234",
+			"cloudEventSource": "example/uri",
+			"cloudEventType":   "example.type",
+			"brigadeEventID":   "efgh",
+			"projectID":        "italian",
+			"backend":          DefaultBackend,
+		},
+		logs.All()[0].ContextMap(),
+	)
+}
+
+type mockAuditSink struct {
+	records []audit.Record
+}
+
+func (m *mockAuditSink) Write(_ context.Context, record audit.Record) {
+	m.records = append(m.records, record)
+}
+
+func (m *mockAuditSink) Close() error {
+	return nil
+}
+
+func TestHandleAudit(t *testing.T) {
+	testCloudEvent := cloudEvents.NewEvent()
+	testCloudEvent.SetID("1234")
+	testCloudEvent.SetSource("example/uri")
+	testCloudEvent.SetType("example.type")
+	testCases := []struct {
+		name     string
+		events   sdk.EventList
+		err      error
+		expected audit.Record
+	}{
+		{
+			name: "accepted",
+			events: sdk.EventList{
+				Items: []sdk.Event{
+					{ObjectMeta: meta.ObjectMeta{ID: "abc"}},
+					{ObjectMeta: meta.ObjectMeta{ID: "def"}},
+				},
+			},
+			expected: audit.Record{
+				Decision:         audit.DecisionAccepted,
+				CloudEventID:     "1234",
+				CloudEventSource: "example/uri",
+				CloudEventType:   "example.type",
+				BrigadeEventIDs:  []string{"abc", "def"},
+			},
+		},
+		{
+			name: "dropped",
+			expected: audit.Record{
+				Decision:         audit.DecisionDropped,
+				CloudEventID:     "1234",
+				CloudEventSource: "example/uri",
+				CloudEventType:   "example.type",
+			},
+		},
+		{
+			name: "failed",
+			err:  errors.New("something went wrong"),
+			expected: audit.Record{
+				Decision: audit.DecisionFailed,
+				Reason: "error creating brigade event from cloud event: " +
+					"something went wrong",
+				CloudEventID:     "1234",
+				CloudEventSource: "example/uri",
+				CloudEventType:   "example.type",
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			auditSink := &mockAuditSink{}
+			s := &service{
+				router: newTestRouter(&sdkTesting.MockEventsClient{
+					CreateFn: func(
+						context.Context,
+						sdk.Event,
+						*sdk.EventCreateOptions,
+					) (sdk.EventList, error) {
+						return testCase.events, testCase.err
+					},
+				}),
+				auditSink:          auditSink,
+				concurrencyLimiter: NewConcurrencyLimiter(ConcurrencyLimiterConfig{}),
+				enricher:           NewEnricher(EnricherConfig{}),
+				promoter:           &promoter{},
+				shutdownTracker:    shutdown.NewTracker(),
+			}
+			_ = s.Handle(context.Background(), testCloudEvent)
+			require.Equal(t, []audit.Record{testCase.expected}, auditSink.records)
+		})
+	}
+}
+
+func TestHandleSaturated(t *testing.T) {
+	testCloudEvent := cloudEvents.NewEvent()
+	testCloudEvent.SetID("1234")
+	testCloudEvent.SetSource("saturated")
+	testCloudEvent.SetType("test")
+	concurrencyLimiter := NewConcurrencyLimiter(
+		ConcurrencyLimiterConfig{MaxInFlight: 1},
+	)
+	// Occupy the only slot
+	_, err := concurrencyLimiter.Acquire(context.Background())
+	require.NoError(t, err)
+	auditSink := &mockAuditSink{}
+	s := &service{
+		router: newTestRouter(&sdkTesting.MockEventsClient{
+			CreateFn: func(
+				context.Context,
+				sdk.Event,
+				*sdk.EventCreateOptions,
+			) (sdk.EventList, error) {
+				require.Fail(t, "the Brigade API should not have been called")
+				return sdk.EventList{}, nil
+			},
+		}),
+		auditSink:          auditSink,
+		concurrencyLimiter: concurrencyLimiter,
+		enricher:           NewEnricher(EnricherConfig{}),
+		promoter:           &promoter{},
+		shutdownTracker:    shutdown.NewTracker(),
+	}
+	counter := eventsTotal.WithLabelValues(eventResultShed)
+	before := testutil.ToFloat64(counter)
+	err = s.Handle(context.Background(), testCloudEvent)
+	require.ErrorIs(t, err, ErrSaturated)
+	require.Equal(t, before+1, testutil.ToFloat64(counter))
+	require.Equal(
+		t,
+		[]audit.Record{
+			{
+				Decision:         audit.DecisionRejected,
+				Reason:           "saturated",
+				CloudEventID:     "1234",
+				CloudEventSource: "saturated",
+				CloudEventType:   "test",
+			},
+		},
+		auditSink.records,
+	)
+}
+
+func TestHandleTracksInFlightWork(t *testing.T) {
+	shutdownTracker := shutdown.NewTracker()
+	var inFlight map[string]int
+	s := &service{
+		router: newTestRouter(&sdkTesting.MockEventsClient{
+			CreateFn: func(
+				context.Context,
+				sdk.Event,
+				*sdk.EventCreateOptions,
+			) (sdk.EventList, error) {
+				// Inspect in-flight work using a context that is already canceled
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				inFlight = shutdownTracker.Wait(ctx)
+				return sdk.EventList{}, nil
+			},
+		}),
+		auditSink:          audit.NewNopSink(),
+		concurrencyLimiter: NewConcurrencyLimiter(ConcurrencyLimiterConfig{}),
+		enricher:           NewEnricher(EnricherConfig{}),
+		promoter:           &promoter{},
+		shutdownTracker:    shutdownTracker,
+	}
+	err := s.Handle(context.Background(), cloudEvents.NewEvent())
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{shutdownKindCloudEvent: 1}, inFlight)
+	require.Empty(t, shutdownTracker.Wait(context.Background()))
+}
+
+func TestHandleEnrichment(t *testing.T) {
+	testCloudEvent := cloudEvents.NewEvent()
+	testCloudEvent.SetID("1234")
+	testCloudEvent.SetSource("foo")
+	testCloudEvent.SetType("bar")
+	var createdEvent sdk.Event
+	s := &service{
+		router: newTestRouter(&sdkTesting.MockEventsClient{
+			CreateFn: func(
+				_ context.Context,
+				event sdk.Event,
+				_ *sdk.EventCreateOptions,
+			) (sdk.EventList, error) {
+				createdEvent = event
+				return sdk.EventList{}, nil
+			},
+		}),
+		auditSink:          audit.NewNopSink(),
+		concurrencyLimiter: NewConcurrencyLimiter(ConcurrencyLimiterConfig{}),
+		enricher: NewEnricher(
+			EnricherConfig{
+				Labels:     []EnrichmentField{EnrichmentFieldPod},
+				Extensions: []EnrichmentField{EnrichmentFieldPod},
+				PodName:    "gateway-abcde",
+			},
+		),
+		promoter:        &promoter{},
+		shutdownTracker: shutdown.NewTracker(),
+	}
+	err := s.Handle(context.Background(), testCloudEvent)
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		map[string]string{"gatewaypod": "gateway-abcde"},
+		createdEvent.Labels,
+	)
+	// The extension attribute should be included in the payload
+	payloadEvent := cloudEvents.NewEvent()
+	err = json.Unmarshal([]byte(createdEvent.Payload), &payloadEvent)
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		map[string]interface{}{"gatewaypod": "gateway-abcde"},
+		payloadEvent.Extensions(),
+	)
+	// The caller's CloudEvent should be unmodified
+	require.Empty(t, testCloudEvent.Extensions())
+}
+
+func TestHandleInvalidAttribute(t *testing.T) {
+	testCloudEvent := cloudEvents.NewEvent()
+	testCloudEvent.SetID("1234")
+	testCloudEvent.SetSource("invalid")
+	testCloudEvent.SetType("test")
+	testCloudEvent.SetExtension("repo", "foo,bar")
+	promoter, err := NewPromoter(PromoterConfig{Qualifiers: []string{"repo"}})
+	require.NoError(t, err)
+	auditSink := &mockAuditSink{}
+	s := &service{
+		router: newTestRouter(&sdkTesting.MockEventsClient{
+			CreateFn: func(
+				context.Context,
+				sdk.Event,
+				*sdk.EventCreateOptions,
+			) (sdk.EventList, error) {
+				require.Fail(t, "the Brigade API should not have been called")
+				return sdk.EventList{}, nil
+			},
+		}),
+		auditSink:          auditSink,
+		concurrencyLimiter: NewConcurrencyLimiter(ConcurrencyLimiterConfig{}),
+		enricher:           NewEnricher(EnricherConfig{}),
+		promoter:           promoter,
+		shutdownTracker:    shutdown.NewTracker(),
+	}
+	counter := eventsTotal.WithLabelValues(eventResultInvalid)
+	before := testutil.ToFloat64(counter)
+	err = s.Handle(context.Background(), testCloudEvent)
+	var attrErr *AttributeError
+	require.True(t, errors.As(err, &attrErr))
+	require.Equal(t, "repo", attrErr.Attribute)
+	require.Equal(t, before+1, testutil.ToFloat64(counter))
+	require.Equal(
+		t,
+		[]audit.Record{
+			{
+				Decision:         audit.DecisionRejected,
+				Reason:           "invalid_attribute",
+				CloudEventID:     "1234",
+				CloudEventSource: "invalid",
+				CloudEventType:   "test",
+			},
+		},
+		auditSink.records,
+	)
+}
+
+func TestHandleFanOut(t *testing.T) {
+	testCloudEvent := cloudEvents.NewEvent()
+	testCloudEvent.SetID("1234")
+	testCloudEvent.SetSource("foo")
+	testCloudEvent.SetType("bar")
+	newEventsClient := func(id string, err error) sdk.EventsClient {
+		return &sdkTesting.MockEventsClient{
+			CreateFn: func(
+				context.Context,
+				sdk.Event,
+				*sdk.EventCreateOptions,
+			) (sdk.EventList, error) {
+				if err != nil {
+					return sdk.EventList{}, err
+				}
+				return sdk.EventList{
+					Items: []sdk.Event{
+						{
+							ObjectMeta: meta.ObjectMeta{ID: id},
+							ProjectID:  "italian",
+						},
+					},
+				}, nil
+			},
+		}
+	}
+	testCases := []struct {
+		name       string
+		backends   []Backend
+		assertions func(reply *cloudEvents.Event, records []audit.Record, err error)
+	}{
+		{
+			name: "all backends succeed",
+			backends: []Backend{
+				{Name: "staging", EventsClient: newEventsClient("abc", nil)},
+				{Name: "production", EventsClient: newEventsClient("def", nil)},
+			},
+			assertions: func(
+				reply *cloudEvents.Event,
+				records []audit.Record,
+				err error,
+			) {
+				require.NoError(t, err)
+				data := ReplyData{}
+				require.NoError(t, reply.DataAs(&data))
+				require.Equal(
+					t,
+					[]CreatedEvent{
+						{ID: "abc", ProjectID: "italian", Backend: "staging"},
+						{ID: "def", ProjectID: "italian", Backend: "production"},
+					},
+					data.Events,
+				)
+				require.Len(t, records, 1)
+				require.Equal(t, audit.DecisionAccepted, records[0].Decision)
+				require.Equal(t, []string{"abc", "def"}, records[0].BrigadeEventIDs)
+			},
+		},
+		{
+			name: "one backend fails",
+			backends: []Backend{
+				{
+					Name:         "staging",
+					EventsClient: newEventsClient("", errors.New("something went wrong")),
+				},
+				{Name: "production", EventsClient: newEventsClient("def", nil)},
+			},
+			assertions: func(
+				_ *cloudEvents.Event,
+				records []audit.Record,
+				err error,
+			) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `error sending to backend "staging"`)
+				require.Contains(t, err.Error(), "something went wrong")
+				require.Len(t, records, 1)
+				require.Equal(t, audit.DecisionFailed, records[0].Decision)
+				// Events created in other backends are still recorded
+				require.Equal(t, []string{"def"}, records[0].BrigadeEventIDs)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			auditSink := &mockAuditSink{}
+			s := &service{
+				router:             &router{defaultBackend: testCase.backends},
+				auditSink:          auditSink,
+				concurrencyLimiter: NewConcurrencyLimiter(ConcurrencyLimiterConfig{}),
+				enricher:           NewEnricher(EnricherConfig{}),
+				promoter:           &promoter{},
+				shutdownTracker:    shutdown.NewTracker(),
+				partialFanOuts:     newPartialFanOuts(),
+			}
+			reply, err := s.HandleWithReply(context.Background(), testCloudEvent)
+			testCase.assertions(reply, auditSink.records, err)
+		})
+	}
+}
+
+func TestHandleFanOutRetry(t *testing.T) {
+	testCloudEvent := cloudEvents.NewEvent()
+	testCloudEvent.SetID("1234")
+	testCloudEvent.SetSource("foo")
+	testCloudEvent.SetType("bar")
+	var stagingCreates, productionCreates int
+	newEventsClient := func(
+		backend string,
+		creates *int,
+		failures int,
+	) sdk.EventsClient {
+		return &sdkTesting.MockEventsClient{
+			CreateFn: func(
+				context.Context,
+				sdk.Event,
+				*sdk.EventCreateOptions,
+			) (sdk.EventList, error) {
+				if *creates++; *creates <= failures {
+					return sdk.EventList{}, errors.New("something went wrong")
+				}
+				return sdk.EventList{
+					Items: []sdk.Event{
+						{
+							ObjectMeta: meta.ObjectMeta{ID: backend},
+							ProjectID:  "italian",
+						},
+					},
+				}, nil
+			},
+		}
+	}
+	stagingClient := newEventsClient("staging", &stagingCreates, 1)
+	productionClient := newEventsClient("production", &productionCreates, 0)
+	s := &service{
+		router: &router{
+			defaultBackend: []Backend{
+				{Name: "staging", EventsClient: stagingClient},
+				{Name: "production", EventsClient: productionClient},
+			},
+		},
+		auditSink:          audit.NewNopSink(),
+		concurrencyLimiter: NewConcurrencyLimiter(ConcurrencyLimiterConfig{}),
+		enricher:           NewEnricher(EnricherConfig{}),
+		promoter:           &promoter{},
+		shutdownTracker:    shutdown.NewTracker(),
+		partialFanOuts:     newPartialFanOuts(),
+	}
+	italianCtx := audit.ContextWithRequest(
+		context.Background(),
+		audit.Request{Tenant: "italian", Sender: "ci"},
+	)
+	_, err := s.HandleWithReply(italianCtx, testCloudEvent)
+	require.Error(t, err)
+	// Concurrent retries are handled one at a time
+	replies := make([]*cloudEvents.Event, 2)
+	errs := make([]error, 2)
+	wg := sync.WaitGroup{}
+	for i := range replies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			replies[i], errs[i] = s.HandleWithReply(italianCtx, testCloudEvent)
+		}(i)
+	}
+	wg.Wait()
+	// The Brigade Event should have been created in each backend only once
+	require.Equal(t, 2, stagingCreates)
+	require.Equal(t, 1, productionCreates)
+	for i, reply := range replies {
+		require.NoError(t, errs[i])
+		data := ReplyData{}
+		require.NoError(t, reply.DataAs(&data))
+		require.Equal(
+			t,
+			[]CreatedEvent{
+				{ID: "staging", ProjectID: "italian", Backend: "staging"},
+				{ID: "production", ProjectID: "italian", Backend: "production"},
+			},
+			data.Events,
+		)
+	}
+	// The same CloudEvent sent by another tenant is sent to every backend
+	_, err = s.HandleWithReply(
+		audit.ContextWithRequest(
+			context.Background(),
+			audit.Request{Tenant: "mexican", Sender: "ci"},
+		),
+		testCloudEvent,
+	)
+	require.NoError(t, err)
+	require.Equal(t, 3, stagingCreates)
+	require.Equal(t, 2, productionCreates)
+}
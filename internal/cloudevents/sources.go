@@ -0,0 +1,111 @@
+package cloudevents
+
+import (
+	"context"
+	"strings"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// ErrSourceNotAllowed is returned when a CloudEvent cannot be handled because
+// its source is not among those the sender is allowed to send CloudEvents
+// from.
+var ErrSourceNotAllowed = errors.New("CloudEvent source is not allowed")
+
+type sourceFilteringService struct {
+	service        Service
+	allowedSources []string
+	auditSink      audit.Sink
+}
+
+// NewSourceFilteringService returns a Service that hands CloudEvents to the
+// provided Service only if their source matches one of the allowed sources.
+// An allowed source matches a CloudEvent's source exactly unless it ends with
+// "*", in which case it matches any source beginning with the characters that
+// precede it. CloudEvents from other sources are recorded using the provided
+// audit.Sink and ErrSourceNotAllowed is returned. If no allowed sources are
+// specified, the provided Service is returned unmodified.
+func NewSourceFilteringService(
+	service Service,
+	allowedSources []string,
+	auditSink audit.Sink,
+) Service {
+	if len(allowedSources) == 0 {
+		return service
+	}
+	return &sourceFilteringService{
+		service:        service,
+		allowedSources: allowedSources,
+		auditSink:      auditSink,
+	}
+}
+
+func (s *sourceFilteringService) Handle(
+	ctx context.Context,
+	event cloudEvents.Event,
+) error {
+	if err := s.checkSource(ctx, event); err != nil {
+		return err
+	}
+	return s.service.Handle(ctx, event)
+}
+
+func (s *sourceFilteringService) HandleWithReply(
+	ctx context.Context,
+	event cloudEvents.Event,
+) (*cloudEvents.Event, error) {
+	if err := s.checkSource(ctx, event); err != nil {
+		return nil, err
+	}
+	return s.service.HandleWithReply(ctx, event)
+}
+
+// checkSource returns ErrSourceNotAllowed if the source of the provided
+// CloudEvent does not match any of the allowed sources.
+func (s *sourceFilteringService) checkSource(
+	ctx context.Context,
+	event cloudEvents.Event,
+) error {
+	if matchesAny(s.allowedSources, event.Source()) {
+		return nil
+	}
+	logging.FromContext(ctx).Info(
+		"rejecting cloud event from source that is not allowed",
+		zap.String("cloudEventID", event.ID()),
+		zap.String("cloudEventSource", event.Source()),
+		zap.String("cloudEventType", event.Type()),
+	)
+	eventsTotal.WithLabelValues(eventResultForbidden).Inc()
+	s.auditSink.Write(
+		ctx,
+		audit.Record{
+			Decision:         audit.DecisionRejected,
+			Reason:           "source_not_allowed",
+			CloudEventID:     event.ID(),
+			CloudEventSource: event.Source(),
+			CloudEventType:   event.Type(),
+		},
+	)
+	return ErrSourceNotAllowed
+}
+
+// matchesAny returns a bool indicating whether the provided value matches any
+// of the provided patterns. A pattern matches a value exactly unless it ends
+// with "*", in which case it matches any value beginning with the characters
+// that precede it.
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if prefix := strings.TrimSuffix(pattern, "*"); prefix != pattern {
+			if strings.HasPrefix(value, prefix) {
+				return true
+			}
+		} else if value == pattern {
+			return true
+		}
+	}
+	return false
+}
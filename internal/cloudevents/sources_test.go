@@ -0,0 +1,136 @@
+package cloudevents
+
+import (
+	"context"
+	"testing"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/require"
+)
+
+type mockService struct {
+	handled int
+}
+
+func (m *mockService) Handle(context.Context, cloudEvents.Event) error {
+	m.handled++
+	return nil
+}
+
+func (m *mockService) HandleWithReply(
+	context.Context,
+	cloudEvents.Event,
+) (*cloudEvents.Event, error) {
+	m.handled++
+	reply := cloudEvents.NewEvent()
+	return &reply, nil
+}
+
+func TestNewSourceFilteringService(t *testing.T) {
+	testService := &mockService{}
+	// With no allowed sources, the service should be returned unmodified
+	require.Same(
+		t,
+		testService,
+		NewSourceFilteringService(testService, nil, &mockAuditSink{}),
+	)
+	s, ok := NewSourceFilteringService(
+		testService,
+		[]string{"example/uri"},
+		&mockAuditSink{},
+	).(*sourceFilteringService)
+	require.True(t, ok)
+	require.Same(t, testService, s.service)
+	require.Equal(t, []string{"example/uri"}, s.allowedSources)
+	require.NotNil(t, s.auditSink)
+}
+
+func TestSourceFilteringServiceHandle(t *testing.T) {
+	testCases := []struct {
+		name           string
+		allowedSources []string
+		source         string
+		assertions     func(handled int, auditSink *mockAuditSink, err error)
+	}{
+		{
+			name:           "exact match",
+			allowedSources: []string{"example/foo", "example/uri"},
+			source:         "example/uri",
+			assertions: func(handled int, auditSink *mockAuditSink, err error) {
+				require.NoError(t, err)
+				require.Equal(t, 1, handled)
+				require.Empty(t, auditSink.records)
+			},
+		},
+		{
+			name:           "prefix match",
+			allowedSources: []string{"example/*"},
+			source:         "example/uri",
+			assertions: func(handled int, auditSink *mockAuditSink, err error) {
+				require.NoError(t, err)
+				require.Equal(t, 1, handled)
+				require.Empty(t, auditSink.records)
+			},
+		},
+		{
+			name:           "no match",
+			allowedSources: []string{"example/foo", "example/uri/*"},
+			source:         "example/uri",
+			assertions: func(handled int, auditSink *mockAuditSink, err error) {
+				require.ErrorIs(t, err, ErrSourceNotAllowed)
+				require.Zero(t, handled)
+				require.Len(t, auditSink.records, 1)
+				require.Equal(
+					t,
+					audit.DecisionRejected,
+					auditSink.records[0].Decision,
+				)
+				require.Equal(t, "source_not_allowed", auditSink.records[0].Reason)
+				require.Equal(
+					t,
+					"example/uri",
+					auditSink.records[0].CloudEventSource,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testService := &mockService{}
+			auditSink := &mockAuditSink{}
+			s := NewSourceFilteringService(
+				testService,
+				testCase.allowedSources,
+				auditSink,
+			)
+			event := cloudEvents.NewEvent()
+			event.SetID("1234")
+			event.SetSource(testCase.source)
+			event.SetType("example.type")
+			err := s.Handle(context.Background(), event)
+			testCase.assertions(testService.handled, auditSink, err)
+		})
+	}
+}
+
+func TestSourceFilteringServiceHandleWithReply(t *testing.T) {
+	testService := &mockService{}
+	auditSink := &mockAuditSink{}
+	s := NewSourceFilteringService(
+		testService,
+		[]string{"example/uri"},
+		auditSink,
+	)
+	event := cloudEvents.NewEvent()
+	event.SetSource("example/uri")
+	reply, err := s.HandleWithReply(context.Background(), event)
+	require.NoError(t, err)
+	require.NotNil(t, reply)
+	event.SetSource("example/foo")
+	reply, err = s.HandleWithReply(context.Background(), event)
+	require.ErrorIs(t, err, ErrSourceNotAllowed)
+	require.Nil(t, reply)
+	require.Equal(t, 1, testService.handled)
+	require.Len(t, auditSink.records, 1)
+}
@@ -0,0 +1,78 @@
+package cloudevents
+
+import (
+	"context"
+
+	"github.com/brigadecore/brigade/sdk/v3"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/extensions"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer(
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents",
+)
+
+// startHandleSpan starts a span for handling the provided CloudEvent. If the
+// CloudEvent carries the CloudEvents distributed tracing extension, the trace
+// context it contains (i.e. the context in which the CloudEvent was created)
+// becomes the span's parent and the span is linked to any span already present
+// in the provided context (e.g. the span for the request that delivered the
+// CloudEvent). Otherwise, the span already present in the provided context, if
+// any, becomes the span's parent.
+func startHandleSpan(
+	ctx context.Context,
+	event cloudEvents.Event,
+) (context.Context, trace.Span) {
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.CloudeventsEventIDKey.String(event.ID()),
+			semconv.CloudeventsEventSourceKey.String(event.Source()),
+			semconv.CloudeventsEventSpecVersionKey.String(event.SpecVersion()),
+			semconv.CloudeventsEventTypeKey.String(event.Type()),
+		),
+	}
+	if event.Subject() != "" {
+		opts = append(
+			opts,
+			trace.WithAttributes(
+				semconv.CloudeventsEventSubjectKey.String(event.Subject()),
+			),
+		)
+	}
+	if dte, ok := extensions.GetDistributedTracingExtension(event); ok {
+		parentCtx := propagation.TraceContext{}.Extract(
+			ctx,
+			propagation.MapCarrier{
+				extensions.TraceParentExtension: dte.TraceParent,
+				extensions.TraceStateExtension:  dte.TraceState,
+			},
+		)
+		if parent := trace.SpanContextFromContext(parentCtx); parent.IsValid() &&
+			parent.IsRemote() {
+			if link := trace.LinkFromContext(ctx); link.SpanContext.IsValid() {
+				opts = append(opts, trace.WithLinks(link))
+			}
+			ctx = parentCtx
+		}
+	}
+	return tracer.Start(ctx, "cloudevents.handle", opts...)
+}
+
+// traceSourceState returns Brigade Event source state containing the trace
+// context found in the provided context, so that Brigade Workers may continue
+// the trace. If there is no valid trace context, nil is returned.
+func traceSourceState(ctx context.Context) *sdk.SourceState {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return &sdk.SourceState{
+		State: carrier,
+	}
+}
@@ -0,0 +1,112 @@
+package cloudevents
+
+import (
+	"context"
+	"testing"
+
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdkTrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	testTraceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testSpanID      = "00f067aa0ba902b7"
+)
+
+func TestStartHandleSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracerProvider := sdkTrace.NewTracerProvider(
+		sdkTrace.WithSpanProcessor(recorder),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	testCases := []struct {
+		name       string
+		setup      func() cloudEvents.Event
+		assertions func(transport trace.SpanContext, handle sdkTrace.ReadOnlySpan)
+	}{
+		{
+			name: "CloudEvent without distributed tracing extension",
+			setup: func() cloudEvents.Event {
+				event := cloudEvents.NewEvent()
+				event.SetID("1234")
+				event.SetSource("example/uri")
+				event.SetType("example.type")
+				return event
+			},
+			assertions: func(
+				transport trace.SpanContext,
+				handle sdkTrace.ReadOnlySpan,
+			) {
+				require.Equal(t, transport.SpanID(), handle.Parent().SpanID())
+				require.Empty(t, handle.Links())
+			},
+		},
+		{
+			name: "CloudEvent with distributed tracing extension",
+			setup: func() cloudEvents.Event {
+				event := cloudEvents.NewEvent()
+				event.SetID("1234")
+				event.SetSource("example/uri")
+				event.SetType("example.type")
+				event.SetExtension("traceparent", testTraceParent)
+				return event
+			},
+			assertions: func(
+				transport trace.SpanContext,
+				handle sdkTrace.ReadOnlySpan,
+			) {
+				require.Equal(t, testTraceID, handle.Parent().TraceID().String())
+				require.Equal(t, testSpanID, handle.Parent().SpanID().String())
+				require.Len(t, handle.Links(), 1)
+				require.Equal(
+					t,
+					transport.SpanID(),
+					handle.Links()[0].SpanContext.SpanID(),
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			event := testCase.setup()
+			ctx, transportSpan := tracerProvider.Tracer("test").Start(
+				context.Background(),
+				"transport",
+			)
+			_, handleSpan := startHandleSpan(ctx, event)
+			handleSpan.End()
+			transportSpan.End()
+			spans := recorder.Ended()
+			handle := spans[len(spans)-2]
+			require.Equal(t, "cloudevents.handle", handle.Name())
+			testCase.assertions(transportSpan.SpanContext(), handle)
+		})
+	}
+}
+
+func TestTraceSourceState(t *testing.T) {
+	require.Nil(t, traceSourceState(context.Background()))
+	traceID, err := trace.TraceIDFromHex(testTraceID)
+	require.NoError(t, err)
+	spanID, err := trace.SpanIDFromHex(testSpanID)
+	require.NoError(t, err)
+	ctx := trace.ContextWithSpanContext(
+		context.Background(),
+		trace.NewSpanContext(
+			trace.SpanContextConfig{
+				TraceID:    traceID,
+				SpanID:     spanID,
+				TraceFlags: trace.FlagsSampled,
+			},
+		),
+	)
+	sourceState := traceSourceState(ctx)
+	require.NotNil(t, sourceState)
+	require.Equal(t, testTraceParent, sourceState.State["traceparent"])
+}
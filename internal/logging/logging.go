@@ -0,0 +1,113 @@
+package logging
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Format represents the format of log output.
+type Format string
+
+const (
+	// FormatJSON represents log output wherein each line is a JSON object.
+	FormatJSON Format = "json"
+	// FormatText represents human-readable log output.
+	FormatText Format = "text"
+)
+
+// Config encapsulates configuration for the logger.
+type Config struct {
+	// Level is the minimum level of messages that are logged.
+	Level zapcore.Level
+	// Format is the format of log output.
+	Format Format
+}
+
+// NewLogger returns a leveled, structured logger configured as specified.
+func NewLogger(config Config) (*zap.Logger, error) {
+	var zapConfig zap.Config
+	switch config.Format {
+	case FormatJSON, "":
+		zapConfig = zap.NewProductionConfig()
+		zapConfig.EncoderConfig.TimeKey = "time"
+		zapConfig.EncoderConfig.EncodeTime = zapcore.RFC3339NanoTimeEncoder
+	case FormatText:
+		zapConfig = zap.NewDevelopmentConfig()
+		// The development config's panic on DPanic and its stack traces on
+		// warnings are not desirable outside of development
+		zapConfig.Development = false
+		zapConfig.DisableStacktrace = true
+	default:
+		return nil, errors.Errorf("unrecognized log format %q", config.Format)
+	}
+	zapConfig.Level = zap.NewAtomicLevelAt(config.Level)
+	// Sampling drops log lines, which defeats the purpose of per-request
+	// logging
+	zapConfig.Sampling = nil
+	return zapConfig.Build()
+}
+
+// Field names that are used consistently across components.
+const (
+	requestIDKey      = "requestID"
+	senderKey         = "sender"
+	tenantKey         = "tenant"
+	brigadeEventIDKey = "brigadeEventID"
+)
+
+// RequestID returns a field identifying the request in the course of which a
+// message was logged.
+func RequestID(requestID string) zap.Field {
+	return zap.String(requestIDKey, requestID)
+}
+
+// Sender returns a field identifying the authenticated sender of the
+// CloudEvent(s) in the course of whose handling a message was logged.
+func Sender(sender string) zap.Field {
+	return zap.String(senderKey, sender)
+}
+
+// Tenant returns a field identifying the tenant to whose endpoint the request
+// in the course of which a message was logged was made.
+func Tenant(tenant string) zap.Field {
+	return zap.String(tenantKey, tenant)
+}
+
+// BrigadeEventID returns a field identifying a Brigade Event.
+func BrigadeEventID(id string) zap.Field {
+	return zap.String(brigadeEventIDKey, id)
+}
+
+type loggerContextKey struct{}
+
+// ContextWithLogger returns a copy of the provided context that carries the
+// provided logger.
+func ContextWithLogger(
+	ctx context.Context,
+	logger *zap.Logger,
+) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, logger)
+}
+
+// ContextWithFields returns a copy of the provided context that carries a
+// logger derived from the one carried by the provided context, with the
+// provided fields added. Every message logged using the derived logger
+// includes those fields.
+func ContextWithFields(
+	ctx context.Context,
+	fields ...zap.Field,
+) context.Context {
+	return ContextWithLogger(ctx, FromContext(ctx).With(fields...))
+}
+
+// FromContext returns the logger carried by the provided context. If the
+// context does not carry a logger, the global logger is returned.
+func FromContext(ctx context.Context) *zap.Logger {
+	if logger, ok := ctx.Value(loggerContextKey{}).(*zap.Logger); ok {
+		return logger
+	}
+	return zap.L()
+}
@@ -0,0 +1,87 @@
+package logging
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestNewLogger(t *testing.T) {
+	testCases := []struct {
+		name       string
+		config     Config
+		assertions func(*zap.Logger, error)
+	}{
+		{
+			name: "unrecognized format",
+			config: Config{
+				Format: "bogus",
+			},
+			assertions: func(_ *zap.Logger, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `unrecognized log format "bogus"`)
+			},
+		},
+		{
+			name: "json format",
+			config: Config{
+				Level:  zapcore.WarnLevel,
+				Format: FormatJSON,
+			},
+			assertions: func(logger *zap.Logger, err error) {
+				require.NoError(t, err)
+				require.False(t, logger.Core().Enabled(zapcore.InfoLevel))
+				require.True(t, logger.Core().Enabled(zapcore.WarnLevel))
+			},
+		},
+		{
+			name: "text format",
+			config: Config{
+				Level:  zapcore.DebugLevel,
+				Format: FormatText,
+			},
+			assertions: func(logger *zap.Logger, err error) {
+				require.NoError(t, err)
+				require.True(t, logger.Core().Enabled(zapcore.DebugLevel))
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			logger, err := NewLogger(testCase.config)
+			testCase.assertions(logger, err)
+		})
+	}
+}
+
+func TestContextWithFields(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	ctx := ContextWithLogger(context.Background(), zap.New(core))
+	ctx = ContextWithFields(ctx, RequestID("1234"))
+	ctx = ContextWithFields(ctx, Sender("example/uri"))
+	FromContext(ctx).Info("foo")
+	require.Equal(t, 1, logs.Len())
+	require.Equal(
+		t,
+		map[string]interface{}{
+			"requestID": "1234",
+			"sender":    "example/uri",
+		},
+		logs.All()[0].ContextMap(),
+	)
+}
+
+func TestFromContext(t *testing.T) {
+	// With no logger in the context, the global logger should be returned
+	require.Same(t, zap.L(), FromContext(context.Background()))
+	logger := zap.NewNop()
+	require.Same(
+		t,
+		logger,
+		FromContext(ContextWithLogger(context.Background(), logger)),
+	)
+}
@@ -0,0 +1,104 @@
+package notifications
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/brigadecore/brigade/sdk/v3"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	workerNotificationTypePrefix = "sh.brigade.worker."
+	jobNotificationTypePrefix    = "sh.brigade.job."
+)
+
+// notificationTypeSuffixes maps the Worker and Job phases that notifications
+// are sent for to suffixes for the notifications' types. Worker and Job phases
+// share the same string values.
+var notificationTypeSuffixes = map[string]string{
+	string(sdk.WorkerPhaseRunning):   "started",
+	string(sdk.WorkerPhaseSucceeded): "succeeded",
+	string(sdk.WorkerPhaseFailed):    "failed",
+	string(sdk.WorkerPhaseTimedOut):  "timed_out",
+}
+
+// notificationData is the data of every notification.
+type notificationData struct {
+	ProjectID string     `json:"projectID"`
+	EventID   string     `json:"eventID"`
+	Job       string     `json:"job,omitempty"`
+	Phase     string     `json:"phase"`
+	Started   *time.Time `json:"started,omitempty"`
+	Ended     *time.Time `json:"ended,omitempty"`
+}
+
+// newWorkerNotification returns a notification for the provided Event's Worker
+// having entered the phase indicated by the provided WorkerStatus. If no
+// notification is sent for that phase, nil is returned.
+func newWorkerNotification(
+	event sdk.Event,
+	status sdk.WorkerStatus,
+) *cloudEvents.Event {
+	suffix, ok := notificationTypeSuffixes[string(status.Phase)]
+	if !ok {
+		return nil
+	}
+	return newNotification(
+		workerNotificationTypePrefix+suffix,
+		fmt.Sprintf("events/%s", event.ID),
+		notificationData{
+			ProjectID: event.ProjectID,
+			EventID:   event.ID,
+			Phase:     string(status.Phase),
+			Started:   status.Started,
+			Ended:     status.Ended,
+		},
+	)
+}
+
+// newJobNotification returns a notification for the specified Job having
+// entered the phase indicated by the provided JobStatus. If no notification is
+// sent for that phase, nil is returned.
+func newJobNotification(
+	event sdk.Event,
+	jobName string,
+	status sdk.JobStatus,
+) *cloudEvents.Event {
+	suffix, ok := notificationTypeSuffixes[string(status.Phase)]
+	if !ok {
+		return nil
+	}
+	return newNotification(
+		jobNotificationTypePrefix+suffix,
+		fmt.Sprintf("events/%s/jobs/%s", event.ID, jobName),
+		notificationData{
+			ProjectID: event.ProjectID,
+			EventID:   event.ID,
+			Job:       jobName,
+			Phase:     string(status.Phase),
+			Started:   status.Started,
+			Ended:     status.Ended,
+		},
+	)
+}
+
+func newNotification(
+	eventType string,
+	subject string,
+	data notificationData,
+) *cloudEvents.Event {
+	notification := cloudEvents.NewEvent()
+	notification.SetID(uuid.New().String())
+	notification.SetSource(fmt.Sprintf("brigade.sh/projects/%s", data.ProjectID))
+	notification.SetType(eventType)
+	notification.SetSubject(subject)
+	notification.SetTime(time.Now())
+	// Marshaling notificationData cannot fail
+	notification.SetData( // nolint: errcheck
+		cloudEvents.ApplicationJSON,
+		data,
+	)
+	return &notification
+}
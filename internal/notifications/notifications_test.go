@@ -0,0 +1,83 @@
+package notifications
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/brigadecore/brigade/sdk/v3"
+	"github.com/brigadecore/brigade/sdk/v3/meta"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewWorkerNotification(t *testing.T) {
+	testEvent := sdk.Event{
+		ObjectMeta: meta.ObjectMeta{
+			ID: "123456789",
+		},
+		ProjectID: "italian",
+	}
+	require.Nil(
+		t,
+		newWorkerNotification(
+			testEvent,
+			sdk.WorkerStatus{Phase: sdk.WorkerPhasePending},
+		),
+	)
+	notification := newWorkerNotification(
+		testEvent,
+		sdk.WorkerStatus{Phase: sdk.WorkerPhaseTimedOut},
+	)
+	require.NotNil(t, notification)
+	require.NoError(t, notification.Validate())
+	require.Equal(t, "sh.brigade.worker.timed_out", notification.Type())
+	require.Equal(t, "brigade.sh/projects/italian", notification.Source())
+	require.Equal(t, "events/123456789", notification.Subject())
+	data := notificationData{}
+	require.NoError(t, json.Unmarshal(notification.Data(), &data))
+	require.Equal(
+		t,
+		notificationData{
+			ProjectID: "italian",
+			EventID:   "123456789",
+			Phase:     string(sdk.WorkerPhaseTimedOut),
+		},
+		data,
+	)
+}
+
+func TestNewJobNotification(t *testing.T) {
+	testEvent := sdk.Event{
+		ObjectMeta: meta.ObjectMeta{
+			ID: "123456789",
+		},
+		ProjectID: "italian",
+	}
+	require.Nil(
+		t,
+		newJobNotification(
+			testEvent,
+			"foo",
+			sdk.JobStatus{Phase: sdk.JobPhasePending},
+		),
+	)
+	started := time.Date(2021, time.August, 3, 19, 13, 37, 0, time.UTC)
+	notification := newJobNotification(
+		testEvent,
+		"foo",
+		sdk.JobStatus{
+			Phase:   sdk.JobPhaseRunning,
+			Started: &started,
+		},
+	)
+	require.NotNil(t, notification)
+	require.NoError(t, notification.Validate())
+	require.Equal(t, "sh.brigade.job.started", notification.Type())
+	require.Equal(t, "brigade.sh/projects/italian", notification.Source())
+	require.Equal(t, "events/123456789/jobs/foo", notification.Subject())
+	data := notificationData{}
+	require.NoError(t, json.Unmarshal(notification.Data(), &data))
+	require.Equal(t, "foo", data.Job)
+	require.Equal(t, string(sdk.JobPhaseRunning), data.Phase)
+	require.True(t, started.Equal(*data.Started))
+}
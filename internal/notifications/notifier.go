@@ -0,0 +1,301 @@
+package notifications
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	"github.com/brigadecore/brigade/sdk/v3"
+	"github.com/brigadecore/brigade/sdk/v3/meta"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// SinkMode represents the CloudEvents HTTP content mode used when sending
+// notifications to a sink.
+type SinkMode string
+
+const (
+	// SinkModeBinary represents binary content mode, wherein CloudEvent
+	// attributes are sent as HTTP headers and the request body contains only
+	// the event data.
+	SinkModeBinary SinkMode = "binary"
+	// SinkModeStructured represents structured content mode, wherein the
+	// request body contains the entire CloudEvent.
+	SinkModeStructured SinkMode = "structured"
+)
+
+// Sink represents a destination that notifications are sent to.
+type Sink struct {
+	// URL is the address notifications are POSTed to.
+	URL string `json:"url"`
+	// Mode is the CloudEvents HTTP content mode notifications are sent in. If
+	// not specified, binary mode is used.
+	Mode SinkMode `json:"mode,omitempty"`
+}
+
+// NotifierConfig encapsulates configuration for the Notifier.
+type NotifierConfig struct {
+	// ProjectIDs are the identifiers of the Brigade Projects whose Workers and
+	// Jobs should be watched.
+	ProjectIDs []string
+	// Sinks are the destinations notifications are sent to.
+	Sinks []Sink
+	// PollInterval is how often the Brigade API is polled for new Events and
+	// Jobs to watch.
+	PollInterval time.Duration
+}
+
+// Notifier is an interface for components that watch Brigade Workers and Jobs
+// and send CloudEvents to configured sinks when their state changes.
+type Notifier interface {
+	// Run watches Workers and Jobs and sends notifications until the provided
+	// context is canceled. This function always returns a non-nil error.
+	Run(context.Context) error
+}
+
+// sender is an interface for the subset of the CloudEvents client's
+// functionality used by the notifier.
+type sender interface {
+	Send(context.Context, cloudEvents.Event) cloudEvents.Result
+}
+
+type notifier struct {
+	config       NotifierConfig
+	eventsClient sdk.EventsClient
+	sender       sender
+	// watched tracks the Events whose Workers (and those Workers' Jobs) are
+	// currently being watched.
+	watched   map[string]*watchedEvent
+	watchedMu sync.Mutex
+}
+
+// watchedEvent tracks the Jobs being watched for a single Event.
+type watchedEvent struct {
+	jobs map[string]struct{}
+}
+
+// NewNotifier returns an implementation of the Notifier interface that uses the
+// provided sdk.EventsClient to watch Workers and Jobs.
+func NewNotifier(
+	config NotifierConfig,
+	eventsClient sdk.EventsClient,
+) (Notifier, error) {
+	if config.PollInterval <= 0 {
+		config.PollInterval = 10 * time.Second
+	}
+	client, err := cloudEvents.NewClientHTTP()
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating CloudEvents client")
+	}
+	return &notifier{
+		config:       config,
+		eventsClient: eventsClient,
+		sender:       client,
+		watched:      map[string]*watchedEvent{},
+	}, nil
+}
+
+func (n *notifier) Run(ctx context.Context) error {
+	ticker := time.NewTicker(n.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		for _, projectID := range n.config.ProjectIDs {
+			if err := n.poll(ctx, projectID); err != nil {
+				logging.FromContext(ctx).Error(
+					"error polling for events",
+					zap.String("projectID", projectID),
+					zap.Error(err),
+				)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// poll lists all of the specified Project's Events whose Workers have not yet
+// reached a terminal phase and begins watching any of those Events' Workers
+// and Jobs that are not already being watched.
+func (n *notifier) poll(ctx context.Context, projectID string) error {
+	opts := &meta.ListOptions{}
+	for {
+		events, err := n.eventsClient.List(
+			ctx,
+			&sdk.EventsSelector{
+				ProjectID:    projectID,
+				WorkerPhases: sdk.WorkerPhasesNonTerminal(),
+			},
+			opts,
+		)
+		if err != nil {
+			return err
+		}
+		for _, event := range events.Items {
+			n.watch(ctx, event)
+		}
+		if events.Continue == "" {
+			return nil
+		}
+		opts.Continue = events.Continue
+	}
+}
+
+// watch begins watching the provided Event's Worker and Jobs if they are not
+// already being watched.
+func (n *notifier) watch(ctx context.Context, event sdk.Event) {
+	n.watchedMu.Lock()
+	defer n.watchedMu.Unlock()
+	we, ok := n.watched[event.ID]
+	if !ok {
+		we = &watchedEvent{
+			jobs: map[string]struct{}{},
+		}
+		n.watched[event.ID] = we
+		go n.watchWorker(ctx, event)
+	}
+	if event.Worker == nil {
+		return
+	}
+	for _, job := range event.Worker.Jobs {
+		if _, ok := we.jobs[job.Name]; !ok {
+			we.jobs[job.Name] = struct{}{}
+			go n.watchJob(ctx, event, job.Name)
+		}
+	}
+}
+
+// watchWorker sends a notification every time the provided Event's Worker
+// enters a notable phase, until the Worker reaches a terminal phase.
+func (n *notifier) watchWorker(ctx context.Context, event sdk.Event) {
+	// Whatever happens, stop tracking this Event when we're done watching its
+	// Worker. If we're done because of an error, this allows the next poll to
+	// try again.
+	defer func() {
+		n.watchedMu.Lock()
+		defer n.watchedMu.Unlock()
+		delete(n.watched, event.ID)
+	}()
+	// Canceling this context when we're done watching ensures the SDK's
+	// goroutine that is streaming status updates also stops.
+	logger := logging.FromContext(ctx).With(
+		zap.String("projectID", event.ProjectID),
+		logging.BrigadeEventID(event.ID),
+	)
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	statusCh, errCh, err :=
+		n.eventsClient.Workers().WatchStatus(watchCtx, event.ID, nil)
+	if err != nil {
+		logger.Error("error watching worker", zap.Error(err))
+		return
+	}
+	var lastPhase sdk.WorkerPhase
+	for {
+		select {
+		case status := <-statusCh:
+			if status.Phase != lastPhase {
+				lastPhase = status.Phase
+				n.notify(
+					ctx,
+					newWorkerNotification(event, status),
+				)
+			}
+			if status.Phase.IsTerminal() {
+				return
+			}
+		case err := <-errCh:
+			logger.Error("error watching worker", zap.Error(err))
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// watchJob sends a notification every time the specified Job enters a notable
+// phase, until the Job reaches a terminal phase.
+func (n *notifier) watchJob(
+	ctx context.Context,
+	event sdk.Event,
+	jobName string,
+) {
+	logger := logging.FromContext(ctx).With(
+		zap.String("projectID", event.ProjectID),
+		logging.BrigadeEventID(event.ID),
+		zap.String("job", jobName),
+	)
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	statusCh, errCh, err := n.eventsClient.Workers().Jobs().WatchStatus(
+		watchCtx,
+		event.ID,
+		jobName,
+		nil,
+	)
+	if err != nil {
+		logger.Error("error watching job", zap.Error(err))
+		return
+	}
+	var lastPhase sdk.JobPhase
+	for {
+		select {
+		case status := <-statusCh:
+			if status.Phase != lastPhase {
+				lastPhase = status.Phase
+				n.notify(
+					ctx,
+					newJobNotification(event, jobName, status),
+				)
+			}
+			if status.Phase.IsTerminal() {
+				return
+			}
+		case err := <-errCh:
+			logger.Error("error watching job", zap.Error(err))
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// notify sends the provided notification to all configured sinks. A nil
+// notification is ignored.
+func (n *notifier) notify(
+	ctx context.Context,
+	notification *cloudEvents.Event,
+) {
+	if notification == nil {
+		return
+	}
+	for _, sink := range n.config.Sinks {
+		sendCtx := cloudEvents.ContextWithTarget(ctx, sink.URL)
+		sendCtx = cloudEvents.ContextWithRetriesExponentialBackoff(
+			sendCtx,
+			100*time.Millisecond,
+			5,
+		)
+		if sink.Mode == SinkModeStructured {
+			sendCtx = binding.WithForceStructured(sendCtx)
+		} else {
+			sendCtx = binding.WithForceBinary(sendCtx)
+		}
+		result := n.sender.Send(sendCtx, *notification)
+		if !cloudEvents.IsACK(result) {
+			logging.FromContext(ctx).Error(
+				"error sending notification",
+				zap.String("notificationType", notification.Type()),
+				zap.String("notificationSubject", notification.Subject()),
+				zap.String("sink", sink.URL),
+				zap.Error(result),
+			)
+		}
+	}
+}
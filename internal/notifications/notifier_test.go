@@ -0,0 +1,279 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brigadecore/brigade/sdk/v3"
+	"github.com/brigadecore/brigade/sdk/v3/meta"
+	sdkTesting "github.com/brigadecore/brigade/sdk/v3/testing"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/require"
+)
+
+type mockSender struct {
+	SendFn func(context.Context, cloudEvents.Event) cloudEvents.Result
+}
+
+func (m *mockSender) Send(
+	ctx context.Context,
+	event cloudEvents.Event,
+) cloudEvents.Result {
+	return m.SendFn(ctx, event)
+}
+
+func TestNewNotifier(t *testing.T) {
+	testConfig := NotifierConfig{
+		ProjectIDs: []string{"italian"},
+	}
+	testEventsClient := &sdkTesting.MockEventsClient{}
+	n, err := NewNotifier(testConfig, testEventsClient)
+	require.NoError(t, err)
+	no, ok := n.(*notifier)
+	require.True(t, ok)
+	require.Equal(t, []string{"italian"}, no.config.ProjectIDs)
+	// Check default
+	require.Equal(t, 10*time.Second, no.config.PollInterval)
+	require.Same(t, testEventsClient, no.eventsClient)
+	require.NotNil(t, no.sender)
+	require.NotNil(t, no.watched)
+}
+
+func TestNotifierPoll(t *testing.T) {
+	testCases := []struct {
+		name         string
+		eventsClient sdk.EventsClient
+		assertions   func(*notifier, error)
+	}{
+		{
+			name: "error listing events",
+			eventsClient: &sdkTesting.MockEventsClient{
+				ListFn: func(
+					context.Context,
+					*sdk.EventsSelector,
+					*meta.ListOptions,
+				) (sdk.EventList, error) {
+					return sdk.EventList{}, errors.New("something went wrong")
+				},
+			},
+			assertions: func(_ *notifier, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "something went wrong")
+			},
+		},
+		{
+			name: "success",
+			eventsClient: &sdkTesting.MockEventsClient{
+				ListFn: func(
+					_ context.Context,
+					selector *sdk.EventsSelector,
+					opts *meta.ListOptions,
+				) (sdk.EventList, error) {
+					require.Equal(t, "italian", selector.ProjectID)
+					require.Equal(
+						t,
+						sdk.WorkerPhasesNonTerminal(),
+						selector.WorkerPhases,
+					)
+					// Return two pages of results
+					if opts.Continue == "" {
+						return sdk.EventList{
+							ListMeta: meta.ListMeta{Continue: "next"},
+							Items: []sdk.Event{
+								{
+									ObjectMeta: meta.ObjectMeta{ID: "1"},
+									Worker: &sdk.Worker{
+										Jobs: []sdk.Job{{Name: "foo"}},
+									},
+								},
+							},
+						}, nil
+					}
+					return sdk.EventList{
+						Items: []sdk.Event{
+							{ObjectMeta: meta.ObjectMeta{ID: "2"}},
+						},
+					}, nil
+				},
+				WorkersClient: &sdkTesting.MockWorkersClient{
+					WatchStatusFn: func(
+						context.Context,
+						string,
+						*sdk.WorkerStatusWatchOptions,
+					) (<-chan sdk.WorkerStatus, <-chan error, error) {
+						// Never send anything
+						return nil, nil, nil
+					},
+					JobsClient: &sdkTesting.MockJobsClient{
+						WatchStatusFn: func(
+							context.Context,
+							string,
+							string,
+							*sdk.JobStatusWatchOptions,
+						) (<-chan sdk.JobStatus, <-chan error, error) {
+							// Never send anything
+							return nil, nil, nil
+						},
+					},
+				},
+			},
+			assertions: func(n *notifier, err error) {
+				require.NoError(t, err)
+				n.watchedMu.Lock()
+				defer n.watchedMu.Unlock()
+				require.Len(t, n.watched, 2)
+				require.Contains(t, n.watched["1"].jobs, "foo")
+				require.Empty(t, n.watched["2"].jobs)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			n := &notifier{
+				eventsClient: testCase.eventsClient,
+				watched:      map[string]*watchedEvent{},
+			}
+			err := n.poll(ctx, "italian")
+			testCase.assertions(n, err)
+		})
+	}
+}
+
+func TestNotifierWatchWorker(t *testing.T) {
+	statusCh := make(chan sdk.WorkerStatus)
+	var sentMu sync.Mutex
+	sentTypes := []string{}
+	n := &notifier{
+		config: NotifierConfig{
+			Sinks: []Sink{{URL: "http://example.com"}},
+		},
+		eventsClient: &sdkTesting.MockEventsClient{
+			WorkersClient: &sdkTesting.MockWorkersClient{
+				WatchStatusFn: func(
+					context.Context,
+					string,
+					*sdk.WorkerStatusWatchOptions,
+				) (<-chan sdk.WorkerStatus, <-chan error, error) {
+					return statusCh, nil, nil
+				},
+			},
+		},
+		sender: &mockSender{
+			SendFn: func(
+				_ context.Context,
+				event cloudEvents.Event,
+			) cloudEvents.Result {
+				sentMu.Lock()
+				defer sentMu.Unlock()
+				sentTypes = append(sentTypes, event.Type())
+				return nil
+			},
+		},
+		watched: map[string]*watchedEvent{
+			"1": {jobs: map[string]struct{}{}},
+		},
+	}
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		n.watchWorker(
+			context.Background(),
+			sdk.Event{ObjectMeta: meta.ObjectMeta{ID: "1"}},
+		)
+	}()
+	statusCh <- sdk.WorkerStatus{Phase: sdk.WorkerPhasePending}
+	statusCh <- sdk.WorkerStatus{Phase: sdk.WorkerPhaseRunning}
+	// A repeated phase shouldn't result in a second notification
+	statusCh <- sdk.WorkerStatus{Phase: sdk.WorkerPhaseRunning}
+	statusCh <- sdk.WorkerStatus{Phase: sdk.WorkerPhaseSucceeded}
+	select {
+	case <-doneCh:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "watcher did not stop after terminal phase")
+	}
+	sentMu.Lock()
+	defer sentMu.Unlock()
+	require.Equal(
+		t,
+		[]string{"sh.brigade.worker.started", "sh.brigade.worker.succeeded"},
+		sentTypes,
+	)
+	// The event should no longer be tracked
+	require.Empty(t, n.watched)
+}
+
+func TestNotifierNotify(t *testing.T) {
+	testCases := []struct {
+		name       string
+		mode       SinkMode
+		assertions func(*http.Request)
+	}{
+		{
+			name: "binary mode",
+			mode: SinkModeBinary,
+			assertions: func(r *http.Request) {
+				require.Equal(t, "sh.brigade.worker.started", r.Header.Get("Ce-Type"))
+				require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			},
+		},
+		{
+			name: "structured mode",
+			mode: SinkModeStructured,
+			assertions: func(r *http.Request) {
+				require.Empty(t, r.Header.Get("Ce-Type"))
+				require.Equal(
+					t,
+					"application/cloudevents+json",
+					r.Header.Get("Content-Type"),
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			reqCh := make(chan *http.Request, 1)
+			server := httptest.NewServer(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					reqCh <- r
+					w.WriteHeader(http.StatusAccepted)
+				}),
+			)
+			defer server.Close()
+			no, err := NewNotifier(
+				NotifierConfig{
+					Sinks: []Sink{
+						{
+							URL:  server.URL,
+							Mode: testCase.mode,
+						},
+					},
+				},
+				&sdkTesting.MockEventsClient{},
+			)
+			require.NoError(t, err)
+			no.(*notifier).notify( // nolint: forcetypeassert
+				context.Background(),
+				newWorkerNotification(
+					sdk.Event{
+						ObjectMeta: meta.ObjectMeta{ID: "1"},
+						ProjectID:  "italian",
+					},
+					sdk.WorkerStatus{Phase: sdk.WorkerPhaseRunning},
+				),
+			)
+			select {
+			case r := <-reqCh:
+				testCase.assertions(r)
+			default:
+				require.FailNow(t, "sink did not receive notification")
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// sweepInterval is how often idle buckets are removed from memory.
+const sweepInterval = time.Minute
+
+type memoryBackend struct{}
+
+// NewMemoryBackend returns a Backend that stores the state of Limiters in
+// memory.
+func NewMemoryBackend() Backend {
+	return &memoryBackend{}
+}
+
+func (m *memoryBackend) NewLimiter(_ string, limit Limit) Limiter {
+	return newMemoryLimiter(limit)
+}
+
+func (m *memoryBackend) Close() error {
+	return nil
+}
+
+// memoryBucket is a token bucket stored in memory.
+type memoryBucket struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+type memoryLimiter struct {
+	limit Limit
+	// idleTTL is the length of time after which an unused bucket is full again
+	// and can therefore be discarded without changing the outcome of any
+	// subsequent call to Allow.
+	idleTTL   time.Duration
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func newMemoryLimiter(limit Limit) *memoryLimiter {
+	return &memoryLimiter{
+		limit: limit,
+		idleTTL: time.Duration(
+			float64(limit.Burst) / limit.Rate * float64(time.Second),
+		),
+		buckets: map[string]*memoryBucket{},
+		now:     time.Now,
+	}
+}
+
+func (m *memoryLimiter) Allow(
+	_ context.Context,
+	key string,
+) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.sweep(now)
+	bucket, ok := m.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{
+			limiter: rate.NewLimiter(rate.Limit(m.limit.Rate), m.limit.Burst),
+		}
+		m.buckets[key] = bucket
+	}
+	bucket.lastUsed = now
+	if bucket.limiter.AllowN(now, 1) {
+		return true, 0, nil
+	}
+	return false, retryAfter(bucket.limiter.TokensAt(now), m.limit.Rate), nil
+}
+
+// sweep periodically discards buckets that have been idle long enough to have
+// refilled completely so that memory use doesn't grow without bound as new
+// keys (e.g. client IPs) are seen.
+func (m *memoryLimiter) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for key, bucket := range m.buckets {
+		if now.Sub(bucket.lastUsed) >= m.idleTTL {
+			delete(m.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	limiter := newMemoryLimiter(Limit{Rate: 1, Burst: 2})
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	ctx := context.Background()
+	// The burst is available immediately
+	for i := 0; i < 2; i++ {
+		allowed, _, err := limiter.Allow(ctx, "foo")
+		require.NoError(t, err)
+		require.True(t, allowed)
+	}
+	// But then the bucket is empty
+	allowed, retryAfter, err := limiter.Allow(ctx, "foo")
+	require.NoError(t, err)
+	require.False(t, allowed)
+	require.Equal(t, time.Second, retryAfter)
+	// Other keys have their own buckets
+	allowed, _, err = limiter.Allow(ctx, "bar")
+	require.NoError(t, err)
+	require.True(t, allowed)
+	// The bucket refills over time
+	now = now.Add(time.Second)
+	allowed, _, err = limiter.Allow(ctx, "foo")
+	require.NoError(t, err)
+	require.True(t, allowed)
+}
+
+func TestMemoryLimiterSweep(t *testing.T) {
+	limiter := newMemoryLimiter(Limit{Rate: 1, Burst: 2})
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	ctx := context.Background()
+	_, _, err := limiter.Allow(ctx, "foo")
+	require.NoError(t, err)
+	require.Len(t, limiter.buckets, 1)
+	// Once the bucket has been idle long enough to have refilled and it's time
+	// to sweep, it should be discarded
+	now = now.Add(sweepInterval)
+	_, _, err = limiter.Allow(ctx, "bar")
+	require.NoError(t, err)
+	require.Len(t, limiter.buckets, 1)
+	require.Contains(t, limiter.buckets, "bar")
+}
@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// Limit describes a token bucket.
+type Limit struct {
+	// Rate is the number of tokens added to the bucket per second. A rate of
+	// zero indicates there is no limit.
+	Rate float64
+	// Burst is the size of the bucket, i.e. the maximum number of tokens that
+	// may be consumed at once.
+	Burst int
+}
+
+// Enabled returns a bool indicating whether the Limit actually limits
+// anything.
+func (l Limit) Enabled() bool {
+	return l.Rate > 0
+}
+
+// Limiter is an interface for components that limit the rate at which events
+// may happen. Each key is subject to its own token bucket.
+type Limiter interface {
+	// Allow consumes a token from the bucket identified by the provided key, if
+	// one is available, and returns true. Otherwise, it returns false and the
+	// length of time after which a token will be available.
+	Allow(ctx context.Context, key string) (bool, time.Duration, error)
+}
+
+// Backend is an interface for components that store the state of Limiters.
+type Backend interface {
+	// NewLimiter returns a Limiter that enforces the provided Limit. The name
+	// distinguishes the Limiter's buckets from those of other Limiters using the
+	// same Backend.
+	NewLimiter(name string, limit Limit) Limiter
+	// Close releases any resources held by the Backend.
+	Close() error
+}
+
+// BackendType represents a type of Backend.
+type BackendType string
+
+const (
+	// BackendTypeMemory represents a Backend that stores state in memory. Each
+	// gateway replica enforces limits independently.
+	BackendTypeMemory BackendType = "memory"
+	// BackendTypeRedis represents a Backend that stores state in Redis. All
+	// gateway replicas using the same Redis share limits.
+	BackendTypeRedis BackendType = "redis"
+)
+
+// BackendConfig encapsulates configuration for a Backend.
+type BackendConfig struct {
+	// Type is the type of Backend.
+	Type BackendType
+	// RedisAddress is the address (host:port) of the Redis server used by a
+	// Backend of type BackendTypeRedis.
+	RedisAddress string
+	// RedisPassword is the password used to authenticate to the Redis server.
+	RedisPassword string
+	// RedisKeyPrefix is prepended to every key stored in Redis.
+	RedisKeyPrefix string
+}
+
+// NewBackend returns a Backend of the configured type.
+func NewBackend(config BackendConfig) (Backend, error) {
+	switch config.Type {
+	case BackendTypeMemory, "":
+		return NewMemoryBackend(), nil
+	case BackendTypeRedis:
+		if config.RedisAddress == "" {
+			return nil, errors.New("no Redis address specified")
+		}
+		return NewRedisBackend(
+			redis.NewClient(
+				&redis.Options{
+					Addr:     config.RedisAddress,
+					Password: config.RedisPassword,
+				},
+			),
+			config.RedisKeyPrefix,
+		), nil
+	default:
+		return nil, errors.Errorf("unrecognized rate limit backend %q", config.Type)
+	}
+}
+
+// retryAfter returns the length of time after which a token bucket that holds
+// the specified number of tokens and refills at the specified rate will hold a
+// whole token.
+func retryAfter(tokens float64, rate float64) time.Duration {
+	return time.Duration(math.Ceil((1 - tokens) / rate * float64(time.Second)))
+}
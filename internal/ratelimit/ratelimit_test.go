@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitEnabled(t *testing.T) {
+	require.False(t, Limit{}.Enabled())
+	require.True(t, Limit{Rate: 0.5, Burst: 1}.Enabled())
+}
+
+func TestNewBackend(t *testing.T) {
+	testCases := []struct {
+		name       string
+		config     BackendConfig
+		assertions func(Backend, error)
+	}{
+		{
+			name:   "memory",
+			config: BackendConfig{Type: BackendTypeMemory},
+			assertions: func(backend Backend, err error) {
+				require.NoError(t, err)
+				require.IsType(t, &memoryBackend{}, backend)
+			},
+		},
+		{
+			name:   "redis with no address",
+			config: BackendConfig{Type: BackendTypeRedis},
+			assertions: func(_ Backend, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "no Redis address specified")
+			},
+		},
+		{
+			name: "redis",
+			config: BackendConfig{
+				Type:           BackendTypeRedis,
+				RedisAddress:   "localhost:6379",
+				RedisKeyPrefix: "foo:",
+			},
+			assertions: func(backend Backend, err error) {
+				require.NoError(t, err)
+				redisBackend, ok := backend.(*redisBackend)
+				require.True(t, ok)
+				require.Equal(t, "foo:", redisBackend.keyPrefix)
+				require.NoError(t, backend.Close())
+			},
+		},
+		{
+			name:   "unrecognized type",
+			config: BackendConfig{Type: "etcd"},
+			assertions: func(_ Backend, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "unrecognized rate limit backend")
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			backend, err := NewBackend(testCase.config)
+			testCase.assertions(backend, err)
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	require.Equal(t, 500*time.Millisecond, retryAfter(0, 2))
+	require.Equal(t, 250*time.Millisecond, retryAfter(0.5, 2))
+}
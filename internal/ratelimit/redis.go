@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// tokenBucketScript atomically refills a token bucket stored in a Redis hash
+// according to the time elapsed since it was last used and then attempts to
+// consume a token from it. It returns 1 if a token was consumed and 0
+// otherwise, along with the number of tokens remaining in the bucket. Buckets
+// expire once they would have refilled completely. The time is read from the
+// Redis server, rather than from each gateway replica, so that buckets shared
+// by replicas are unaffected by skew between their clocks.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+redis.replicate_commands()
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+  tokens = burst
+  ts = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - ts) / 1000 * rate)
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate * 1000))
+return {allowed, tostring(tokens)}
+`)
+
+type redisBackend struct {
+	client    redis.UniversalClient
+	keyPrefix string
+}
+
+// NewRedisBackend returns a Backend that stores the state of Limiters in Redis
+// using the provided client, so that limits are shared by every gateway replica
+// using the same Redis. Every key is prefixed with the provided prefix.
+func NewRedisBackend(client redis.UniversalClient, keyPrefix string) Backend {
+	return &redisBackend{
+		client:    client,
+		keyPrefix: keyPrefix,
+	}
+}
+
+func (r *redisBackend) NewLimiter(name string, limit Limit) Limiter {
+	return &redisLimiter{
+		client:    r.client,
+		keyPrefix: r.keyPrefix + name + ":",
+		limit:     limit,
+	}
+}
+
+func (r *redisBackend) Close() error {
+	return r.client.Close()
+}
+
+type redisLimiter struct {
+	client    redis.UniversalClient
+	keyPrefix string
+	limit     Limit
+}
+
+func (r *redisLimiter) Allow(
+	ctx context.Context,
+	key string,
+) (bool, time.Duration, error) {
+	res, err := tokenBucketScript.Run(
+		ctx,
+		r.client,
+		[]string{r.keyPrefix + key},
+		r.limit.Rate,
+		r.limit.Burst,
+	).Slice()
+	if err != nil {
+		return false, 0, errors.Wrap(err, "error evaluating rate limit in Redis")
+	}
+	if len(res) != 2 {
+		return false, 0, errors.Errorf(
+			"unexpected rate limit result from Redis: %v",
+			res,
+		)
+	}
+	if allowed, _ := res[0].(int64); allowed == 1 {
+		return true, 0, nil
+	}
+	tokensStr, _ := res[1].(string)
+	tokens, err := strconv.ParseFloat(tokensStr, 64)
+	if err != nil {
+		return false, 0, errors.Wrap(err, "error parsing tokens remaining")
+	}
+	return false, retryAfter(math.Max(0, tokens), r.limit.Rate), nil
+}
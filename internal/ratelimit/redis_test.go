@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisLimiter(t *testing.T) {
+	mr := miniredis.RunT(t)
+	backend := NewRedisBackend(
+		redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		"prefix:",
+	)
+	defer backend.Close()
+	limiter := backend.NewLimiter("test", Limit{Rate: 1, Burst: 2})
+	// The time is read from Redis
+	now := time.Now()
+	mr.SetTime(now)
+	ctx := context.Background()
+	// The burst is available immediately
+	for i := 0; i < 2; i++ {
+		allowed, _, err := limiter.Allow(ctx, "foo")
+		require.NoError(t, err)
+		require.True(t, allowed)
+	}
+	require.True(t, mr.Exists("prefix:test:foo"))
+	// But then the bucket is empty
+	allowed, retryAfter, err := limiter.Allow(ctx, "foo")
+	require.NoError(t, err)
+	require.False(t, allowed)
+	require.Equal(t, time.Second, retryAfter)
+	// Other keys have their own buckets
+	allowed, _, err = limiter.Allow(ctx, "bar")
+	require.NoError(t, err)
+	require.True(t, allowed)
+	// The bucket refills over time
+	mr.SetTime(now.Add(time.Second))
+	allowed, _, err = limiter.Allow(ctx, "foo")
+	require.NoError(t, err)
+	require.True(t, allowed)
+}
+
+func TestRedisLimiterError(t *testing.T) {
+	mr := miniredis.RunT(t)
+	backend := NewRedisBackend(
+		redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		"prefix:",
+	)
+	defer backend.Close()
+	mr.Close()
+	_, _, err := backend.NewLimiter("test", Limit{Rate: 1, Burst: 2}).Allow(
+		context.Background(),
+		"foo",
+	)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "error evaluating rate limit in Redis")
+}
@@ -0,0 +1,148 @@
+package readiness
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/brigadecore/brigade/sdk/v3"
+	"github.com/pkg/errors"
+)
+
+const (
+	statusReady    = "ready"
+	statusNotReady = "not ready"
+
+	// checkTimeout is the maximum length of time any single Checker may take to
+	// complete a check before its component is considered not ready.
+	checkTimeout = 5 * time.Second
+)
+
+// Checker is an interface for components that can check whether some
+// component the gateway depends upon is ready.
+type Checker interface {
+	// Check returns an error if the component is not ready.
+	Check(context.Context) error
+}
+
+// CheckerFunc is an adapter that allows an ordinary function to be used as a
+// Checker.
+type CheckerFunc func(context.Context) error
+
+// Check calls c(ctx).
+func (c CheckerFunc) Check(ctx context.Context) error {
+	return c(ctx)
+}
+
+// cachingChecker is a Checker that caches the result of another Checker.
+type cachingChecker struct {
+	checker Checker
+	ttl     time.Duration
+	// mu serializes checks so that concurrent callers never cause the
+	// underlying Checker to be invoked more than once per TTL.
+	mu        sync.Mutex
+	checkedAt time.Time
+	err       error
+	now       func() time.Time
+}
+
+// NewCachingChecker returns a Checker that invokes the provided Checker at most
+// once per the specified TTL and otherwise returns the cached result of its
+// most recent check. This is useful for limiting the rate at which expensive
+// checks, such as those requiring a network call, are performed.
+func NewCachingChecker(checker Checker, ttl time.Duration) Checker {
+	return &cachingChecker{
+		checker: checker,
+		ttl:     ttl,
+		now:     time.Now,
+	}
+}
+
+func (c *cachingChecker) Check(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.checkedAt.IsZero() && c.now().Sub(c.checkedAt) < c.ttl {
+		return c.err
+	}
+	c.err = c.checker.Check(ctx)
+	c.checkedAt = c.now()
+	return c.err
+}
+
+// NewBrigadeAPIChecker returns a Checker that verifies the Brigade API server
+// is reachable and that the gateway's API token is accepted by it.
+func NewBrigadeAPIChecker(authnClient sdk.AuthnClient) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		if _, err := authnClient.WhoAmI(ctx); err != nil {
+			return errors.Wrap(err, "error authenticating to the Brigade API")
+		}
+		return nil
+	})
+}
+
+// componentStatus represents the readiness of a single component.
+type componentStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// report represents the readiness of the gateway as a whole as well as that of
+// each of its components.
+type report struct {
+	Status     string                     `json:"status"`
+	Components map[string]componentStatus `json:"components"`
+}
+
+// handler is an http.Handler that reports on the readiness of the gateway.
+type handler struct {
+	checkers map[string]Checker
+}
+
+// NewHandler returns an http.Handler that concurrently checks the readiness of
+// every component for which a Checker is provided and reports the results as
+// JSON. If all components are ready, the response has status 200. Otherwise,
+// it has status 503.
+func NewHandler(checkers map[string]Checker) http.Handler {
+	return &handler{
+		checkers: checkers,
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+	rep := report{
+		Status:     statusReady,
+		Components: make(map[string]componentStatus, len(h.checkers)),
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, checker := range h.checkers {
+		wg.Add(1)
+		go func(name string, checker Checker) {
+			defer wg.Done()
+			status := componentStatus{Status: statusReady}
+			if err := checker.Check(ctx); err != nil {
+				status = componentStatus{
+					Status: statusNotReady,
+					Error:  err.Error(),
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			rep.Components[name] = status
+			if status.Status != statusReady {
+				rep.Status = statusNotReady
+			}
+		}(name, checker)
+	}
+	wg.Wait()
+	w.Header().Set("Content-Type", "application/json")
+	if rep.Status != statusReady {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	// There's nothing useful we can do if this fails
+	json.NewEncoder(w).Encode(rep) // nolint: errcheck
+}
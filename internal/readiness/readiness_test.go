@@ -0,0 +1,159 @@
+package readiness
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/brigadecore/brigade/sdk/v3"
+	sdkTesting "github.com/brigadecore/brigade/sdk/v3/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachingChecker(t *testing.T) {
+	var calls int
+	checker, ok := NewCachingChecker(
+		CheckerFunc(func(context.Context) error {
+			calls++
+			return errors.New("something went wrong")
+		}),
+		time.Minute,
+	).(*cachingChecker)
+	require.True(t, ok)
+	now := time.Now()
+	checker.now = func() time.Time { return now }
+	// The first check should invoke the underlying checker
+	require.Error(t, checker.Check(context.Background()))
+	require.Equal(t, 1, calls)
+	// A check within the TTL should return the cached result
+	now = now.Add(30 * time.Second)
+	require.Error(t, checker.Check(context.Background()))
+	require.Equal(t, 1, calls)
+	// A check after the TTL has elapsed should invoke the underlying checker
+	// again
+	now = now.Add(time.Minute)
+	require.Error(t, checker.Check(context.Background()))
+	require.Equal(t, 2, calls)
+}
+
+func TestBrigadeAPIChecker(t *testing.T) {
+	testCases := []struct {
+		name        string
+		authnClient sdk.AuthnClient
+		assertions  func(error)
+	}{
+		{
+			name: "error authenticating",
+			authnClient: &sdkTesting.MockAuthnClient{
+				WhoAmIFn: func(context.Context) (sdk.PrincipalReference, error) {
+					return sdk.PrincipalReference{}, errors.New("something went wrong")
+				},
+			},
+			assertions: func(err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					"error authenticating to the Brigade API",
+				)
+				require.Contains(t, err.Error(), "something went wrong")
+			},
+		},
+		{
+			name: "success",
+			authnClient: &sdkTesting.MockAuthnClient{
+				WhoAmIFn: func(context.Context) (sdk.PrincipalReference, error) {
+					return sdk.PrincipalReference{}, nil
+				},
+			},
+			assertions: func(err error) {
+				require.NoError(t, err)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := NewBrigadeAPIChecker(testCase.authnClient).Check(
+				context.Background(),
+			)
+			testCase.assertions(err)
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	ready := CheckerFunc(func(context.Context) error { return nil })
+	notReady := CheckerFunc(func(context.Context) error {
+		return errors.New("something went wrong")
+	})
+	testCases := []struct {
+		name       string
+		checkers   map[string]Checker
+		assertions func(int, report)
+	}{
+		{
+			name: "all components ready",
+			checkers: map[string]Checker{
+				"foo": ready,
+				"bar": ready,
+			},
+			assertions: func(statusCode int, rep report) {
+				require.Equal(t, http.StatusOK, statusCode)
+				require.Equal(
+					t,
+					report{
+						Status: statusReady,
+						Components: map[string]componentStatus{
+							"foo": {Status: statusReady},
+							"bar": {Status: statusReady},
+						},
+					},
+					rep,
+				)
+			},
+		},
+		{
+			name: "a component not ready",
+			checkers: map[string]Checker{
+				"foo": ready,
+				"bar": notReady,
+			},
+			assertions: func(statusCode int, rep report) {
+				require.Equal(t, http.StatusServiceUnavailable, statusCode)
+				require.Equal(
+					t,
+					report{
+						Status: statusNotReady,
+						Components: map[string]componentStatus{
+							"foo": {Status: statusReady},
+							"bar": {
+								Status: statusNotReady,
+								Error:  "something went wrong",
+							},
+						},
+					},
+					rep,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			NewHandler(testCase.checkers).ServeHTTP(
+				rr,
+				httptest.NewRequest(http.MethodGet, "/readyz", nil),
+			)
+			res := rr.Result()
+			defer res.Body.Close()
+			require.Equal(t, "application/json", res.Header.Get("Content-Type"))
+			rep := report{}
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&rep))
+			testCase.assertions(res.StatusCode, rep)
+		})
+	}
+}
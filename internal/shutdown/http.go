@@ -0,0 +1,36 @@
+package shutdown
+
+import (
+	"net/http"
+
+	libHTTP "github.com/brigadecore/brigade-foundations/http"
+)
+
+// KindHTTPRequest is the kind of work tracked for every HTTP request handled
+// by a handler decorated by the filter returned from NewFilter. WebSocket
+// connections and multiplexed gRPC streams are tracked as such for as long as
+// they remain open.
+const KindHTTPRequest = "http_request"
+
+// filter is a component that implements the http.Filter interface and tracks
+// every request it decorates as in-flight work.
+type filter struct {
+	tracker Tracker
+}
+
+// NewFilter returns a component that implements the http.Filter interface and
+// tracks every request it decorates as in-flight work using the provided
+// Tracker. This permits the gateway to wait for requests to be fully handled,
+// including writing a response, before it exits.
+func NewFilter(tracker Tracker) libHTTP.Filter {
+	return &filter{
+		tracker: tracker,
+	}
+}
+
+func (f *filter) Decorate(handle http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer f.tracker.Track(KindHTTPRequest)()
+		handle(w, r)
+	}
+}
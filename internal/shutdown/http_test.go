@@ -0,0 +1,25 @@
+package shutdown
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter(t *testing.T) {
+	tracker := NewTracker()
+	var tracked map[string]int
+	NewFilter(tracker).Decorate(
+		func(http.ResponseWriter, *http.Request) {
+			// Inspect in-flight work using a context that is already canceled
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			tracked = tracker.Wait(ctx)
+		},
+	)(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	require.Equal(t, map[string]int{KindHTTPRequest: 1}, tracked)
+	require.Empty(t, tracker.Wait(context.Background()))
+}
@@ -0,0 +1,123 @@
+package shutdown
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrShuttingDown is returned by a Tracker's Check function once the gateway
+// has begun shutting down.
+var ErrShuttingDown = errors.New("gateway is shutting down")
+
+// Config encapsulates configuration for the gateway's shutdown sequence.
+type Config struct {
+	// Delay is the length of time for which the gateway continues to accept new
+	// connections after it has been marked not ready. This gives load balancers
+	// an opportunity to observe that the gateway is no longer ready and stop
+	// routing new requests to it.
+	Delay time.Duration
+	// GracePeriod is the maximum length of time the gateway waits for in-flight
+	// work to complete after it has stopped accepting new connections. Work that
+	// is still in flight when the grace period elapses is abandoned.
+	GracePeriod time.Duration
+}
+
+// Tracker is an interface for components that keep track of in-flight work so
+// that it can be drained when the gateway shuts down.
+type Tracker interface {
+	// Track records the start of a unit of work of the specified kind and
+	// returns a function that MUST be called when the work completes.
+	Track(kind string) func()
+	// Shutdown marks the gateway as shutting down. After it has been called,
+	// Check returns ErrShuttingDown. Work may continue to be tracked.
+	Shutdown()
+	// Check returns ErrShuttingDown if Shutdown has been called. This makes the
+	// Tracker usable as a readiness.Checker.
+	Check(context.Context) error
+	// Wait blocks until no work is in flight or until the provided context is
+	// canceled, whichever comes first. It returns the number of units of work,
+	// by kind, that were still in flight when it returned. The returned map is
+	// empty if all work completed.
+	Wait(context.Context) map[string]int
+}
+
+type tracker struct {
+	// mu guards all of the following fields.
+	mu           sync.Mutex
+	shuttingDown bool
+	inFlight     map[string]int
+	total        int
+	// idleCh, if non-nil, is closed when total next reaches zero.
+	idleCh chan struct{}
+}
+
+// NewTracker returns a Tracker.
+func NewTracker() Tracker {
+	return &tracker{
+		inFlight: map[string]int{},
+	}
+}
+
+func (t *tracker) Track(kind string) func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inFlight[kind]++
+	t.total++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if t.inFlight[kind]--; t.inFlight[kind] == 0 {
+				delete(t.inFlight, kind)
+			}
+			if t.total--; t.total == 0 && t.idleCh != nil {
+				close(t.idleCh)
+				t.idleCh = nil
+			}
+		})
+	}
+}
+
+func (t *tracker) Shutdown() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.shuttingDown = true
+}
+
+func (t *tracker) Check(context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.shuttingDown {
+		return ErrShuttingDown
+	}
+	return nil
+}
+
+func (t *tracker) Wait(ctx context.Context) map[string]int {
+	t.mu.Lock()
+	if t.total == 0 {
+		t.mu.Unlock()
+		return map[string]int{}
+	}
+	if t.idleCh == nil {
+		t.idleCh = make(chan struct{})
+	}
+	idleCh := t.idleCh
+	t.mu.Unlock()
+	select {
+	case <-idleCh:
+		return map[string]int{}
+	case <-ctx.Done():
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	abandoned := make(map[string]int, len(t.inFlight))
+	for kind, count := range t.inFlight {
+		abandoned[kind] = count
+	}
+	return abandoned
+}
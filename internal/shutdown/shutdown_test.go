@@ -0,0 +1,68 @@
+package shutdown
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrackerCheck(t *testing.T) {
+	tracker := NewTracker()
+	require.NoError(t, tracker.Check(context.Background()))
+	tracker.Shutdown()
+	require.ErrorIs(t, tracker.Check(context.Background()), ErrShuttingDown)
+}
+
+func TestTrackerWait(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func(Tracker)
+		assertions func(abandoned map[string]int)
+	}{
+		{
+			name:  "nothing in flight",
+			setup: func(Tracker) {},
+			assertions: func(abandoned map[string]int) {
+				require.Empty(t, abandoned)
+			},
+		},
+		{
+			name: "all work completes",
+			setup: func(tracker Tracker) {
+				done := tracker.Track("foo")
+				tracker.Track("bar")() // Completes immediately
+				go func() {
+					<-time.After(100 * time.Millisecond)
+					done()
+					done() // Should be idempotent
+				}()
+			},
+			assertions: func(abandoned map[string]int) {
+				require.Empty(t, abandoned)
+			},
+		},
+		{
+			name: "work is abandoned",
+			setup: func(tracker Tracker) {
+				tracker.Track("foo")
+				tracker.Track("foo")
+				tracker.Track("bar")
+				tracker.Track("bat")()
+			},
+			assertions: func(abandoned map[string]int) {
+				require.Equal(t, map[string]int{"foo": 2, "bar": 1}, abandoned)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			tracker := NewTracker()
+			testCase.setup(tracker)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			testCase.assertions(tracker.Wait(ctx))
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package tokens
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	ourCloudHTTP "github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/http" // nolint: lll
+	"github.com/pkg/errors"
+)
+
+// maxDirectoryDepth is the maximum depth of subdirectories a directory Source
+// reads tokens from. Symbolic links are followed, so this also guards against
+// cycles.
+const maxDirectoryDepth = 8
+
+type directorySource struct {
+	path            string
+	refreshInterval time.Duration
+}
+
+// NewDirectorySource returns a Source that reads each token from its own file
+// in the specified directory, checking it for changes at the specified
+// interval. Each token is named for the path of its file relative to the
+// directory, with forward slashes as separators. A file contains either the
+// token in plain text or hashed, or a JSON object in the format of an
+// ourCloudHTTP.Token. Files and directories whose names begin with a dot, such
+// as those Kubernetes uses to update projected volumes atomically, are
+// ignored.
+func NewDirectorySource(path string, refreshInterval time.Duration) Source {
+	return &directorySource{
+		path:            path,
+		refreshInterval: refreshInterval,
+	}
+}
+
+func (d *directorySource) Tokens(
+	context.Context,
+) (map[string]ourCloudHTTP.Token, error) {
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading tokens directory")
+	}
+	if !info.IsDir() {
+		return nil, errors.Errorf("%s is not a directory", d.path)
+	}
+	tokens := map[string]ourCloudHTTP.Token{}
+	if err := d.readDir(d.path, "", 0, tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// readDir adds a token to the provided map for each file in the specified
+// directory, and its subdirectories, naming each for the provided prefix
+// followed by the path of the file relative to the directory.
+func (d *directorySource) readDir(
+	dir string,
+	prefix string,
+	depth int,
+	tokens map[string]ourCloudHTTP.Token,
+) error {
+	if depth > maxDirectoryDepth {
+		return errors.Errorf(
+			"directory %s is nested more than %d levels deep",
+			dir,
+			maxDirectoryDepth,
+		)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return errors.Wrapf(err, "error reading directory %s", dir)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		entryPath := filepath.Join(dir, entry.Name())
+		name := path.Join(prefix, entry.Name())
+		// ReadDir doesn't follow symbolic links, but Stat does
+		info, err := os.Stat(entryPath)
+		if err != nil {
+			return errors.Wrapf(err, "error reading %s", entryPath)
+		}
+		if info.IsDir() {
+			if err = d.readDir(entryPath, name, depth+1, tokens); err != nil {
+				return err
+			}
+			continue
+		}
+		if tokens[name], err = readTokenFile(entryPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *directorySource) Watch(
+	ctx context.Context,
+	update func(map[string]ourCloudHTTP.Token),
+) error {
+	return poll(ctx, d.refreshInterval, d.Tokens, update)
+}
+
+// readTokenFile reads a token from the file at the specified path. The file
+// contains either the token in plain text or hashed, or a JSON object in the
+// format of an ourCloudHTTP.Token.
+func readTokenFile(path string) (ourCloudHTTP.Token, error) {
+	token := ourCloudHTTP.Token{}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return token, errors.Wrapf(err, "error reading %s", path)
+	}
+	contents = bytes.TrimSpace(contents)
+	if len(contents) == 0 {
+		return token, errors.Errorf("file %s is empty", path)
+	}
+	if contents[0] != '{' {
+		token.Value = string(contents)
+		return token, nil
+	}
+	if err = json.Unmarshal(contents, &token); err != nil {
+		return token, errors.Wrapf(err, "error parsing %s", path)
+	}
+	return token, nil
+}
@@ -0,0 +1,142 @@
+package tokens
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ourCloudHTTP "github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/http" // nolint: lll
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirectorySourceTokens(t *testing.T) {
+	// writeFiles writes the provided files, indexed by path relative to a new
+	// temporary directory, and returns the path of the directory.
+	writeFiles := func(files map[string]string) string {
+		dir := t.TempDir()
+		for path, contents := range files {
+			path = filepath.Join(dir, path)
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+			require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0600))
+		}
+		return dir
+	}
+	testCases := []struct {
+		name       string
+		setup      func() string
+		assertions func(map[string]ourCloudHTTP.Token, error)
+	}{
+		{
+			name: "directory does not exist",
+			setup: func() string {
+				return "/completely/bogus/path"
+			},
+			assertions: func(_ map[string]ourCloudHTTP.Token, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "error reading tokens directory")
+			},
+		},
+		{
+			name: "path is not a directory",
+			setup: func() string {
+				return filepath.Join(writeFiles(map[string]string{"foo": "bar"}), "foo")
+			},
+			assertions: func(_ map[string]ourCloudHTTP.Token, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is not a directory")
+			},
+		},
+		{
+			name: "empty file",
+			setup: func() string {
+				return writeFiles(map[string]string{"foo": "\n"})
+			},
+			assertions: func(_ map[string]ourCloudHTTP.Token, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is empty")
+			},
+		},
+		{
+			name: "invalid token object",
+			setup: func() string {
+				return writeFiles(map[string]string{"foo": `{"disabled":true}`})
+			},
+			assertions: func(_ map[string]ourCloudHTTP.Token, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "token is not specified")
+			},
+		},
+		{
+			name: "directory nested too deeply",
+			setup: func() string {
+				return writeFiles(
+					map[string]string{
+						strings.Repeat("foo/", maxDirectoryDepth+1) + "bar": "baz",
+					},
+				)
+			},
+			assertions: func(_ map[string]ourCloudHTTP.Token, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "is nested more than")
+			},
+		},
+		{
+			name: "success",
+			setup: func() string {
+				dir := writeFiles(
+					map[string]string{
+						"foo":                   "bar\n",
+						"example/uri":           "MySharedSecret",
+						"example/expiring":      `{"token":"bat","expiresAt":"2022-01-01T00:00:00Z"}`, // nolint: lll
+						".hidden":               "ignored",
+						"..2022_01_01/linked":   "baz",
+						"..2022_01_01/.ignored": "ignored",
+					},
+				)
+				// Emulate how Kubernetes projects Secrets into volumes
+				require.NoError(
+					t,
+					os.Symlink(
+						filepath.Join(dir, "..2022_01_01"),
+						filepath.Join(dir, "..data"),
+					),
+				)
+				require.NoError(
+					t,
+					os.Symlink(
+						filepath.Join(dir, "..data", "linked"),
+						filepath.Join(dir, "linked"),
+					),
+				)
+				return dir
+			},
+			assertions: func(tokens map[string]ourCloudHTTP.Token, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					map[string]ourCloudHTTP.Token{
+						"foo":         {Value: "bar"},
+						"example/uri": {Value: "MySharedSecret"},
+						"example/expiring": {
+							Value:     "bat",
+							ExpiresAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+						},
+						"linked": {Value: "baz"},
+					},
+					tokens,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			tokens, err := NewDirectorySource(testCase.setup(), 0).
+				Tokens(context.Background())
+			testCase.assertions(tokens, err)
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package tokens
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	ourCloudHTTP "github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/http" // nolint: lll
+	"github.com/brigadecore/brigade-foundations/file"
+	"github.com/pkg/errors"
+)
+
+type fileSource struct {
+	path            string
+	contents        []byte
+	refreshInterval time.Duration
+}
+
+// NewFileSource returns a Source that reads tokens, indexed by name, from the
+// JSON file at the specified path, checking it for changes at the specified
+// interval. If contents are provided, they're used in place of reading a file
+// and never change.
+func NewFileSource(
+	path string,
+	contents []byte,
+	refreshInterval time.Duration,
+) Source {
+	return &fileSource{
+		path:            path,
+		contents:        contents,
+		refreshInterval: refreshInterval,
+	}
+}
+
+func (f *fileSource) Tokens(
+	context.Context,
+) (map[string]ourCloudHTTP.Token, error) {
+	contents, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	tokens := map[string]ourCloudHTTP.Token{}
+	if err := json.Unmarshal(contents, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// read returns the contents provided in place of a file or, if there are none,
+// the contents of the file.
+func (f *fileSource) read() ([]byte, error) {
+	if f.contents != nil {
+		return f.contents, nil
+	}
+	exists, err := file.Exists(f.path)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.Errorf("file %s does not exist", f.path)
+	}
+	return ioutil.ReadFile(f.path)
+}
+
+func (f *fileSource) Watch(
+	ctx context.Context,
+	update func(map[string]ourCloudHTTP.Token),
+) error {
+	refreshInterval := f.refreshInterval
+	if f.contents != nil {
+		refreshInterval = 0
+	}
+	return poll(ctx, refreshInterval, f.Tokens, update)
+}
@@ -0,0 +1,95 @@
+package tokens
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	ourCloudHTTP "github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/http" // nolint: lll
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSourceTokens(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func() Source
+		assertions func(map[string]ourCloudHTTP.Token, error)
+	}{
+		{
+			name: "file does not exist",
+			setup: func() Source {
+				return NewFileSource("/completely/bogus/path", nil, 0)
+			},
+			assertions: func(_ map[string]ourCloudHTTP.Token, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					"file /completely/bogus/path does not exist",
+				)
+			},
+		},
+		{
+			name: "file does not contain valid json",
+			setup: func() Source {
+				path := filepath.Join(t.TempDir(), "tokens.json")
+				require.NoError(
+					t,
+					ioutil.WriteFile(path, []byte("this is not json"), 0600),
+				)
+				return NewFileSource(path, nil, 0)
+			},
+			assertions: func(_ map[string]ourCloudHTTP.Token, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "invalid character")
+			},
+		},
+		{
+			name: "file",
+			setup: func() Source {
+				path := filepath.Join(t.TempDir(), "tokens.json")
+				require.NoError(
+					t,
+					ioutil.WriteFile(
+						path,
+						[]byte(`{"foo":"bar","bat":{"token":"baz","disabled":true}}`),
+						0600,
+					),
+				)
+				return NewFileSource(path, nil, 0)
+			},
+			assertions: func(tokens map[string]ourCloudHTTP.Token, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					map[string]ourCloudHTTP.Token{
+						"foo": {Value: "bar"},
+						"bat": {Value: "baz", Disabled: true},
+					},
+					tokens,
+				)
+			},
+		},
+		{
+			name: "contents",
+			setup: func() Source {
+				return NewFileSource("", []byte(`{"foo":"bar"}`), 0)
+			},
+			assertions: func(tokens map[string]ourCloudHTTP.Token, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					map[string]ourCloudHTTP.Token{"foo": {Value: "bar"}},
+					tokens,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			tokens, err := testCase.setup().Tokens(context.Background())
+			testCase.assertions(tokens, err)
+		})
+	}
+}
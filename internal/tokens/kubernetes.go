@@ -0,0 +1,431 @@
+package tokens
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	ourCloudHTTP "github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/http" // nolint: lll
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// serviceAccountDir is where Kubernetes mounts the credentials of a pod's
+// service account.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubernetesRetryInterval is how long a Kubernetes Source waits before trying
+// again after failing to list or watch Secrets.
+const kubernetesRetryInterval = 5 * time.Second
+
+// kubernetesListTimeout is how long a Kubernetes Source waits for Secrets to
+// be listed before giving up.
+const kubernetesListTimeout = 30 * time.Second
+
+// Keys of the data of Secrets read by a Kubernetes Source.
+const (
+	// secretKeyToken is the key of the token, in plain text or hashed. It is
+	// required.
+	secretKeyToken = "token"
+	// secretKeyName is the key of the token's name. If it is not present, the
+	// token is named for the Secret.
+	secretKeyName = "name"
+	// secretKeyNotBefore is the key of the RFC 3339 timestamp before which the
+	// token may not be used.
+	secretKeyNotBefore = "notBefore"
+	// secretKeyExpiresAt is the key of the RFC 3339 timestamp at and after which
+	// the token may not be used.
+	secretKeyExpiresAt = "expiresAt"
+	// secretKeyDisabled is the key of a boolean indicating the token may not be
+	// used at all.
+	secretKeyDisabled = "disabled"
+	// secretKeyAllowedNetworks is the key of a comma-delimited list of the only
+	// networks, in CIDR notation, that clients may use the token from.
+	secretKeyAllowedNetworks = "allowedNetworks"
+	// secretKeyDeniedNetworks is the key of a comma-delimited list of networks,
+	// in CIDR notation, that clients may never use the token from.
+	secretKeyDeniedNetworks = "deniedNetworks"
+)
+
+// errWatchExpired is returned when the version of Secrets being watched is too
+// old to resume watching from, in which case Secrets must be listed again.
+var errWatchExpired = errors.New("watch expired")
+
+// The following types represent the subset of the Kubernetes API's resources
+// used by a Kubernetes Source.
+
+type objectMeta struct {
+	Name            string `json:"name"`
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+type secret struct {
+	Metadata objectMeta        `json:"metadata"`
+	Data     map[string][]byte `json:"data"`
+}
+
+type secretList struct {
+	Metadata objectMeta `json:"metadata"`
+	Items    []secret   `json:"items"`
+}
+
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+type status struct {
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+type kubernetesSource struct {
+	// address is the base URL of the Kubernetes API server.
+	address string
+	client  *http.Client
+	// tokenPath is the path of the file containing the token used to
+	// authenticate to the Kubernetes API server. It is read before each request
+	// because Kubernetes rotates the token.
+	tokenPath     string
+	namespace     string
+	labelSelector string
+	retryInterval time.Duration
+	listTimeout   time.Duration
+}
+
+// NewKubernetesSource returns a Source that reads each token from its own
+// Kubernetes Secret in the specified namespace, selected using the specified
+// label selector. If no namespace is specified, the gateway's own namespace is
+// used. Each Secret must have a "token" key. It may also have "name",
+// "notBefore", "expiresAt", "disabled", "allowedNetworks", and
+// "deniedNetworks" keys, the last two of which are comma-delimited. If it has
+// no "name" key, the token is named for the Secret. Secrets are watched for
+// changes. The Source authenticates to the Kubernetes API server using the
+// gateway's service account, so it only works when the gateway is running in a
+// Kubernetes pod.
+func NewKubernetesSource(namespace, labelSelector string) (Source, error) {
+	host := os.Getenv("KUBERNETES_SERVICE_HOST")
+	port := os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New(
+			"KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set; the " +
+				"gateway does not appear to be running in a Kubernetes pod",
+		)
+	}
+	caCert, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading Kubernetes CA certificate")
+	}
+	caCertPool := x509.NewCertPool()
+	if !caCertPool.AppendCertsFromPEM(caCert) {
+		return nil, errors.New("error parsing Kubernetes CA certificate")
+	}
+	if namespace == "" {
+		namespaceBytes, err :=
+			ioutil.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading namespace")
+		}
+		namespace = strings.TrimSpace(string(namespaceBytes))
+	}
+	return &kubernetesSource{
+		address: "https://" + net.JoinHostPort(host, port),
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{
+					RootCAs:    caCertPool,
+					MinVersion: tls.VersionTLS12,
+				},
+			},
+		},
+		tokenPath:     filepath.Join(serviceAccountDir, "token"),
+		namespace:     namespace,
+		labelSelector: labelSelector,
+		retryInterval: kubernetesRetryInterval,
+		listTimeout:   kubernetesListTimeout,
+	}, nil
+}
+
+func (k *kubernetesSource) Tokens(
+	ctx context.Context,
+) (map[string]ourCloudHTTP.Token, error) {
+	list, err := k.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	secrets := map[string]secret{}
+	for _, s := range list.Items {
+		secrets[s.Metadata.Name] = s
+	}
+	return tokensFromSecrets(ctx, secrets), nil
+}
+
+func (k *kubernetesSource) Watch(
+	ctx context.Context,
+	update func(map[string]ourCloudHTTP.Token),
+) error {
+	var last map[string]ourCloudHTTP.Token
+	// Secrets that are modified in ways that don't affect tokens (e.g. by having
+	// their labels changed) shouldn't result in updates
+	updateIfChanged := func(tokens map[string]ourCloudHTTP.Token) {
+		if last == nil || !reflect.DeepEqual(tokens, last) {
+			update(tokens)
+			last = tokens
+		}
+	}
+	for {
+		err := k.watch(ctx, updateIfChanged)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, errWatchExpired) {
+			continue
+		}
+		logging.FromContext(ctx).Error(
+			"error watching Kubernetes Secrets",
+			zap.Error(err),
+		)
+		select {
+		case <-time.After(k.retryInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// watch lists Secrets and then watches them for changes, passing the tokens
+// they define to the provided function after listing them and after each
+// change. It returns when watching cannot continue without listing Secrets
+// again. This function always returns a non-nil error.
+func (k *kubernetesSource) watch(
+	ctx context.Context,
+	update func(map[string]ourCloudHTTP.Token),
+) error {
+	list, err := k.list(ctx)
+	if err != nil {
+		return err
+	}
+	secrets := map[string]secret{}
+	for _, s := range list.Items {
+		secrets[s.Metadata.Name] = s
+	}
+	update(tokensFromSecrets(ctx, secrets))
+	resourceVersion := list.Metadata.ResourceVersion
+	for {
+		res, err := k.get(
+			ctx,
+			url.Values{
+				"watch":               []string{"true"},
+				"resourceVersion":     []string{resourceVersion},
+				"allowWatchBookmarks": []string{"true"},
+			},
+		)
+		if err != nil {
+			return err
+		}
+		decoder := json.NewDecoder(res.Body)
+		for {
+			event := watchEvent{}
+			if err = decoder.Decode(&event); err != nil {
+				break
+			}
+			if event.Type == "ERROR" {
+				s := status{}
+				_ = json.Unmarshal(event.Object, &s)
+				res.Body.Close()
+				if s.Code == http.StatusGone {
+					return errWatchExpired
+				}
+				return errors.Errorf("error watching Secrets: %s", s.Message)
+			}
+			s := secret{}
+			if err = json.Unmarshal(event.Object, &s); err != nil {
+				break
+			}
+			resourceVersion = s.Metadata.ResourceVersion
+			switch event.Type {
+			case "ADDED", "MODIFIED":
+				secrets[s.Metadata.Name] = s
+			case "DELETED":
+				delete(secrets, s.Metadata.Name)
+			default:
+				// Bookmarks only advance the resource version
+				continue
+			}
+			update(tokensFromSecrets(ctx, secrets))
+		}
+		res.Body.Close()
+		if err != io.EOF {
+			return errors.Wrap(err, "error reading Secrets watch events")
+		}
+		// The Kubernetes API server ends watches periodically. Resume watching
+		// from where we left off.
+	}
+}
+
+// list lists Secrets. It returns an error if they are not listed within the
+// Source's list timeout.
+func (k *kubernetesSource) list(ctx context.Context) (secretList, error) {
+	list := secretList{}
+	ctx, cancel := context.WithTimeout(ctx, k.listTimeout)
+	defer cancel()
+	res, err := k.get(ctx, url.Values{})
+	if err == nil {
+		defer res.Body.Close()
+		if err = json.NewDecoder(res.Body).Decode(&list); err != nil {
+			err = errors.Wrap(err, "error decoding Secrets")
+		}
+	}
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return list, errors.Errorf(
+			"timed out after %s listing Kubernetes Secrets",
+			k.listTimeout,
+		)
+	}
+	return list, err
+}
+
+// get sends a GET request for Secrets, with the provided query parameters in
+// addition to the Source's label selector, to the Kubernetes API server. If
+// the response is successful, it is returned. It is the caller's
+// responsibility to close the response body.
+func (k *kubernetesSource) get(
+	ctx context.Context,
+	query url.Values,
+) (*http.Response, error) {
+	token, err := ioutil.ReadFile(k.tokenPath)
+	if err != nil {
+		return nil,
+			errors.Wrap(err, "error reading Kubernetes service account token")
+	}
+	query.Set("labelSelector", k.labelSelector)
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		fmt.Sprintf(
+			"%s/api/v1/namespaces/%s/secrets?%s",
+			k.address,
+			url.PathEscape(k.namespace),
+			query.Encode(),
+		),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set(
+		"Authorization",
+		"Bearer "+strings.TrimSpace(string(token)),
+	)
+	res, err := k.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error sending request to Kubernetes")
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		s := status{}
+		_ = json.NewDecoder(res.Body).Decode(&s)
+		return nil, errors.Errorf(
+			"received %d from Kubernetes: %s",
+			res.StatusCode,
+			s.Message,
+		)
+	}
+	return res, nil
+}
+
+// tokensFromSecrets returns the tokens defined by the provided Secrets,
+// indexed by name. Secrets that don't define a valid token, or that define a
+// token with the same name as another Secret, are logged and ignored.
+func tokensFromSecrets(
+	ctx context.Context,
+	secrets map[string]secret,
+) map[string]ourCloudHTTP.Token {
+	// Secrets are visited in order so that which of several Secrets defining
+	// the same token name is ignored doesn't vary
+	secretNames := make([]string, 0, len(secrets))
+	for secretName := range secrets {
+		secretNames = append(secretNames, secretName)
+	}
+	sort.Strings(secretNames)
+	tokens := map[string]ourCloudHTTP.Token{}
+	for _, secretName := range secretNames {
+		logger := logging.FromContext(ctx).With(zap.String("secret", secretName))
+		name, token, err := tokenFromSecret(secrets[secretName])
+		if err != nil {
+			logger.Error("ignoring invalid token Secret", zap.Error(err))
+			continue
+		}
+		if _, ok := tokens[name]; ok {
+			logger.Error(
+				"ignoring token Secret with duplicate name",
+				logging.Sender(name),
+			)
+			continue
+		}
+		tokens[name] = token
+	}
+	return tokens
+}
+
+// tokenFromSecret returns the name of the token defined by the provided Secret
+// and the token itself.
+func tokenFromSecret(s secret) (string, ourCloudHTTP.Token, error) {
+	name := s.Metadata.Name
+	if nameBytes, ok := s.Data[secretKeyName]; ok {
+		name = strings.TrimSpace(string(nameBytes))
+	}
+	token := ourCloudHTTP.Token{
+		Value: strings.TrimSpace(string(s.Data[secretKeyToken])),
+	}
+	for key, t := range map[string]*time.Time{
+		secretKeyNotBefore: &token.NotBefore,
+		secretKeyExpiresAt: &token.ExpiresAt,
+	} {
+		if value, ok := s.Data[key]; ok {
+			var err error
+			if *t, err =
+				time.Parse(time.RFC3339, strings.TrimSpace(string(value))); err != nil {
+				return "", token, errors.Wrapf(err, "error parsing %s", key)
+			}
+		}
+	}
+	if value, ok := s.Data[secretKeyDisabled]; ok {
+		var err error
+		if token.Disabled, err =
+			strconv.ParseBool(strings.TrimSpace(string(value))); err != nil {
+			return "", token, errors.Wrapf(err, "error parsing %s", secretKeyDisabled)
+		}
+	}
+	for key, networks := range map[string]*[]string{
+		secretKeyAllowedNetworks: &token.AllowedNetworks,
+		secretKeyDeniedNetworks:  &token.DeniedNetworks,
+	} {
+		for _, network := range strings.Split(string(s.Data[key]), ",") {
+			if network = strings.TrimSpace(network); network != "" {
+				*networks = append(*networks, network)
+			}
+		}
+	}
+	if name == "" {
+		return "", token, errors.New("token name is empty")
+	}
+	return name, token, token.Validate()
+}
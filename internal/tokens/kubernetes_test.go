@@ -0,0 +1,227 @@
+package tokens
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ourCloudHTTP "github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/http" // nolint: lll
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewKubernetesSource(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	_, err := NewKubernetesSource("", "foo=bar")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "does not appear to be running")
+}
+
+// testSecret returns the JSON representation of a Secret with the provided name
+// and resource version, defining the provided token.
+func testSecret(name string, resourceVersion string, token string) string {
+	return fmt.Sprintf(
+		`{"metadata":{"name":%q,"resourceVersion":%q},"data":{"token":%q}}`,
+		name,
+		resourceVersion,
+		// Secret data is base64 encoded
+		base64.StdEncoding.EncodeToString([]byte(token)),
+	)
+}
+
+// newTestKubernetesSource returns a kubernetesSource that sends requests to
+// the provided handler.
+func newTestKubernetesSource(
+	t *testing.T,
+	handler http.HandlerFunc,
+) *kubernetesSource {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, ioutil.WriteFile(tokenPath, []byte("foo\n"), 0600))
+	return &kubernetesSource{
+		address:       server.URL,
+		client:        server.Client(),
+		tokenPath:     tokenPath,
+		namespace:     "gateway",
+		labelSelector: "cloudevents.brigade.sh/token=true",
+		retryInterval: time.Millisecond,
+		listTimeout:   time.Second,
+	}
+}
+
+func TestKubernetesSourceTokens(t *testing.T) {
+	testCases := []struct {
+		name       string
+		handler    http.HandlerFunc
+		assertions func(map[string]ourCloudHTTP.Token, error)
+	}{
+		{
+			name: "unsuccessful response",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"message":"secrets is forbidden"}`))
+			},
+			assertions: func(_ map[string]ourCloudHTTP.Token, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					"received 403 from Kubernetes: secrets is forbidden",
+				)
+			},
+		},
+		{
+			name: "success",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/api/v1/namespaces/gateway/secrets", r.URL.Path)
+				require.Equal(
+					t,
+					"cloudevents.brigade.sh/token=true",
+					r.URL.Query().Get("labelSelector"),
+				)
+				require.Equal(t, "Bearer foo", r.Header.Get("Authorization"))
+				_, _ = w.Write([]byte(`{
+					"metadata": {"resourceVersion": "1"},
+					"items": [
+						` + testSecret("foo", "1", "bar") + `,
+						{
+							"metadata": {"name": "bat"},
+							"data": {
+								"token": "YmF6",
+								"name": "ZXhhbXBsZS91cmk=",
+								"expiresAt": "MjAyMi0wMS0wMVQwMDowMDowMFo=",
+								"disabled": "dHJ1ZQ==",
+								"allowedNetworks": "MTAuMC4wLjAvOCwgMTkyLjAuMi4wLzI0"
+							}
+						},
+						{"metadata": {"name": "invalid"}, "data": {}},
+						{
+							"metadata": {"name": "unparseable"},
+							"data": {"token": "JHNjcnlwdCRsbj0xNQ=="}
+						},
+						{
+							"metadata": {"name": "zzz"},
+							"data": {"token": "YmFy", "name": "Zm9v"}
+						}
+					]
+				}`))
+			},
+			assertions: func(tokens map[string]ourCloudHTTP.Token, err error) {
+				require.NoError(t, err)
+				// The Secret without a token, the Secret with a hash that cannot be
+				// parsed, and the Secret with a duplicate name are ignored
+				require.Equal(
+					t,
+					map[string]ourCloudHTTP.Token{
+						"foo": {Value: "bar"},
+						"example/uri": {
+							Value:     "baz",
+							ExpiresAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+							Disabled:  true,
+							AllowedNetworks: []string{
+								"10.0.0.0/8",
+								"192.0.2.0/24",
+							},
+						},
+					},
+					tokens,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			tokens, err := newTestKubernetesSource(t, testCase.handler).
+				Tokens(context.Background())
+			testCase.assertions(tokens, err)
+		})
+	}
+}
+
+func TestKubernetesSourceTokensTimeout(t *testing.T) {
+	source := newTestKubernetesSource(
+		t,
+		func(_ http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		},
+	)
+	source.listTimeout = 10 * time.Millisecond
+	_, err := source.Tokens(context.Background())
+	require.Error(t, err)
+	require.Contains(
+		t,
+		err.Error(),
+		"timed out after 10ms listing Kubernetes Secrets",
+	)
+}
+
+func TestKubernetesSourceWatch(t *testing.T) {
+	var lists, watches int
+	source := newTestKubernetesSource(
+		t,
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("watch") == "" {
+				lists++
+				_, _ = w.Write([]byte(
+					`{"metadata":{"resourceVersion":"1"},"items":[` +
+						testSecret("foo", "1", "bar") + `]}`,
+				))
+				return
+			}
+			watches++
+			switch watches {
+			case 1:
+				require.Equal(t, "1", r.URL.Query().Get("resourceVersion"))
+				fmt.Fprintf(
+					w,
+					`{"type":"ADDED","object":%s}
+{"type":"MODIFIED","object":%s}
+{"type":"BOOKMARK","object":{"metadata":{"resourceVersion":"4"}}}`,
+					testSecret("bat", "2", "baz"),
+					// Changing a Secret without changing its token isn't an update
+					testSecret("bat", "3", "baz"),
+				)
+			case 2:
+				// Watching resumes where it left off
+				require.Equal(t, "4", r.URL.Query().Get("resourceVersion"))
+				fmt.Fprintf(
+					w,
+					`{"type":"DELETED","object":%s}
+{"type":"ERROR","object":{"code":410,"message":"too old resource version"}}`,
+					testSecret("foo", "5", "bar"),
+				)
+			default:
+				// Block until the client goes away
+				<-r.Context().Done()
+			}
+		},
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var updates []map[string]ourCloudHTTP.Token
+	err := source.Watch(ctx, func(tokens map[string]ourCloudHTTP.Token) {
+		updates = append(updates, tokens)
+		if len(updates) == 4 {
+			cancel()
+		}
+	})
+	require.Equal(t, context.Canceled, err)
+	require.Equal(
+		t,
+		[]map[string]ourCloudHTTP.Token{
+			{"foo": {Value: "bar"}},
+			{"foo": {Value: "bar"}, "bat": {Value: "baz"}},
+			{"bat": {Value: "baz"}},
+			// An expired watch results in Secrets being listed again
+			{"foo": {Value: "bar"}},
+		},
+		updates,
+	)
+	require.Equal(t, 2, lists)
+}
@@ -0,0 +1,70 @@
+package tokens
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	ourCloudHTTP "github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/http" // nolint: lll
+	"github.com/pkg/errors"
+)
+
+type tenantFileSource struct {
+	*fileSource
+	tenant string
+}
+
+// NewTenantFileSource returns a Source that reads the tokens of the specified
+// tenant from the JSON file at the specified path, which defines every tenant
+// indexed by name, checking it for changes at the specified interval. If
+// contents are provided, they're used in place of reading a file and never
+// change.
+func NewTenantFileSource(
+	path string,
+	contents []byte,
+	tenant string,
+	refreshInterval time.Duration,
+) Source {
+	return &tenantFileSource{
+		fileSource: &fileSource{
+			path:            path,
+			contents:        contents,
+			refreshInterval: refreshInterval,
+		},
+		tenant: tenant,
+	}
+}
+
+func (t *tenantFileSource) Tokens(
+	context.Context,
+) (map[string]ourCloudHTTP.Token, error) {
+	contents, err := t.read()
+	if err != nil {
+		return nil, err
+	}
+	tenants := map[string]struct {
+		Tokens map[string]ourCloudHTTP.Token `json:"tokens"`
+	}{}
+	if err = json.Unmarshal(contents, &tenants); err != nil {
+		return nil, err
+	}
+	tenant, ok := tenants[t.tenant]
+	if !ok {
+		return nil, errors.Errorf("tenant %q is not defined", t.tenant)
+	}
+	if len(tenant.Tokens) == 0 {
+		return nil, errors.Errorf("tenant %q has no tokens", t.tenant)
+	}
+	return tenant.Tokens, nil
+}
+
+func (t *tenantFileSource) Watch(
+	ctx context.Context,
+	update func(map[string]ourCloudHTTP.Token),
+) error {
+	refreshInterval := t.refreshInterval
+	if t.contents != nil {
+		refreshInterval = 0
+	}
+	return poll(ctx, refreshInterval, t.Tokens, update)
+}
@@ -0,0 +1,111 @@
+package tokens
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	ourCloudHTTP "github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/http" // nolint: lll
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantFileSourceTokens(t *testing.T) {
+	testCases := []struct {
+		name       string
+		setup      func() Source
+		assertions func(map[string]ourCloudHTTP.Token, error)
+	}{
+		{
+			name: "file does not exist",
+			setup: func() Source {
+				return NewTenantFileSource("/completely/bogus/path", nil, "italian", 0)
+			},
+			assertions: func(_ map[string]ourCloudHTTP.Token, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					"file /completely/bogus/path does not exist",
+				)
+			},
+		},
+		{
+			name: "file does not contain valid json",
+			setup: func() Source {
+				return NewTenantFileSource(
+					"",
+					[]byte("this is not json"),
+					"italian",
+					0,
+				)
+			},
+			assertions: func(_ map[string]ourCloudHTTP.Token, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "invalid character")
+			},
+		},
+		{
+			name: "tenant not defined",
+			setup: func() Source {
+				return NewTenantFileSource(
+					"",
+					[]byte(`{"mexican":{"tokens":{"foo":"bar"}}}`),
+					"italian",
+					0,
+				)
+			},
+			assertions: func(_ map[string]ourCloudHTTP.Token, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `tenant "italian" is not defined`)
+			},
+		},
+		{
+			name: "tenant has no tokens",
+			setup: func() Source {
+				return NewTenantFileSource(
+					"",
+					[]byte(`{"italian":{}}`),
+					"italian",
+					0,
+				)
+			},
+			assertions: func(_ map[string]ourCloudHTTP.Token, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `tenant "italian" has no tokens`)
+			},
+		},
+		{
+			name: "file",
+			setup: func() Source {
+				path := filepath.Join(t.TempDir(), "tenants.json")
+				require.NoError(
+					t,
+					ioutil.WriteFile(
+						path,
+						[]byte(
+							`{"italian":{"tokens":{"foo":"bar"},"apiToken":"pasta"},`+
+								`"mexican":{"tokens":{"bat":"baz"}}}`,
+						),
+						0600,
+					),
+				)
+				return NewTenantFileSource(path, nil, "italian", 0)
+			},
+			assertions: func(tokens map[string]ourCloudHTTP.Token, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					map[string]ourCloudHTTP.Token{"foo": {Value: "bar"}},
+					tokens,
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			tokens, err := testCase.setup().Tokens(context.Background())
+			testCase.assertions(tokens, err)
+		})
+	}
+}
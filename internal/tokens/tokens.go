@@ -0,0 +1,155 @@
+package tokens
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	ourCloudHTTP "github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/http" // nolint: lll
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Source is an interface for components that provide the tokens senders may
+// authenticate with.
+type Source interface {
+	// Tokens returns the tokens currently provided by the Source, indexed by
+	// name.
+	Tokens(ctx context.Context) (map[string]ourCloudHTTP.Token, error)
+	// Watch invokes the provided function with the tokens provided by the
+	// Source, indexed by name, and then again each time they change, until the
+	// provided context is canceled. Errors encountered along the way are logged
+	// and do not stop the Source from being watched. This function always
+	// returns a non-nil error.
+	Watch(
+		ctx context.Context,
+		update func(map[string]ourCloudHTTP.Token),
+	) error
+}
+
+// SourceType represents a type of Source.
+type SourceType string
+
+const (
+	// SourceTypeFile represents a Source that reads tokens, indexed by name,
+	// from a single JSON file.
+	SourceTypeFile SourceType = "file"
+	// SourceTypeDirectory represents a Source that reads each token from its own
+	// file in a directory, such as a volume of projected Kubernetes Secrets or
+	// files rendered by a Vault agent. Each token is named for the path of its
+	// file relative to the directory.
+	SourceTypeDirectory SourceType = "directory"
+	// SourceTypeKubernetes represents a Source that reads each token from its
+	// own Kubernetes Secret. Secrets are selected using a label selector and are
+	// watched for changes.
+	SourceTypeKubernetes SourceType = "kubernetes"
+)
+
+// SourceConfig encapsulates configuration for a Source.
+type SourceConfig struct {
+	// Type is the type of Source.
+	Type SourceType
+	// Path is the path of the JSON file read by a Source of type SourceTypeFile
+	// or of the directory read by a Source of type SourceTypeDirectory.
+	Path string
+	// Contents, if non-nil, are used by a Source of type SourceTypeFile in place
+	// of reading a file. Such tokens never change.
+	Contents []byte
+	// RefreshInterval is how often a Source of type SourceTypeFile or
+	// SourceTypeDirectory checks whether tokens have changed. If zero, tokens
+	// are only read once.
+	RefreshInterval time.Duration
+	// KubernetesNamespace is the namespace in which a Source of type
+	// SourceTypeKubernetes looks for Secrets. If unspecified, the gateway's own
+	// namespace is used.
+	KubernetesNamespace string
+	// KubernetesLabelSelector selects the Secrets read by a Source of type
+	// SourceTypeKubernetes.
+	KubernetesLabelSelector string
+}
+
+// NewSource returns a Source of the configured type.
+func NewSource(config SourceConfig) (Source, error) {
+	switch config.Type {
+	case SourceTypeFile, "":
+		if config.Path == "" && config.Contents == nil {
+			return nil, errors.New("no tokens file specified")
+		}
+		return NewFileSource(config.Path, config.Contents, config.RefreshInterval),
+			nil
+	case SourceTypeDirectory:
+		if config.Path == "" {
+			return nil, errors.New("no tokens directory specified")
+		}
+		return NewDirectorySource(config.Path, config.RefreshInterval), nil
+	case SourceTypeKubernetes:
+		if config.KubernetesLabelSelector == "" {
+			return nil, errors.New("no Kubernetes label selector specified")
+		}
+		return NewKubernetesSource(
+			config.KubernetesNamespace,
+			config.KubernetesLabelSelector,
+		)
+	}
+	return nil, errors.Errorf("unsupported token source type %q", config.Type)
+}
+
+// Sync keeps the tokens of the provided TokenFilterConfig up to date with those
+// provided by the Source until the provided context is canceled. If the Source
+// provides tokens that cannot be used, the TokenFilterConfig retains the tokens
+// it has. This function always returns a non-nil error.
+func Sync(
+	ctx context.Context,
+	source Source,
+	config ourCloudHTTP.TokenFilterConfig,
+) error {
+	logger := logging.FromContext(ctx)
+	return source.Watch(ctx, func(tokens map[string]ourCloudHTTP.Token) {
+		if err := config.SetTokens(tokens); err != nil {
+			logger.Error(
+				"error refreshing tokens; previous tokens remain in use",
+				zap.Error(err),
+			)
+			return
+		}
+		logger.Info("tokens refreshed", zap.Int("count", len(tokens)))
+	})
+}
+
+// poll invokes the provided load function immediately and then at the
+// provided interval until the provided context is canceled, passing the tokens
+// it returns to the provided update function whenever they differ from the
+// tokens it last returned. If the interval is zero, tokens are only loaded
+// once. This function always returns a non-nil error.
+func poll(
+	ctx context.Context,
+	interval time.Duration,
+	load func(context.Context) (map[string]ourCloudHTTP.Token, error),
+	update func(map[string]ourCloudHTTP.Token),
+) error {
+	var ticks <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	var last map[string]ourCloudHTTP.Token
+	for {
+		tokens, err := load(ctx)
+		if err != nil {
+			logging.FromContext(ctx).Error(
+				"error loading tokens",
+				zap.Error(err),
+			)
+		} else if last == nil || !reflect.DeepEqual(tokens, last) {
+			update(tokens)
+			last = tokens
+		}
+		select {
+		case <-ticks:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
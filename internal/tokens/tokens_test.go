@@ -0,0 +1,206 @@
+package tokens
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ourCloudHTTP "github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/http" // nolint: lll
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSource(t *testing.T) {
+	testCases := []struct {
+		name       string
+		config     SourceConfig
+		assertions func(Source, error)
+	}{
+		{
+			name:   "unsupported type",
+			config: SourceConfig{Type: "carrier-pigeon"},
+			assertions: func(_ Source, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					`unsupported token source type "carrier-pigeon"`,
+				)
+			},
+		},
+		{
+			name: "file without path",
+			assertions: func(_ Source, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "no tokens file specified")
+			},
+		},
+		{
+			name: "file",
+			config: SourceConfig{
+				Type:            SourceTypeFile,
+				Path:            "/app/config/tokens.json",
+				RefreshInterval: time.Minute,
+			},
+			assertions: func(source Source, err error) {
+				require.NoError(t, err)
+				require.Equal(
+					t,
+					&fileSource{
+						path:            "/app/config/tokens.json",
+						refreshInterval: time.Minute,
+					},
+					source,
+				)
+			},
+		},
+		{
+			name:   "directory without path",
+			config: SourceConfig{Type: SourceTypeDirectory},
+			assertions: func(_ Source, err error) {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "no tokens directory specified")
+			},
+		},
+		{
+			name: "directory",
+			config: SourceConfig{
+				Type: SourceTypeDirectory,
+				Path: "/app/tokens",
+			},
+			assertions: func(source Source, err error) {
+				require.NoError(t, err)
+				require.Equal(t, &directorySource{path: "/app/tokens"}, source)
+			},
+		},
+		{
+			name:   "kubernetes without label selector",
+			config: SourceConfig{Type: SourceTypeKubernetes},
+			assertions: func(_ Source, err error) {
+				require.Error(t, err)
+				require.Contains(
+					t,
+					err.Error(),
+					"no Kubernetes label selector specified",
+				)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			source, err := NewSource(testCase.config)
+			testCase.assertions(source, err)
+		})
+	}
+}
+
+func TestSync(t *testing.T) {
+	config := ourCloudHTTP.NewTokenFilterConfig()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	source := &mockSource{
+		WatchFn: func(
+			ctx context.Context,
+			update func(map[string]ourCloudHTTP.Token),
+		) error {
+			update(map[string]ourCloudHTTP.Token{"foo": {Value: "foo"}})
+			// Invalid tokens don't replace valid ones
+			update(map[string]ourCloudHTTP.Token{"bar": {Value: "$scrypt$ln=15"}})
+			cancel()
+			return ctx.Err()
+		},
+	}
+	require.Equal(t, context.Canceled, Sync(ctx, source, config))
+	name, err := config.Authenticate(context.Background(), "foo", nil)
+	require.NoError(t, err)
+	require.Equal(t, "foo", name)
+}
+
+func TestPoll(t *testing.T) {
+	t.Run("without a refresh interval", func(t *testing.T) {
+		ctx, cancel :=
+			context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		var loads, updates int
+		err := poll(
+			ctx,
+			0,
+			func(context.Context) (map[string]ourCloudHTTP.Token, error) {
+				loads++
+				return map[string]ourCloudHTTP.Token{}, nil
+			},
+			func(map[string]ourCloudHTTP.Token) {
+				updates++
+			},
+		)
+		require.Equal(t, context.DeadlineExceeded, err)
+		require.Equal(t, 1, loads)
+		// Even an empty set of tokens is an update the first time
+		require.Equal(t, 1, updates)
+	})
+
+	t.Run("with a refresh interval", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		results := []map[string]ourCloudHTTP.Token{
+			{"foo": {Value: "foo"}},
+			{"foo": {Value: "foo"}},
+			nil, // Represents an error
+			{"foo": {Value: "bar"}},
+		}
+		var loads int
+		var updates []map[string]ourCloudHTTP.Token
+		err := poll(
+			ctx,
+			time.Millisecond,
+			func(context.Context) (map[string]ourCloudHTTP.Token, error) {
+				if loads == len(results) {
+					// Loading may be attempted again before cancelation is noticed
+					return results[len(results)-1], nil
+				}
+				result := results[loads]
+				if loads++; loads == len(results) {
+					cancel()
+				}
+				if result == nil {
+					return nil, errors.New("something went wrong")
+				}
+				return result, nil
+			},
+			func(tokens map[string]ourCloudHTTP.Token) {
+				updates = append(updates, tokens)
+			},
+		)
+		require.Equal(t, context.Canceled, err)
+		// Only changes are updates
+		require.Equal(
+			t,
+			[]map[string]ourCloudHTTP.Token{
+				{"foo": {Value: "foo"}},
+				{"foo": {Value: "bar"}},
+			},
+			updates,
+		)
+	})
+}
+
+type mockSource struct {
+	TokensFn func(context.Context) (map[string]ourCloudHTTP.Token, error)
+	WatchFn  func(
+		context.Context,
+		func(map[string]ourCloudHTTP.Token),
+	) error
+}
+
+func (m *mockSource) Tokens(
+	ctx context.Context,
+) (map[string]ourCloudHTTP.Token, error) {
+	return m.TokensFn(ctx)
+}
+
+func (m *mockSource) Watch(
+	ctx context.Context,
+	update func(map[string]ourCloudHTTP.Token),
+) error {
+	return m.WatchFn(ctx, update)
+}
@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdkTrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+const serviceName = "brigade-cloudevents-gateway"
+
+// Init configures OpenTelemetry to export spans over OTLP/HTTP and to
+// propagate W3C trace context. The exporter is configured using the standard
+// OTEL_EXPORTER_OTLP_* environment variables and the service's resource
+// attributes may be supplemented using the standard OTEL_SERVICE_NAME and
+// OTEL_RESOURCE_ATTRIBUTES environment variables. The returned function
+// flushes any buffered spans and shuts down the exporter.
+func Init(ctx context.Context) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating OTLP trace exporter")
+	}
+	res, err := resource.New(
+		ctx,
+		resource.WithAttributes(semconv.ServiceNameKey.String(serviceName)),
+		// Attributes from the environment take precedence over the defaults above
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating OpenTelemetry resource")
+	}
+	tracerProvider := sdkTrace.NewTracerProvider(
+		sdkTrace.WithBatcher(exporter),
+		sdkTrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(
+		propagation.NewCompositeTextMapPropagator(
+			propagation.TraceContext{},
+			propagation.Baggage{},
+		),
+	)
+	return tracerProvider.Shutdown, nil
+}
@@ -1,84 +1,780 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"time"
 
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/audit"
 	"github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents"
+	ourCloudGRPC "github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/grpc" // nolint: lll
 	ourCloudHTTP "github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/http" // nolint: lll
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/mqtt"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/logging"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/notifications"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/ratelimit"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/readiness"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/shutdown"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/tokens"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/tracing"
 	libHTTP "github.com/brigadecore/brigade-foundations/http"
+	"github.com/brigadecore/brigade-foundations/os"
 	"github.com/brigadecore/brigade-foundations/signals"
 	"github.com/brigadecore/brigade-foundations/version"
 	"github.com/brigadecore/brigade/sdk/v3"
+	"github.com/brigadecore/brigade/sdk/v3/restmachinery"
 	"github.com/cloudevents/sdk-go/v2/client"
 	cloudHTTP "github.com/cloudevents/sdk-go/v2/protocol/http"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 func main() {
 
-	log.Printf(
-		"Starting Brigade CloudEvents Gateway -- version %s -- commit %s",
-		version.Version(),
-		version.Commit(),
-	)
+	runCommand()
 
-	ctx := signals.Context()
+	// The configuration file is optional. It is loaded before anything else so
+	// that its settings apply to every component. Settings in the environment
+	// take precedence over those in the file.
+	if configPath := os.GetEnvVar("CONFIG_PATH", ""); configPath != "" {
+		if err := loadConfigFile(configPath); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	var cloudEventsService cloudevents.Service
+	var logger *zap.Logger
 	{
-		address, token, opts, err := apiClientConfig()
+		config, err := loggerConfig()
 		if err != nil {
 			log.Fatal(err)
 		}
-		cloudEventsService = cloudevents.NewService(
-			sdk.NewEventsClient(address, token, &opts),
+		if logger, err = logging.NewLogger(config); err != nil {
+			log.Fatal(err)
+		}
+		zap.ReplaceGlobals(logger)
+		// Anything still using the standard library's logger (e.g. dependencies)
+		// is redirected to the structured logger
+		zap.RedirectStdLog(logger)
+	}
+
+	logger.Info(
+		"Starting Brigade CloudEvents Gateway",
+		zap.String("version", version.Version()),
+		zap.String("commit", version.Commit()),
+	)
+
+	// When a signal is received, the gateway is first marked not ready. After a
+	// configurable delay, ctx is canceled, which causes all servers and
+	// receivers to stop accepting new work. In-flight work is then given a
+	// configurable grace period to complete before the gateway exits.
+	shutdownTracker := shutdown.NewTracker()
+	var shutdownGracePeriod time.Duration
+	var ctx context.Context
+	{
+		config, err := shutdownConfig()
+		if err != nil {
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+		shutdownGracePeriod = config.GracePeriod
+		var stopServing context.CancelFunc
+		ctx, stopServing = context.WithCancel(
+			logging.ContextWithLogger(context.Background(), logger),
 		)
+		signalCtx := signals.Context()
+		go func() {
+			<-signalCtx.Done()
+			logger.Info(
+				"shutting down",
+				zap.Duration("delay", config.Delay),
+				zap.Duration("gracePeriod", config.GracePeriod),
+			)
+			shutdownTracker.Shutdown()
+			<-time.After(config.Delay)
+			stopServing()
+		}()
+	}
+
+	// Tracing is optional
+	var shutdownTracing func(context.Context) error
+	{
+		tracingEnabled, err := os.GetBoolFromEnvVar("TRACING_ENABLED", false)
+		if err != nil {
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+		if tracingEnabled {
+			if shutdownTracing, err = tracing.Init(ctx); err != nil {
+				logger.Fatal("error starting gateway", zap.Error(err))
+			}
+		}
+	}
+
+	var apiAddress string
+	var apiOpts restmachinery.APIClientOptions
+	var apiToken string
+	var eventsClient sdk.EventsClient
+	var readinessTTL time.Duration
+	{
+		var err error
+		apiAddress, apiToken, apiOpts, err = apiClientConfig()
+		if err != nil {
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+		eventsClient = sdk.NewEventsClient(apiAddress, apiToken, &apiOpts)
+		if readinessTTL, err = readinessCheckTTL(); err != nil {
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+	}
+
+	// Auditing is optional
+	auditSink := audit.NewNopSink()
+	{
+		auditEnabled, err := os.GetBoolFromEnvVar("AUDIT_ENABLED", false)
+		if err != nil {
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+		if auditEnabled {
+			config, err := auditSinkConfig()
+			if err != nil {
+				logger.Fatal("error starting gateway", zap.Error(err))
+			}
+			if auditSink, err = audit.NewSink(config); err != nil {
+				logger.Fatal("error starting gateway", zap.Error(err))
+			}
+		}
+	}
+
+	var concurrencyLimiter cloudevents.ConcurrencyLimiter
+	{
+		config, err := concurrencyLimiterConfig()
+		if err != nil {
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+		concurrencyLimiter = cloudevents.NewConcurrencyLimiter(config)
+	}
+
+	var enrichment cloudevents.EnricherConfig
+	var enricher cloudevents.Enricher
+	{
+		var err error
+		if enrichment, err = enricherConfig(); err != nil {
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+		enricher = cloudevents.NewEnricher(enrichment)
+	}
+
+	var promoter cloudevents.Promoter
+	{
+		var err error
+		if promoter, err =
+			cloudevents.NewPromoter(promoterConfig()); err != nil {
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+	}
+
+	// Routing to additional Brigade API servers is optional
+	var routing cloudevents.RouterConfig
+	var backendRouter cloudevents.Router
+	{
+		routingEnabled, err := os.GetBoolFromEnvVar("ROUTING_ENABLED", false)
+		if err != nil {
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+		if routingEnabled {
+			if routing, err = routerConfig(); err != nil {
+				logger.Fatal("error starting gateway", zap.Error(err))
+			}
+		}
+		if backendRouter, err =
+			cloudevents.NewRouter(eventsClient, routing); err != nil {
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+	}
+
+	// Every Brigade API server that Brigade Events may be sent to is checked for
+	// readiness, but only once for each token the gateway uses to reach it
+	brigadeAPICheckers := map[string]readiness.Checker{
+		"brigadeAPI": readiness.NewCachingChecker(
+			readiness.NewBrigadeAPIChecker(
+				sdk.NewAuthnClient(apiAddress, apiToken, &apiOpts),
+			),
+			readinessTTL,
+		),
+	}
+	{
+		checked := map[[2]string]struct{}{{apiAddress, apiToken}: {}}
+		for name, backend := range routing.Backends {
+			key := [2]string{backend.Address, backend.Token}
+			if _, ok := checked[key]; ok {
+				continue
+			}
+			checked[key] = struct{}{}
+			brigadeAPICheckers["brigadeAPI/"+name] = readiness.NewCachingChecker(
+				readiness.NewBrigadeAPIChecker(
+					sdk.NewAuthnClient(
+						backend.Address,
+						backend.Token,
+						&backend.ClientOptions,
+					),
+				),
+				readinessTTL,
+			)
+		}
+	}
+
+	cloudEventsService := cloudevents.NewService(
+		backendRouter,
+		auditSink,
+		concurrencyLimiter,
+		enricher,
+		promoter,
+		shutdownTracker,
+	)
+
+	// The lifecycle notifier is optional
+	var notifier notifications.Notifier
+	{
+		notificationsEnabled, err :=
+			os.GetBoolFromEnvVar("NOTIFICATIONS_ENABLED", false)
+		if err != nil {
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+		if notificationsEnabled {
+			config, err := notifierConfig()
+			if err != nil {
+				logger.Fatal("error starting gateway", zap.Error(err))
+			}
+			if notifier, err =
+				notifications.NewNotifier(config, eventsClient); err != nil {
+				logger.Fatal("error starting gateway", zap.Error(err))
+			}
+		}
 	}
 
 	var cloudEventsHandler *client.EventReceiver
 	{
 		proto, err := cloudHTTP.New()
 		if err != nil {
-			log.Fatal(err)
+			logger.Fatal("error starting gateway", zap.Error(err))
 		}
-		cloudEventsHandler, err =
-			client.NewHTTPReceiveHandler(ctx, proto, cloudEventsService.Handle)
+		cloudEventsHandler, err = client.NewHTTPReceiveHandler(
+			ctx,
+			proto,
+			ourCloudHTTP.NewReceiveFn(cloudEventsService),
+		)
 		if err != nil {
-			log.Fatal(err)
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+	}
+
+	// The Azure Event Grid endpoint is optional
+	var eventGridHandler http.Handler
+	{
+		eventGridEnabled, err := os.GetBoolFromEnvVar("EVENT_GRID_ENABLED", false)
+		if err != nil {
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+		if eventGridEnabled {
+			eventGridHandler =
+				ourCloudHTTP.NewEventGridHandler(cloudEventsService, shutdownTracker)
+		}
+	}
+
+	// The AWS SNS and EventBridge endpoint is optional
+	var awsHandler http.Handler
+	{
+		awsEnabled, err := os.GetBoolFromEnvVar("AWS_ENABLED", false)
+		if err != nil {
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+		if awsEnabled {
+			config, err := awsHandlerConfig()
+			if err != nil {
+				logger.Fatal("error starting gateway", zap.Error(err))
+			}
+			awsHandler = ourCloudHTTP.NewAWSHandler(cloudEventsService, config)
+		}
+	}
+
+	// The MQTT receiver is optional
+	var mqttReceiver mqtt.Receiver
+	{
+		mqttEnabled, err := os.GetBoolFromEnvVar("MQTT_ENABLED", false)
+		if err != nil {
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+		if mqttEnabled {
+			config, err := mqttReceiverConfig()
+			if err != nil {
+				logger.Fatal("error starting gateway", zap.Error(err))
+			}
+			mqttReceiver = mqtt.NewReceiver(config, cloudEventsService)
+		}
+	}
+
+	// Clients' IP addresses are resolved, and filtered, the same way for every
+	// HTTP route and for gRPC.
+	var clientIPResolver ourCloudHTTP.ClientIPResolver
+	var ipRules ourCloudHTTP.IPRules
+	var ipFilter libHTTP.Filter
+	{
+		trustedProxies, err := trustedProxiesConfig()
+		if err != nil {
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+		clientIPResolver = ourCloudHTTP.NewClientIPResolver(trustedProxies)
+		if ipRules, err = ipRulesConfig(); err != nil {
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+		ipFilter = ourCloudHTTP.NewIPFilter(ipRules, auditSink)
+	}
+
+	// Rate limiting is optional
+	var rateLimitBackend ratelimit.Backend
+	var rateLimitFilter ourCloudHTTP.RateLimitFilter
+	{
+		rateLimitEnabled, err := os.GetBoolFromEnvVar("RATE_LIMIT_ENABLED", false)
+		if err != nil {
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+		if rateLimitEnabled {
+			backendConfig, err := rateLimitBackendConfig()
+			if err != nil {
+				logger.Fatal("error starting gateway", zap.Error(err))
+			}
+			if rateLimitBackend, err =
+				ratelimit.NewBackend(backendConfig); err != nil {
+				logger.Fatal("error starting gateway", zap.Error(err))
+			}
+			config, err := rateLimitFilterConfig()
+			if err != nil {
+				logger.Fatal("error starting gateway", zap.Error(err))
+			}
+			rateLimitFilter =
+				ourCloudHTTP.NewRateLimitFilter(config, rateLimitBackend, auditSink)
+		}
+	}
+
+	// The WebSocket handler is optional
+	var webSocketHandler http.Handler
+	{
+		webSocketEnabled, err := os.GetBoolFromEnvVar("WEBSOCKET_ENABLED", false)
+		if err != nil {
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+		if webSocketEnabled {
+			config, err := webSocketHandlerConfig()
+			if err != nil {
+				logger.Fatal("error starting gateway", zap.Error(err))
+			}
+			config.RateLimitFilter = rateLimitFilter
+			webSocketHandler =
+				ourCloudHTTP.NewWebSocketHandler(ctx, cloudEventsService, config)
 		}
 	}
 
 	var tokenFilter libHTTP.Filter
+	var tokensChecker readiness.Checker
+	// tokenConfigs indexes every TokenFilterConfig by tenant so that tokens can
+	// be kept up to date and tokens that are about to expire can be reported.
+	// The gateway's default tokens are indexed by the empty string.
+	tokenConfigs := map[string]ourCloudHTTP.TokenFilterConfig{}
+	// tokenSources indexes the Source of the tokens of every TokenFilterConfig
+	// in the same way.
+	tokenSources := map[string]tokens.Source{}
+	var grpcServer ourCloudGRPC.Server
+	// Whether the gRPC server (if enabled) is multiplexed with the HTTP/S server
+	var grpcMultiplexed bool
 	{
-		config, err := tokenFilterConfig()
+		config, source, err := tokenFilterConfig(ctx)
 		if err != nil {
-			log.Fatal(err)
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+		tokenSources[""] = source
+		tokenConfigs[""] = config
+		tokenFilter = ourCloudHTTP.NewTokenFilter(config, auditSink)
+		tokensChecker = ourCloudHTTP.NewTokensChecker(config)
+		// The gRPC server is optional
+		grpcEnabled, err := os.GetBoolFromEnvVar("GRPC_ENABLED", false)
+		if err != nil {
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+		if grpcEnabled {
+			grpcConfig, err := grpcServerConfig()
+			if err != nil {
+				logger.Fatal("error starting gateway", zap.Error(err))
+			}
+			grpcMultiplexed = grpcConfig.Port == 0
+			grpcConfig.ClientIPResolver = clientIPResolver
+			grpcConfig.IPRules = ipRules
+			grpcConfig.RateLimitFilter = rateLimitFilter
+			if grpcServer, err = ourCloudGRPC.NewServer(
+				cloudEventsService,
+				config,
+				auditSink,
+				&grpcConfig,
+			); err != nil {
+				logger.Fatal("error starting gateway", zap.Error(err))
+			}
+		}
+	}
+
+	// Tenants are optional. Each tenant has its own endpoint, tokens, Brigade API
+	// token, and rules for mapping CloudEvents to Brigade Events.
+	var tenantHandler http.Handler
+	{
+		tenantsEnabled, err := os.GetBoolFromEnvVar("TENANTS_ENABLED", false)
+		if err != nil {
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+		if tenantsEnabled {
+			tenants, err := tenantsConfig()
+			if err != nil {
+				logger.Fatal("error starting gateway", zap.Error(err))
+			}
+			handlers := make(map[string]http.Handler, len(tenants))
+			for name, tenant := range tenants {
+				tenantEnrichment := enrichment
+				tenantEnrichment.StaticQualifiers = tenant.Qualifiers
+				tenantEnrichment.StaticLabels = tenant.Labels
+				tenantPromoter, err := cloudevents.NewPromoter(
+					cloudevents.PromoterConfig{
+						Qualifiers: tenant.PromotedQualifiers,
+						Labels:     tenant.PromotedLabels,
+					},
+				)
+				if err != nil {
+					logger.Fatal(
+						"error starting gateway",
+						logging.Tenant(name),
+						zap.Error(err),
+					)
+				}
+				// Brigade Events are always created using the tenant's own tokens
+				tenantRouting, err := tenantRouterConfig(routing, name, tenant)
+				if err != nil {
+					logger.Fatal("error starting gateway", zap.Error(err))
+				}
+				tenantRouter, err := cloudevents.NewRouter(
+					sdk.NewEventsClient(apiAddress, tenant.APIToken, &apiOpts),
+					tenantRouting,
+				)
+				if err != nil {
+					logger.Fatal("error starting gateway", zap.Error(err))
+				}
+				tenantService := cloudevents.NewSourceFilteringService(
+					cloudevents.NewService(
+						tenantRouter,
+						auditSink,
+						concurrencyLimiter,
+						cloudevents.NewEnricher(tenantEnrichment),
+						tenantPromoter,
+						shutdownTracker,
+					),
+					tenant.AllowedSources,
+					auditSink,
+				)
+				proto, err := cloudHTTP.New()
+				if err != nil {
+					logger.Fatal("error starting gateway", zap.Error(err))
+				}
+				receiveHandler, err := client.NewHTTPReceiveHandler(
+					ctx,
+					proto,
+					ourCloudHTTP.NewReceiveFn(tenantService),
+				)
+				if err != nil {
+					logger.Fatal("error starting gateway", zap.Error(err))
+				}
+				tokenConfig, err := newTokenFilterConfig(tenant.Tokens)
+				if err != nil {
+					logger.Fatal(
+						"error starting gateway",
+						logging.Tenant(name),
+						zap.Error(err),
+					)
+				}
+				tokenConfigs[name] = tokenConfig
+				if tokenSources[name], err = tenantTokenSource(name); err != nil {
+					logger.Fatal(
+						"error starting gateway",
+						logging.Tenant(name),
+						zap.Error(err),
+					)
+				}
+				handler := ourCloudHTTP.WithRequestData(receiveHandler.ServeHTTP)
+				if rateLimitFilter != nil {
+					handler = rateLimitFilter.Decorate(handler)
+				}
+				handlers[name] = ourCloudHTTP.NewTokenFilter(
+					tokenConfig,
+					auditSink,
+				).Decorate(handler)
+			}
+			tenantHandler = ourCloudHTTP.NewTenantHandler(handlers)
+		}
+	}
+
+	// Admin endpoints are served by the main server unless a separate admin port
+	// is configured
+	var adminServer libHTTP.Server
+	{
+		config, err := adminServerConfig()
+		if err != nil {
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+		if config.Port != 0 {
+			router := mux.NewRouter()
+			router.StrictSlash(true)
+			router.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+			router.HandleFunc("/healthz", libHTTP.Healthz).Methods(http.MethodGet)
+			adminServer = libHTTP.NewServer(router, &config)
 		}
-		tokenFilter = ourCloudHTTP.NewTokenFilter(config)
 	}
 
 	var server libHTTP.Server
 	{
 		router := mux.NewRouter()
 		router.StrictSlash(true)
+		if grpcMultiplexed {
+			router.MatcherFunc(
+				func(r *http.Request, _ *mux.RouteMatch) bool {
+					return ourCloudGRPC.IsGRPCRequest(r)
+				},
+			).Handler(grpcServer)
+		}
+		requestIDFilter := ourCloudHTTP.NewRequestIDFilter(clientIPResolver)
+		eventsHandler :=
+			ourCloudHTTP.WithRequestData(cloudEventsHandler.ServeHTTP)
+		if rateLimitFilter != nil {
+			// Applied after the token filter so that requests can be limited per
+			// sender
+			eventsHandler = rateLimitFilter.Decorate(eventsHandler)
+		}
 		router.Handle(
 			"/events",
-			tokenFilter.Decorate(cloudEventsHandler.ServeHTTP),
+			ourCloudHTTP.NewTracingHandler(
+				requestIDFilter.Decorate(
+					ipFilter.Decorate(tokenFilter.Decorate(eventsHandler)),
+				),
+				"POST /events",
+			),
 		).Methods(http.MethodPost)
 		router.HandleFunc(
 			"/events",
-			ourCloudHTTP.ValidateEventSource, // No auth filter for OPTIONS requests
+			// No auth filter for OPTIONS requests
+			requestIDFilter.Decorate(
+				ipFilter.Decorate(
+					ourCloudHTTP.NewEventSourceValidator(shutdownTracker),
+				),
+			),
 		).Methods(http.MethodOptions)
+		if tenantHandler != nil {
+			router.Handle(
+				"/events/{tenant}",
+				ourCloudHTTP.NewTracingHandler(
+					// The tenant handler must be decorated by the request ID filter
+					// because the latter establishes the details of the request that
+					// the former amends
+					requestIDFilter.Decorate(ipFilter.Decorate(tenantHandler.ServeHTTP)),
+					"POST /events/{tenant}",
+				),
+			).Methods(http.MethodPost)
+			router.HandleFunc(
+				"/events/{tenant}",
+				// No auth filter for OPTIONS requests
+				requestIDFilter.Decorate(
+					ipFilter.Decorate(
+						ourCloudHTTP.NewEventSourceValidator(shutdownTracker),
+					),
+				),
+			).Methods(http.MethodOptions)
+		}
+		if eventGridHandler != nil {
+			handler := eventGridHandler.ServeHTTP
+			if rateLimitFilter != nil {
+				handler = rateLimitFilter.Decorate(handler)
+			}
+			router.Handle(
+				"/eventgrid",
+				ourCloudHTTP.NewTracingHandler(
+					requestIDFilter.Decorate(
+						ipFilter.Decorate(tokenFilter.Decorate(handler)),
+					),
+					"POST /eventgrid",
+				),
+			).Methods(http.MethodPost)
+		}
+		if awsHandler != nil {
+			handler := awsHandler.ServeHTTP
+			if rateLimitFilter != nil {
+				handler = rateLimitFilter.Decorate(handler)
+			}
+			router.Handle(
+				"/aws",
+				ourCloudHTTP.NewTracingHandler(
+					requestIDFilter.Decorate(
+						ipFilter.Decorate(tokenFilter.Decorate(handler)),
+					),
+					"POST /aws",
+				),
+			).Methods(http.MethodPost)
+		}
+		if webSocketHandler != nil {
+			router.Handle(
+				"/ws/events",
+				ourCloudHTTP.NewTracingHandler(
+					requestIDFilter.Decorate(
+						ipFilter.Decorate(
+							tokenFilter.Decorate(webSocketHandler.ServeHTTP),
+						),
+					),
+					"GET /ws/events",
+				),
+			).Methods(http.MethodGet)
+		}
 		router.HandleFunc("/healthz", libHTTP.Healthz).Methods(http.MethodGet)
+		readinessCheckers := map[string]readiness.Checker{
+			"brigadeAPICapacity": concurrencyLimiter,
+			"shutdown":           shutdownTracker,
+			"tokens":             tokensChecker,
+		}
+		for name, checker := range brigadeAPICheckers {
+			readinessCheckers[name] = checker
+		}
+		router.Handle(
+			"/readyz",
+			readiness.NewHandler(readinessCheckers),
+		).Methods(http.MethodGet)
+		if adminServer == nil {
+			router.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+		}
 		serverConfig, err := serverConfig()
 		if err != nil {
-			log.Fatal(err)
+			logger.Fatal("error starting gateway", zap.Error(err))
 		}
-		server = libHTTP.NewServer(router, &serverConfig)
+		// Every request is tracked so that the gateway can wait for in-flight
+		// requests to be fully handled before it exits
+		shutdownFilter := shutdown.NewFilter(shutdownTracker)
+		var handler http.Handler = shutdownFilter.Decorate(router.ServeHTTP)
+		if grpcMultiplexed && !serverConfig.TLSEnabled {
+			// gRPC requires HTTP/2. With TLS enabled, HTTP/2 is negotiated
+			// automatically. Without it, we need to explicitly support HTTP/2 over
+			// cleartext.
+			handler = h2c.NewHandler(handler, &http2.Server{})
+		}
+		server = libHTTP.NewServer(handler, &serverConfig)
+	}
+
+	var tokenExpiryMonitor ourCloudHTTP.TokenExpiryMonitor
+	{
+		config, err := tokenExpiryMonitorConfig()
+		if err != nil {
+			logger.Fatal("error starting gateway", zap.Error(err))
+		}
+		tokenExpiryMonitor =
+			ourCloudHTTP.NewTokenExpiryMonitor(tokenConfigs, config)
+	}
+
+	for name, source := range tokenSources {
+		go func(name string, source tokens.Source) {
+			syncCtx := ctx
+			if name != "" {
+				syncCtx = logging.ContextWithFields(ctx, logging.Tenant(name))
+			}
+			logging.FromContext(syncCtx).Info(
+				"token source stopped",
+				zap.Error(tokens.Sync(syncCtx, source, tokenConfigs[name])),
+			)
+		}(name, source)
+	}
+
+	go func() {
+		logger.Info(
+			"token expiry monitor stopped",
+			zap.Error(tokenExpiryMonitor.Run(ctx)),
+		)
+	}()
+
+	if adminServer != nil {
+		go func() {
+			logger.Info(
+				"admin server stopped",
+				zap.Error(adminServer.ListenAndServe(ctx)),
+			)
+		}()
+	}
+
+	if mqttReceiver != nil {
+		go func() {
+			logger.Info(
+				"MQTT receiver stopped",
+				zap.Error(mqttReceiver.Run(ctx)),
+			)
+		}()
+	}
+
+	if notifier != nil {
+		go func() {
+			logger.Info(
+				"notifier stopped",
+				zap.Error(notifier.Run(ctx)),
+			)
+		}()
+	}
+
+	if grpcServer != nil && !grpcMultiplexed {
+		go func() {
+			logger.Info(
+				"gRPC server stopped",
+				zap.Error(grpcServer.ListenAndServe(ctx)),
+			)
+		}()
 	}
 
-	log.Println(
-		server.ListenAndServe(ctx),
+	logger.Info(
+		"server stopped",
+		zap.Error(server.ListenAndServe(ctx)),
 	)
+
+	// The server has stopped accepting new connections. Wait for in-flight work
+	// to complete and report anything that had to be abandoned.
+	{
+		drainCtx, cancel :=
+			context.WithTimeout(context.Background(), shutdownGracePeriod)
+		abandoned := shutdownTracker.Wait(drainCtx)
+		cancel()
+		if len(abandoned) > 0 {
+			logger.Warn(
+				"shutdown grace period elapsed; abandoning in-flight work",
+				zap.Any("abandoned", abandoned),
+			)
+		} else {
+			logger.Info("all in-flight work completed")
+		}
+	}
+
+	if rateLimitBackend != nil {
+		if err := rateLimitBackend.Close(); err != nil {
+			logger.Error("error closing rate limit backend", zap.Error(err))
+		}
+	}
+
+	if err := auditSink.Close(); err != nil {
+		logger.Error("error closing audit sink", zap.Error(err))
+	}
+
+	if shutdownTracing != nil {
+		// The main context has already been canceled, so use a new one to allow
+		// buffered spans to be flushed
+		shutdownCtx, cancel :=
+			context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			logger.Error("error shutting down tracing", zap.Error(err))
+		}
+	}
 }
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brigadecore/brigade-foundations/os"
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	cloudHTTP "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	sendModeBinary     = "binary"
+	sendModeStructured = "structured"
+	sendModeBatch      = "batch"
+)
+
+// sendOptions encapsulates options for the send command.
+type sendOptions struct {
+	url       string
+	token     string
+	mode      string
+	source    string
+	eventType string
+	subject   string
+	data      string
+	count     int
+	reply     bool
+	insecure  bool
+	timeout   time.Duration
+}
+
+// sendCommand sends a sample CloudEvent (or, in batch mode, several) to a
+// gateway and writes the gateway's response to the provided io.Writer. This
+// makes it easy to smoke test a deployment. The exit code of the command is
+// returned. It is non-zero unless the gateway responded with a 2xx.
+func sendCommand(args []string, out, errOut io.Writer) int {
+	opts := sendOptions{}
+	flags := flag.NewFlagSet("send", flag.ContinueOnError)
+	flags.SetOutput(errOut)
+	flags.Usage = func() {
+		fmt.Fprintln(errOut, "usage: send [flags] <gateway events URL>")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(
+		&opts.token,
+		"token",
+		"",
+		"token used to authenticate to the gateway; defaults to the value of "+
+			"GATEWAY_TOKEN",
+	)
+	flags.StringVar(
+		&opts.mode,
+		"mode",
+		sendModeBinary,
+		"content mode: binary, structured, or batch",
+	)
+	flags.StringVar(
+		&opts.source,
+		"source",
+		"brigade.sh/cloudevents-gateway/send",
+		"source of the CloudEvent",
+	)
+	flags.StringVar(
+		&opts.eventType,
+		"type",
+		"sh.brigade.cloudevents-gateway.test",
+		"type of the CloudEvent",
+	)
+	flags.StringVar(&opts.subject, "subject", "", "subject of the CloudEvent")
+	flags.StringVar(
+		&opts.data,
+		"data",
+		`{"message":"Hello from the Brigade CloudEvents Gateway"}`,
+		"JSON data of the CloudEvent",
+	)
+	flags.IntVar(
+		&opts.count,
+		"count",
+		2,
+		"number of CloudEvents in a batch (batch mode only)",
+	)
+	flags.BoolVar(
+		&opts.reply,
+		"reply",
+		false,
+		"request a reply identifying the Brigade Events that were created "+
+			"(binary and structured modes only)",
+	)
+	flags.BoolVar(
+		&opts.insecure,
+		"insecure",
+		false,
+		"skip verification of the gateway's certificate",
+	)
+	flags.DurationVar(
+		&opts.timeout,
+		"timeout",
+		30*time.Second,
+		"time to wait for the gateway to respond",
+	)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	opts.url = flags.Arg(0)
+	if opts.token == "" {
+		opts.token = os.GetEnvVar("GATEWAY_TOKEN", "")
+	}
+	res, err := send(opts)
+	if err != nil {
+		fmt.Fprintln(errOut, err)
+		return 1
+	}
+	defer res.Body.Close()
+	if err = writeResponse(res, out); err != nil {
+		fmt.Fprintln(errOut, err)
+		return 1
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return 1
+	}
+	return 0
+}
+
+// send sends one or more sample CloudEvents to a gateway as specified by the
+// provided sendOptions and returns the gateway's response.
+func send(opts sendOptions) (*http.Response, error) {
+	if !json.Valid([]byte(opts.data)) {
+		return nil, errors.New("data is not valid JSON")
+	}
+	count := 1
+	if opts.mode == sendModeBatch {
+		if opts.count < 1 {
+			return nil, errors.New("count must be at least 1")
+		}
+		count = opts.count
+	}
+	events := make([]cloudEvents.Event, count)
+	for i := range events {
+		events[i] = cloudEvents.NewEvent()
+		events[i].SetID(uuid.New().String())
+		events[i].SetSource(opts.source)
+		events[i].SetType(opts.eventType)
+		events[i].SetTime(time.Now())
+		if opts.subject != "" {
+			events[i].SetSubject(opts.subject)
+		}
+		if err := events[i].SetData(
+			cloudEvents.ApplicationJSON,
+			json.RawMessage(opts.data),
+		); err != nil {
+			return nil, errors.Wrap(err, "error setting CloudEvent data")
+		}
+		if err := events[i].Validate(); err != nil {
+			return nil, errors.Wrap(err, "invalid CloudEvent")
+		}
+	}
+	ctx := context.Background()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, opts.url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating request")
+	}
+	switch opts.mode {
+	case sendModeBinary, sendModeStructured:
+		writeCtx := binding.WithForceBinary(ctx)
+		if opts.mode == sendModeStructured {
+			writeCtx = binding.WithForceStructured(ctx)
+		}
+		if err = cloudHTTP.WriteRequest(
+			writeCtx,
+			binding.ToMessage(&events[0]),
+			req,
+		); err != nil {
+			return nil, errors.Wrap(err, "error writing CloudEvent to request")
+		}
+		if opts.reply {
+			req.Header.Set("Prefer", "return=representation")
+		}
+	case sendModeBatch:
+		body, err := json.Marshal(events)
+		if err != nil {
+			return nil, errors.Wrap(err, "error marshaling batch")
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+		req.Header.Set(
+			"Content-Type",
+			cloudEvents.ApplicationCloudEventsBatchJSON,
+		)
+	default:
+		return nil, errors.Errorf(
+			"unsupported mode %q; must be %s, %s, or %s",
+			opts.mode,
+			sendModeBinary,
+			sendModeStructured,
+			sendModeBatch,
+		)
+	}
+	if opts.token != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.token)
+	}
+	client := &http.Client{
+		Timeout: opts.timeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: opts.insecure, // nolint: gosec
+			},
+		},
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error sending to %s", opts.url)
+	}
+	return res, nil
+}
+
+// writeResponse writes the status, CloudEvent attributes (if the response is a
+// reply), and body of the provided response to the provided io.Writer.
+func writeResponse(res *http.Response, out io.Writer) error {
+	fmt.Fprintln(out, res.Status)
+	var ceHeaders []string
+	for name := range res.Header {
+		if strings.HasPrefix(name, "Ce-") {
+			ceHeaders = append(ceHeaders, name)
+		}
+	}
+	sort.Strings(ceHeaders)
+	for _, name := range ceHeaders {
+		fmt.Fprintf(out, "%s: %s\n", name, res.Header.Get(name))
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return errors.Wrap(err, "error reading response")
+	}
+	if body = bytes.TrimSpace(body); len(body) > 0 {
+		fmt.Fprintln(out, string(body))
+	}
+	return nil
+}
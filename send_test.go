@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cloudEvents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendCommand(t *testing.T) {
+	var req *http.Request
+	var reqBody []byte
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req = r
+			var err error
+			reqBody, err = ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			if r.Header.Get("Authorization") != "Bearer foo" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			if r.Header.Get("Prefer") != "" {
+				w.Header().Set("Ce-Id", "5678")
+				w.Header().Set("Ce-Type", "sh.brigade.event.created")
+				_, _ = w.Write([]byte(`{"events":[]}`))
+			}
+		}),
+	)
+	defer server.Close()
+	testCases := []struct {
+		name       string
+		args       []string
+		assertions func(code int, out string, errOut string)
+	}{
+		{
+			name: "no URL",
+			args: []string{"-token", "foo"},
+			assertions: func(code int, _ string, errOut string) {
+				require.Equal(t, 2, code)
+				require.Contains(t, errOut, "usage: send")
+			},
+		},
+		{
+			name: "unsupported mode",
+			args: []string{"-token", "foo", "-mode", "carrier-pigeon", server.URL},
+			assertions: func(code int, _ string, errOut string) {
+				require.Equal(t, 1, code)
+				require.Contains(t, errOut, `unsupported mode "carrier-pigeon"`)
+			},
+		},
+		{
+			name: "data is not JSON",
+			args: []string{"-token", "foo", "-data", "{", server.URL},
+			assertions: func(code int, _ string, errOut string) {
+				require.Equal(t, 1, code)
+				require.Contains(t, errOut, "data is not valid JSON")
+			},
+		},
+		{
+			name: "gateway rejects request",
+			args: []string{"-token", "bar", server.URL},
+			assertions: func(code int, out string, _ string) {
+				require.Equal(t, 1, code)
+				require.Equal(t, "403 Forbidden\n", out)
+			},
+		},
+		{
+			name: "binary mode",
+			args: []string{"-token", "foo", "-subject", "bat", server.URL},
+			assertions: func(code int, out string, _ string) {
+				require.Equal(t, 0, code)
+				require.Equal(t, "200 OK\n", out)
+				require.Equal(t, "application/json", req.Header.Get("Content-Type"))
+				require.NotEmpty(t, req.Header.Get("Ce-Id"))
+				require.Equal(t, "bat", req.Header.Get("Ce-Subject"))
+				require.JSONEq(
+					t,
+					`{"message":"Hello from the Brigade CloudEvents Gateway"}`,
+					string(reqBody),
+				)
+			},
+		},
+		{
+			name: "structured mode with reply",
+			args: []string{
+				"-token", "foo",
+				"-mode", "structured",
+				"-reply",
+				server.URL,
+			},
+			assertions: func(code int, out string, _ string) {
+				require.Equal(t, 0, code)
+				require.Equal(
+					t,
+					"200 OK\nCe-Id: 5678\nCe-Type: sh.brigade.event.created\n"+
+						`{"events":[]}`+"\n",
+					out,
+				)
+				require.Equal(
+					t,
+					cloudEvents.ApplicationCloudEventsJSON,
+					req.Header.Get("Content-Type"),
+				)
+				event := cloudEvents.NewEvent()
+				require.NoError(t, json.Unmarshal(reqBody, &event))
+				require.Equal(t, "sh.brigade.cloudevents-gateway.test", event.Type())
+			},
+		},
+		{
+			name: "batch mode",
+			args: []string{"-mode", "batch", "-count", "3", server.URL},
+			assertions: func(code int, out string, _ string) {
+				require.Equal(t, 0, code)
+				require.Equal(
+					t,
+					cloudEvents.ApplicationCloudEventsBatchJSON,
+					req.Header.Get("Content-Type"),
+				)
+				events := []cloudEvents.Event{}
+				require.NoError(t, json.Unmarshal(reqBody, &events))
+				require.Len(t, events, 3)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			// The token is taken from the environment when not specified
+			t.Setenv("GATEWAY_TOKEN", "foo")
+			out := &bytes.Buffer{}
+			errOut := &bytes.Buffer{}
+			code := sendCommand(testCase.args, out, errOut)
+			testCase.assertions(code, out.String(), errOut.String())
+		})
+	}
+}
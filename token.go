@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	ourCloudHTTP "github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/http" // nolint: lll
+	"github.com/pkg/errors"
+)
+
+const (
+	tokenChars = "abcdefghijklmnopqrstuvwxyz" +
+		"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
+		"0123456789"
+	defaultTokenLength = 32
+	minTokenLength     = 16
+)
+
+// tokenGenerateCommand writes a new, random token suitable for use by a sender
+// to the provided io.Writer. The exit code of the command is returned.
+func tokenGenerateCommand(args []string, out, errOut io.Writer) int {
+	flags := flag.NewFlagSet("token generate", flag.ContinueOnError)
+	flags.SetOutput(errOut)
+	length := flags.Int(
+		"length",
+		defaultTokenLength,
+		"number of characters in the token",
+	)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 || *length < minTokenLength {
+		fmt.Fprintf(
+			errOut,
+			"usage: token generate [-length n] (n must be at least %d)\n",
+			minTokenLength,
+		)
+		return 2
+	}
+	token, err := generateToken(*length)
+	if err != nil {
+		fmt.Fprintln(errOut, err)
+		return 1
+	}
+	fmt.Fprintln(out, token)
+	return 0
+}
+
+// generateToken returns a random, alphanumeric token of the specified length.
+func generateToken(length int) (string, error) {
+	max := big.NewInt(int64(len(tokenChars)))
+	var token strings.Builder
+	for i := 0; i < length; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", errors.Wrap(err, "error generating token")
+		}
+		token.WriteByte(tokenChars[n.Int64()])
+	}
+	return token.String(), nil
+}
+
+// tokenHashCommand writes a salted hash of a token, suitable for use in place
+// of the plain text token when configuring tokens, to the provided io.Writer.
+// The token is taken from the provided arguments or, to keep it out of shell
+// history, from the first line of the provided io.Reader. The exit code of the
+// command is returned.
+func tokenHashCommand(
+	args []string,
+	in io.Reader,
+	out io.Writer,
+	errOut io.Writer,
+) int {
+	flags := flag.NewFlagSet("token hash", flag.ContinueOnError)
+	flags.SetOutput(errOut)
+	algorithm := flags.String(
+		"algorithm",
+		ourCloudHTTP.TokenHashArgon2id,
+		fmt.Sprintf(
+			"hash algorithm: %s, %s, or %s",
+			ourCloudHTTP.TokenHashArgon2id,
+			ourCloudHTTP.TokenHashBcrypt,
+			ourCloudHTTP.TokenHashScrypt,
+		),
+	)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 1 {
+		fmt.Fprintln(errOut, "usage: token hash [-algorithm name] [token]")
+		return 2
+	}
+	token := flags.Arg(0)
+	if token == "" {
+		scanner := bufio.NewScanner(in)
+		if scanner.Scan() {
+			token = strings.TrimSpace(scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			fmt.Fprintf(errOut, "error reading token: %s\n", err)
+			return 1
+		}
+	}
+	if token == "" {
+		fmt.Fprintln(errOut, "no token was provided")
+		return 1
+	}
+	hash, err := ourCloudHTTP.HashToken(*algorithm, token)
+	if err != nil {
+		fmt.Fprintln(errOut, err)
+		return 1
+	}
+	fmt.Fprintln(out, hash)
+	return 0
+}
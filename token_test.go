@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	ourCloudHTTP "github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents/http" // nolint: lll
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenGenerateCommand(t *testing.T) {
+	testCases := []struct {
+		name       string
+		args       []string
+		assertions func(code int, out string, errOut string)
+	}{
+		{
+			name: "length too short",
+			args: []string{"-length", "8"},
+			assertions: func(code int, _ string, errOut string) {
+				require.Equal(t, 2, code)
+				require.Contains(t, errOut, "must be at least 16")
+			},
+		},
+		{
+			name: "unexpected argument",
+			args: []string{"foo"},
+			assertions: func(code int, _ string, errOut string) {
+				require.Equal(t, 2, code)
+				require.Contains(t, errOut, "usage: token generate")
+			},
+		},
+		{
+			name: "default length",
+			assertions: func(code int, out string, _ string) {
+				require.Equal(t, 0, code)
+				require.Regexp(t, "^[a-zA-Z0-9]{32}\n$", out)
+			},
+		},
+		{
+			name: "custom length",
+			args: []string{"-length", "48"},
+			assertions: func(code int, out string, _ string) {
+				require.Equal(t, 0, code)
+				require.Regexp(t, "^[a-zA-Z0-9]{48}\n$", out)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			errOut := &bytes.Buffer{}
+			code := tokenGenerateCommand(testCase.args, out, errOut)
+			testCase.assertions(code, out.String(), errOut.String())
+		})
+	}
+}
+
+func TestGenerateToken(t *testing.T) {
+	token1, err := generateToken(defaultTokenLength)
+	require.NoError(t, err)
+	token2, err := generateToken(defaultTokenLength)
+	require.NoError(t, err)
+	require.NotEqual(t, token1, token2)
+}
+
+func TestTokenHashCommand(t *testing.T) {
+	testCases := []struct {
+		name       string
+		args       []string
+		in         string
+		assertions func(code int, out string, errOut string)
+	}{
+		{
+			name: "too many arguments",
+			args: []string{"foo", "bar"},
+			assertions: func(code int, _ string, errOut string) {
+				require.Equal(t, 2, code)
+				require.Contains(t, errOut, "usage: token hash")
+			},
+		},
+		{
+			name: "no token provided",
+			in:   "\n",
+			assertions: func(code int, _ string, errOut string) {
+				require.Equal(t, 1, code)
+				require.Contains(t, errOut, "no token was provided")
+			},
+		},
+		{
+			name: "unsupported algorithm",
+			args: []string{"-algorithm", "md5", "foo"},
+			assertions: func(code int, _ string, errOut string) {
+				require.Equal(t, 1, code)
+				require.Contains(t, errOut, "unsupported hash algorithm")
+			},
+		},
+		{
+			name: "token provided as argument",
+			args: []string{"foo"},
+			assertions: func(code int, out string, _ string) {
+				require.Equal(t, 0, code)
+				require.True(t, strings.HasPrefix(out, "$argon2id$"))
+				requireTokenMatchesHash(t, "foo", strings.TrimSpace(out))
+			},
+		},
+		{
+			name: "token provided via input",
+			args: []string{"-algorithm", "bcrypt"},
+			in:   "foo\nbar\n",
+			assertions: func(code int, out string, _ string) {
+				require.Equal(t, 0, code)
+				require.True(t, strings.HasPrefix(out, "$2a$"))
+				requireTokenMatchesHash(t, "foo", strings.TrimSpace(out))
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			errOut := &bytes.Buffer{}
+			code := tokenHashCommand(
+				testCase.args,
+				strings.NewReader(testCase.in),
+				out,
+				errOut,
+			)
+			testCase.assertions(code, out.String(), errOut.String())
+		})
+	}
+}
+
+// requireTokenMatchesHash asserts that the gateway authenticates the provided
+// token using the provided hash.
+func requireTokenMatchesHash(t *testing.T, token string, hash string) {
+	config, err := newTokenFilterConfig(
+		map[string]ourCloudHTTP.Token{"foo": {Value: hash}},
+	)
+	require.NoError(t, err)
+	name, err := config.Authenticate(context.Background(), token, nil)
+	require.NoError(t, err)
+	require.Equal(t, "foo", name)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/cloudevents"
+	"github.com/brigadecore/brigade-cloudevents-gateway/internal/tokens"
+	"github.com/brigadecore/brigade-foundations/os"
+	"github.com/pkg/errors"
+)
+
+// validateCommand checks the configuration file at the path specified by the
+// provided arguments, or by the CONFIG_PATH environment variable, along with
+// any settings in the environment, without starting the gateway. Every problem
+// found is written to the provided io.Writer. The exit code of the command is
+// returned.
+func validateCommand(args []string, out io.Writer) int {
+	if len(args) > 1 {
+		fmt.Fprintln(out, "usage: config validate [path to configuration file]")
+		return 2
+	}
+	path := os.GetEnvVar("CONFIG_PATH", "")
+	if len(args) == 1 {
+		path = args[0]
+	}
+	if path != "" {
+		if err := loadConfigFile(path); err != nil {
+			fmt.Fprintln(out, err)
+			return 1
+		}
+	}
+	errs := validateConfig()
+	for _, err := range errs {
+		fmt.Fprintln(out, err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	fmt.Fprintln(out, "configuration is valid")
+	return 0
+}
+
+// validateConfig populates configuration for every component of the gateway
+// that is enabled, exactly as the gateway does at startup, and returns every
+// error encountered.
+func validateConfig() []error {
+	var errs []error
+	check := func(component string, err error) {
+		if err != nil {
+			errs = append(
+				errs,
+				errors.Wrapf(err, "invalid %s configuration", component),
+			)
+		}
+	}
+	enabled := func(envVar string) bool {
+		enabled, err := os.GetBoolFromEnvVar(envVar, false)
+		check(envVar, err)
+		return enabled
+	}
+	_, err := loggerConfig()
+	check("logger", err)
+	_, err = shutdownConfig()
+	check("shutdown", err)
+	_, _, _, err = apiClientConfig()
+	check("Brigade API client", err)
+	_, err = readinessCheckTTL()
+	check("readiness check", err)
+	_, err = concurrencyLimiterConfig()
+	check("concurrency limiter", err)
+	_, err = enricherConfig()
+	check("enrichment", err)
+	_, err = cloudevents.NewPromoter(promoterConfig())
+	check("promotion", err)
+	var sourceConfig tokens.SourceConfig
+	if sourceConfig, err = tokenSourceConfig(); err == nil &&
+		sourceConfig.Type != tokens.SourceTypeKubernetes {
+		// Tokens in Kubernetes Secrets can only be read from inside the cluster
+		_, _, err = tokenFilterConfig(context.Background())
+	}
+	check("token", err)
+	_, err = tokenExpiryMonitorConfig()
+	check("token expiry", err)
+	_, err = trustedProxiesConfig()
+	check("trusted proxies", err)
+	_, err = ipRulesConfig()
+	check("IP rules", err)
+	_, err = serverConfig()
+	check("server", err)
+	_, err = adminServerConfig()
+	check("admin server", err)
+	// Tracing has no configuration of its own to check
+	enabled("TRACING_ENABLED")
+	if enabled("AUDIT_ENABLED") {
+		_, err = auditSinkConfig()
+		check("audit", err)
+	}
+	if enabled("ROUTING_ENABLED") {
+		var config cloudevents.RouterConfig
+		if config, err = routerConfig(); err == nil {
+			_, err = cloudevents.NewRouter(nil, config)
+		}
+		check("routing", err)
+	}
+	if enabled("TENANTS_ENABLED") {
+		var tenants map[string]tenantConfig
+		tenants, err = tenantsConfig()
+		for name, tenant := range tenants {
+			if err != nil {
+				break
+			}
+			if _, err = cloudevents.NewPromoter(
+				cloudevents.PromoterConfig{
+					Qualifiers: tenant.PromotedQualifiers,
+					Labels:     tenant.PromotedLabels,
+				},
+			); err != nil {
+				err = errors.Wrapf(err, "tenant %q", name)
+			}
+		}
+		check("tenants", err)
+	}
+	if enabled("RATE_LIMIT_ENABLED") {
+		_, err = rateLimitBackendConfig()
+		check("rate limit backend", err)
+		_, err = rateLimitFilterConfig()
+		check("rate limit", err)
+	}
+	if enabled("WEBSOCKET_ENABLED") {
+		_, err = webSocketHandlerConfig()
+		check("WebSocket", err)
+	}
+	// The Event Grid endpoint has no configuration of its own to check
+	enabled("EVENT_GRID_ENABLED")
+	if enabled("AWS_ENABLED") {
+		_, err = awsHandlerConfig()
+		check("AWS", err)
+	}
+	if enabled("GRPC_ENABLED") {
+		_, err = grpcServerConfig()
+		check("gRPC server", err)
+	}
+	if enabled("MQTT_ENABLED") {
+		_, err = mqttReceiverConfig()
+		check("MQTT receiver", err)
+	}
+	if enabled("NOTIFICATIONS_ENABLED") {
+		_, err = notifierConfig()
+		check("notifications", err)
+	}
+	return errs
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateCommand(t *testing.T) {
+	testCases := []struct {
+		name       string
+		data       string
+		assertions func(code int, output string)
+	}{
+		{
+			name: "configuration file is invalid",
+			data: "version: v1\nbrigade:\n  apiAdress: https://brigade.example.com\n",
+			assertions: func(code int, output string) {
+				require.Equal(t, 1, code)
+				require.Contains(t, output, "field apiAdress not found")
+			},
+		},
+		{
+			name: "configuration is incomplete",
+			data: "version: v1\nbrigade:\n  apiAddress: https://example.com\n",
+			assertions: func(code int, output string) {
+				require.Equal(t, 1, code)
+				require.Contains(
+					t,
+					output,
+					"invalid Brigade API client configuration",
+				)
+				require.Contains(t, output, "invalid token configuration")
+			},
+		},
+		{
+			name: "configuration is valid",
+			data: `version: v1
+brigade:
+  apiAddress: https://brigade.example.com
+  apiToken: 11235813213455
+tokens:
+  foo: bar
+`,
+			assertions: func(code int, output string) {
+				require.Equal(t, 0, code)
+				require.Equal(t, "configuration is valid\n", output)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Cleanup(func() {
+				configFileContents = map[string][]byte{}
+			})
+			// Registers these to be restored after the test
+			t.Setenv("API_ADDRESS", "")
+			t.Setenv("API_TOKEN", "")
+			t.Setenv("TOKENS_PATH", "")
+			configFile, err := ioutil.TempFile("", "config.yaml")
+			require.NoError(t, err)
+			defer configFile.Close()
+			_, err = configFile.Write([]byte(testCase.data))
+			require.NoError(t, err)
+			out := &bytes.Buffer{}
+			code := validateCommand([]string{configFile.Name()}, out)
+			testCase.assertions(code, out.String())
+		})
+	}
+}
+
+func TestValidateCommandUsage(t *testing.T) {
+	out := &bytes.Buffer{}
+	require.Equal(t, 2, validateCommand([]string{"foo", "bar"}, out))
+	require.Contains(t, out.String(), "usage:")
+}